@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// parseFlags parses a command's flags, then sets any flags which weren't given
+// on the command line from the -config file
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", "", "A YAML file of flag values, keyed by flag name. Flags given on the command line override the file.")
+	fs.Parse(args)
+	if len(*configPath) == 0 {
+		return nil
+	}
+
+	settings, err := loadConfigFile(*configPath)
+	if err != nil {
+		return configError(err)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name := range set {
+		delete(settings, name)
+	}
+	if err = applySettings(fs, settings); err != nil {
+		return configError(err)
+	}
+	return nil
+}
+
+// applySettings sets flags from config file settings. Files may be shared
+// between commands, so settings for other commands' flags are ignored.
+func applySettings(fs *flag.FlagSet, settings map[string]string) error {
+	for name, value := range settings {
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("Invalid value for %q in config file: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// loadConfigFile reads a YAML config file into flag values. Lists are joined
+// with commas, and maps (e.g. template_vars) into 'key1=val1;key2=val2'.
+func loadConfigFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %s", err.Error())
+	}
+	var raw map[string]interface{}
+	if err = yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("Could not decode config file: %s", err.Error())
+	}
+	settings := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if value == nil {
+			continue
+		}
+		settings[name] = configValue(value)
+	}
+	return settings, nil
+}
+
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, len(v))
+		for idx, item := range v {
+			values[idx] = configValue(item)
+		}
+		return strings.Join(values, ",")
+	case map[interface{}]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			pairs = append(pairs, fmt.Sprint(key)+"="+configValue(item))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ";")
+	}
+	return fmt.Sprint(value)
+}
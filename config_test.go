@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFlagsConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-config")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "snapshot.yaml")
+	ioutil.WriteFile(configFile, []byte(`
+from: now-6h
+to: now
+max_points: 100
+panels: [SLOs, "*debug*"]
+template_vars:
+  env: prod
+  dc: eu
+unset:
+listen: ":8080" # another command's flag
+`), 0600)
+	invalidFile := filepath.Join(dir, "invalid.yaml")
+	ioutil.WriteFile(invalidFile, []byte("max_points: lots\n"), 0600)
+
+	newFlags := func() (*flag.FlagSet, map[string]*string, *int) {
+		fs := flag.NewFlagSet("take", flag.ContinueOnError)
+		strs := map[string]*string{}
+		for _, name := range []string{"from", "to", "panels", "template_vars", "unset"} {
+			strs[name] = fs.String(name, "default", "")
+		}
+		return fs, strs, fs.Int("max_points", 0, "")
+	}
+
+	// flags on the command line override the file
+	fs, strs, maxPoints := newFlags()
+	if err = parseFlags(fs, []string{"-config=" + configFile, "-from=now-1h"}); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	expected := map[string]string{
+		"from": "now-1h",
+		"to":   "now",
+		// lists are joined by commas, and maps into k=v pairs by semicolons
+		"panels":        "SLOs,*debug*",
+		"template_vars": "dc=eu;env=prod",
+		"unset":         "default",
+	}
+	for name, value := range expected {
+		if *strs[name] != value {
+			t.Errorf("Expected %q to be %q, got %q", name, value, *strs[name])
+		}
+	}
+	if *maxPoints != 100 {
+		t.Errorf("Expected \"max_points\" to be 100, got %d", *maxPoints)
+	}
+
+	// invalid values, and missing files, are config errors
+	for _, path := range []string{invalidFile, filepath.Join(dir, "missing.yaml")} {
+		fs, _, _ = newFlags()
+		err = parseFlags(fs, []string{"-config=" + path})
+		if err == nil {
+			t.Errorf("Config file %q unexpectedly passed", filepath.Base(path))
+		} else if code := exitCode(err); code != exitConfig {
+			t.Errorf("Config file %q expected exit code %d, got %d: %s", filepath.Base(path), exitConfig, code, err.Error())
+		}
+	}
+}
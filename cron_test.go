@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2017, time.February, 8, 15, 4, 5, 0, time.UTC)
+	cronTests := []struct {
+		purpose  string
+		spec     string
+		valid    bool
+		expected time.Time
+	}{
+		{
+			purpose:  "Nightly",
+			spec:     "0 2 * * *",
+			valid:    true,
+			expected: time.Date(2017, time.February, 9, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Later today",
+			spec:     "30 15,18 * * *",
+			valid:    true,
+			expected: time.Date(2017, time.February, 8, 15, 30, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Every 15 minutes",
+			spec:     "*/15 * * * *",
+			valid:    true,
+			expected: time.Date(2017, time.February, 8, 15, 15, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Weekdays by name",
+			spec:     "0 9 * * mon-fri",
+			valid:    true,
+			expected: time.Date(2017, time.February, 9, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Sunday as 7",
+			spec:     "0 0 * * 7",
+			valid:    true,
+			expected: time.Date(2017, time.February, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Day of month or week",
+			spec:     "0 0 1 * fri",
+			valid:    true,
+			expected: time.Date(2017, time.February, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Macro",
+			spec:     "@monthly",
+			valid:    true,
+			expected: time.Date(2017, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Next year",
+			spec:     "0 0 1 jan *",
+			valid:    true,
+			expected: time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			purpose:  "Never",
+			spec:     "0 0 30 2 *",
+			valid:    true,
+			expected: time.Time{},
+		},
+		{
+			purpose: "Too few fields",
+			spec:    "0 2 * *",
+			valid:   false,
+		},
+		{
+			purpose: "Out of range",
+			spec:    "0 24 * * *",
+			valid:   false,
+		},
+		{
+			purpose: "Backwards range",
+			spec:    "0 5-2 * * *",
+			valid:   false,
+		},
+	}
+	// test
+	for _, tt := range cronTests {
+		cron, err := parseCron(tt.spec)
+		if tt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		} else if !tt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", tt.purpose)
+		} else if tt.valid {
+			if out := cron.next(now); !out.Equal(tt.expected) {
+				t.Errorf("Test \"%s\" expected %s, got %s", tt.purpose, tt.expected, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// schedule takes snapshots of its dashboards each time its cron expression
+// matches
+type schedule struct {
+	name     string
+	cron     *cronSchedule
+	location *time.Location
+	take     *takeFlags
+	next     time.Time
+}
+
+// Settings selecting dashboards, which a schedule's own replace rather than
+// add to
+var dashboardSettings = []string{"dashboard_slug", "dashboard_uid", "folder", "all"}
+
+// loadSchedules reads the schedules from a config file. Each schedule has a
+// "cron" expression plus any of take's flags, and the file's top level
+// settings apply to every schedule which doesn't set them.
+func loadSchedules(path string, settings map[string]string) ([]*schedule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %s", err.Error())
+	}
+	var file struct {
+		Schedules []map[string]interface{} `yaml:"schedules"`
+	}
+	if err = yaml.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("Could not decode config file: %s", err.Error())
+	}
+	if len(file.Schedules) == 0 {
+		return nil, errors.New("No schedules in config file")
+	}
+
+	var schedules []*schedule
+	for idx, raw := range file.Schedules {
+		name := fmt.Sprintf("schedule %d", idx+1)
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		take := addTakeFlags(fs)
+
+		own := make(map[string]string, len(raw))
+		for key, value := range raw {
+			if value == nil {
+				continue
+			}
+			if key != "cron" && fs.Lookup(key) == nil {
+				return nil, fmt.Errorf("Unknown setting %q in %s", key, name)
+			}
+			own[key] = configValue(value)
+		}
+		if len(own["cron"]) == 0 {
+			return nil, fmt.Errorf("\"cron\" must be set in %s", name)
+		}
+		cron, err := parseCron(own["cron"])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s", name, err.Error())
+		}
+
+		merged := make(map[string]string, len(settings))
+		for key, value := range settings {
+			merged[key] = value
+		}
+		for _, key := range dashboardSettings {
+			if _, ok := own[key]; ok {
+				for _, shared := range dashboardSettings {
+					delete(merged, shared)
+				}
+				break
+			}
+		}
+		for key, value := range own {
+			merged[key] = value
+		}
+		if err = applySettings(fs, merged); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+
+		// cron times are in the schedule's time zone
+		location, err := snapshot.ParseLocation(*take.timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid \"timezone\" in %s: %s", name, err.Error())
+		}
+		schedules = append(schedules, &schedule{
+			name:     fmt.Sprintf("%s (%s)", name, own["cron"]),
+			cron:     cron,
+			location: location,
+			take:     take,
+		})
+	}
+	return schedules, nil
+}
+
+// runDaemon takes snapshots on the schedules in the config file until
+// interrupted
+func runDaemon(fs *flag.FlagSet, args []string) error {
+	conn := addConnectionFlags(fs)
+	metricsAddr := fs.String("metrics_addr", "", "The address to serve Prometheus metrics on at /metrics, e.g. \":9090\". Defaults to not serving metrics.")
+	notify := addNotifyFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	notifiers, err := notify.notifiers()
+	if err != nil {
+		return configError(fmt.Errorf("Failed to parse flags: %s", err.Error()))
+	}
+	configPath := fs.Lookup("config").Value.String()
+	if len(configPath) == 0 {
+		return errors.New("\"config\" must be set to a file with schedules")
+	}
+	settings, err := loadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	schedules, err := loadSchedules(configPath, settings)
+	if err != nil {
+		return err
+	}
+	metrics := newPrometheusMetrics()
+	snapclient, config, err := conn.clientWithMetrics(metrics)
+	if err != nil {
+		return err
+	}
+	if len(*metricsAddr) > 0 {
+		listener, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			return fmt.Errorf("Failed to serve metrics: %s", err.Error())
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go http.Serve(listener, mux)
+		config.Logger.Info("Serving metrics", "addr", listener.Addr())
+	}
+
+	// stop on SIGINT or SIGTERM, cancelling any snapshot being taken
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		config.Logger.Info("Stopping", "signal", sig)
+		cancel()
+	}()
+
+	now := time.Now()
+	for _, s := range schedules {
+		if s.next = s.cron.next(now.In(s.location)); s.next.IsZero() {
+			return fmt.Errorf("%s never runs", s.name)
+		}
+		config.Logger.Info("Scheduled next run", "schedule", s.name, "at", s.next.Format(timeLayout+" MST"))
+	}
+	for {
+		next := schedules[0].next
+		for _, s := range schedules[1:] {
+			if s.next.Before(next) {
+				next = s.next
+			}
+		}
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		for _, s := range schedules {
+			if s.next.After(time.Now()) {
+				continue
+			}
+			s.run(ctx, snapclient, config, notifiers)
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.next = s.cron.next(time.Now().In(s.location))
+			config.Logger.Info("Scheduled next run", "schedule", s.name, "at", s.next.Format(timeLayout+" MST"))
+		}
+	}
+}
+
+// run takes a snapshot of each of the schedule's dashboards, logging the
+// results and sending them with the notifiers
+func (s *schedule) run(ctx context.Context, snapclient *snapshot.SnapClient, config *snapshot.Config, notifiers []snapshot.Notifier) {
+	takeConfigs, err := s.take.takeConfigs(snapclient)
+	if err != nil {
+		config.Logger.Error("Failed to parse schedule settings", "schedule", s.name, "error", err)
+		return
+	}
+	for _, takeConfig := range takeConfigs {
+		dashboard := takeConfig.DashSlug + takeConfig.DashUID
+		snapshot, err := snapclient.TakeWithContext(ctx, takeConfig)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			config.Logger.Error("Failed to take snapshot", "schedule", s.name, "dashboard", dashboard, "error", err)
+		} else {
+			config.Logger.Info("Took snapshot", "schedule", s.name, "dashboard", dashboard, "url", snapshotURL(config, snapshot))
+		}
+		for _, notifyErr := range notify(ctx, notifiers, newNotification(config, takeConfig, snapshot, err)) {
+			config.Logger.Error("Failed to notify", "schedule", s.name, "dashboard", dashboard, "error", notifyErr)
+		}
+	}
+}
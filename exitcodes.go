@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot"
+)
+
+// Exit codes, so scripts can tell failures worth retrying, such as a
+// datasource or the snapshot host being down, from those which will fail
+// again
+const (
+	// exitFailure is any other failure
+	exitFailure = 1
+	// exitConfig is invalid flags or config file, as the flag package
+	// exits with for unknown flags
+	exitConfig = 2
+	// exitNotFound is a dashboard which doesn't exist
+	exitNotFound = 3
+	// exitQuery is a datasource query failing
+	exitQuery = 4
+	// exitUpload is posting or writing the snapshot failing
+	exitUpload = 5
+	// exitUnauthorized is Grafana or the snapshot host rejecting the
+	// credentials
+	exitUnauthorized = 6
+)
+
+// codedError is an error which exits with code
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// configError marks err as caused by invalid flags or config
+func configError(err error) error {
+	return &codedError{code: exitConfig, err: err}
+}
+
+// exitCode is the code to exit with for err
+func exitCode(err error) int {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	if errors.Is(err, snapshot.ErrDashboardNotFound) {
+		return exitNotFound
+	}
+	if errors.Is(err, snapshot.ErrUnauthorized) {
+		return exitUnauthorized
+	}
+	var takeErr *snapshot.TakeError
+	if errors.As(err, &takeErr) {
+		switch takeErr.Stage {
+		case snapshot.StageConfig:
+			return exitConfig
+		case snapshot.StageQuery:
+			return exitQuery
+		case snapshot.StageUpload:
+			return exitUpload
+		}
+	}
+	return exitFailure
+}
+
+// commonExitCode is the code to exit with for errs if they all share one,
+// otherwise exitFailure
+func commonExitCode(errs []error) int {
+	code := exitFailure
+	for idx, err := range errs {
+		if idx == 0 {
+			code = exitCode(err)
+		} else if exitCode(err) != code {
+			return exitFailure
+		}
+	}
+	return code
+}
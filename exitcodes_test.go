@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot"
+)
+
+func TestExitCode(t *testing.T) {
+	notFound := &snapshot.TakeError{Stage: snapshot.StageDashboard, Err: fmt.Errorf("%w: %q", snapshot.ErrDashboardNotFound, "my-dash")}
+	queryFailed := &snapshot.TakeError{Stage: snapshot.StageQuery, Err: errors.New("Unexpected status code: 502 Bad Gateway")}
+	exitTests := []struct {
+		purpose  string
+		err      error
+		expected int
+	}{
+		{
+			purpose:  "Other error",
+			err:      errors.New("Failed to list snapshots"),
+			expected: exitFailure,
+		},
+		{
+			purpose:  "Invalid flags",
+			err:      configError(errors.New("\"max_points\" cannot be negative")),
+			expected: exitConfig,
+		},
+		{
+			purpose:  "Invalid TakeConfig",
+			err:      &snapshot.TakeError{Stage: snapshot.StageConfig, Err: errors.New("Invalid time-range")},
+			expected: exitConfig,
+		},
+		{
+			purpose:  "Dashboard not found",
+			err:      fmt.Errorf("Failed to take snapshot: %w", notFound),
+			expected: exitNotFound,
+		},
+		{
+			purpose:  "Grafana unavailable",
+			err:      &snapshot.TakeError{Stage: snapshot.StageDashboard, Err: errors.New("connection refused")},
+			expected: exitFailure,
+		},
+		{
+			purpose:  "Query failed",
+			err:      fmt.Errorf("Failed to take snapshot: %w", queryFailed),
+			expected: exitQuery,
+		},
+		{
+			purpose:  "Upload failed",
+			err:      &snapshot.TakeError{Stage: snapshot.StageUpload, Err: errors.New("Unexpected status code: 413")},
+			expected: exitUpload,
+		},
+		{
+			purpose:  "Credentials rejected",
+			err:      &snapshot.TakeError{Stage: snapshot.StageUpload, Err: fmt.Errorf("%w: The snapshot host responded 401 Unauthorized", snapshot.ErrUnauthorized)},
+			expected: exitUnauthorized,
+		},
+		{
+			purpose:  "Several snapshots failed alike",
+			err:      &codedError{code: commonExitCode([]error{queryFailed, queryFailed}), err: errors.New("Failed to take 2 of 3 snapshots")},
+			expected: exitQuery,
+		},
+		{
+			purpose:  "Several snapshots failed differently",
+			err:      &codedError{code: commonExitCode([]error{queryFailed, notFound}), err: errors.New("Failed to take 2 of 3 snapshots")},
+			expected: exitFailure,
+		},
+	}
+	// test
+	for _, et := range exitTests {
+		if code := exitCode(et.err); code != et.expected {
+			t.Errorf("Test \"%s\" expected exit code %d, got %d", et.purpose, et.expected, code)
+		}
+	}
+}
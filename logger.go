@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Log levels, in increasing severity
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// cliLogger implements snapshot.Logger, writing messages at or above its
+// level to the standard logger as 'LEVEL message key=value ...'
+type cliLogger struct {
+	level int
+}
+
+func newCLILogger(level string) (*cliLogger, error) {
+	for idx, name := range logLevels {
+		if strings.ToLower(level) == name {
+			return &cliLogger{level: idx}, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown log level %q, expected one of %s", level, strings.Join(logLevels, ", "))
+}
+
+func (l *cliLogger) Debug(msg string, args ...interface{}) { l.log(0, msg, args) }
+func (l *cliLogger) Info(msg string, args ...interface{})  { l.log(1, msg, args) }
+func (l *cliLogger) Warn(msg string, args ...interface{})  { l.log(2, msg, args) }
+func (l *cliLogger) Error(msg string, args ...interface{}) { l.log(3, msg, args) }
+
+func (l *cliLogger) log(level int, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString(strings.ToUpper(logLevels[level]))
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for idx := 0; idx < len(args); idx += 2 {
+		if idx+1 == len(args) {
+			fmt.Fprintf(&b, " %v", args[idx])
+			break
+		}
+		value := fmt.Sprint(args[idx+1])
+		if strings.ContainsAny(value, " \"=") || len(value) == 0 {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %v=%s", args[idx], value)
+	}
+	log.Print(b.String())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestCLILogger(t *testing.T) {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	logger, err := newCLILogger("INFO")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	logger.Debug("Requesting data points", "url", "http://grafana/")
+	logger.Error("Failed to take snapshot", "dashboard", "test-slug", "error", errors.New("Not found"))
+	if expected := "ERROR Failed to take snapshot dashboard=test-slug error=\"Not found\"\n"; b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+	if _, err = newCLILogger("verbose"); err == nil {
+		t.Errorf("Unknown level unexpectedly passed")
+	}
+}
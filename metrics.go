@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Buckets of the query latency histogram, in seconds
+var queryDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Buckets of the snapshot payload size histogram, in bytes
+var payloadSizeBuckets = []float64{1 << 10, 1 << 12, 1 << 14, 1 << 16, 1 << 18, 1 << 20, 1 << 22, 1 << 24, 1 << 26}
+
+// histogram counts observations into cumulative buckets, as Prometheus
+// histograms do
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for idx, bound := range h.buckets {
+		if v <= bound {
+			h.counts[idx]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// prometheusMetrics implements snapshot.Metrics, serving the measurements in
+// Prometheus' text format
+type prometheusMetrics struct {
+	mu              sync.Mutex
+	taken           uint64
+	lastSuccess     time.Time
+	failures        map[string]uint64
+	queryFailures   map[string]uint64
+	queryDurations  map[string]*histogram
+	payloadSizes    *histogram
+	takeDurationSum float64
+}
+
+func newPrometheusMetrics() *prometheusMetrics {
+	return &prometheusMetrics{
+		failures:       make(map[string]uint64),
+		queryFailures:  make(map[string]uint64),
+		queryDurations: make(map[string]*histogram),
+		payloadSizes:   newHistogram(payloadSizeBuckets),
+	}
+}
+
+func (m *prometheusMetrics) SnapshotTaken(size int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.taken++
+	m.lastSuccess = time.Now()
+	m.payloadSizes.observe(float64(size))
+	m.takeDurationSum += duration.Seconds()
+}
+
+func (m *prometheusMetrics) SnapshotFailed(stage string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[stage]++
+}
+
+func (m *prometheusMetrics) QueryDone(datasourceType string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.queryDurations[datasourceType]
+	if !ok {
+		h = newHistogram(queryDurationBuckets)
+		m.queryDurations[datasourceType] = h
+	}
+	h.observe(duration.Seconds())
+	if err != nil {
+		m.queryFailures[datasourceType]++
+	}
+}
+
+func (m *prometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// write writes the metrics in Prometheus' text exposition format
+func (m *prometheusMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP snapshot_grafana_snapshots_taken_total Snapshots taken successfully.")
+	fmt.Fprintln(w, "# TYPE snapshot_grafana_snapshots_taken_total counter")
+	fmt.Fprintf(w, "snapshot_grafana_snapshots_taken_total %d\n", m.taken)
+
+	fmt.Fprintln(w, "# HELP snapshot_grafana_snapshot_failures_total Snapshots which failed, by the stage they failed at.")
+	fmt.Fprintln(w, "# TYPE snapshot_grafana_snapshot_failures_total counter")
+	for _, stage := range sortedKeys(m.failures) {
+		fmt.Fprintf(w, "snapshot_grafana_snapshot_failures_total{stage=%q} %d\n", stage, m.failures[stage])
+	}
+
+	fmt.Fprintln(w, "# HELP snapshot_grafana_last_success_timestamp_seconds When a snapshot was last taken successfully.")
+	fmt.Fprintln(w, "# TYPE snapshot_grafana_last_success_timestamp_seconds gauge")
+	lastSuccess := float64(0)
+	if !m.lastSuccess.IsZero() {
+		lastSuccess = float64(m.lastSuccess.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(w, "snapshot_grafana_last_success_timestamp_seconds %s\n", formatFloat(lastSuccess))
+
+	fmt.Fprintln(w, "# HELP snapshot_grafana_snapshot_duration_seconds_total Time spent taking successful snapshots.")
+	fmt.Fprintln(w, "# TYPE snapshot_grafana_snapshot_duration_seconds_total counter")
+	fmt.Fprintf(w, "snapshot_grafana_snapshot_duration_seconds_total %s\n", formatFloat(m.takeDurationSum))
+
+	fmt.Fprintln(w, "# HELP snapshot_grafana_snapshot_payload_bytes Size of the snapshots taken.")
+	fmt.Fprintln(w, "# TYPE snapshot_grafana_snapshot_payload_bytes histogram")
+	writeHistogram(w, "snapshot_grafana_snapshot_payload_bytes", "", m.payloadSizes)
+
+	fmt.Fprintln(w, "# HELP snapshot_grafana_query_duration_seconds Latency of datasource queries, by datasource type.")
+	fmt.Fprintln(w, "# TYPE snapshot_grafana_query_duration_seconds histogram")
+	for _, datasourceType := range sortedKeys(m.queryDurations) {
+		labels := fmt.Sprintf("datasource_type=%q", datasourceType)
+		writeHistogram(w, "snapshot_grafana_query_duration_seconds", labels, m.queryDurations[datasourceType])
+	}
+
+	fmt.Fprintln(w, "# HELP snapshot_grafana_query_failures_total Datasource queries which failed, by datasource type.")
+	fmt.Fprintln(w, "# TYPE snapshot_grafana_query_failures_total counter")
+	for _, datasourceType := range sortedKeys(m.queryFailures) {
+		fmt.Fprintf(w, "snapshot_grafana_query_failures_total{datasource_type=%q} %d\n", datasourceType, m.queryFailures[datasourceType])
+	}
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	prefix := ""
+	if len(labels) > 0 {
+		prefix = labels + ","
+	}
+	for idx, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, prefix, formatFloat(bound), h.counts[idx])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+	if len(labels) > 0 {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]uint64:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]*histogram:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := newPrometheusMetrics()
+	m.QueryDone("prometheus", 200*time.Millisecond, nil)
+	m.QueryDone("prometheus", 3*time.Second, errors.New("timeout"))
+	m.SnapshotFailed(snapshot.StageQuery)
+	m.SnapshotTaken(5000, time.Second)
+
+	var b bytes.Buffer
+	m.write(&b)
+	out := b.String()
+	for _, expected := range []string{
+		"snapshot_grafana_snapshots_taken_total 1\n",
+		"snapshot_grafana_snapshot_failures_total{stage=\"query\"} 1\n",
+		"snapshot_grafana_query_duration_seconds_bucket{datasource_type=\"prometheus\",le=\"0.25\"} 1\n",
+		"snapshot_grafana_query_duration_seconds_bucket{datasource_type=\"prometheus\",le=\"5\"} 2\n",
+		"snapshot_grafana_query_duration_seconds_bucket{datasource_type=\"prometheus\",le=\"+Inf\"} 2\n",
+		"snapshot_grafana_query_duration_seconds_sum{datasource_type=\"prometheus\"} 3.2\n",
+		"snapshot_grafana_query_failures_total{datasource_type=\"prometheus\"} 1\n",
+		"snapshot_grafana_snapshot_payload_bytes_bucket{le=\"4096\"} 0\n",
+		"snapshot_grafana_snapshot_payload_bytes_bucket{le=\"16384\"} 1\n",
+		"snapshot_grafana_snapshot_payload_bytes_count 1\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot"
+)
+
+// notifyFlags are the flags configuring how the result of each snapshot is
+// announced
+type notifyFlags struct {
+	webhookURL     *string
+	webhookMethod  *string
+	webhookHeaders *string
+	webhookBody    *string
+	slackWebhook   *string
+	slackChannel   *string
+	slackThumbnail *bool
+	smtpAddr       *string
+	smtpFrom       *string
+	smtpTo         *listFlag
+}
+
+func addNotifyFlags(fs *flag.FlagSet) *notifyFlags {
+	f := &notifyFlags{
+		webhookURL:     fs.String("webhook_url", "", "Call this URL with the result of each snapshot, successful or not: its status, dashboard, title, URL, time range and any error."),
+		webhookMethod:  fs.String("webhook_method", "POST", "The HTTP method of the webhook request."),
+		webhookHeaders: fs.String("webhook_headers", "", "Headers to send with the webhook request, in the format 'Name1=value1;Name2=value2'."),
+		webhookBody:    fs.String("webhook_body", "", "A Go template for the webhook request body, like '{\"text\": {{json .URL}}}', given the result's .Status, .Org, .Dashboard, .Title, .URL, .From, .To, .Error, .Images and .Report. Defaults to the result as JSON."),
+		slackWebhook:   fs.String("slack_webhook_url", "", "Post a link to each snapshot, or why it failed, with this Slack incoming webhook."),
+		slackChannel:   fs.String("slack_channel", "", "Post a link to each snapshot, or why it failed, to this Slack channel as the bot whose token is in the SLACK_BOT_TOKEN environment variable."),
+		slackThumbnail: fs.Bool("slack_thumbnail", false, "Post the snapshot's image rendered with \"render_dir\" to \"slack_channel\" with the link. Channels must be given by ID to post images."),
+		smtpAddr:       fs.String("smtp_addr", "", "Email each snapshot's URL, or why it failed, through this mail server, e.g. \"smtp.myorg.com:587\", with any images and report exported attached. Authenticates as the SMTP_USERNAME and SMTP_PASSWORD environment variables, if set."),
+		smtpFrom:       fs.String("smtp_from", "", "The address emails are sent from."),
+		smtpTo:         &listFlag{},
+	}
+	fs.Var(f.smtpTo, "smtp_to", "The addresses to email. Repeat or comma separate for several.")
+	return f
+}
+
+// notifiers returns a Notifier for each way of announcing results which is
+// configured
+func (f *notifyFlags) notifiers() ([]snapshot.Notifier, error) {
+	var notifiers []snapshot.Notifier
+	if len(*f.webhookURL) > 0 {
+		webhookURL, err := url.Parse(*f.webhookURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid \"webhook_url\": %s", err.Error())
+		}
+		config := &snapshot.WebhookConfig{URL: webhookURL, Method: *f.webhookMethod, Headers: make(map[string]string), BodyTemplate: *f.webhookBody}
+		for _, pairS := range strings.Split(*f.webhookHeaders, ";") {
+			if len(strings.TrimSpace(pairS)) == 0 {
+				continue
+			}
+			pairA := strings.SplitN(pairS, "=", 2)
+			if len(pairA) != 2 || len(strings.TrimSpace(pairA[0])) == 0 {
+				return nil, errors.New("\"webhook_headers\" contained an invalid pairing: \"" + pairS + "\"")
+			}
+			config.Headers[strings.TrimSpace(pairA[0])] = strings.TrimSpace(pairA[1])
+		}
+		webhook, err := snapshot.NewWebhook(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	if len(*f.slackWebhook) > 0 || len(*f.slackChannel) > 0 {
+		config, err := snapshot.SlackConfigFromEnv(*f.slackChannel)
+		if err != nil {
+			return nil, err
+		}
+		// the flags choose between the webhook and the bot
+		config.WebhookURL = nil
+		if len(*f.slackChannel) == 0 {
+			config.Token = ""
+			if config.WebhookURL, err = url.Parse(*f.slackWebhook); err != nil {
+				return nil, fmt.Errorf("Invalid \"slack_webhook_url\": %s", err.Error())
+			}
+		} else if len(config.Token) == 0 {
+			return nil, errors.New("\"slack_channel\" requires the SLACK_BOT_TOKEN environment variable")
+		}
+		config.Thumbnail = *f.slackThumbnail
+		slack, err := snapshot.NewSlack(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, slack)
+	}
+	if len(*f.smtpAddr) > 0 {
+		config, err := snapshot.SMTPConfigFromEnv(*f.smtpAddr)
+		if err != nil {
+			return nil, err
+		}
+		config.From = *f.smtpFrom
+		config.To = *f.smtpTo
+		smtp, err := snapshot.NewSMTP(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, smtp)
+	}
+	return notifiers, nil
+}
+
+// newNotification returns the result of taking a snapshot for notifiers. A
+// snapshot which was taken but failed to export still has its URL.
+func newNotification(config *snapshot.Config, takeConfig *snapshot.TakeConfig, snap *snapshot.Snapshot, err error) *snapshot.Notification {
+	n := &snapshot.Notification{
+		Status:    snapshot.NotifySuccess,
+		Dashboard: takeConfig.DashSlug + takeConfig.DashUID,
+		From:      *takeConfig.From,
+		To:        *takeConfig.To,
+	}
+	if snap != nil {
+		n.Title = snap.Title
+		if len(takeConfig.OutputPath) == 0 {
+			n.URL = snapshotURL(config, snap)
+		}
+	}
+	if err != nil {
+		n.Status = snapshot.NotifyFailure
+		n.Error = err.Error()
+	}
+	return n
+}
+
+// notify sends a notification with each notifier, returning an error for
+// each which fails, so one failing doesn't stop the others
+func notify(ctx context.Context, notifiers []snapshot.Notifier, n *snapshot.Notification) []error {
+	var errs []error
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(ctx, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
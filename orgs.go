@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// org is a Grafana organization to take the same snapshots in, listed under
+// "orgs" in the config file with its own connection flag settings, e.g. its
+// org_id and grafana_api_key
+type org struct {
+	name     string
+	settings map[string]string
+}
+
+// loadOrgs reads the "orgs" list of a config file, if it has one. Each org is
+// named by its "name" setting, or otherwise its org_id.
+func loadOrgs(path string) ([]org, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %s", err.Error())
+	}
+	var raw struct {
+		Orgs []map[string]interface{} `yaml:"orgs"`
+	}
+	if err = yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("Could not decode config file: %s", err.Error())
+	}
+	orgs := make([]org, 0, len(raw.Orgs))
+	for idx, entry := range raw.Orgs {
+		o := org{settings: make(map[string]string, len(entry))}
+		for name, value := range entry {
+			if value != nil {
+				o.settings[name] = configValue(value)
+			}
+		}
+		o.name = o.settings["name"]
+		delete(o.settings, "name")
+		if len(o.name) == 0 {
+			if len(o.settings["org_id"]) == 0 {
+				return nil, fmt.Errorf("Entry %d of \"orgs\" in config file needs an \"org_id\" or \"name\"", idx+1)
+			}
+			o.name = "org " + o.settings["org_id"]
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs, nil
+}
+
+// connectionFlags returns the connection flags of fs overridden by the org's
+// settings. Only connection flags can be set per org.
+func (o org) connectionFlags(fs *flag.FlagSet) (*connectionFlags, error) {
+	orgFS := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	conn := addConnectionFlags(orgFS)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if orgFS.Lookup(f.Name) != nil && err == nil {
+			err = orgFS.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range o.settings {
+		if orgFS.Lookup(name) == nil {
+			return nil, fmt.Errorf("Invalid setting %q for %s in config file: only connection flags can be set per org", name, o.name)
+		}
+	}
+	if err = applySettings(orgFS, o.settings); err != nil {
+		return nil, fmt.Errorf("%s: %s", o.name, err.Error())
+	}
+	return conn, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestOrgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-orgs")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	orgTests := []struct {
+		purpose  string
+		config   string
+		valid    bool
+		expected []string
+	}{
+		{
+			purpose:  "No orgs",
+			config:   "grafana_api_key: XXXXX\n",
+			valid:    true,
+			expected: []string{},
+		},
+		{
+			purpose: "Orgs",
+			config: `grafana_api_key: XXXXX
+dashboard_uid: abc
+orgs:
+  - org_id: 1
+  - org_id: 2
+    name: staging
+    grafana_api_key: YYYYY
+`,
+			valid:    true,
+			expected: []string{"org 1:1:XXXXX", "staging:2:YYYYY"},
+		},
+		{
+			purpose: "Org without org_id or name",
+			config: `orgs:
+  - grafana_api_key: YYYYY
+`,
+			valid: false,
+		},
+		{
+			purpose: "Org with take flag",
+			config: `orgs:
+  - org_id: 2
+    dashboard_uid: abc
+`,
+			valid: false,
+		},
+	}
+	// test
+	for idx, ot := range orgTests {
+		path := filepath.Join(dir, "config"+strconv.Itoa(idx)+".yaml")
+		if err = ioutil.WriteFile(path, []byte(ot.config), 0600); err != nil {
+			t.Fatalf("Unexpectedly failed: %s", err.Error())
+		}
+		fs := flag.NewFlagSet("take", flag.ContinueOnError)
+		addConnectionFlags(fs)
+		addTakeFlags(fs)
+		if err = parseFlags(fs, []string{"-config", path}); err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", ot.purpose, err.Error())
+		}
+
+		orgs, err := loadOrgs(path)
+		out := []string{}
+		for _, o := range orgs {
+			orgConn, connErr := o.connectionFlags(fs)
+			if connErr != nil {
+				err = connErr
+				break
+			}
+			out = append(out, o.name+":"+strconv.FormatInt(*orgConn.orgID, 10)+":"+*orgConn.grafanaAPIKey)
+		}
+		if ot.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", ot.purpose, err.Error())
+		} else if !ot.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", ot.purpose)
+		} else if ot.valid && !reflect.DeepEqual(out, ot.expected) {
+			t.Errorf("Test \"%s\" expected orgs %q, got %q", ot.purpose, ot.expected, out)
+		}
+	}
+}
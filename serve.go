@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot"
+)
+
+// snapshotRequest is the body of a POST /snapshot request. Fields other than
+// vars are named after take's flags, and take the same values.
+type snapshotRequest struct {
+	DashSlug        string            `json:"dashboard_slug"`
+	DashUID         string            `json:"dashboard_uid"`
+	From            string            `json:"from"`
+	To              string            `json:"to"`
+	Range           string            `json:"range"`
+	Timezone        string            `json:"timezone"`
+	Vars            map[string]string `json:"vars"`
+	RefreshVars     bool              `json:"refresh_vars"`
+	SnapshotName    string            `json:"snapshot_name"`
+	SnapshotKey     string            `json:"snapshot_key"`
+	SnapshotExpires string            `json:"snapshot_expires"`
+	MaxPoints       int               `json:"max_points"`
+	Downsample      string            `json:"downsample"`
+	External        bool              `json:"external"`
+	Panels          []string          `json:"panels"`
+	ExcludePanels   []string          `json:"exclude_panels"`
+	AnnotationTags  []string          `json:"annotation_tags"`
+}
+
+// settings returns the request as take flag settings
+func (r *snapshotRequest) settings() map[string]string {
+	settings := map[string]string{
+		"dashboard_slug":   r.DashSlug,
+		"dashboard_uid":    r.DashUID,
+		"from":             r.From,
+		"to":               r.To,
+		"range":            r.Range,
+		"timezone":         r.Timezone,
+		"snapshot_name":    r.SnapshotName,
+		"snapshot_key":     r.SnapshotKey,
+		"snapshot_expires": r.SnapshotExpires,
+		"downsample":       r.Downsample,
+	}
+	for name, value := range settings {
+		if len(value) == 0 {
+			delete(settings, name)
+		}
+	}
+	if r.RefreshVars {
+		settings["refresh_vars"] = "true"
+	}
+	if r.External {
+		settings["external"] = "true"
+	}
+	if len(r.Panels) > 0 {
+		settings["panels"] = strings.Join(r.Panels, ",")
+	}
+	if len(r.ExcludePanels) > 0 {
+		settings["exclude_panels"] = strings.Join(r.ExcludePanels, ",")
+	}
+	if len(r.AnnotationTags) > 0 {
+		settings["annotation_tags"] = strings.Join(r.AnnotationTags, ",")
+	}
+	if r.MaxPoints != 0 {
+		settings["max_points"] = strconv.Itoa(r.MaxPoints)
+	}
+	return settings
+}
+
+// maxRequestBody is the largest POST /snapshot body read
+const maxRequestBody = 1 << 20
+
+// snapshotHandler takes a snapshot for each POST /snapshot request
+type snapshotHandler struct {
+	snapclient *snapshot.SnapClient
+	logger     snapshot.Logger
+	// token, if set, must be given as a bearer token
+	token string
+}
+
+func (h *snapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("Snapshots must be requested with POST"))
+		return
+	}
+	if len(h.token) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.token)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, errors.New("Invalid or missing bearer token"))
+		return
+	}
+
+	var req snapshotRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errors.New("Could not decode request json: "+err.Error()))
+		return
+	}
+	if len(req.DashSlug) == 0 && len(req.DashUID) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("\"dashboard_slug\" or \"dashboard_uid\" must be set"))
+		return
+	}
+	if len(req.DashSlug) > 0 && len(req.DashUID) > 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("Only one of \"dashboard_slug\" and \"dashboard_uid\" can be set"))
+		return
+	}
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	take := addTakeFlags(fs)
+	if err := applySettings(fs, req.settings()); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	takeConfigs, err := take.takeConfigs(h.snapclient)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	takeConfig := takeConfigs[0]
+	if req.Vars != nil {
+		takeConfig.Vars = req.Vars
+	}
+
+	snap, err := h.snapclient.TakeWithContext(r.Context(), takeConfig)
+	if err != nil {
+		h.logger.Error("Failed to take snapshot", "dashboard", takeConfig.DashSlug+takeConfig.DashUID, "error", err)
+		writeJSONError(w, http.StatusBadGateway, errors.New("Failed to take snapshot: "+err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// runServe serves an HTTP endpoint taking snapshots on demand
+func runServe(fs *flag.FlagSet, args []string) error {
+	conn := addConnectionFlags(fs)
+	listen := fs.String("listen", "127.0.0.1:8080", "The address to listen on. Addresses other than loopback ones require \"token\".")
+	token := fs.String("token", "", "A token requests must give in an \"Authorization: Bearer <token>\" header. Defaults to no authentication, only allowed on a loopback address.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if len(*token) == 0 && !loopbackAddr(*listen) {
+		return fmt.Errorf("Listening on %q requires \"token\", as anyone who can reach it could snapshot with Grafana's credentials", *listen)
+	}
+
+	metrics := newPrometheusMetrics()
+	snapclient, config, err := conn.clientWithMetrics(metrics)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/snapshot", &snapshotHandler{snapclient: snapclient, logger: config.Logger, token: *token})
+	mux.Handle("/metrics", metrics)
+	config.Logger.Info("Listening", "addr", *listen)
+	return http.ListenAndServe(*listen, mux)
+}
+
+// loopbackAddr reports whether a listen address is only reachable from the
+// host: a loopback IP or localhost. An empty host is every interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotHandler(t *testing.T) {
+	handlerTests := []struct {
+		purpose  string
+		method   string
+		token    string
+		body     string
+		expected int
+	}{
+		{
+			purpose:  "Wrong method",
+			method:   "GET",
+			expected: http.StatusMethodNotAllowed,
+		},
+		{
+			purpose:  "Missing token",
+			method:   "POST",
+			token:    "",
+			body:     `{"dashboard_slug": "test-slug"}`,
+			expected: http.StatusUnauthorized,
+		},
+		{
+			purpose:  "Invalid json",
+			method:   "POST",
+			token:    "secret",
+			body:     `{"dashboard_slug": `,
+			expected: http.StatusBadRequest,
+		},
+		{
+			purpose:  "No dashboard",
+			method:   "POST",
+			token:    "secret",
+			body:     `{"from": "now-6h"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			purpose:  "Slug and UID",
+			method:   "POST",
+			token:    "secret",
+			body:     `{"dashboard_slug": "test-slug", "dashboard_uid": "Abc123"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			purpose:  "Invalid expiry",
+			method:   "POST",
+			token:    "secret",
+			body:     `{"dashboard_slug": "test-slug", "snapshot_expires": "soon"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			purpose:  "Unknown range",
+			method:   "POST",
+			token:    "secret",
+			body:     `{"dashboard_slug": "test-slug", "range": "fortnight", "timezone": "utc"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			purpose:  "Body too large",
+			method:   "POST",
+			token:    "secret",
+			body:     `{"dashboard_slug": "test-slug", "snapshot_name": "` + strings.Repeat("x", maxRequestBody) + `"}`,
+			expected: http.StatusBadRequest,
+		},
+	}
+	// test
+	handler := &snapshotHandler{token: "secret"}
+	for _, tt := range handlerTests {
+		req := httptest.NewRequest(tt.method, "/snapshot", strings.NewReader(tt.body))
+		if len(tt.token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.expected {
+			t.Errorf("Test \"%s\" expected status %d, got %d: %s", tt.purpose, tt.expected, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	addrTests := []struct {
+		addr     string
+		loopback bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"[::1]:8080", true},
+		{"localhost:8080", true},
+		{":8080", false},
+		{"0.0.0.0:8080", false},
+		{"10.0.0.1:8080", false},
+		{"8080", false},
+	}
+	for _, at := range addrTests {
+		if out := loopbackAddr(at.addr); out != at.loopback {
+			t.Errorf("Address %q expected loopback %t, got %t", at.addr, at.loopback, out)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// unifiedAlerting reports whether Grafana uses unified alerting, the default
+// from Grafana 9, rather than legacy dashboard alerts. Grafanas too old to say
+// are taken to use legacy alerts.
+func (sc *SnapClient) unifiedAlerting(ctx context.Context) bool {
+	return sc.grafana(ctx).unifiedAlerting
+}
+
+// alertAnnotations returns the dashboard's alert state changes, given the
+// query of its built-in annotations. They're untagged, so aren't filtered by
+// the AnnotationTags. Unified alerting annotates dashboards by UID.
+func (sc *SnapClient) alertAnnotations(ctx context.Context, query url.Values, dashboard map[string]interface{}, unified bool) ([]interface{}, error) {
+	alertQuery := url.Values{}
+	for _, param := range []string{"from", "to", "limit", "dashboardId", "dashboardUID"} {
+		if value := query.Get(param); len(value) > 0 {
+			alertQuery.Set(param, value)
+		}
+	}
+	alertQuery.Set("type", "alert")
+	if uid, ok := dashboard["uid"].(string); ok && unified {
+		alertQuery.Del("dashboardId")
+		alertQuery.Set("dashboardUID", uid)
+	}
+	return sc.getAnnotations(ctx, alertQuery)
+}
+
+// snapshotAlertStates sets the state of each of the dashboard's panels with
+// an alert, as Grafana shows it, e.g. "alerting" or "ok". Failing to get the
+// states only warns, as they aren't needed to show the panels.
+func (sc *SnapClient) snapshotAlertStates(ctx context.Context, dashboard map[string]interface{}, unified bool) {
+	var states map[int64]string
+	var err error
+	if unified {
+		states, err = sc.unifiedAlertStates(ctx, dashboard)
+	} else {
+		states, err = sc.legacyAlertStates(ctx, dashboard)
+	}
+	if err != nil {
+		sc.warn(ctx, "Failed to get alert states", "error", err)
+		return
+	}
+	for _, panel := range dashboardPanels(dashboard) {
+		id, _ := panel["id"].(float64)
+		if state, ok := states[int64(id)]; ok {
+			panel["alertState"] = state
+		}
+	}
+}
+
+// legacyAlertStates returns the states of the dashboard's legacy alerts, by
+// panel ID. Only dashboards with panels with an alert are looked up.
+func (sc *SnapClient) legacyAlertStates(ctx context.Context, dashboard map[string]interface{}) (map[int64]string, error) {
+	hasAlerts := false
+	for _, panel := range dashboardPanels(dashboard) {
+		if _, ok := panel["alert"]; ok {
+			hasAlerts = true
+		}
+	}
+	id, ok := dashboard["id"].(float64)
+	if !hasAlerts || !ok {
+		return nil, nil
+	}
+	body, err := sc.grafanaGet(ctx, "api/alerts", url.Values{"dashboardId": {strconv.FormatInt(int64(id), 10)}})
+	if err != nil {
+		return nil, err
+	}
+	var alerts []struct {
+		PanelID int64  `json:"panelId"`
+		State   string `json:"state"`
+	}
+	if err = json.Unmarshal(body, &alerts); err != nil {
+		return nil, err
+	}
+	states := make(map[int64]string)
+	for _, alert := range alerts {
+		states[alert.PanelID] = alert.State
+	}
+	return states, nil
+}
+
+// The panel states of unified alert rule states, as legacy alerts name them
+var unifiedPanelStates = map[string]string{
+	"firing":   "alerting",
+	"pending":  "pending",
+	"inactive": "ok",
+	"normal":   "ok",
+}
+
+// unifiedAlertStates returns the states of the unified alert rules linked to
+// the dashboard's panels, by panel ID. A panel with several rules takes the
+// state of its worst.
+func (sc *SnapClient) unifiedAlertStates(ctx context.Context, dashboard map[string]interface{}) (map[int64]string, error) {
+	uid, ok := dashboard["uid"].(string)
+	if !ok {
+		return nil, nil
+	}
+	body, err := sc.grafanaGet(ctx, "api/prometheus/grafana/api/v1/rules", url.Values{"dashboard_uid": {uid}})
+	if err != nil {
+		return nil, err
+	}
+	var rules struct {
+		Data struct {
+			Groups []struct {
+				Rules []struct {
+					State       string            `json:"state"`
+					Annotations map[string]string `json:"annotations"`
+				} `json:"rules"`
+			} `json:"groups"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &rules); err != nil {
+		return nil, err
+	}
+	states := make(map[int64]string)
+	for _, group := range rules.Data.Groups {
+		for _, rule := range group.Rules {
+			if rule.Annotations["__dashboardUid__"] != uid {
+				continue
+			}
+			panelID, err := strconv.ParseInt(rule.Annotations["__panelId__"], 10, 64)
+			if err != nil {
+				continue
+			}
+			state, ok := unifiedPanelStates[rule.State]
+			if !ok {
+				continue
+			}
+			if current, ok := states[panelID]; !ok || alertStateRank(state) > alertStateRank(current) {
+				states[panelID] = state
+			}
+		}
+	}
+	return states, nil
+}
+
+// alertStateRank orders panel alert states from ok to alerting
+func alertStateRank(state string) int {
+	switch state {
+	case "alerting":
+		return 2
+	case "pending":
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSnapshotAlertStates(t *testing.T) {
+	unified := false
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/frontend/settings":
+			json.NewEncoder(w).Encode(map[string]interface{}{"unifiedAlertingEnabled": unified})
+		case r.URL.Path == "/api/alerts" && r.URL.Query().Get("dashboardId") == "7":
+			w.Write([]byte(`[{"id": 3, "panelId": 2, "state": "alerting"}, {"id": 4, "panelId": 9, "state": "ok"}]`))
+		case r.URL.Path == "/api/prometheus/grafana/api/v1/rules" && r.URL.Query().Get("dashboard_uid") == "abc":
+			w.Write([]byte(`{"status": "success", "data": {"groups": [{"name": "api", "rules": [
+				{"name": "Latency", "state": "inactive", "annotations": {"__dashboardUid__": "abc", "__panelId__": "1"}},
+				{"name": "Errors", "state": "firing", "annotations": {"__dashboardUid__": "abc", "__panelId__": "2"}},
+				{"name": "Saturation", "state": "pending", "annotations": {"__dashboardUid__": "abc", "__panelId__": "2"}},
+				{"name": "Other", "state": "firing", "annotations": {"__dashboardUid__": "xyz", "__panelId__": "1"}}
+			]}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	newDashboard := func() map[string]interface{} {
+		var dashboard map[string]interface{}
+		json.Unmarshal([]byte(`{"id": 7, "uid": "abc", "panels": [{"id": 1}, {"type": "row", "panels": [{"id": 2, "alert": {"name": "Errors"}}]}]}`), &dashboard)
+		return dashboard
+	}
+	stateTests := []struct {
+		purpose  string
+		unified  bool
+		expected []interface{}
+	}{
+		{
+			purpose:  "Legacy alerting",
+			unified:  false,
+			expected: []interface{}{nil, nil, "alerting"},
+		},
+		{
+			purpose:  "Unified alerting",
+			unified:  true,
+			expected: []interface{}{"ok", nil, "alerting"},
+		},
+	}
+	// test
+	for _, st := range stateTests {
+		unified = st.unified
+		// detect Grafana afresh, rather than waiting to retry
+		sc.grafanaInfo = nil
+		if detected := sc.unifiedAlerting(context.Background()); detected != st.unified {
+			t.Errorf("Test \"%s\" expected unified alerting to be %t, got %t", st.purpose, st.unified, detected)
+		}
+		dashboard := newDashboard()
+		sc.snapshotAlertStates(context.Background(), dashboard, st.unified)
+		for idx, panel := range dashboardPanels(dashboard) {
+			if state := panel["alertState"]; state != st.expected[idx] {
+				t.Errorf("Test \"%s\" expected panel %d alert state %v, got %v", st.purpose, idx, st.expected[idx], state)
+			}
+		}
+	}
+
+	// a Grafana without the alerts API only warns
+	ctx, warnings := contextWithWarnings(context.Background())
+	dashboard := newDashboard()
+	dashboard["id"] = float64(8)
+	sc.snapshotAlertStates(ctx, dashboard, false)
+	if len(warnings.warnings()) != 1 {
+		t.Errorf("Expected a warning, got %q", warnings.warnings())
+	}
+}
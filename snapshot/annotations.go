@@ -0,0 +1,161 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The most annotations an annotation query finds if it doesn't set a limit,
+// as in Grafana
+const defaultAnnotationLimit = 100
+
+// snapshotAnnotations replaces the dashboard's enabled annotation queries with
+// the annotations they find in the time range, as Grafana does when sharing a
+// snapshot, and drops its disabled ones. The built-in query also finds the
+// dashboard's alert annotations. Only queries of Grafana's own
+// annotations are supported; others are kept without annotations.
+func (sc *SnapClient) snapshotAnnotations(ctx context.Context, c *TakeConfig, dashboard map[string]interface{}, unified bool) error {
+	annotations, _ := dashboard["annotations"].(map[string]interface{})
+	if annotations == nil {
+		return nil
+	}
+	list, _ := annotations["list"].([]interface{})
+	snapshotList := []interface{}{}
+	for _, a := range list {
+		annotation, ok := a.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if enable, _ := annotation["enable"].(bool); !enable {
+			continue
+		}
+		name, _ := annotation["name"].(string)
+		events := []interface{}{}
+		if query, ok := annotationQuery(c, dashboard, annotation); ok {
+			found, err := sc.getAnnotations(ctx, query)
+			if err != nil {
+				return fmt.Errorf("Failed to get annotations of %q: %s", name, err.Error())
+			}
+			events = filterAnnotations(found, c.AnnotationTags)
+			if builtIn, _ := annotation["builtIn"].(float64); builtIn == 1 {
+				alerts, err := sc.alertAnnotations(ctx, query, dashboard, unified)
+				if err != nil {
+					return fmt.Errorf("Failed to get alert annotations: %s", err.Error())
+				}
+				events = append(events, alerts...)
+			}
+		} else {
+			sc.warn(ctx, "Skipping annotation query: unsupported datasource", "name", name)
+		}
+		snapshotList = append(snapshotList, map[string]interface{}{
+			"name":         name,
+			"enable":       true,
+			"iconColor":    annotation["iconColor"],
+			"type":         annotation["type"],
+			"builtIn":      annotation["builtIn"],
+			"hide":         annotation["hide"],
+			"snapshotData": events,
+		})
+	}
+	annotations["list"] = snapshotList
+	return nil
+}
+
+// annotationQuery returns the /api/annotations query params for an annotation
+// query of Grafana's own annotations, or false if it's of another datasource.
+// The dashboard's built-in query finds only the dashboard's annotations, and a
+// tags query those with its tags.
+func annotationQuery(c *TakeConfig, dashboard, annotation map[string]interface{}) (url.Values, bool) {
+	if !grafanaAnnotations(annotation) {
+		return nil, false
+	}
+	// newer Grafana versions keep the query in its target
+	target, ok := annotation["target"].(map[string]interface{})
+	if !ok {
+		target = annotation
+	}
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(c.From.UnixNano()/int64(time.Millisecond), 10))
+	query.Set("to", strconv.FormatInt(c.To.UnixNano()/int64(time.Millisecond), 10))
+	query.Set("type", "annotation")
+	limit := defaultAnnotationLimit
+	if l, ok := target["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+	query.Set("limit", strconv.Itoa(limit))
+
+	tags := c.AnnotationTags
+	if queryType, _ := target["type"].(string); queryType == "tags" {
+		tags = variableStrings(target["tags"])
+		if matchAny, _ := target["matchAny"].(bool); matchAny {
+			// the AnnotationTags are left to filterAnnotations, as
+			// the API can't match all of them and any of these
+			query.Set("matchAny", "true")
+		} else {
+			tags = append(tags, c.AnnotationTags...)
+		}
+	} else if id, ok := dashboard["id"].(float64); ok {
+		query.Set("dashboardId", strconv.FormatInt(int64(id), 10))
+	} else if uid, ok := dashboard["uid"].(string); ok {
+		query.Set("dashboardUID", uid)
+	}
+	for _, tag := range tags {
+		query.Add("tags", tag)
+	}
+	return query, true
+}
+
+// grafanaAnnotations reports whether an annotation query is of Grafana's own
+// annotations, which the built-in query always is
+func grafanaAnnotations(annotation map[string]interface{}) bool {
+	if builtIn, _ := annotation["builtIn"].(float64); builtIn == 1 {
+		return true
+	}
+	switch ds := annotation["datasource"].(type) {
+	case string:
+		return ds == "-- Grafana --" || ds == "grafana"
+	case map[string]interface{}:
+		return ds["uid"] == "grafana" || ds["uid"] == "-- Grafana --"
+	}
+	return false
+}
+
+// filterAnnotations returns the annotations which have all of tags
+func filterAnnotations(events []interface{}, tags []string) []interface{} {
+	if len(tags) == 0 {
+		return events
+	}
+	filtered := []interface{}{}
+	for _, e := range events {
+		event, _ := e.(map[string]interface{})
+		has := make(map[string]bool)
+		for _, tag := range variableStrings(event["tags"]) {
+			has[tag] = true
+		}
+		hasAll := true
+		for _, tag := range tags {
+			hasAll = hasAll && has[tag]
+		}
+		if hasAll {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// getAnnotations returns the annotations matching an /api/annotations query
+func (sc *SnapClient) getAnnotations(ctx context.Context, query url.Values) ([]interface{}, error) {
+	body, err := sc.grafanaAPI().Annotations(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var events []interface{}
+	if err = json.Unmarshal(body, &events); err != nil {
+		return nil, fmt.Errorf("Could not decode annotations json: %s", err.Error())
+	}
+	return events, nil
+}
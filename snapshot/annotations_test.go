@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnnotationQuery(t *testing.T) {
+	from := time.Unix(1500000000, 0)
+	to := time.Unix(1500003600, 0)
+	dashboard := map[string]interface{}{"id": float64(7), "uid": "abc"}
+	queryTests := []struct {
+		purpose    string
+		tags       []string
+		annotation string
+		valid      bool
+		expected   url.Values
+	}{
+		{
+			purpose:    "Built-in query",
+			annotation: `{"builtIn": 1, "datasource": "-- Grafana --", "enable": true, "type": "dashboard"}`,
+			valid:      true,
+			expected:   url.Values{"from": {"1500000000000"}, "to": {"1500003600000"}, "type": {"annotation"}, "limit": {"100"}, "dashboardId": {"7"}},
+		},
+		{
+			purpose:    "Built-in query with AnnotationTags",
+			tags:       []string{"deploy", "prod"},
+			annotation: `{"builtIn": 1, "datasource": "-- Grafana --", "enable": true, "type": "dashboard", "limit": 20}`,
+			valid:      true,
+			expected:   url.Values{"from": {"1500000000000"}, "to": {"1500003600000"}, "type": {"annotation"}, "limit": {"20"}, "dashboardId": {"7"}, "tags": {"deploy", "prod"}},
+		},
+		{
+			purpose:    "Tags query",
+			tags:       []string{"prod"},
+			annotation: `{"datasource": {"type": "datasource", "uid": "grafana"}, "enable": true, "target": {"type": "tags", "tags": ["deploy"]}}`,
+			valid:      true,
+			expected:   url.Values{"from": {"1500000000000"}, "to": {"1500003600000"}, "type": {"annotation"}, "limit": {"100"}, "tags": {"deploy", "prod"}},
+		},
+		{
+			purpose:    "Tags query matching any",
+			tags:       []string{"prod"},
+			annotation: `{"datasource": "-- Grafana --", "enable": true, "type": "tags", "tags": ["deploy", "rollback"], "matchAny": true}`,
+			valid:      true,
+			expected:   url.Values{"from": {"1500000000000"}, "to": {"1500003600000"}, "type": {"annotation"}, "limit": {"100"}, "tags": {"deploy", "rollback"}, "matchAny": {"true"}},
+		},
+		{
+			purpose:    "Other datasource",
+			annotation: `{"datasource": "Prometheus", "enable": true, "expr": "changes(up[5m]) > 0"}`,
+			valid:      false,
+		},
+	}
+	// test
+	for _, qt := range queryTests {
+		var annotation map[string]interface{}
+		if err := json.Unmarshal([]byte(qt.annotation), &annotation); err != nil {
+			t.Fatalf("Test \"%s\" has invalid annotation: %s", qt.purpose, err.Error())
+		}
+		c := &TakeConfig{From: &from, To: &to, AnnotationTags: qt.tags}
+		query, ok := annotationQuery(c, dashboard, annotation)
+		if ok != qt.valid {
+			t.Errorf("Test \"%s\" expected valid to be %t, got %t", qt.purpose, qt.valid, ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(query, qt.expected) {
+			t.Errorf("Test \"%s\" expected query %v, got %v", qt.purpose, qt.expected, query)
+		}
+	}
+}
+
+func TestSnapshotAnnotations(t *testing.T) {
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/api/annotations":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case r.URL.Query().Get("type") == "alert":
+			w.Write([]byte(`[{"id": 3, "alertId": 3, "panelId": 2, "time": 1500000300000, "newState": "alerting"}]`))
+			return
+		}
+		w.Write([]byte(`[{"id": 1, "time": 1500000100000, "text": "Deployed", "tags": ["deploy", "prod"]}, {"id": 2, "time": 1500000200000, "text": "Rolled back", "tags": ["rollback"]}]`))
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	var dashboard map[string]interface{}
+	json.Unmarshal([]byte(`{"id": 7, "annotations": {"list": [
+		{"builtIn": 1, "datasource": "-- Grafana --", "enable": true, "name": "Annotations & Alerts", "type": "dashboard"},
+		{"datasource": "-- Grafana --", "enable": false, "name": "Disabled", "type": "tags", "tags": ["deploy"]},
+		{"datasource": "Prometheus", "enable": true, "name": "Restarts"}
+	]}}`), &dashboard)
+	from := time.Unix(1500000000, 0)
+	to := time.Unix(1500003600, 0)
+	c := &TakeConfig{From: &from, To: &to, AnnotationTags: []string{"prod"}}
+	if err = sc.snapshotAnnotations(context.Background(), c, dashboard, false); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	list := dashboard["annotations"].(map[string]interface{})["list"].([]interface{})
+	if len(list) != 2 {
+		t.Fatalf("Expected disabled annotation query to be dropped, got %d queries", len(list))
+	}
+	events := list[0].(map[string]interface{})["snapshotData"].([]interface{})
+	if len(events) != 2 || events[0].(map[string]interface{})["text"] != "Deployed" || events[1].(map[string]interface{})["newState"] != "alerting" {
+		t.Errorf("Expected the annotation tagged \"prod\" and the alert annotation, got %v", events)
+	}
+	if events := list[1].(map[string]interface{})["snapshotData"].([]interface{}); len(events) != 0 {
+		t.Errorf("Expected no annotations for other datasource, got %v", events)
+	}
+}
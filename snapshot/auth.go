@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+type tokenAuthKey struct{}
+type tokenRefreshKey struct{}
+
+// TokenRefresh reports whether a Config's TokenProvider is being called
+// because the last token it returned was rejected, so it should return a new
+// token rather than a cached one
+func TokenRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(tokenRefreshKey{}).(bool)
+	return refresh
+}
+
+// grafanaAuth authenticates a request to Grafana with a token from the
+// TokenProvider, the API key, or the basic auth credentials, as the OrgID
+func (sc *SnapClient) grafanaAuth(req *http.Request) (*http.Request, error) {
+	sc.setOrgID(req)
+	if sc.config.TokenProvider != nil {
+		return tokenAuth(req, sc.config.TokenProvider)
+	}
+	if len(sc.config.GrafanaAPIKey) > 0 {
+		req.Header.Set("Authorization", "Bearer "+sc.config.GrafanaAPIKey)
+	} else {
+		req.SetBasicAuth(sc.config.Username, sc.config.Password)
+	}
+	return req, nil
+}
+
+// snapshotAuth authenticates a request to the snapshot host with its API key,
+// basic auth credentials or token provider, or those of Grafana if it has none
+func (sc *SnapClient) snapshotAuth(req *http.Request) (*http.Request, error) {
+	if req.URL.Host == sc.config.GrafanaAddr.Host {
+		sc.setOrgID(req)
+	}
+	if len(sc.config.SnapshotAPIKey) > 0 {
+		req.Header.Set("Authorization", "Bearer "+sc.config.SnapshotAPIKey)
+	} else if len(sc.config.SnapshotUsername) > 0 {
+		req.SetBasicAuth(sc.config.SnapshotUsername, sc.config.SnapshotPassword)
+	} else if sc.config.SnapshotTokenProvider != nil {
+		return tokenAuth(req, sc.config.SnapshotTokenProvider)
+	} else if sc.config.TokenProvider != nil {
+		return tokenAuth(req, sc.config.TokenProvider)
+	}
+	return req, nil
+}
+
+// setOrgID sets the Grafana organization a request is made as, if OrgID is set
+func (sc *SnapClient) setOrgID(req *http.Request) {
+	if sc.config.OrgID > 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(sc.config.OrgID, 10))
+	}
+}
+
+// tokenAuth authenticates a request with a token from provider, marking it to
+// be retried with a new token if it's rejected
+func tokenAuth(req *http.Request, provider func(ctx context.Context) (string, error)) (*http.Request, error) {
+	token, err := provider(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), tokenAuthKey{}, provider))
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// tokenRefreshTransport retries requests authenticated by a token provider
+// once with a new token if they're rejected as unauthorized
+type tokenRefreshTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tokenRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	provider, _ := req.Context().Value(tokenAuthKey{}).(func(ctx context.Context) (string, error))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || provider == nil {
+		return resp, err
+	}
+	// the body is sent again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, err
+	}
+
+	token, err := provider(context.WithValue(req.Context(), tokenRefreshKey{}, true))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	retry := req.WithContext(req.Context())
+	retry.Header = req.Header.Clone()
+	retry.Header.Set("Authorization", "Bearer "+token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	resp.Body.Close()
+	return t.next.RoundTrip(retry)
+}
@@ -0,0 +1,152 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTokenProvider(t *testing.T) {
+	tokenTests := []struct {
+		purpose  string
+		accepted string // the token the server accepts
+		expected int    // expected status code
+		tokens   int    // expected number of tokens provided
+		requests int    // expected number of requests made
+	}{
+		{
+			purpose:  "Token accepted",
+			accepted: "token-1",
+			expected: http.StatusOK,
+			tokens:   1,
+			requests: 1,
+		},
+		{
+			purpose:  "Token refreshed after rejection",
+			accepted: "token-2",
+			expected: http.StatusOK,
+			tokens:   2,
+			requests: 2,
+		},
+		{
+			purpose:  "Refreshed token rejected",
+			accepted: "token-3",
+			expected: http.StatusUnauthorized,
+			tokens:   2,
+			requests: 2,
+		},
+	}
+	// test
+	for _, tt := range tokenTests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if body, _ := ioutil.ReadAll(r.Body); string(body) != "body" {
+				t.Errorf("Test \"%s\" request %d had body %q", tt.purpose, requests, body)
+			}
+			if r.Header.Get("Authorization") != "Bearer "+tt.accepted {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+		tokens := 0
+		provider := func(ctx context.Context) (string, error) {
+			if TokenRefresh(ctx) != (tokens > 0) {
+				t.Errorf("Test \"%s\" token %d had TokenRefresh %t", tt.purpose, tokens+1, TokenRefresh(ctx))
+			}
+			tokens++
+			return "token-" + strconv.Itoa(tokens), nil
+		}
+		addr, _ := url.Parse(server.URL)
+		config, err := processConfig(&Config{GrafanaAddr: addr, TokenProvider: provider})
+		if err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		}
+		sc := &SnapClient{config: config}
+
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader("body"))
+		req, err = sc.grafanaAuth(req)
+		if err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		}
+		resp, err := sc.httpClient().Do(req)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Test \"%s\" expected status %d, got %d", tt.purpose, tt.expected, resp.StatusCode)
+			}
+		}
+		if tokens != tt.tokens {
+			t.Errorf("Test \"%s\" expected %d tokens, got %d", tt.purpose, tt.tokens, tokens)
+		}
+		if requests != tt.requests {
+			t.Errorf("Test \"%s\" expected %d requests, got %d", tt.purpose, tt.requests, requests)
+		}
+		server.Close()
+	}
+}
+
+func TestOrgID(t *testing.T) {
+	orgTests := []struct {
+		purpose      string
+		orgID        int64
+		snapshotAddr string
+		expected     string
+	}{
+		{
+			purpose:  "No OrgID",
+			orgID:    0,
+			expected: "",
+		},
+		{
+			purpose:  "OrgID",
+			orgID:    3,
+			expected: "3",
+		},
+		{
+			purpose:      "OrgID with another snapshot host",
+			orgID:        3,
+			snapshotAddr: "https://snapshots.raintank.io/",
+			expected:     "3",
+		},
+	}
+	// test
+	for _, ot := range orgTests {
+		grafanaAddr, _ := url.Parse("http://grafana.local/")
+		config := &Config{GrafanaAddr: grafanaAddr, GrafanaAPIKey: "XXXXX", OrgID: ot.orgID}
+		if len(ot.snapshotAddr) > 0 {
+			config.SnapshotAddr, _ = url.Parse(ot.snapshotAddr)
+		}
+		config, err := processConfig(config)
+		if err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", ot.purpose, err.Error())
+		}
+		sc := &SnapClient{config: config}
+
+		req, _ := http.NewRequest("GET", config.GrafanaAddr.String()+"api/search", nil)
+		if req, err = sc.grafanaAuth(req); err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", ot.purpose, err.Error())
+		}
+		if orgID := req.Header.Get("X-Grafana-Org-Id"); orgID != ot.expected {
+			t.Errorf("Test \"%s\" expected Grafana request org %q, got %q", ot.purpose, ot.expected, orgID)
+		}
+		// only a snapshot host which is Grafana is sent it
+		expected := ot.expected
+		if len(ot.snapshotAddr) > 0 {
+			expected = ""
+		}
+		req, _ = http.NewRequest("POST", config.SnapshotAddr.String()+"api/snapshots", nil)
+		if req, err = sc.snapshotAuth(req); err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", ot.purpose, err.Error())
+		}
+		if orgID := req.Header.Get("X-Grafana-Org-Id"); orgID != expected {
+			t.Errorf("Test \"%s\" expected snapshot request org %q, got %q", ot.purpose, expected, orgID)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var azureKQLMacroRe = regexp.MustCompile(`\$__(timeFilter|timeFrom|timeTo)\(([^\)]*)\)`)
+
+// Azure Monitor query types which return data panels show, as opposed to
+// those for template variables
+var azureQueryTypes = map[string]bool{
+	"Azure Monitor":        true,
+	"Azure Log Analytics":  true,
+	"Azure Resource Graph": true,
+	"Azure Traces":         true,
+	"Application Insights": true,
+	"Insights Analytics":   true,
+}
+
+func (sc *SnapClient) fetchDataPointsAzure(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	refID, _ := target["refId"].(string)
+	if len(refID) == 0 {
+		refID = "A"
+	}
+
+	// The Azure plugin is a backend datasource so the target is posted as is,
+	// with the Log Analytics time macros expanded for the snapshot range
+	query := make(map[string]interface{})
+	for k, v := range target {
+		query[k] = v
+	}
+	query["refId"] = refID
+	if la, ok := target["azureLogAnalytics"].(map[string]interface{}); ok {
+		kql, _ := la["query"].(string)
+		expanded := make(map[string]interface{})
+		for k, v := range la {
+			expanded[k] = v
+		}
+		expanded["query"] = azureExpandKQL(kql, r.From, r.To, step)
+		query["azureLogAnalytics"] = expanded
+	}
+	queryType, _ := target["queryType"].(string)
+	if len(queryType) == 0 {
+		return nil, errors.New("Azure Monitor target has no queryType")
+	}
+	if !azureQueryTypes[queryType] {
+		return nil, fmt.Errorf("Unsupported Azure Monitor queryType: %q", queryType)
+	}
+
+	results, err := sc.tsdbQuery(ctx, r, datasource, step, []map[string]interface{}{query})
+	if err != nil {
+		return nil, err
+	}
+	return tsdbToSnapshotData(results[refID]), nil
+}
+
+// azureExpandKQL expands the time macros Grafana supports in Log Analytics
+// queries. $__timeFilter() with no column filters on TimeGenerated.
+func azureExpandKQL(kql string, from, to time.Time, step time.Duration) string {
+	kql = azureKQLMacroRe.ReplaceAllStringFunc(kql, func(match string) string {
+		groups := azureKQLMacroRe.FindStringSubmatch(match)
+		switch groups[1] {
+		case "timeFrom":
+			return azureDatetime(from)
+		case "timeTo":
+			return azureDatetime(to)
+		}
+		column := strings.TrimSpace(groups[2])
+		if len(column) == 0 {
+			column = "TimeGenerated"
+		}
+		return column + " >= " + azureDatetime(from) + " and " + column + " <= " + azureDatetime(to)
+	})
+	return strings.Replace(kql, "$__interval", influxDuration(step), -1)
+}
+
+func azureDatetime(t time.Time) string {
+	return "datetime(" + t.UTC().Format(time.RFC3339Nano) + ")"
+}
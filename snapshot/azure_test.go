@@ -0,0 +1,118 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAzureExpandKQL(t *testing.T) {
+	// some vars
+	from := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.UTC)
+	to := from.Add(6 * time.Hour)
+	// queries to test
+	var kqlTests = []struct {
+		purpose  string
+		kql      string
+		expected string
+	}{
+		{
+			purpose:  "Time filter on TimeGenerated",
+			kql:      "Perf | where $__timeFilter()",
+			expected: "Perf | where TimeGenerated >= datetime(2017-02-05T06:00:00Z) and TimeGenerated <= datetime(2017-02-05T12:00:00Z)",
+		},
+		{
+			purpose:  "Time filter on a column",
+			kql:      "Requests | where $__timeFilter( timestamp )",
+			expected: "Requests | where timestamp >= datetime(2017-02-05T06:00:00Z) and timestamp <= datetime(2017-02-05T12:00:00Z)",
+		},
+		{
+			purpose:  "Time from and to",
+			kql:      "Perf | where TimeGenerated between ($__timeFrom() .. $__timeTo())",
+			expected: "Perf | where TimeGenerated between (datetime(2017-02-05T06:00:00Z) .. datetime(2017-02-05T12:00:00Z))",
+		},
+		{
+			purpose:  "Interval",
+			kql:      "Perf | summarize avg(CounterValue) by bin(TimeGenerated, $__interval)",
+			expected: "Perf | summarize avg(CounterValue) by bin(TimeGenerated, 60s)",
+		},
+		{
+			purpose:  "No macros",
+			kql:      "Heartbeat | count",
+			expected: "Heartbeat | count",
+		},
+	}
+	// test
+	for _, kt := range kqlTests {
+		if out := azureExpandKQL(kt.kql, from, to, time.Minute); out != kt.expected {
+			t.Errorf("Test \"%s\" expected %q, got %q", kt.purpose, kt.expected, out)
+		}
+	}
+}
+
+func TestAzureQueryType(t *testing.T) {
+	var query map[string]interface{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/ds/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Queries []map[string]interface{} `json:"queries"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		query = body.Queries[0]
+		w.Write([]byte(`{"results": {"A": {"frames": []}}}`))
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", GrafanaVersion: "9.1.0"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	datasource := map[string]interface{}{"id": 4.0, "uid": "azure", "type": "grafana-azure-monitor-datasource"}
+	r := TimeRange{From: time.Unix(0, 0).UTC(), To: time.Unix(3600, 0).UTC()}
+
+	// query types to test
+	var typeTests = []struct {
+		purpose   string
+		queryType string
+		valid     bool
+	}{
+		{"Log Analytics", "Azure Log Analytics", true},
+		{"Metrics", "Azure Monitor", true},
+		{"Resource Graph", "Azure Resource Graph", true},
+		{"No query type", "", false},
+		{"Template variable query type", "Azure Subscriptions", false},
+		{"Unknown query type", "Azure Everything", false},
+	}
+	// test
+	for _, tt := range typeTests {
+		query = nil
+		target := map[string]interface{}{
+			"refId":             "A",
+			"queryType":         tt.queryType,
+			"azureLogAnalytics": map[string]interface{}{"query": "Perf | where $__timeFilter()"},
+		}
+		_, err := sc.fetchDataPointsAzure(context.Background(), target, datasource, r, time.Minute)
+		if tt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		} else if !tt.valid {
+			if err == nil {
+				t.Errorf("Test \"%s\" unexpectedly passed", tt.purpose)
+			} else if query != nil {
+				t.Errorf("Test \"%s\" expected no query to be made, got %v", tt.purpose, query)
+			}
+			continue
+		}
+		// the target is posted with its time macros expanded
+		la, _ := query["azureLogAnalytics"].(map[string]interface{})
+		if expected := "Perf | where TimeGenerated >= datetime(1970-01-01T00:00:00Z) and TimeGenerated <= datetime(1970-01-01T01:00:00Z)"; la["query"] != expected {
+			t.Errorf("Test \"%s\" expected query %q, got %v", tt.purpose, expected, la["query"])
+		}
+	}
+}
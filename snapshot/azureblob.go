@@ -0,0 +1,285 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// AzureBlobConfig configures uploading snapshot artifacts to an Azure Blob
+// Storage container
+type AzureBlobConfig struct {
+	Container string
+	// NameTemplate is a text/template for the blob name of each file,
+	// evaluated against StorageKeyData. Defaults to
+	// DefaultStorageKeyTemplate.
+	NameTemplate string
+	// ConnectionString is the storage account's connection string, with
+	// either its AccountKey or a SharedAccessSignature. Without one, the
+	// Account is authenticated with the managed identity, the user assigned
+	// identity ClientID if it's set.
+	ConnectionString string
+	Account          string
+	ClientID         string
+}
+
+// The version of the Blob Storage API used, which supports managed identity
+// tokens
+const azureBlobVersion = "2020-10-02"
+
+// AzureBlobConfigFromEnv builds an AzureBlobConfig for container from the
+// AZURE_STORAGE_CONNECTION_STRING, AZURE_STORAGE_ACCOUNT and AZURE_CLIENT_ID
+// environment variables
+func AzureBlobConfigFromEnv(container string) (*AzureBlobConfig, error) {
+	return &AzureBlobConfig{
+		Container:        container,
+		ConnectionString: os.Getenv("AZURE_STORAGE_CONNECTION_STRING"),
+		Account:          os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		ClientID:         os.Getenv("AZURE_CLIENT_ID"),
+	}, nil
+}
+
+// AzureBlob is a Storage uploading snapshot artifacts to an Azure Blob Storage
+// container
+type AzureBlob struct {
+	config       *AzureBlobConfig
+	client       *http.Client
+	nameTemplate *template.Template
+	// endpoint is the blob service, and key or sas its credentials from
+	// the connection string
+	endpoint *url.URL
+	account  string
+	key      []byte
+	sas      string
+	// identityAddr is the instance metadata service, which managed identity
+	// tokens are requested from
+	identityAddr string
+	token        tokenCache
+}
+
+// NewAzureBlob creates an AzureBlob for uploading to the configured container
+func NewAzureBlob(config *AzureBlobConfig) (*AzureBlob, error) {
+	if len(config.Container) == 0 {
+		return nil, errors.New("Missing required AzureBlobConfig field: \"Container\"")
+	}
+	c := *config
+	if len(c.NameTemplate) == 0 {
+		c.NameTemplate = DefaultStorageKeyTemplate
+	}
+	nameTemplate, err := parseKeyTemplate("AzureBlobConfig NameTemplate", c.NameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	a := &AzureBlob{config: &c, client: &http.Client{Timeout: 5 * time.Minute}, nameTemplate: nameTemplate, account: c.Account}
+	if len(c.ConnectionString) > 0 {
+		if err = a.parseConnectionString(c.ConnectionString); err != nil {
+			return nil, err
+		}
+	} else if len(c.Account) == 0 {
+		return nil, errors.New("Missing required AzureBlobConfig field: \"ConnectionString\" or \"Account\"")
+	}
+	if a.endpoint == nil {
+		a.endpoint = &url.URL{Scheme: "https", Host: a.account + ".blob.core.windows.net", Path: "/"}
+	}
+	if a.key == nil && len(a.sas) == 0 {
+		a.identityAddr = "http://169.254.169.254/"
+		a.token.fetch = a.identityToken
+	}
+	return a, nil
+}
+
+// parseConnectionString reads the account, its credentials and the blob
+// service endpoint from a connection string
+func (a *AzureBlob) parseConnectionString(connectionString string) error {
+	settings := make(map[string]string)
+	for _, setting := range strings.Split(connectionString, ";") {
+		if pair := strings.SplitN(setting, "=", 2); len(pair) == 2 {
+			settings[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+		}
+	}
+	if len(settings["AccountName"]) > 0 {
+		a.account = settings["AccountName"]
+	}
+	if accountKey := settings["AccountKey"]; len(accountKey) > 0 {
+		key, err := base64.StdEncoding.DecodeString(accountKey)
+		if err != nil {
+			return errors.New("Invalid AzureBlobConfig ConnectionString: AccountKey isn't base64")
+		}
+		a.key = key
+	}
+	a.sas = strings.TrimPrefix(settings["SharedAccessSignature"], "?")
+	if a.key == nil && len(a.sas) == 0 {
+		return errors.New("Invalid AzureBlobConfig ConnectionString: needs an AccountKey or SharedAccessSignature")
+	}
+
+	if blobEndpoint := settings["BlobEndpoint"]; len(blobEndpoint) > 0 {
+		endpoint, err := url.Parse(blobEndpoint)
+		if err != nil {
+			return fmt.Errorf("Invalid AzureBlobConfig ConnectionString BlobEndpoint: %s", err.Error())
+		}
+		a.endpoint = endpoint
+	} else if len(a.account) > 0 {
+		protocol, suffix := settings["DefaultEndpointsProtocol"], settings["EndpointSuffix"]
+		if len(protocol) == 0 {
+			protocol = "https"
+		}
+		if len(suffix) == 0 {
+			suffix = "core.windows.net"
+		}
+		a.endpoint = &url.URL{Scheme: protocol, Host: a.account + ".blob." + suffix, Path: "/"}
+	}
+	if a.endpoint == nil || (a.key != nil && len(a.account) == 0) {
+		return errors.New("Invalid AzureBlobConfig ConnectionString: needs an AccountName or BlobEndpoint")
+	}
+	return nil
+}
+
+// Upload uploads body as the block blob named by the NameTemplate evaluated
+// against data, returning its URL
+func (a *AzureBlob) Upload(ctx context.Context, data *StorageKeyData, body []byte, contentType string) (string, error) {
+	name, err := storageKey(a.nameTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	blobURL := *a.endpoint
+	blobURL.Path = strings.TrimSuffix(blobURL.Path, "/") + "/" + a.config.Container + "/" + name
+	blobURL.RawQuery = a.sas
+
+	// a managed identity token which has been revoked is replaced once
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("PUT", blobURL.String(), bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+		req.Header.Set("X-Ms-Version", azureBlobVersion)
+		req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+		switch {
+		case a.key != nil:
+			signature := hmac.New(sha256.New, a.key)
+			signature.Write([]byte(sharedKeyStringToSign(req, a.account)))
+			req.Header.Set("Authorization", "SharedKey "+a.account+":"+base64.StdEncoding.EncodeToString(signature.Sum(nil)))
+		case len(a.sas) == 0:
+			token, err := a.token.get(ctx, attempt > 0)
+			if err != nil {
+				return "", err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && a.token.fetch != nil && attempt == 0 {
+			continue
+		}
+		if resp.StatusCode != http.StatusCreated {
+			// Blob Storage describes errors in XML
+			var azureErr struct {
+				Code    string
+				Message string
+			}
+			if xml.Unmarshal(respBody, &azureErr) == nil && len(azureErr.Code) > 0 {
+				return "", uploadFailed(fmt.Errorf("Failed to upload %q to Azure Blob Storage: %s: %s", name, azureErr.Code, strings.SplitN(azureErr.Message, "\n", 2)[0]))
+			}
+			return "", uploadFailed(fmt.Errorf("Failed to upload %q to Azure Blob Storage: %s", name, resp.Status))
+		}
+		blobURL.RawQuery = ""
+		return blobURL.String(), nil
+	}
+}
+
+// sharedKeyStringToSign returns what a Blob Storage request is signed over
+// with the account key: its standard headers, its x-ms- headers and the
+// resource it's for
+func sharedKeyStringToSign(req *http.Request, account string) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		req.Header.Get("Date"),
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+	var msHeaders []string
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+	lines = append(lines, msHeaders...)
+
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		sort.Strings(values)
+		params = append(params, strings.ToLower(name)+":"+strings.Join(values, ","))
+	}
+	sort.Strings(params)
+	return strings.Join(append(lines, append([]string{resource}, params...)...), "\n")
+}
+
+// identityToken requests a managed identity access token for Blob Storage from
+// the instance metadata service, returning it and how long it lasts
+func (a *AzureBlob) identityToken(ctx context.Context) (string, time.Duration, error) {
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {"https://storage.azure.com/"}}
+	if len(a.config.ClientID) > 0 {
+		query.Set("client_id", a.config.ClientID)
+	}
+	req, err := http.NewRequest("GET", a.identityAddr+"metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", 0, fmt.Errorf("Failed to get managed identity token: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("Failed to get managed identity token: %s", resp.Status)
+	}
+	// the metadata service quotes expires_in
+	var token struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil || len(token.AccessToken) == 0 {
+		return "", 0, errors.New("Failed to get managed identity token: no token in response")
+	}
+	expiresIn, _ := token.ExpiresIn.Int64()
+	return token.AccessToken, time.Duration(expiresIn) * time.Second, nil
+}
@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedKeyStringToSign(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "https://myaccount.blob.core.windows.net/snapshots/abc%20xyz/snapshot.json?timeout=30&Comp=block", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ms-Version", "2020-10-02")
+	req.Header.Set("X-Ms-Date", "Sun, 05 Feb 2017 06:00:00 GMT")
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+
+	expected := "PUT\n\n\n2\n\napplication/json\n\n\n\n\n\n\n" +
+		"x-ms-blob-type:BlockBlob\nx-ms-date:Sun, 05 Feb 2017 06:00:00 GMT\nx-ms-version:2020-10-02\n" +
+		"/myaccount/snapshots/abc%20xyz/snapshot.json\ncomp:block\ntimeout:30"
+	if stringToSign := sharedKeyStringToSign(req, "myaccount"); stringToSign != expected {
+		t.Errorf("Expected string to sign %q, got %q", expected, stringToSign)
+	}
+}
+
+func TestAzureBlobUpload(t *testing.T) {
+	var uploaded []string
+	azure := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metadata/identity/oauth2/token" {
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != "https://storage.azure.com/" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token": "token-` + r.URL.Query().Get("client_id") + `", "expires_in": "86399", "token_type": "Bearer"}`))
+			return
+		}
+		if strings.Contains(r.URL.Path, "/missing/") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>ContainerNotFound</Code><Message>The specified container does not exist.\nRequestId:1</Message></Error>"))
+			return
+		}
+		if r.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		auth := r.Header.Get("Authorization")
+		if idx := strings.Index(auth, ":"); idx > 0 {
+			auth = auth[:idx]
+		}
+		uploaded = append(uploaded, r.URL.Path+" "+auth+" "+r.URL.Query().Get("sig")+" "+string(body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer azure.Close()
+
+	from := time.Date(2017, time.February, 5, 6, 0, 0, 0, time.UTC)
+	data := &StorageKeyData{SnapshotNameData: SnapshotNameData{DashUID: "abc", From: from, To: from.Add(time.Hour)}, File: "snapshot.json"}
+	uploadTests := []struct {
+		purpose  string
+		config   *AzureBlobConfig
+		valid    bool
+		expected string
+	}{
+		{
+			purpose:  "Account key",
+			config:   &AzureBlobConfig{Container: "snapshots", ConnectionString: "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==;BlobEndpoint=" + azure.URL + "/devstoreaccount1;"},
+			valid:    true,
+			expected: "/devstoreaccount1/snapshots/abc/20170205T060000-20170205T070000/snapshot.json SharedKey devstoreaccount1  {}",
+		},
+		{
+			purpose:  "Shared access signature",
+			config:   &AzureBlobConfig{Container: "snapshots", NameTemplate: "{{.DashUID}}/{{.File}}", ConnectionString: "BlobEndpoint=" + azure.URL + "/;SharedAccessSignature=sv=2020-10-02&sp=cw&sig=abc%3D"},
+			valid:    true,
+			expected: "/snapshots/abc/snapshot.json  abc= {}",
+		},
+		{
+			purpose:  "Managed identity",
+			config:   &AzureBlobConfig{Container: "snapshots", NameTemplate: "{{.File}}", Account: "myaccount", ClientID: "client"},
+			valid:    true,
+			expected: "/snapshots/snapshot.json Bearer token-client  {}",
+		},
+		{
+			purpose: "Missing container",
+			config:  &AzureBlobConfig{Container: "missing", ConnectionString: "BlobEndpoint=" + azure.URL + "/;SharedAccessSignature=sig=abc"},
+			valid:   false,
+		},
+		{
+			purpose: "Connection string without credentials",
+			config:  &AzureBlobConfig{Container: "snapshots", ConnectionString: "AccountName=myaccount"},
+			valid:   false,
+		},
+		{
+			purpose: "No connection string or account",
+			config:  &AzureBlobConfig{Container: "snapshots"},
+			valid:   false,
+		},
+	}
+	// test
+	for _, ut := range uploadTests {
+		uploaded = nil
+		a, err := NewAzureBlob(ut.config)
+		if err == nil {
+			if a.identityAddr != "" {
+				a.identityAddr = azure.URL + "/"
+				a.endpoint.Scheme, a.endpoint.Host = "http", strings.TrimPrefix(azure.URL, "http://")
+			}
+			_, err = a.Upload(context.Background(), data, []byte("{}"), "application/json")
+		}
+		if ut.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", ut.purpose, err.Error())
+		} else if !ut.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", ut.purpose)
+		} else if ut.valid && (len(uploaded) != 1 || uploaded[0] != ut.expected) {
+			t.Errorf("Test \"%s\" expected upload %q, got %q", ut.purpose, ut.expected, uploaded)
+		}
+	}
+}
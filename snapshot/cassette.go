@@ -0,0 +1,186 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Cassette modes
+const (
+	// CassetteRecord makes requests as usual, recording each one and its
+	// response to the cassette, which is overwritten
+	CassetteRecord = "record"
+	// CassetteReplay answers requests with the responses in the cassette,
+	// without connecting to Grafana or the snapshot host
+	CassetteReplay = "replay"
+)
+
+// CassetteConfig configures recording the requests a SnapClient makes to
+// Grafana, its datasources and the snapshot host, and their responses, to a
+// file, and replaying them later, e.g. for offline regression tests against
+// real dashboards
+type CassetteConfig struct {
+	// Path is the file the cassette is recorded to or replayed from
+	Path string
+	// Mode is CassetteRecord or CassetteReplay
+	Mode string
+}
+
+func processCassetteConfig(configIn *CassetteConfig) (*CassetteConfig, error) {
+	if len(configIn.Path) == 0 {
+		return nil, errors.New("Missing required CassetteConfig field: \"Path\"")
+	}
+	switch configIn.Mode {
+	case CassetteRecord, CassetteReplay:
+	default:
+		return nil, fmt.Errorf("Unknown CassetteConfig \"Mode\" %q, expected %q or %q", configIn.Mode, CassetteRecord, CassetteReplay)
+	}
+	configOut := *configIn
+	return &configOut, nil
+}
+
+// cassette is the file a cassetteTransport records to or replays from
+type cassette struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+// interaction is a recorded request and its response. Requests are recorded
+// without their headers, and responses without credentials.
+type interaction struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"requestBody,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body"`
+
+	replayed bool
+}
+
+// cassetteTransport records requests and their responses to a cassette, or
+// replays them from it
+type cassetteTransport struct {
+	next   http.RoundTripper
+	config *CassetteConfig
+
+	mu       sync.Mutex
+	cassette cassette
+}
+
+// newCassetteTransport returns a cassetteTransport recording the requests made
+// through next, or replaying them from the cassette
+func newCassetteTransport(next http.RoundTripper, config *CassetteConfig) (*cassetteTransport, error) {
+	t := &cassetteTransport{next: next, config: config}
+	if config.Mode == CassetteReplay {
+		b, err := ioutil.ReadFile(config.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read cassette: %s", err.Error())
+		}
+		if err = json.Unmarshal(b, &t.cassette); err != nil {
+			return nil, fmt.Errorf("Could not parse cassette %q: %s", config.Path, err.Error())
+		}
+	}
+	return t, nil
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	if t.config.Mode == CassetteReplay {
+		return t.replay(req, reqBody)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	header := http.Header{}
+	for name, values := range resp.Header {
+		if !isDebugSecret(name) && !strings.EqualFold(name, "Set-Cookie") {
+			header[name] = values
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cassette.Interactions = append(t.cassette.Interactions, &interaction{
+		Method:      strings.ToUpper(req.Method),
+		URL:         req.URL.RequestURI(),
+		RequestBody: string(reqBody),
+		Status:      resp.StatusCode,
+		Header:      header,
+		Body:        string(respBody),
+	})
+	// the cassette is rewritten after each request, so it's complete
+	// however the SnapClient's use ends
+	b, err := json.MarshalIndent(&t.cassette, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(t.config.Path, b, 0644); err != nil {
+		return nil, fmt.Errorf("Could not write cassette: %s", err.Error())
+	}
+	return resp, nil
+}
+
+// replay returns the response of the first interaction not yet replayed with
+// the request's method, path, query and body, or failing that its method,
+// path and query. Hosts aren't compared, so cassettes can be replayed with
+// any GrafanaAddr.
+func (t *cassetteTransport) replay(req *http.Request, reqBody []byte) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	method, reqURL := strings.ToUpper(req.Method), req.URL.RequestURI()
+	var found *interaction
+	for _, i := range t.cassette.Interactions {
+		if i.replayed || i.Method != method || i.URL != reqURL {
+			continue
+		}
+		if i.RequestBody == string(reqBody) {
+			found = i
+			break
+		}
+		if found == nil {
+			found = i
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Cassette %q has no response for %s %s", t.config.Path, method, reqURL)
+	}
+	found.replayed = true
+
+	header := http.Header{}
+	for name, values := range found.Header {
+		header[name] = values
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", found.Status, http.StatusText(found.Status)),
+		StatusCode:    found.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(found.Body)),
+		ContentLength: int64(len(found.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestCassette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	srv := snapshottest.NewServer()
+	from, to := time.Unix(0, 0).UTC(), time.Unix(3600, 0).UTC()
+	srv.AddDashboard("recorded", map[string]interface{}{
+		"uid":   "recorded",
+		"title": "Recorded",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "title": "Up", "datasource": "prom", "targets": []interface{}{
+				map[string]interface{}{"refId": "A", "expr": "up"},
+			}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "prom", "type": "prometheus", "isDefault": true})
+	srv.AddSeries("up", snapshottest.Series{Points: []snapshottest.Point{{Time: from.Add(time.Minute), Value: 1}}})
+	grafanaAddr := srv.Addr()
+
+	take := func(mode string) (*Snapshot, error) {
+		sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaAddr, GrafanaAPIKey: snapshottest.APIKey, Cassette: &CassetteConfig{Path: path, Mode: mode}})
+		if err != nil {
+			return nil, err
+		}
+		return sc.Take(&TakeConfig{DashUID: "recorded", From: &from, To: &to})
+	}
+	recorded, err := take(CassetteRecord)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed to record: %s", err.Error())
+	}
+	srv.Close()
+
+	replayed, err := take(CassetteReplay)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed to replay: %s", err.Error())
+	}
+	if replayed.Key != recorded.Key || replayed.Panels != 1 || replayed.Datapoints != 1 {
+		t.Errorf("Expected the recorded snapshot %q with 1 panel and 1 data point, got %q with %d and %d", recorded.Key, replayed.Key, replayed.Panels, replayed.Datapoints)
+	}
+
+	// requests which weren't recorded fail
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaAddr, GrafanaAPIKey: snapshottest.APIKey, Cassette: &CassetteConfig{Path: path, Mode: CassetteReplay}})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = sc.Take(&TakeConfig{DashUID: "unrecorded", From: &from, To: &to}); err == nil {
+		t.Error("Expected an unrecorded request to fail")
+	}
+
+	configTests := []struct {
+		purpose string
+		config  *CassetteConfig
+	}{
+		{"Path is required", &CassetteConfig{Mode: CassetteRecord}},
+		{"Mode must be known", &CassetteConfig{Path: path, Mode: "rewind"}},
+		{"Replayed cassettes must exist", &CassetteConfig{Path: filepath.Join(dir, "missing.json"), Mode: CassetteReplay}},
+	}
+	for _, tt := range configTests {
+		if _, err := NewSnapClient(&Config{GrafanaAddr: grafanaAddr, GrafanaAPIKey: snapshottest.APIKey, Cassette: tt.config}); err == nil {
+			t.Errorf("%s: expected an error", tt.purpose)
+		}
+	}
+}
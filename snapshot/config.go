@@ -1,10 +1,15 @@
 package snapshot
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -17,17 +22,283 @@ type Config struct {
 	SnapshotAPIKey string
 	GrafanaAddr    *url.URL
 	SnapshotAddr   *url.URL
+	// GrafanaAPIKeyFile and SnapshotAPIKeyFile are files to read the API
+	// keys from instead, e.g. mounted Kubernetes secrets. Surrounding
+	// whitespace is trimmed.
+	GrafanaAPIKeyFile  string
+	SnapshotAPIKeyFile string
+	// TokenProvider, if set, is called for a token to authenticate each
+	// request to Grafana with instead of GrafanaAPIKey, e.g. to use short
+	// lived service account tokens. If a token is rejected, it's called
+	// again with a context for which TokenRefresh is true, and the request
+	// is retried once.
+	TokenProvider func(ctx context.Context) (string, error)
+	// SnapshotTokenProvider does the same for the snapshot host, instead of
+	// SnapshotAPIKey
+	SnapshotTokenProvider func(ctx context.Context) (string, error)
+	// Username and Password authenticate with Grafana by basic auth, for
+	// instances without an API key. The API key is used if both are set.
+	Username string
+	Password string
+	// SnapshotUsername and SnapshotPassword authenticate with the snapshot
+	// host by basic auth. Without them or a SnapshotAPIKey, the Grafana
+	// credentials or TokenProvider are used.
+	SnapshotUsername string
+	SnapshotPassword string
+	// Metrics, if set, receives measurements of the snapshots taken
+	Metrics Metrics
+	// Logger, if set, receives diagnostic messages. Defaults to discarding
+	// them.
+	Logger Logger
+	// Debug logs every request made to Grafana, its datasources and the
+	// snapshot host, and their responses, at debug level. Credentials are
+	// redacted.
+	Debug bool
+	// Retry, if set, retries GET requests and snapshot posts which fail with
+	// a network error or a retryable status code
+	Retry *RetryConfig
+	// RateLimit, if set, limits the rate of datasource queries, including
+	// template variable queries and retries
+	RateLimit *RateLimit
+	// DashboardTimeout limits each request to Grafana's API other than
+	// datasource queries and snapshot posts, e.g. for the dashboard, its
+	// datasources, or listing snapshots. Defaults to 30s.
+	DashboardTimeout time.Duration
+	// QueryTimeout limits each datasource query, including template variable
+	// queries. Defaults to 60s.
+	QueryTimeout time.Duration
+	// UploadTimeout limits each snapshot post. Defaults to 60s.
+	UploadTimeout time.Duration
+	// TakeTimeout limits taking a whole snapshot. Defaults to 10m.
+	// Negative timeouts disable the limit.
+	TakeTimeout time.Duration
+	// TLS, if set, configures the TLS connections to Grafana and the
+	// snapshot host
+	TLS *TLSConfig
+	// ProxyURL, if set, is the http, https or socks5 proxy to connect to
+	// Grafana and the snapshot host through. Defaults to the proxy set by
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	ProxyURL *url.URL
+	// Transport, if set, makes the connections to Grafana, its datasources
+	// and the snapshot host instead of a copy of http.DefaultTransport, e.g.
+	// to add instrumentation or corporate middleware. Retries, rate limiting
+	// and debug logging still wrap it. It can't be set with TLS or ProxyURL,
+	// which configure the default transport.
+	Transport http.RoundTripper
+	// GrafanaAPI, if set, replaces Grafana's HTTP API for reading
+	// dashboards, datasources and annotations, and posting snapshots
+	GrafanaAPI GrafanaAPI
+	// LedgerPath, if set, is a file to append each snapshot posted by Take
+	// or Upload to, as a line of JSON with its keys and delete URL (see
+	// LedgerEntry), so it can be deleted later with Prune or by hand
+	LedgerPath string
+	// OrgID, if set, is the Grafana organization to make requests to Grafana
+	// as, by the X-Grafana-Org-Id header, for users and keys which belong to
+	// several. It's also sent to the snapshot host if it's Grafana.
+	OrgID int64
+	// ViewerParams, if set, are query parameters added to the URLs of the
+	// snapshots taken, e.g. kiosk, theme or var-* presets. Parameters
+	// without a value, like kiosk, are added bare.
+	ViewerParams url.Values
+	// Cassette, if set, records the requests made to Grafana, its
+	// datasources and the snapshot host, and their responses, to a file, or
+	// replays them from one made earlier instead of making them
+	Cassette *CassetteConfig
+	// OnQueryError is what a failing panel target query does to snapshots
+	// whose TakeConfig doesn't say: QueryErrorFail or QueryErrorWarn.
+	// Defaults to QueryErrorFail.
+	OnQueryError string
+	// GrafanaVersion, if set, is Grafana's version, e.g. "9.5.2", which
+	// decides the API endpoints used. Defaults to the version Grafana
+	// reports, or the oldest endpoints if it doesn't.
+	GrafanaVersion string
+	// MaxLogLines is the most log lines kept of each logs query, e.g. of a
+	// Loki target of a logs panel, which are its newest. A target's or
+	// datasource's smaller max lines is used instead. Defaults to 1000.
+	MaxLogLines int
+	// MaxDataPoints is the most data points each series is queried at. A
+	// target whose step would give more over the time range has its step
+	// widened, with a warning. Defaults to 11,000, Prometheus's limit.
+	MaxDataPoints int
+	// DirectDatasources are datasources to query directly at their own
+	// addresses, with their own credentials, rather than through Grafana's
+	// datasource proxy, by their name or UID
+	DirectDatasources map[string]*DirectDatasource
+
+	// sharedSnapshotAuth is whether the snapshot host is authenticated with
+	// the Grafana credentials, as it has none of its own
+	sharedSnapshotAuth bool
 }
 
+// Default timeouts, used when a Config's are zero
+const (
+	defaultDashboardTimeout = 30 * time.Second
+	defaultQueryTimeout     = 60 * time.Second
+	defaultUploadTimeout    = 60 * time.Second
+	defaultTakeTimeout      = 10 * time.Minute
+)
+
+// Default Config.MaxLogLines, as Grafana's
+const defaultMaxLogLines = 1000
+
+// Default Config.MaxDataPoints, as Prometheus's limit
+const defaultMaxDataPoints = maxSeriesPoints
+
 // TakeConfig for defining exactly which dashboard and time-range to snapshot,
-// and also the name and expiry duration of the snapshot.
+// and also the name and expiry duration of the snapshot. The dashboard is
+// selected by either its slug or, for newer Grafana versions, its UID.
+// SnapshotName may be a text/template, evaluated against SnapshotNameData, and
+// defaults to the "To" date and the dashboard's slug or UID.
 type TakeConfig struct {
 	DashSlug     string
+	DashUID      string
 	From         *time.Time
 	To           *time.Time
 	Vars         map[string]string
 	Expires      time.Duration
 	SnapshotName string
+	// RefreshVariables runs the queries of "query" type template variables
+	// for the snapshot time range instead of using the saved options
+	RefreshVariables bool
+	// OutputPath writes the snapshot to this file rather than posting it to
+	// the snapshot host, in which case Take returns an empty Snapshot
+	OutputPath string
+	// Location is the time zone the snapshot is shown in, and its default
+	// name is dated in. Defaults to the location of To.
+	Location *time.Location
+	// MaxConcurrency is the most panel target queries to run at once.
+	// Defaults to 4.
+	MaxConcurrency int
+	// MaxPoints, if set, downsamples series with more data points than this
+	// to shrink snapshots of long time ranges
+	MaxPoints int
+	// DownsampleMethod is how series are downsampled to MaxPoints:
+	// DownsampleLTTB or DownsampleAverage. Defaults to DownsampleLTTB.
+	DownsampleMethod string
+	// MaxPayloadBytes, if set, fails snapshots whose encoded payload is
+	// larger than this before they're posted, as snapshot hosts reject
+	// large snapshots with unclear errors
+	MaxPayloadBytes int64
+	// DownsampleToFit downsamples snapshots larger than MaxPayloadBytes
+	// until they fit, rather than failing them
+	DownsampleToFit bool
+	// External publishes the snapshot to an external snapshot service, as
+	// Grafana's "Publish to snapshots.raintank.io" does. If the Config's
+	// SnapshotAddr is the service, e.g. https://snapshots.raintank.io/, it's
+	// posted there without the Grafana credentials; otherwise Grafana
+	// publishes it to the external service it's configured with.
+	External bool
+	// Key and DeleteKey, if set, are used for the snapshot instead of random
+	// keys, so its URL is predictable, e.g. /dashboard/snapshot/nightly. Any
+	// snapshot already using them is deleted first, so re-taking a snapshot
+	// replaces it. That's once the new snapshot is taken and encoded, but if
+	// posting it then fails, the keys are left without a snapshot until the
+	// next success. Keys may only contain letters, digits, "-" and "_".
+	Key       string
+	DeleteKey string
+	// OnProgress, if set, is called as taking the snapshot progresses: with
+	// the stage (StageDashboard, StageQuery or StageUpload) as it starts
+	// and each time more of it is done, with how many of its total steps
+	// are done. The query stage's steps are the panel targets. It's called
+	// from one goroutine at a time, and should return quickly.
+	OnProgress func(stage string, done, total int)
+	// MutateDashboard, if set, is called with the dashboard before its
+	// panels are queried, once its template variables' values are resolved,
+	// to e.g. change titles or drop panels. An error fails the snapshot.
+	MutateDashboard func(dashboard map[string]interface{}) error
+	// MutateSnapshot, if set, is called with the snapshot before it's
+	// posted or written, holding the dashboard with its panels' data, to
+	// e.g. stamp metadata. Its size is checked against MaxPayloadBytes
+	// afterwards, and panel data may still be downsampled to fit it. An
+	// error fails the snapshot.
+	MutateSnapshot func(snapshot map[string]interface{}) error
+	// PanelFilter, if set, selects which panels to snapshot, e.g. only a
+	// row of a big dashboard. Panels it drops aren't queried or posted.
+	PanelFilter *PanelFilter
+	// QueryHiddenTargets queries panel targets hidden with "hide", which
+	// are skipped by default as Grafana doesn't show them
+	QueryHiddenTargets bool
+	// RefIDs, if set, restricts the targets queried for panels by their ID
+	// to those with these refIds, e.g. {12: {"A", "C"}}. Other panels' targets
+	// are all queried.
+	RefIDs map[int][]string
+	// AnnotationTags, if set, restricts the annotations snapshotted to those
+	// with all of these tags
+	AnnotationTags []string
+	// CSVDir, if set, is a directory to write the data points of each panel
+	// to as well, as panel-<id>.csv, for loading into spreadsheets
+	CSVDir string
+	// OnQueryError is what a failing panel target query does:
+	// QueryErrorFail fails the snapshot, and QueryErrorWarn adds the error
+	// to its Warnings and leaves the target's data out. Defaults to the
+	// Config's OnQueryError.
+	OnQueryError string
+	// PanelLinks is what's done with panels' links, which lead to Grafana
+	// rather than the snapshot host: PanelLinksDrop, PanelLinksKeep or
+	// PanelLinksAbsolute. Defaults to PanelLinksDrop.
+	PanelLinks string
+
+	// nameTemplate is the SnapshotName parsed, if it's a template
+	nameTemplate *template.Template
+}
+
+// The characters a caller-supplied snapshot key may contain, as it's used in
+// URL paths
+var validSnapshotKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// queryTarget reports whether to query a panel target
+func (c *TakeConfig) queryTarget(panel, target map[string]interface{}) bool {
+	if hide, _ := target["hide"].(bool); hide && !c.QueryHiddenTargets {
+		return false
+	}
+	id, _ := panel["id"].(float64)
+	refIDs, ok := c.RefIDs[int(id)]
+	if !ok {
+		return true
+	}
+	for _, refID := range refIDs {
+		if target["refId"] == refID {
+			return true
+		}
+	}
+	return false
+}
+
+// progress reports progress to OnProgress, if set
+func (c *TakeConfig) progress(stage string, done, total int) {
+	if c.OnProgress != nil {
+		c.OnProgress(stage, done, total)
+	}
+}
+
+// Default TakeConfig.MaxConcurrency
+const defaultMaxConcurrency = 4
+
+// What a failing panel target query does, for OnQueryError
+const (
+	// QueryErrorFail fails the snapshot
+	QueryErrorFail = "fail"
+	// QueryErrorWarn adds the error to the snapshot's Warnings, leaving the
+	// target's data out, and carries on
+	QueryErrorWarn = "warn"
+)
+
+// PruneConfig for selecting which snapshots on the snapshot host to delete.
+// A snapshot is pruned if it matches all of the criteria which are set.
+type PruneConfig struct {
+	// OlderThan prunes snapshots created longer ago than this
+	OlderThan time.Duration
+	// NamePrefix prunes snapshots whose name starts with this
+	NamePrefix string
+	// Expired prunes snapshots which have passed their expiry time
+	Expired bool
+	// DryRun selects the snapshots without deleting them
+	DryRun bool
+	// LedgerPath, if set, prunes the snapshots recorded in this ledger (see
+	// Config.LedgerPath) rather than those the snapshot host lists, deleting
+	// them by their delete keys or URLs so external snapshots can be pruned
+	// too. Deleted snapshots are removed from the ledger.
+	LedgerPath string
 }
 
 func processConfig(configIn *Config) (*Config, error) {
@@ -43,11 +314,25 @@ func processConfig(configIn *Config) (*Config, error) {
 		configOut.GrafanaAddr.Path = configOut.GrafanaAddr.Path + "/"
 	}
 
-	// Grafana API key
-	if len(configIn.GrafanaAPIKey) == 0 {
-		return nil, errors.New("Missing required Config field: \"GrafanaAPIKey\"")
+	// Grafana API key or basic auth credentials
+	if len(configIn.GrafanaAPIKeyFile) > 0 {
+		if len(configIn.GrafanaAPIKey) > 0 {
+			return nil, errors.New("Only one of Config fields \"GrafanaAPIKey\" and \"GrafanaAPIKeyFile\" can be set")
+		}
+		key, err := readKeyFile(configIn.GrafanaAPIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		configOut.GrafanaAPIKey = key
+	} else {
+		configOut.GrafanaAPIKey = configIn.GrafanaAPIKey
+	}
+	if len(configOut.GrafanaAPIKey) == 0 && len(configIn.Username) == 0 && configIn.TokenProvider == nil {
+		return nil, errors.New("Missing required Config field: \"GrafanaAPIKey\", \"TokenProvider\" or \"Username\"")
 	}
-	configOut.GrafanaAPIKey = configIn.GrafanaAPIKey
+	configOut.TokenProvider = configIn.TokenProvider
+	configOut.Username = configIn.Username
+	configOut.Password = configIn.Password
 
 	// Parse Snapshot host Address or default to Grafana address
 	if configIn.SnapshotAddr == nil || len(configIn.SnapshotAddr.String()) == 0 {
@@ -59,25 +344,159 @@ func processConfig(configIn *Config) (*Config, error) {
 		configOut.SnapshotAddr.Path = configOut.SnapshotAddr.Path + "/"
 	}
 
-	// Snapshot API key
-	if len(configIn.SnapshotAPIKey) == 0 {
-		configOut.SnapshotAPIKey = configIn.GrafanaAPIKey
+	// Snapshot API key or basic auth credentials, or default to Grafana's
+	if len(configIn.SnapshotAPIKeyFile) > 0 {
+		if len(configIn.SnapshotAPIKey) > 0 {
+			return nil, errors.New("Only one of Config fields \"SnapshotAPIKey\" and \"SnapshotAPIKeyFile\" can be set")
+		}
+		key, err := readKeyFile(configIn.SnapshotAPIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		configOut.SnapshotAPIKey = key
 	} else {
 		configOut.SnapshotAPIKey = configIn.SnapshotAPIKey
 	}
+	if len(configOut.SnapshotAPIKey) == 0 && len(configIn.SnapshotUsername) == 0 && configIn.SnapshotTokenProvider == nil {
+		configOut.sharedSnapshotAuth = true
+		configOut.SnapshotAPIKey = configOut.GrafanaAPIKey
+		configOut.SnapshotUsername = configIn.Username
+		configOut.SnapshotPassword = configIn.Password
+	} else {
+		configOut.SnapshotUsername = configIn.SnapshotUsername
+		configOut.SnapshotPassword = configIn.SnapshotPassword
+	}
+	configOut.SnapshotTokenProvider = configIn.SnapshotTokenProvider
+
+	configOut.Metrics = configIn.Metrics
+	configOut.Debug = configIn.Debug
+	configOut.DashboardTimeout = defaultDuration(configIn.DashboardTimeout, defaultDashboardTimeout)
+	configOut.QueryTimeout = defaultDuration(configIn.QueryTimeout, defaultQueryTimeout)
+	configOut.UploadTimeout = defaultDuration(configIn.UploadTimeout, defaultUploadTimeout)
+	configOut.TakeTimeout = defaultDuration(configIn.TakeTimeout, defaultTakeTimeout)
+	if configIn.ProxyURL != nil {
+		switch configIn.ProxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("Unsupported Config \"ProxyURL\" scheme %q, expected http, https or socks5", configIn.ProxyURL.Scheme)
+		}
+		configOut.ProxyURL = configIn.ProxyURL
+	}
+	if configIn.TLS != nil {
+		tlsConfig, err := processTLSConfig(configIn.TLS)
+		if err != nil {
+			return nil, err
+		}
+		configOut.TLS = tlsConfig
+	}
+	if configIn.Transport != nil && (configIn.TLS != nil || configIn.ProxyURL != nil) {
+		return nil, errors.New("Config field \"Transport\" can't be set with \"TLS\" or \"ProxyURL\"")
+	}
+	configOut.Transport = configIn.Transport
+	configOut.GrafanaAPI = configIn.GrafanaAPI
+	configOut.LedgerPath = configIn.LedgerPath
+	if configIn.OrgID < 0 {
+		return nil, errors.New("Config field \"OrgID\" cannot be negative")
+	}
+	configOut.OrgID = configIn.OrgID
+	configOut.ViewerParams = configIn.ViewerParams
+	if configIn.Retry != nil {
+		retry, err := processRetryConfig(configIn.Retry)
+		if err != nil {
+			return nil, err
+		}
+		configOut.Retry = retry
+	}
+	if configIn.RateLimit != nil {
+		rateLimit, err := processRateLimit(configIn.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		configOut.RateLimit = rateLimit
+	}
+	switch configIn.OnQueryError {
+	case "", QueryErrorFail, QueryErrorWarn:
+		configOut.OnQueryError = configIn.OnQueryError
+	default:
+		return nil, fmt.Errorf("Unknown Config \"OnQueryError\" %q, expected %q or %q", configIn.OnQueryError, QueryErrorFail, QueryErrorWarn)
+	}
+	if _, _, err := parseVersion(configIn.GrafanaVersion); err != nil {
+		return nil, fmt.Errorf("Invalid Config \"GrafanaVersion\": %s", err.Error())
+	}
+	configOut.GrafanaVersion = configIn.GrafanaVersion
+	if configIn.MaxLogLines < 0 {
+		return nil, errors.New("Config field \"MaxLogLines\" cannot be negative")
+	}
+	configOut.MaxLogLines = configIn.MaxLogLines
+	if configIn.MaxDataPoints < 0 {
+		return nil, errors.New("Config field \"MaxDataPoints\" cannot be negative")
+	}
+	configOut.MaxDataPoints = configIn.MaxDataPoints
+	if len(configIn.DirectDatasources) > 0 {
+		configOut.DirectDatasources = make(map[string]*DirectDatasource, len(configIn.DirectDatasources))
+		for name, direct := range configIn.DirectDatasources {
+			direct, err := processDirectDatasource(name, direct)
+			if err != nil {
+				return nil, err
+			}
+			configOut.DirectDatasources[name] = direct
+		}
+	}
+	if configIn.Cassette != nil {
+		cassette, err := processCassetteConfig(configIn.Cassette)
+		if err != nil {
+			return nil, err
+		}
+		configOut.Cassette = cassette
+	}
+	if configIn.Logger == nil {
+		configOut.Logger = nopLogger{}
+	} else {
+		configOut.Logger = configIn.Logger
+	}
 
 	// return ok
 	return configOut, nil
 }
 
+// readKeyFile reads an API key from a file
+func readKeyFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Could not read API key file: %s", err.Error())
+	}
+	key := strings.TrimSpace(string(b))
+	if len(key) == 0 {
+		return "", fmt.Errorf("API key file %q is empty", path)
+	}
+	return key, nil
+}
+
+func defaultDuration(d, defaultD time.Duration) time.Duration {
+	if d == 0 {
+		return defaultD
+	}
+	return d
+}
+
+// withTimeout returns a context which is cancelled after timeout, unless it's
+// negative
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout < 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func processTakeConfig(configIn *TakeConfig) (*TakeConfig, error) {
 	configOut := &TakeConfig{}
 
-	// Parse DashSlug
-	if len(configIn.DashSlug) == 0 {
-		return nil, errors.New("Missing required Config field: \"DashSlug\"")
+	// Parse DashSlug and DashUID
+	if len(configIn.DashSlug) == 0 && len(configIn.DashUID) == 0 {
+		return nil, errors.New("Missing required Config field: \"DashSlug\" or \"DashUID\"")
 	}
 	configOut.DashSlug = configIn.DashSlug
+	configOut.DashUID = configIn.DashUID
 
 	// Parse From
 	if configIn.From == nil {
@@ -105,11 +524,140 @@ func processTakeConfig(configIn *TakeConfig) (*TakeConfig, error) {
 	} else {
 		configOut.Expires = configIn.Expires
 	}
+	// Parse Location
+	if configIn.Location == nil {
+		configOut.Location = configIn.To.Location()
+	} else {
+		configOut.Location = configIn.Location
+	}
 	// Parse SnapshotName
 	if len(configIn.SnapshotName) == 0 {
-		configOut.SnapshotName = fmt.Sprintf("%s %s", configIn.To.Format("2006-01-02"), configIn.DashSlug)
+		configOut.SnapshotName = fmt.Sprintf("%s %s", configIn.To.In(configOut.Location).Format("2006-01-02"), configOut.dashboardID())
 	} else {
 		configOut.SnapshotName = configIn.SnapshotName
+		nameTemplate, err := parseNameTemplate(configIn.SnapshotName)
+		if err != nil {
+			return nil, err
+		}
+		configOut.nameTemplate = nameTemplate
+	}
+	// Parse RefreshVariables
+	configOut.RefreshVariables = configIn.RefreshVariables
+	// Parse OutputPath
+	configOut.OutputPath = configIn.OutputPath
+	// Parse MaxConcurrency
+	if configIn.MaxConcurrency < 0 {
+		return nil, errors.New("TakeConfig field \"MaxConcurrency\" cannot be negative")
+	} else if configIn.MaxConcurrency == 0 {
+		configOut.MaxConcurrency = defaultMaxConcurrency
+	} else {
+		configOut.MaxConcurrency = configIn.MaxConcurrency
+	}
+	// Parse MaxPoints and DownsampleMethod
+	if configIn.MaxPoints < 0 {
+		return nil, errors.New("TakeConfig field \"MaxPoints\" cannot be negative")
+	}
+	configOut.MaxPoints = configIn.MaxPoints
+	switch configIn.DownsampleMethod {
+	case "":
+		if configOut.MaxPoints > 0 || configIn.DownsampleToFit {
+			configOut.DownsampleMethod = DownsampleLTTB
+		}
+	case DownsampleLTTB, DownsampleAverage:
+		configOut.DownsampleMethod = configIn.DownsampleMethod
+	default:
+		return nil, fmt.Errorf("Unknown TakeConfig \"DownsampleMethod\" %q, expected %q or %q", configIn.DownsampleMethod, DownsampleLTTB, DownsampleAverage)
+	}
+	// Parse MaxPayloadBytes and DownsampleToFit
+	if configIn.MaxPayloadBytes < 0 {
+		return nil, errors.New("TakeConfig field \"MaxPayloadBytes\" cannot be negative")
+	}
+	if configIn.DownsampleToFit && configIn.MaxPayloadBytes == 0 {
+		return nil, errors.New("TakeConfig field \"DownsampleToFit\" requires \"MaxPayloadBytes\"")
+	}
+	configOut.MaxPayloadBytes = configIn.MaxPayloadBytes
+	configOut.DownsampleToFit = configIn.DownsampleToFit
+	// Parse External
+	configOut.External = configIn.External
+	// Parse Key and DeleteKey
+	keys := []struct{ field, key string }{{"Key", configIn.Key}, {"DeleteKey", configIn.DeleteKey}}
+	for _, k := range keys {
+		if len(k.key) > 0 && !validSnapshotKey.MatchString(k.key) {
+			return nil, fmt.Errorf("TakeConfig field %q can only contain letters, digits, \"-\" and \"_\"", k.field)
+		}
+		if len(k.key) > 0 && configIn.External {
+			return nil, fmt.Errorf("TakeConfig field %q can't be set for External snapshots, which are keyed by the external service", k.field)
+		}
+	}
+	configOut.Key = configIn.Key
+	configOut.DeleteKey = configIn.DeleteKey
+	// Parse OnProgress
+	configOut.OnProgress = configIn.OnProgress
+	// Parse MutateDashboard and MutateSnapshot
+	configOut.MutateDashboard = configIn.MutateDashboard
+	configOut.MutateSnapshot = configIn.MutateSnapshot
+	// Parse QueryHiddenTargets and RefIDs
+	configOut.QueryHiddenTargets = configIn.QueryHiddenTargets
+	configOut.RefIDs = configIn.RefIDs
+	// Parse AnnotationTags
+	configOut.AnnotationTags = configIn.AnnotationTags
+	// Parse CSVDir
+	configOut.CSVDir = configIn.CSVDir
+	// Parse OnQueryError
+	switch configIn.OnQueryError {
+	case "", QueryErrorFail, QueryErrorWarn:
+		configOut.OnQueryError = configIn.OnQueryError
+	default:
+		return nil, fmt.Errorf("Unknown TakeConfig \"OnQueryError\" %q, expected %q or %q", configIn.OnQueryError, QueryErrorFail, QueryErrorWarn)
+	}
+	// Parse PanelLinks
+	switch configIn.PanelLinks {
+	case "", PanelLinksDrop, PanelLinksKeep, PanelLinksAbsolute:
+		configOut.PanelLinks = configIn.PanelLinks
+	default:
+		return nil, fmt.Errorf("Unknown TakeConfig \"PanelLinks\" %q, expected %q, %q or %q", configIn.PanelLinks, PanelLinksDrop, PanelLinksKeep, PanelLinksAbsolute)
+	}
+	// Parse PanelFilter
+	if configIn.PanelFilter != nil {
+		filter, err := processPanelFilter(configIn.PanelFilter)
+		if err != nil {
+			return nil, err
+		}
+		configOut.PanelFilter = filter
+	}
+
+	// return ok
+	return configOut, nil
+}
+
+// dashboardID returns the slug or UID the dashboard is selected by
+func (c *TakeConfig) dashboardID() string {
+	if len(c.DashUID) > 0 {
+		return c.DashUID
+	}
+	return c.DashSlug
+}
+
+func processPruneConfig(configIn *PruneConfig) (*PruneConfig, error) {
+	configOut := &PruneConfig{}
+
+	// Parse OlderThan
+	if configIn.OlderThan < 0 {
+		return nil, errors.New("PruneConfig \"OlderThan\" field cannot be negative")
+	}
+	configOut.OlderThan = configIn.OlderThan
+	// Parse NamePrefix
+	configOut.NamePrefix = configIn.NamePrefix
+	// Parse Expired
+	configOut.Expired = configIn.Expired
+	// Parse DryRun
+	configOut.DryRun = configIn.DryRun
+	// Parse LedgerPath
+	configOut.LedgerPath = configIn.LedgerPath
+
+	// refuse to prune every snapshot
+	if configOut.OlderThan == 0 && len(configOut.NamePrefix) == 0 && !configOut.Expired {
+		return nil, errors.New("PruneConfig requires at least one of \"OlderThan\", \"NamePrefix\" or \"Expired\"")
 	}
 
 	// return ok
@@ -1,7 +1,13 @@
 package snapshot
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -27,10 +33,16 @@ func TestProcessConfig(t *testing.T) {
 				GrafanaAPIKey: "XXXXX",
 			},
 			expected: &Config{
-				GrafanaAddr:    urlGraf,
-				GrafanaAPIKey:  "XXXXX",
-				SnapshotAddr:   urlGraf,
-				SnapshotAPIKey: "XXXXX",
+				GrafanaAddr:        urlGraf,
+				GrafanaAPIKey:      "XXXXX",
+				SnapshotAddr:       urlGraf,
+				SnapshotAPIKey:     "XXXXX",
+				sharedSnapshotAuth: true,
+				Logger:             nopLogger{},
+				DashboardTimeout:   defaultDashboardTimeout,
+				QueryTimeout:       defaultQueryTimeout,
+				UploadTimeout:      defaultUploadTimeout,
+				TakeTimeout:        defaultTakeTimeout,
 			},
 			valid: true,
 		},
@@ -43,10 +55,15 @@ func TestProcessConfig(t *testing.T) {
 				SnapshotAPIKey: "ZZZZZ",
 			},
 			expected: &Config{
-				GrafanaAddr:    urlGraf,
-				GrafanaAPIKey:  "YYYYY",
-				SnapshotAddr:   urlRain,
-				SnapshotAPIKey: "ZZZZZ",
+				GrafanaAddr:      urlGraf,
+				GrafanaAPIKey:    "YYYYY",
+				SnapshotAddr:     urlRain,
+				SnapshotAPIKey:   "ZZZZZ",
+				Logger:           nopLogger{},
+				DashboardTimeout: defaultDashboardTimeout,
+				QueryTimeout:     defaultQueryTimeout,
+				UploadTimeout:    defaultUploadTimeout,
+				TakeTimeout:      defaultTakeTimeout,
 			},
 			valid: true,
 		},
@@ -59,13 +76,116 @@ func TestProcessConfig(t *testing.T) {
 				SnapshotAPIKey: "ZZZZZ",
 			},
 			expected: &Config{
-				GrafanaAddr:    urlGraf,
-				GrafanaAPIKey:  "YYYYY",
-				SnapshotAddr:   urlRain,
-				SnapshotAPIKey: "ZZZZZ",
+				GrafanaAddr:      urlGraf,
+				GrafanaAPIKey:    "YYYYY",
+				SnapshotAddr:     urlRain,
+				SnapshotAPIKey:   "ZZZZZ",
+				Logger:           nopLogger{},
+				DashboardTimeout: defaultDashboardTimeout,
+				QueryTimeout:     defaultQueryTimeout,
+				UploadTimeout:    defaultUploadTimeout,
+				TakeTimeout:      defaultTakeTimeout,
+			},
+			valid: true,
+		},
+		{
+			purpose: "Basic auth Config",
+			in: &Config{
+				GrafanaAddr: urlGraf,
+				Username:    "admin",
+				Password:    "secret",
+			},
+			expected: &Config{
+				GrafanaAddr:        urlGraf,
+				Username:           "admin",
+				Password:           "secret",
+				SnapshotAddr:       urlGraf,
+				SnapshotUsername:   "admin",
+				SnapshotPassword:   "secret",
+				sharedSnapshotAuth: true,
+				Logger:             nopLogger{},
+				DashboardTimeout:   defaultDashboardTimeout,
+				QueryTimeout:       defaultQueryTimeout,
+				UploadTimeout:      defaultUploadTimeout,
+				TakeTimeout:        defaultTakeTimeout,
+			},
+			valid: true,
+		},
+		{
+			purpose: "Snapshot host basic auth with Grafana API key",
+			in: &Config{
+				GrafanaAddr:      urlGraf,
+				GrafanaAPIKey:    "XXXXX",
+				SnapshotAddr:     urlRain,
+				SnapshotUsername: "snap",
+				SnapshotPassword: "secret",
+			},
+			expected: &Config{
+				GrafanaAddr:      urlGraf,
+				GrafanaAPIKey:    "XXXXX",
+				SnapshotAddr:     urlRain,
+				SnapshotUsername: "snap",
+				SnapshotPassword: "secret",
+				Logger:           nopLogger{},
+				DashboardTimeout: defaultDashboardTimeout,
+				QueryTimeout:     defaultQueryTimeout,
+				UploadTimeout:    defaultUploadTimeout,
+				TakeTimeout:      defaultTakeTimeout,
 			},
 			valid: true,
 		},
+		{
+			purpose: "Custom timeouts",
+			in: &Config{
+				GrafanaAddr:   urlGraf,
+				GrafanaAPIKey: "XXXXX",
+				QueryTimeout:  5 * time.Minute,
+				TakeTimeout:   -1,
+			},
+			expected: &Config{
+				GrafanaAddr:        urlGraf,
+				GrafanaAPIKey:      "XXXXX",
+				SnapshotAddr:       urlGraf,
+				SnapshotAPIKey:     "XXXXX",
+				sharedSnapshotAuth: true,
+				Logger:             nopLogger{},
+				DashboardTimeout:   defaultDashboardTimeout,
+				QueryTimeout:       5 * time.Minute,
+				UploadTimeout:      defaultUploadTimeout,
+				TakeTimeout:        -1,
+			},
+			valid: true,
+		},
+		{
+			purpose: "Negative OrgID",
+			in: &Config{
+				GrafanaAddr:   urlGraf,
+				GrafanaAPIKey: "XXXXX",
+				OrgID:         -1,
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "Negative MaxLogLines",
+			in: &Config{
+				GrafanaAddr:   urlGraf,
+				GrafanaAPIKey: "XXXXX",
+				MaxLogLines:   -1,
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "Negative MaxDataPoints",
+			in: &Config{
+				GrafanaAddr:   urlGraf,
+				GrafanaAPIKey: "XXXXX",
+				MaxDataPoints: -1,
+			},
+			expected: nil,
+			valid:    false,
+		},
 		{
 			purpose: "Missing required GrafanaAPIKey",
 			in: &Config{
@@ -112,6 +232,8 @@ func TestProcessTakeConfig(t *testing.T) {
 	// some vars
 	from := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.Local)
 	to := time.Date(2017, time.February, 05, 12, 0, 0, 0, time.Local)
+	late := time.Date(2017, time.February, 05, 22, 0, 0, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
 	vars := make(map[string]string)
 	vars["key1"] = "val1"
 	vars["key2"] = "val2"
@@ -130,34 +252,156 @@ func TestProcessTakeConfig(t *testing.T) {
 				To:       &to,
 			},
 			expected: &TakeConfig{
-				DashSlug:     "test-slug",
-				From:         &from,
-				To:           &to,
-				Vars:         make(map[string]string),
-				Expires:      time.Second * 0,
-				SnapshotName: from.Format("2006-01-02") + " test-slug",
+				DashSlug:       "test-slug",
+				From:           &from,
+				To:             &to,
+				Vars:           make(map[string]string),
+				Expires:        time.Second * 0,
+				SnapshotName:   from.Format("2006-01-02") + " test-slug",
+				Location:       time.Local,
+				MaxConcurrency: 4,
 			},
 			valid: true,
 		},
 		{
 			purpose: "Complete valid config",
 			in: &TakeConfig{
-				DashSlug:     "test-slug",
-				From:         &from,
-				To:           &to,
-				Vars:         vars,
-				Expires:      time.Second * 3600,
-				SnapshotName: "My Test Snapshot",
+				DashSlug:       "test-slug",
+				From:           &from,
+				To:             &to,
+				Vars:           vars,
+				Expires:        time.Second * 3600,
+				SnapshotName:   "My Test Snapshot",
+				MaxConcurrency: 10,
+			},
+			expected: &TakeConfig{
+				DashSlug:       "test-slug",
+				From:           &from,
+				To:             &to,
+				Vars:           vars,
+				Expires:        time.Second * 3600,
+				SnapshotName:   "My Test Snapshot",
+				Location:       time.Local,
+				MaxConcurrency: 10,
+			},
+			valid: true,
+		},
+		{
+			purpose: "Dashboard UID instead of slug",
+			in: &TakeConfig{
+				DashUID: "Abc123",
+				From:    &from,
+				To:      &to,
+			},
+			expected: &TakeConfig{
+				DashUID:        "Abc123",
+				From:           &from,
+				To:             &to,
+				Vars:           make(map[string]string),
+				Expires:        time.Second * 0,
+				SnapshotName:   from.Format("2006-01-02") + " Abc123",
+				Location:       time.Local,
+				MaxConcurrency: 4,
+			},
+			valid: true,
+		},
+		{
+			purpose: "Name dated in location",
+			in: &TakeConfig{
+				DashSlug: "test-slug",
+				From:     &from,
+				To:       &late,
+				Location: tokyo,
 			},
 			expected: &TakeConfig{
+				DashSlug:       "test-slug",
+				From:           &from,
+				To:             &late,
+				Vars:           make(map[string]string),
+				SnapshotName:   "2017-02-06 test-slug",
+				Location:       tokyo,
+				MaxConcurrency: 4,
+			},
+			valid: true,
+		},
+		{
+			purpose: "Unknown downsample method",
+			in: &TakeConfig{
+				DashSlug:         "test-slug",
+				From:             &from,
+				To:               &to,
+				MaxPoints:        500,
+				DownsampleMethod: "median",
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "Unknown panel links mode",
+			in: &TakeConfig{
+				DashSlug:   "test-slug",
+				From:       &from,
+				To:         &to,
+				PanelLinks: "rewrite",
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "Invalid SnapshotName template",
+			in: &TakeConfig{
 				DashSlug:     "test-slug",
 				From:         &from,
 				To:           &to,
-				Vars:         vars,
-				Expires:      time.Second * 3600,
-				SnapshotName: "My Test Snapshot",
+				SnapshotName: "{{.DashTitle",
 			},
-			valid: true,
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "Invalid Key",
+			in: &TakeConfig{
+				DashSlug: "test-slug",
+				From:     &from,
+				To:       &to,
+				Key:      "nightly/payments",
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "DeleteKey for External snapshot",
+			in: &TakeConfig{
+				DashSlug:  "test-slug",
+				From:      &from,
+				To:        &to,
+				DeleteKey: "nightly-delete",
+				External:  true,
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "Invalid panel filter pattern",
+			in: &TakeConfig{
+				DashSlug:    "test-slug",
+				From:        &from,
+				To:          &to,
+				PanelFilter: &PanelFilter{Include: []string{"[SLO"}},
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "Negative concurrency",
+			in: &TakeConfig{
+				DashSlug:       "test-slug",
+				From:           &from,
+				To:             &to,
+				MaxConcurrency: -1,
+			},
+			expected: nil,
+			valid:    false,
 		},
 		{
 			purpose: "Invalid time-range",
@@ -195,3 +439,237 @@ func TestProcessTakeConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestProcessPruneConfig(t *testing.T) {
+	// configs to test
+	var pruneConfigTests = []struct {
+		purpose  string
+		in       *PruneConfig // input config
+		expected *PruneConfig // expected config
+		valid    bool         // expected result
+	}{
+		{
+			purpose: "Valid config",
+			in: &PruneConfig{
+				OlderThan:  time.Hour * 24 * 30,
+				NamePrefix: "daily ",
+			},
+			expected: &PruneConfig{
+				OlderThan:  time.Hour * 24 * 30,
+				NamePrefix: "daily ",
+			},
+			valid: true,
+		},
+		{
+			purpose: "Negative age",
+			in: &PruneConfig{
+				OlderThan: -time.Hour,
+			},
+			expected: nil,
+			valid:    false,
+		},
+		{
+			purpose: "No criteria",
+			in: &PruneConfig{
+				DryRun: true,
+			},
+			expected: nil,
+			valid:    false,
+		},
+	}
+	// test
+	for _, pct := range pruneConfigTests {
+		out, err := processPruneConfig(pct.in)
+		if pct.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed validation: %s", pct.purpose, err.Error())
+		} else if !pct.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed validation", pct.purpose)
+		} else {
+			if !reflect.DeepEqual(out, pct.expected) {
+				t.Errorf("Test \"%s\" DeepEqual compare failed", pct.purpose)
+				t.Logf("Expected:\n%v\nActual:\n%v", pct.expected, out)
+			}
+		}
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	grafanaURL, _ := url.Parse("http://grafana.internal/")
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", ProxyURL: proxyURL})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = sc.grafanaGet(context.Background(), "api/health", nil); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if expected := "http://grafana.internal/api/health"; proxied != expected {
+		t.Errorf("Expected the proxy to get %q, got %q", expected, proxied)
+	}
+
+	ftpURL, _ := url.Parse("ftp://proxy.internal/")
+	if _, err = NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", ProxyURL: ftpURL}); err == nil {
+		t.Errorf("Unsupported proxy scheme unexpectedly passed")
+	}
+}
+
+// countingTransport counts the requests made through it
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTransport(t *testing.T) {
+	var auth string
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+
+	transport := &countingTransport{}
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", Transport: transport})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = sc.grafanaGet(context.Background(), "api/health", nil); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if transport.requests != 1 || auth != "Bearer XXXXX" {
+		t.Errorf("Expected one authenticated request through the transport, got %d with %q", transport.requests, auth)
+	}
+
+	if _, err = NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", Transport: transport, TLS: &TLSConfig{Insecure: true}}); err == nil {
+		t.Errorf("Transport with TLS unexpectedly passed")
+	}
+}
+
+func TestAPIKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-keys")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "grafana-key")
+	emptyFile := filepath.Join(dir, "empty")
+	ioutil.WriteFile(keyFile, []byte("XXXXX\n"), 0600)
+	ioutil.WriteFile(emptyFile, []byte("\n"), 0600)
+	urlGraf, _ := url.Parse("https://grafana.net/")
+
+	keyTests := []struct {
+		purpose  string
+		in       *Config
+		valid    bool
+		expected string // expected snapshot API key
+	}{
+		{
+			purpose:  "Key file",
+			in:       &Config{GrafanaAddr: urlGraf, GrafanaAPIKeyFile: keyFile},
+			valid:    true,
+			expected: "XXXXX",
+		},
+		{
+			purpose:  "Snapshot key file",
+			in:       &Config{GrafanaAddr: urlGraf, GrafanaAPIKey: "YYYYY", SnapshotAPIKeyFile: keyFile},
+			valid:    true,
+			expected: "XXXXX",
+		},
+		{
+			purpose: "Key and key file",
+			in:      &Config{GrafanaAddr: urlGraf, GrafanaAPIKey: "YYYYY", GrafanaAPIKeyFile: keyFile},
+			valid:   false,
+		},
+		{
+			purpose: "Missing key file",
+			in:      &Config{GrafanaAddr: urlGraf, GrafanaAPIKeyFile: filepath.Join(dir, "missing")},
+			valid:   false,
+		},
+		{
+			purpose: "Empty key file",
+			in:      &Config{GrafanaAddr: urlGraf, GrafanaAPIKeyFile: emptyFile},
+			valid:   false,
+		},
+	}
+	// test
+	for _, kt := range keyTests {
+		out, err := processConfig(kt.in)
+		if kt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed validation: %s", kt.purpose, err.Error())
+		} else if !kt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed validation", kt.purpose)
+		} else if kt.valid && out.SnapshotAPIKey != kt.expected {
+			t.Errorf("Test \"%s\" expected snapshot API key %q, got %q", kt.purpose, kt.expected, out.SnapshotAPIKey)
+		}
+	}
+}
+
+func TestQueryTarget(t *testing.T) {
+	panel := map[string]interface{}{"id": float64(12)}
+	other := map[string]interface{}{"id": float64(14)}
+	queryTests := []struct {
+		purpose  string
+		config   *TakeConfig
+		panel    map[string]interface{}
+		target   map[string]interface{}
+		expected bool
+	}{
+		{
+			purpose:  "Shown target",
+			config:   &TakeConfig{},
+			panel:    panel,
+			target:   map[string]interface{}{"refId": "A"},
+			expected: true,
+		},
+		{
+			purpose:  "Hidden target",
+			config:   &TakeConfig{},
+			panel:    panel,
+			target:   map[string]interface{}{"refId": "A", "hide": true},
+			expected: false,
+		},
+		{
+			purpose:  "Hidden target queried",
+			config:   &TakeConfig{QueryHiddenTargets: true},
+			panel:    panel,
+			target:   map[string]interface{}{"refId": "A", "hide": true},
+			expected: true,
+		},
+		{
+			purpose:  "Selected refId",
+			config:   &TakeConfig{RefIDs: map[int][]string{12: {"A", "C"}}},
+			panel:    panel,
+			target:   map[string]interface{}{"refId": "C"},
+			expected: true,
+		},
+		{
+			purpose:  "Unselected refId",
+			config:   &TakeConfig{RefIDs: map[int][]string{12: {"A", "C"}}},
+			panel:    panel,
+			target:   map[string]interface{}{"refId": "B"},
+			expected: false,
+		},
+		{
+			purpose:  "Other panel's refIds",
+			config:   &TakeConfig{RefIDs: map[int][]string{12: {"A", "C"}}},
+			panel:    other,
+			target:   map[string]interface{}{"refId": "B"},
+			expected: true,
+		},
+	}
+	// test
+	for _, qt := range queryTests {
+		if out := qt.config.queryTarget(qt.panel, qt.target); out != qt.expected {
+			t.Errorf("Test \"%s\" expected %t, got %t", qt.purpose, qt.expected, out)
+		}
+	}
+}
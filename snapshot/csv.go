@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// writeCSVFiles writes the data points of each panel with snapshot data to
+// panel-<id>.csv in dir, returning the files' paths. Each row is a data
+// point, with its time in loc, series and value; null values are empty.
+func writeCSVFiles(dir string, dashboard map[string]interface{}, loc *time.Location) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, panel := range dashboardPanels(dashboard) {
+		data, ok := panel["snapshotData"].([]interface{})
+		id, hasID := panel["id"].(float64)
+		if !ok || !hasID {
+			continue
+		}
+		path := filepath.Join(dir, fmt.Sprintf("panel-%d.csv", int(id)))
+		if err := writeCSVFile(path, data, loc); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeCSVFile(path string, data []interface{}, loc *time.Location) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"time", "series", "value"})
+	for _, d := range data {
+		series, ok := d.(SnapshotData)
+		if !ok {
+			continue
+		}
+		for _, dp := range series.Datapoints {
+			if len(dp) < 2 {
+				continue
+			}
+			ms, _ := dp[1].(float64)
+			t := time.Unix(0, int64(ms)*int64(time.Millisecond)).In(loc)
+			w.Write([]string{t.Format(time.RFC3339Nano), series.Target, csvValue(dp[0])})
+		}
+	}
+	w.Flush()
+	err = w.Error()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// csvValue formats a data point's value without an exponent, so spreadsheets
+// read it as written
+func csvValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		return value
+	}
+	return fmt.Sprint(v)
+}
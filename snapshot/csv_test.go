@@ -0,0 +1,47 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteCSVFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-csv")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	dashboard := map[string]interface{}{
+		"panels": []interface{}{
+			map[string]interface{}{"id": float64(2), "snapshotData": []interface{}{
+				SnapshotData{Target: "up{instance=\"a\"}", Datapoints: [][]interface{}{{1.5, float64(1500000000000)}, {nil, float64(1500000060000)}}},
+				SnapshotData{Target: "b", Datapoints: [][]interface{}{{float64(1e21), float64(1500000000000)}}},
+			}},
+			map[string]interface{}{"id": float64(3), "type": "text"},
+		},
+	}
+	paths, err := writeCSVFiles(filepath.Join(dir, "data"), dashboard, time.UTC)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if expected := []string{filepath.Join(dir, "data", "panel-2.csv")}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected files %q, got %q", expected, paths)
+	}
+	b, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	expected := `time,series,value
+2017-07-14T02:40:00Z,"up{instance=""a""}",1.5
+2017-07-14T02:41:00Z,"up{instance=""a""}",
+2017-07-14T02:40:00Z,b,1000000000000000000000
+`
+	if string(b) != expected {
+		t.Errorf("Expected CSV %q, got %q", expected, string(b))
+	}
+}
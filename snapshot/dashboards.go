@@ -0,0 +1,239 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DashboardSummary describes a dashboard found with Grafana's search API
+type DashboardSummary struct {
+	ID          int64  `json:"id"`
+	UID         string `json:"uid"`
+	Title       string `json:"title"`
+	URI         string `json:"uri"`
+	URL         string `json:"url"`
+	FolderUID   string `json:"folderUid"`
+	FolderTitle string `json:"folderTitle"`
+}
+
+// Slug returns the dashboard's slug, for Grafana versions without UIDs. Newer
+// Grafanas without URIs give it as the last part of the URL, /d/<uid>/<slug>.
+func (d DashboardSummary) Slug() string {
+	if len(d.URI) == 0 && len(d.URL) > 0 {
+		return d.URL[strings.LastIndex(d.URL, "/")+1:]
+	}
+	return strings.TrimPrefix(d.URI, "db/")
+}
+
+// DashboardLocation is for finding the time zone a dashboard is configured to
+// be shown in. Dashboards shown in the browser's time zone return time.Local.
+func (sc *SnapClient) DashboardLocation(config *TakeConfig) (*time.Location, error) {
+	return sc.DashboardLocationWithContext(context.Background(), config)
+}
+
+// DashboardLocationWithContext is for finding a dashboard's time zone, using
+// ctx for the request made to Grafana
+func (sc *SnapClient) DashboardLocationWithContext(ctx context.Context, config *TakeConfig) (*time.Location, error) {
+	rawDashString, err := sc.getDashboardDef(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	dashboard, err := parseDashboard(rawDashString)
+	if err != nil {
+		return nil, err
+	}
+	timezone, _ := dashboard["timezone"].(string)
+	return ParseLocation(timezone)
+}
+
+// folderSummary is a folder returned by Grafana's folders API
+type folderSummary struct {
+	ID    int64  `json:"id"`
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+}
+
+// The folder dashboards not in any folder belong to, which has no UID
+const generalFolder = "General"
+
+// Dashboards is for finding every dashboard on the Grafana instance which the
+// API key can see
+func (sc *SnapClient) Dashboards() ([]DashboardSummary, error) {
+	return sc.DashboardsWithContext(context.Background())
+}
+
+// DashboardsWithContext is for finding every dashboard, using ctx for the
+// requests made to Grafana
+func (sc *SnapClient) DashboardsWithContext(ctx context.Context) ([]DashboardSummary, error) {
+	return sc.searchDashboards(ctx, url.Values{})
+}
+
+// FolderDashboards is for finding the dashboards in a folder, given the
+// folder's UID or title
+func (sc *SnapClient) FolderDashboards(folder string) ([]DashboardSummary, error) {
+	return sc.FolderDashboardsWithContext(context.Background(), folder)
+}
+
+// FolderDashboardsWithContext is for finding the dashboards in a folder, using
+// ctx for the requests made to Grafana
+func (sc *SnapClient) FolderDashboardsWithContext(ctx context.Context, folder string) ([]DashboardSummary, error) {
+	body, err := sc.grafanaGet(ctx, "api/folders", url.Values{"limit": {"1000"}})
+	if err != nil {
+		return nil, err
+	}
+	var folders []folderSummary
+	if err = json.Unmarshal(body, &folders); err != nil {
+		return nil, fmt.Errorf("Could not decode folders json: %s", err.Error())
+	}
+	id, err := findFolder(folders, folder)
+	if err != nil {
+		return nil, err
+	}
+	return sc.searchDashboards(ctx, url.Values{"folderIds": {strconv.FormatInt(id, 10)}})
+}
+
+// findFolder returns the ID of the folder with the given UID or title
+func findFolder(folders []folderSummary, folder string) (int64, error) {
+	for _, f := range folders {
+		if f.UID == folder {
+			return f.ID, nil
+		}
+	}
+	var matches []folderSummary
+	for _, f := range folders {
+		if f.Title == folder {
+			matches = append(matches, f)
+		}
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0].ID, nil
+	case len(matches) > 1:
+		return 0, fmt.Errorf("Folder title %q is ambiguous, use the folder's UID", folder)
+	case folder == generalFolder:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("No folder found with UID or title %q", folder)
+}
+
+// The most results Grafana's search API returns per page
+const searchPageLimit = 5000
+
+// searchDashboards returns the dashboards matching the search API query,
+// requesting pages until one isn't full
+func (sc *SnapClient) searchDashboards(ctx context.Context, query url.Values) ([]DashboardSummary, error) {
+	query.Set("type", "dash-db")
+	query.Set("limit", strconv.Itoa(searchPageLimit))
+	var dashboards []DashboardSummary
+	seen := make(map[int64]bool)
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		body, err := sc.grafanaGet(ctx, "api/search", query)
+		if err != nil {
+			return nil, err
+		}
+		var results []DashboardSummary
+		if err = json.Unmarshal(body, &results); err != nil {
+			return nil, fmt.Errorf("Could not decode search json: %s", err.Error())
+		}
+		for _, d := range results {
+			// Grafana versions without paging return the first page again
+			if seen[d.ID] {
+				return dashboards, nil
+			}
+			seen[d.ID] = true
+			dashboards = append(dashboards, d)
+		}
+		if len(results) < searchPageLimit {
+			return dashboards, nil
+		}
+	}
+}
+
+// grafanaGet makes an authenticated GET request to Grafana's API, returning
+// the response body
+func (sc *SnapClient) grafanaGet(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, sc.config.DashboardTimeout)
+	defer cancel()
+
+	reqURL := *sc.config.GrafanaAddr
+	reqURL.Path = reqURL.Path + path
+	reqURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if req, err = sc.grafanaAuth(req); err != nil {
+		return nil, err
+	}
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = unauthorized("Grafana", resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code from %s: %s", path, resp.Status)
+	}
+	return body, nil
+}
+
+// resolveLibraryPanels replaces the dashboard's library panels, which only
+// refer to their library element, with the panel model from Grafana's
+// library elements API, keeping their ID and position on the dashboard. Each
+// library element is got once.
+func (sc *SnapClient) resolveLibraryPanels(ctx context.Context, dashboard map[string]interface{}) error {
+	models := make(map[string]map[string]interface{})
+	for _, panel := range dashboardPanels(dashboard) {
+		libraryPanel, _ := panel["libraryPanel"].(map[string]interface{})
+		uid, _ := libraryPanel["uid"].(string)
+		if len(uid) == 0 {
+			continue
+		}
+		model, ok := models[uid]
+		if !ok {
+			body, err := sc.grafanaGet(ctx, "api/library-elements/"+url.PathEscape(uid), url.Values{})
+			if err != nil {
+				return fmt.Errorf("Could not get library panel %q: %s", libraryPanel["name"], err.Error())
+			}
+			var element struct {
+				Result struct {
+					Model map[string]interface{} `json:"model"`
+				} `json:"result"`
+			}
+			if err = json.Unmarshal(body, &element); err != nil {
+				return fmt.Errorf("Could not decode library panel %q: %s", libraryPanel["name"], err.Error())
+			}
+			if model = element.Result.Model; model == nil {
+				return fmt.Errorf("Library panel %q has no model", libraryPanel["name"])
+			}
+			models[uid] = model
+		}
+		for key, v := range copyJSON(model).(map[string]interface{}) {
+			switch key {
+			case "id", "gridPos", "libraryPanel":
+			default:
+				panel[key] = v
+			}
+		}
+		// so Grafana doesn't try to load the library panel again when the
+		// snapshot's shown
+		delete(panel, "libraryPanel")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestFindFolder(t *testing.T) {
+	// some vars
+	folders := []folderSummary{
+		{ID: 1, UID: "team-a", Title: "Team A"},
+		{ID: 2, UID: "team-b", Title: "Team B"},
+		{ID: 3, UID: "team-b-old", Title: "Team B"},
+	}
+	// folders to test
+	var folderTests = []struct {
+		purpose  string
+		folder   string
+		expected int64
+		valid    bool
+	}{
+		{
+			purpose:  "By UID",
+			folder:   "team-b",
+			expected: 2,
+			valid:    true,
+		},
+		{
+			purpose:  "By title",
+			folder:   "Team A",
+			expected: 1,
+			valid:    true,
+		},
+		{
+			purpose:  "General folder",
+			folder:   "General",
+			expected: 0,
+			valid:    true,
+		},
+		{
+			purpose: "Ambiguous title",
+			folder:  "Team B",
+			valid:   false,
+		},
+		{
+			purpose: "Unknown folder",
+			folder:  "Team C",
+			valid:   false,
+		},
+	}
+	// test
+	for _, ft := range folderTests {
+		id, err := findFolder(folders, ft.folder)
+		if ft.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", ft.purpose, err.Error())
+		} else if !ft.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", ft.purpose)
+		} else if id != ft.expected {
+			t.Errorf("Test \"%s\" expected folder %d, got %d", ft.purpose, ft.expected, id)
+		}
+	}
+}
+
+func TestLibraryPanels(t *testing.T) {
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	from, to := time.Unix(0, 0).UTC(), time.Unix(3600, 0).UTC()
+	libraryPanel := map[string]interface{}{"uid": "cpu", "name": "CPU"}
+	srv.AddDashboard("library", map[string]interface{}{
+		"uid": "library",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "gridPos": map[string]interface{}{"x": 0.0}, "libraryPanel": libraryPanel},
+			map[string]interface{}{"id": 2.0, "type": "row", "collapsed": true, "panels": []interface{}{
+				map[string]interface{}{"id": 3.0, "gridPos": map[string]interface{}{"x": 12.0}, "libraryPanel": libraryPanel},
+			}},
+		},
+	})
+	srv.AddLibraryPanel("cpu", map[string]interface{}{
+		"id": 9.0, "title": "CPU", "type": "timeseries", "datasource": "prom",
+		"gridPos": map[string]interface{}{"x": 6.0},
+		"targets": []interface{}{map[string]interface{}{"refId": "A", "expr": "up"}},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "prom", "type": "prometheus"})
+	srv.AddSeries("up", snapshottest.Series{Points: []snapshottest.Point{{Time: from.Add(time.Minute), Value: 1}}})
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	snapshot, err := sc.Take(&TakeConfig{DashUID: "library", From: &from, To: &to})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if snapshot.Panels != 2 || snapshot.Datapoints != 2 {
+		t.Errorf("Expected both library panels to be queried, got %d panels with %d data points", snapshot.Panels, snapshot.Datapoints)
+	}
+	dashboard, _ := srv.Snapshots()[0]["dashboard"].(map[string]interface{})
+	for _, panel := range dashboardPanels(dashboard) {
+		if panel["type"] == "row" {
+			continue
+		}
+		gridPos, _ := panel["gridPos"].(map[string]interface{})
+		if panel["title"] != "CPU" || panel["id"] == 9.0 || gridPos["x"] == 6.0 || panel["libraryPanel"] != nil {
+			t.Errorf("Expected the library panel in place of panel %v, got %v", panel["id"], panel)
+		}
+	}
+
+	srv.AddDashboard("missing", map[string]interface{}{
+		"uid":    "missing",
+		"panels": []interface{}{map[string]interface{}{"id": 1.0, "libraryPanel": map[string]interface{}{"uid": "gone", "name": "Gone"}}},
+	})
+	if _, err = sc.Take(&TakeConfig{DashUID: "missing", From: &from, To: &to}); err == nil {
+		t.Error("Expected a missing library panel to fail")
+	}
+}
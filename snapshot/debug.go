@@ -0,0 +1,110 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The most of a request or response body logged in debug mode
+const maxDebugBody = 16 << 10
+
+// Headers and JSON fields containing these are redacted in debug mode
+var debugSecrets = []string{"authorization", "cookie", "password", "secret", "token", "apikey", "deletekey", "deleteurl"}
+
+// debugTransport logs each request and its response, with credentials
+// redacted
+type debugTransport struct {
+	next   http.RoundTripper
+	logger Logger
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	t.logger.Debug("HTTP request", "method", req.Method, "url", req.URL.String(), "headers", redactHeaders(req.Header), "body", redactBody(reqBody))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.logger.Debug("HTTP request failed", "method", req.Method, "url", req.URL.String(), "duration", time.Since(start), "error", err)
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	t.logger.Debug("HTTP response", "method", req.Method, "url", req.URL.String(), "status", resp.Status, "duration", time.Since(start), "headers", redactHeaders(resp.Header), "body", redactBody(respBody))
+	return resp, nil
+}
+
+func isDebugSecret(name string) bool {
+	name = strings.ToLower(strings.Replace(name, "_", "", -1))
+	for _, secret := range debugSecrets {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders formats headers as 'Name: value; ...', with credentials
+// redacted
+func redactHeaders(header http.Header) string {
+	var headers []string
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if isDebugSecret(name) {
+			value = "REDACTED"
+		}
+		headers = append(headers, name+": "+value)
+	}
+	sort.Strings(headers)
+	return strings.Join(headers, "; ")
+}
+
+// redactBody returns a body for logging, truncated and with secret fields of
+// JSON bodies redacted
+func redactBody(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if b, err := json.Marshal(redactValue(v)); err == nil {
+			body = b
+		}
+	}
+	if len(body) > maxDebugBody {
+		return string(body[:maxDebugBody]) + "...(truncated)"
+	}
+	return string(body)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if isDebugSecret(key) {
+				value[key] = "REDACTED"
+			} else {
+				value[key] = redactValue(item)
+			}
+		}
+	case []interface{}:
+		for idx, item := range value {
+			value[idx] = redactValue(item)
+		}
+	}
+	return v
+}
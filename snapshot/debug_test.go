@@ -0,0 +1,74 @@
+package snapshot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingLogger keeps the debug messages logged, formatted with their
+// context
+type recordingLogger struct {
+	nopLogger
+	messages []string
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) {
+	l.messages = append(l.messages, msg+" "+fmt.Sprint(args...))
+}
+
+func TestDebugTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name":"prom","basicAuthPassword":"hunter2","secureJsonFields":{}}]`))
+	}))
+	defer server.Close()
+
+	logger := &recordingLogger{}
+	sc := &SnapClient{config: &Config{Debug: true, Logger: logger}}
+	req, _ := http.NewRequest("POST", server.URL+"/api/datasources", strings.NewReader(`{"apiKey":"abc"}`))
+	req.Header.Set("Authorization", "Bearer secret-key")
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "hunter2") {
+		t.Errorf("Expected the response body to be unchanged, got %s", body)
+	}
+
+	if len(logger.messages) != 2 {
+		t.Fatalf("Expected a request and response to be logged, got %q", logger.messages)
+	}
+	log := strings.Join(logger.messages, "\n")
+	for _, secret := range []string{"secret-key", "abc", "hunter2"} {
+		if strings.Contains(log, secret) {
+			t.Errorf("Expected %q to be redacted, got %s", secret, log)
+		}
+	}
+	if !strings.Contains(log, `"name":"prom"`) {
+		t.Errorf("Expected the response body to be logged, got %s", log)
+	}
+
+	// snapshot delete keys are credentials, as are the delete URLs ending in them
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"key":"snapkey","url":"http://grafana/dashboard/snapshot/snapkey","deleteKey":"delkey","deleteUrl":"http://grafana/api/snapshots-delete/delkey"}`))
+	}))
+	defer server.Close()
+	logger.messages = nil
+	req, _ = http.NewRequest("POST", server.URL+"/api/snapshots", strings.NewReader(`{}`))
+	if resp, err = sc.httpClient().Do(req); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	resp.Body.Close()
+	log = strings.Join(logger.messages, "\n")
+	if strings.Contains(log, "delkey") {
+		t.Errorf("Expected the snapshot's delete key and URL to be redacted, got %s", log)
+	}
+	if !strings.Contains(log, "snapkey") {
+		t.Errorf("Expected the snapshot's key to be logged, got %s", log)
+	}
+}
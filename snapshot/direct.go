@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DirectDatasource is the address of a datasource to query directly rather
+// than through Grafana's datasource proxy, for datasources whose queries take
+// longer than the proxy allows. Datasources Grafana queries itself, through
+// its query API, as SQL datasources are, are still queried through Grafana.
+type DirectDatasource struct {
+	// Addr is the datasource's address, as its URL in Grafana, e.g.
+	// "http://prometheus:9090/"
+	Addr *url.URL
+	// APIKey, if set, is sent as a bearer token, or else Username and
+	// Password, if set, by basic auth
+	APIKey   string
+	Username string
+	Password string
+}
+
+func processDirectDatasource(name string, configIn *DirectDatasource) (*DirectDatasource, error) {
+	if configIn == nil || configIn.Addr == nil || len(configIn.Addr.String()) == 0 {
+		return nil, fmt.Errorf("Missing required DirectDatasource %q field: \"Addr\"", name)
+	}
+	if configIn.Addr.Scheme != "http" && configIn.Addr.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid DirectDatasource %q \"Addr\" %q, expected an http or https URL", name, configIn.Addr.String())
+	}
+	configOut := *configIn
+	addr := *configIn.Addr
+	if !strings.HasSuffix(addr.Path, "/") {
+		addr.Path = addr.Path + "/"
+	}
+	configOut.Addr = &addr
+	return &configOut, nil
+}
+
+// directDatasource returns the direct address configured for a datasource,
+// by its name or UID, or nil if it's queried through Grafana
+func (sc *SnapClient) directDatasource(datasource map[string]interface{}) *DirectDatasource {
+	for _, key := range []string{"name", "uid"} {
+		if ref, _ := datasource[key].(string); len(ref) > 0 {
+			if direct, ok := sc.config.DirectDatasources[ref]; ok {
+				return direct
+			}
+		}
+	}
+	return nil
+}
+
+// directQueryKey marks the context of requests made directly to a datasource
+type directQueryKey struct{}
+
+// datasourceURL returns the URL requests to a datasource are made under, its
+// direct address or its Grafana datasource proxy
+func (sc *SnapClient) datasourceURL(datasource map[string]interface{}) (url.URL, error) {
+	if direct := sc.directDatasource(datasource); direct != nil {
+		return *direct.Addr, nil
+	}
+	id, ok := datasource["id"].(float64)
+	if !ok {
+		return url.URL{}, errors.New("Datasource has no ID to query it through Grafana's datasource proxy")
+	}
+	reqURL := *sc.config.GrafanaAddr
+	reqURL.Path = reqURL.Path + "api/datasources/proxy/" + strconv.Itoa(int(id)) + "/"
+	return reqURL, nil
+}
+
+// datasourceAuth authenticates a request to a datasource with its direct
+// credentials, or Grafana's if it's queried through the datasource proxy
+func (sc *SnapClient) datasourceAuth(req *http.Request, datasource map[string]interface{}) (*http.Request, error) {
+	direct := sc.directDatasource(datasource)
+	if direct == nil {
+		return sc.grafanaAuth(req)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), directQueryKey{}, true))
+	if len(direct.APIKey) > 0 {
+		req.Header.Set("Authorization", "Bearer "+direct.APIKey)
+	} else if len(direct.Username) > 0 {
+		req.SetBasicAuth(direct.Username, direct.Password)
+	}
+	return req, nil
+}
+
+// datasourceHost names what answers requests to a datasource, for errors
+func (sc *SnapClient) datasourceHost(datasource map[string]interface{}) string {
+	if sc.directDatasource(datasource) != nil {
+		name, _ := datasource["name"].(string)
+		return fmt.Sprintf("Datasource %q", name)
+	}
+	return "Grafana"
+}
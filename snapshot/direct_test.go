@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestDirectDatasources(t *testing.T) {
+	var paths []string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "prom" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "matrix", "result": [
+			{"metric": {"instance": "direct"}, "values": [[60, "1"], [120, "2"]]}
+		]}}`))
+	}))
+	defer prometheus.Close()
+	promURL, _ := url.Parse(prometheus.URL + "/prom")
+
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("direct", map[string]interface{}{
+		"uid": "direct",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "datasource": "prom", "targets": []interface{}{map[string]interface{}{"refId": "A", "expr": "up"}}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "prom", "uid": "prom-uid", "type": "prometheus"})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	// datasources are found by their name or UID
+	for _, ref := range []string{"prom", "prom-uid"} {
+		sc, err := NewSnapClient(&Config{
+			GrafanaAddr:       srv.Addr(),
+			GrafanaAPIKey:     snapshottest.APIKey,
+			DirectDatasources: map[string]*DirectDatasource{ref: {Addr: promURL, Username: "prom", Password: "secret"}},
+		})
+		if err != nil {
+			t.Fatalf("Unexpectedly failed: %s", err.Error())
+		}
+		paths = nil
+		snapshot, err := sc.Take(&TakeConfig{DashUID: "direct", From: &from, To: &to})
+		if err != nil {
+			t.Fatalf("Datasource %q unexpectedly failed: %s", ref, err.Error())
+		}
+		if snapshot.Datapoints != 2 || len(paths) != 1 || paths[0] != "/prom/api/v1/query_range" {
+			t.Errorf("Datasource %q expected 2 data points queried directly, got %d from %v", ref, snapshot.Datapoints, paths)
+		}
+	}
+
+	// the datasource's own credentials are used, not Grafana's
+	sc, err := NewSnapClient(&Config{
+		GrafanaAddr:       srv.Addr(),
+		GrafanaAPIKey:     snapshottest.APIKey,
+		DirectDatasources: map[string]*DirectDatasource{"prom": {Addr: promURL, Username: "prom", Password: "wrong"}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	body, err := sc.DatasourceProxyRequest(context.Background(), "GET", map[string]interface{}{"name": "prom"}, "api/v1/labels", url.Values{}, nil, "")
+	if !errors.Is(err, ErrUnauthorized) || !strings.Contains(err.Error(), `Datasource "prom"`) {
+		t.Errorf("Expected the datasource to reject the credentials, got %q, %v", body, err)
+	}
+
+	if _, err = NewSnapClient(&Config{
+		GrafanaAddr:       srv.Addr(),
+		GrafanaAPIKey:     snapshottest.APIKey,
+		DirectDatasources: map[string]*DirectDatasource{"prom": {}},
+	}); err == nil {
+		t.Error("Expected a DirectDatasource without an Addr to fail")
+	}
+}
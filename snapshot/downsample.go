@@ -0,0 +1,197 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// Methods of downsampling series, for TakeConfig.DownsampleMethod
+const (
+	// DownsampleLTTB picks the points which best keep the shape of the
+	// series, with the Largest-Triangle-Three-Buckets algorithm
+	DownsampleLTTB = "lttb"
+	// DownsampleAverage averages the points in equal sized buckets
+	DownsampleAverage = "average"
+)
+
+// point is a data point of a series, where null is a gap
+type point struct {
+	ts    float64
+	value float64
+	null  bool
+}
+
+// downsample reduces each series to about maxPoints data points, plus a null
+// for each gap with LTTB. Series whose data points aren't numeric
+// [value, timestamp] pairs are left as they are.
+func downsample(data []SnapshotData, method string, maxPoints int) {
+	for idx, series := range data {
+		if len(series.Datapoints) <= maxPoints {
+			continue
+		}
+		points, ok := seriesPoints(series.Datapoints)
+		if !ok {
+			continue
+		}
+		if method == DownsampleAverage {
+			points = averageBuckets(points, maxPoints)
+		} else {
+			points = lttbWithGaps(points, maxPoints)
+		}
+		datapoints := make([][]interface{}, len(points))
+		for i, p := range points {
+			if p.null {
+				datapoints[i] = []interface{}{nil, p.ts}
+			} else {
+				datapoints[i] = []interface{}{p.value, p.ts}
+			}
+		}
+		data[idx].Datapoints = datapoints
+	}
+}
+
+// seriesPoints converts a series' data points, reporting false if they
+// aren't all numeric [value, timestamp] pairs
+func seriesPoints(datapoints [][]interface{}) ([]point, bool) {
+	points := make([]point, len(datapoints))
+	for idx, dp := range datapoints {
+		if len(dp) != 2 {
+			return nil, false
+		}
+		ts, ok := toFloat(dp[1])
+		if !ok {
+			return nil, false
+		}
+		points[idx].ts = ts
+		if dp[0] == nil {
+			points[idx].null = true
+		} else if points[idx].value, ok = toFloat(dp[0]); !ok {
+			return nil, false
+		}
+	}
+	return points, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// averageBuckets splits the points into n buckets, replacing each with the
+// average of its values at the time of its first point. Buckets with only
+// nulls stay null.
+func averageBuckets(points []point, n int) []point {
+	out := make([]point, 0, n)
+	for b := 0; b < n; b++ {
+		start := b * len(points) / n
+		end := (b + 1) * len(points) / n
+		if start == end {
+			continue
+		}
+		sum, count := 0.0, 0
+		for _, p := range points[start:end] {
+			if !p.null {
+				sum += p.value
+				count++
+			}
+		}
+		if count == 0 {
+			out = append(out, point{ts: points[start].ts, null: true})
+		} else {
+			out = append(out, point{ts: points[start].ts, value: sum / float64(count)})
+		}
+	}
+	return out
+}
+
+// lttbWithGaps downsamples each run of points between nulls with LTTB,
+// sharing n points between the runs by their length, and keeps a null
+// between runs so gaps still show
+func lttbWithGaps(points []point, n int) []point {
+	nonNull := 0
+	for _, p := range points {
+		if !p.null {
+			nonNull++
+		}
+	}
+	out := make([]point, 0, n)
+	for start := 0; start < len(points); {
+		if points[start].null {
+			out = append(out, points[start])
+			for start < len(points) && points[start].null {
+				start++
+			}
+			continue
+		}
+		end := start
+		for end < len(points) && !points[end].null {
+			end++
+		}
+		run := points[start:end]
+		out = append(out, lttb(run, int(math.Round(float64(n)*float64(len(run))/float64(nonNull))))...)
+		start = end
+	}
+	return out
+}
+
+// lttb picks n of the points with the Largest-Triangle-Three-Buckets
+// algorithm, always keeping the first and last. See Sveinn Steinarsson's
+// thesis "Downsampling Time Series for Visual Representation".
+func lttb(points []point, n int) []point {
+	if n >= len(points) {
+		return points
+	}
+	if n < 3 {
+		if len(points) < 2 || n < 2 {
+			return points[:1]
+		}
+		return []point{points[0], points[len(points)-1]}
+	}
+
+	out := make([]point, 0, n)
+	out = append(out, points[0])
+	// the points between the first and last are split into n-2 buckets
+	every := float64(len(points)-2) / float64(n-2)
+	a := 0
+	for i := 0; i < n-2; i++ {
+		// average of the next bucket, or the last point
+		nextStart := int(math.Floor(float64(i+1)*every)) + 1
+		nextEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if nextEnd > len(points) {
+			nextEnd = len(points)
+		}
+		avgTs, avgValue := 0.0, 0.0
+		for _, p := range points[nextStart:nextEnd] {
+			avgTs += p.ts
+			avgValue += p.value
+		}
+		count := float64(nextEnd - nextStart)
+		avgTs /= count
+		avgValue /= count
+
+		// the point of this bucket making the largest triangle with the
+		// last picked point and the next bucket's average
+		start := int(math.Floor(float64(i)*every)) + 1
+		end := int(math.Floor(float64(i+1)*every)) + 1
+		maxArea, picked := -1.0, start
+		for j := start; j < end; j++ {
+			area := math.Abs((points[a].ts-avgTs)*(points[j].value-points[a].value) - (points[a].ts-points[j].ts)*(avgValue-points[a].value))
+			if area > maxArea {
+				maxArea, picked = area, j
+			}
+		}
+		out = append(out, points[picked])
+		a = picked
+	}
+	return append(out, points[len(points)-1])
+}
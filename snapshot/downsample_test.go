@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDownsample(t *testing.T) {
+	// a flat series of 1000 points with a spike at 500, and gaps at 200-209
+	series := func() [][]interface{} {
+		datapoints := make([][]interface{}, 1000)
+		for idx := range datapoints {
+			value := interface{}(float64(1))
+			if idx == 500 {
+				value = float64(100)
+			} else if idx >= 200 && idx < 210 {
+				value = nil
+			}
+			datapoints[idx] = []interface{}{value, float64(idx * 1000)}
+		}
+		return datapoints
+	}
+	downsampleTests := []struct {
+		purpose   string
+		method    string
+		maxPoints int
+		points    int  // expected number of points
+		spike     bool // expect the spike to be kept
+		gap       bool // expect a null point to be kept
+	}{
+		{
+			purpose:   "LTTB",
+			method:    DownsampleLTTB,
+			maxPoints: 100,
+			points:    101,
+			spike:     true,
+			gap:       true,
+		},
+		{
+			purpose:   "Average",
+			method:    DownsampleAverage,
+			maxPoints: 100,
+			points:    100,
+			spike:     false,
+			gap:       true,
+		},
+		{
+			purpose:   "Short series untouched",
+			method:    DownsampleLTTB,
+			maxPoints: 1000,
+			points:    1000,
+			spike:     true,
+			gap:       true,
+		},
+	}
+	// test
+	for _, dt := range downsampleTests {
+		data := []SnapshotData{{Datapoints: series()}}
+		downsample(data, dt.method, dt.maxPoints)
+		out := data[0].Datapoints
+		if len(out) != dt.points {
+			t.Errorf("Test \"%s\" expected %d points, got %d", dt.purpose, dt.points, len(out))
+		}
+		spike, gap := false, false
+		lastTs := math.Inf(-1)
+		for _, dp := range out {
+			if dp[0] == nil {
+				gap = true
+			} else if dp[0].(float64) == 100 {
+				spike = true
+			}
+			if dp[1].(float64) <= lastTs {
+				t.Errorf("Test \"%s\" points are out of order at %v", dt.purpose, dp[1])
+			}
+			lastTs = dp[1].(float64)
+		}
+		if spike != dt.spike {
+			t.Errorf("Test \"%s\" expected spike kept to be %t", dt.purpose, dt.spike)
+		}
+		if gap != dt.gap {
+			t.Errorf("Test \"%s\" expected gap kept to be %t", dt.purpose, dt.gap)
+		}
+		if first := out[0][1].(float64); first != 0 {
+			t.Errorf("Test \"%s\" expected the first point to be kept, got %v", dt.purpose, first)
+		}
+	}
+
+	// series which aren't time series are left alone
+	table := []SnapshotData{{Datapoints: [][]interface{}{{"a", "b"}, {"c", "d"}, {"e", "f"}}}}
+	downsample(table, DownsampleLTTB, 2)
+	if len(table[0].Datapoints) != 3 {
+		t.Errorf("Non-numeric series was downsampled")
+	}
+}
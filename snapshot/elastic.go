@@ -0,0 +1,550 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Names Grafana gives to series produced by each Elasticsearch metric type
+var elasticMetricNames = map[string]string{
+	"count":          "Count",
+	"avg":            "Average",
+	"sum":            "Sum",
+	"max":            "Max",
+	"min":            "Min",
+	"cardinality":    "Unique Count",
+	"extended_stats": "Extended Stats",
+	"percentiles":    "Percentiles",
+	"moving_avg":     "Moving Average",
+	"derivative":     "Derivative",
+	"cumulative_sum": "Cumulative Sum",
+}
+
+// Elasticsearch metric types which reference another metric rather than a field
+var elasticPipelineAggs = map[string]bool{
+	"moving_avg":     true,
+	"derivative":     true,
+	"cumulative_sum": true,
+}
+
+func (sc *SnapClient) fetchDataPointsElastic(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	jsonData, _ := datasource["jsonData"].(map[string]interface{})
+	timeField, _ := jsonData["timeField"].(string)
+	if len(timeField) == 0 {
+		timeField = "@timestamp"
+	}
+	index, _ := datasource["database"].(string)
+	indexInterval, _ := jsonData["interval"].(string)
+	indices, err := elasticIndices(index, indexInterval, r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the search
+	query, _ := target["query"].(string)
+	if len(strings.TrimSpace(query)) == 0 {
+		query = "*"
+	}
+	bucketAggs, _ := target["bucketAggs"].([]interface{})
+	metrics, _ := target["metrics"].([]interface{})
+	aggs, err := elasticBuildAggs(bucketAggs, metrics, timeField, r, step, jsonData)
+	if err != nil {
+		return nil, err
+	}
+	search := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{
+						"range": map[string]interface{}{
+							timeField: map[string]interface{}{
+								"gte":    r.From.UnixNano() / int64(time.Millisecond),
+								"lte":    r.To.UnixNano() / int64(time.Millisecond),
+								"format": "epoch_millis",
+							},
+						},
+					},
+					map[string]interface{}{
+						"query_string": map[string]interface{}{
+							"analyze_wildcard": true,
+							"query":            query,
+						},
+					},
+				},
+			},
+		},
+		"aggs": aggs,
+	}
+	header := map[string]interface{}{
+		"search_type":        "query_then_fetch",
+		"ignore_unavailable": true,
+		"index":              indices,
+	}
+
+	// _msearch takes newline delimited json
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	if err = enc.Encode(header); err != nil {
+		return nil, err
+	}
+	if err = enc.Encode(search); err != nil {
+		return nil, err
+	}
+
+	respBody, err := sc.DatasourceProxyRequest(ctx, "POST", datasource, "_msearch", nil, &body, "application/x-ndjson")
+	if err != nil {
+		return nil, err
+	}
+	var msearch struct {
+		Responses []struct {
+			Error        interface{}            `json:"error"`
+			Aggregations map[string]interface{} `json:"aggregations"`
+		} `json:"responses"`
+	}
+	if err = json.Unmarshal(respBody, &msearch); err != nil {
+		return nil, fmt.Errorf("Could not decode elasticsearch response: %s", err.Error())
+	}
+	if len(msearch.Responses) == 0 {
+		return nil, errors.New("Elasticsearch returned no responses")
+	}
+	if msearch.Responses[0].Error != nil {
+		return nil, fmt.Errorf("Elasticsearch query failed: %v", msearch.Responses[0].Error)
+	}
+
+	alias, _ := target["alias"].(string)
+	var results []SnapshotData
+	elasticProcessBuckets(msearch.Responses[0].Aggregations, bucketAggs, metrics, 0, model.Metric{}, alias, &results)
+	return results, nil
+}
+
+// elasticBuildAggs nests the panel's bucket aggregations, innermost last, and
+// attaches the metric aggregations to the innermost bucket
+func elasticBuildAggs(bucketAggs, metrics []interface{}, timeField string, r TimeRange, step time.Duration, jsonData map[string]interface{}) (map[string]interface{}, error) {
+	if len(bucketAggs) == 0 {
+		return nil, errors.New("Elasticsearch target has no bucket aggregations")
+	}
+
+	// metric aggregations go on the innermost bucket
+	metricAggs := make(map[string]interface{})
+	for _, m := range metrics {
+		metric, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mType, _ := metric["type"].(string)
+		id := elasticID(metric["id"])
+		if mType == "count" {
+			// doc_count comes with every bucket
+			continue
+		}
+		settings, _ := metric["settings"].(map[string]interface{})
+		aggBody := make(map[string]interface{})
+		for k, v := range settings {
+			aggBody[k] = v
+		}
+		if elasticPipelineAggs[mType] {
+			pipelineAgg := elasticID(metric["field"])
+			if len(pipelineAgg) == 0 {
+				continue
+			}
+			// pipeline aggregations over another metric, or over the doc count
+			bucketsPath := "_count"
+			for _, o := range metrics {
+				other, _ := o.(map[string]interface{})
+				if other != nil && elasticID(other["id"]) == pipelineAgg && other["type"] != "count" {
+					bucketsPath = pipelineAgg
+				}
+			}
+			aggBody["buckets_path"] = bucketsPath
+		} else if field, ok := metric["field"].(string); ok && len(field) > 0 {
+			aggBody["field"] = field
+		}
+		metricAggs[id] = map[string]interface{}{mType: aggBody}
+	}
+
+	var aggs map[string]interface{}
+	for i := len(bucketAggs) - 1; i >= 0; i-- {
+		bucket, ok := bucketAggs[i].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid elasticsearch bucket aggregation: %v", bucketAggs[i])
+		}
+		bType, _ := bucket["type"].(string)
+		field, _ := bucket["field"].(string)
+		settings, _ := bucket["settings"].(map[string]interface{})
+		aggBody := make(map[string]interface{})
+
+		switch bType {
+		case "date_histogram":
+			if len(field) == 0 {
+				field = timeField
+			}
+			interval, _ := settings["interval"].(string)
+			if len(interval) == 0 || interval == "auto" {
+				interval = strconv.FormatInt(int64(step/time.Millisecond), 10) + "ms"
+			}
+			intervalKey := "interval"
+			if esVersion, ok := jsonData["esVersion"].(float64); ok && esVersion >= 70 {
+				intervalKey = "fixed_interval"
+			}
+			aggBody["field"] = field
+			aggBody[intervalKey] = interval
+			aggBody["min_doc_count"] = 0
+			aggBody["format"] = "epoch_millis"
+			aggBody["extended_bounds"] = map[string]interface{}{
+				"min": r.From.UnixNano() / int64(time.Millisecond),
+				"max": r.To.UnixNano() / int64(time.Millisecond),
+			}
+		case "terms":
+			aggBody["field"] = field
+			size := 500
+			if s, ok := settings["size"].(string); ok {
+				if n, err := strconv.Atoi(s); err == nil && n > 0 {
+					size = n
+				}
+			}
+			aggBody["size"] = size
+			order, _ := settings["order"].(string)
+			if len(order) == 0 {
+				order = "desc"
+			}
+			orderBy, _ := settings["orderBy"].(string)
+			if len(orderBy) == 0 {
+				orderBy = "_term"
+			}
+			aggBody["order"] = map[string]interface{}{orderBy: order}
+			if minDocCount, ok := settings["min_doc_count"]; ok {
+				aggBody["min_doc_count"] = minDocCount
+			}
+		case "histogram":
+			aggBody["field"] = field
+			interval := float64(1000)
+			if s, ok := settings["interval"].(string); ok {
+				if n, err := strconv.ParseFloat(s, 64); err == nil {
+					interval = n
+				}
+			}
+			aggBody["interval"] = interval
+			aggBody["min_doc_count"] = 0
+		case "filters":
+			filters := make(map[string]interface{})
+			list, _ := settings["filters"].([]interface{})
+			for _, f := range list {
+				filter, ok := f.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				q, _ := filter["query"].(string)
+				label, _ := filter["label"].(string)
+				if len(label) == 0 {
+					label = q
+				}
+				filters[label] = map[string]interface{}{
+					"query_string": map[string]interface{}{
+						"analyze_wildcard": true,
+						"query":            q,
+					},
+				}
+			}
+			aggBody["filters"] = filters
+		default:
+			return nil, fmt.Errorf("Unsupported elasticsearch bucket aggregation: %q", bType)
+		}
+
+		agg := map[string]interface{}{bType: aggBody}
+		if aggs != nil {
+			agg["aggs"] = aggs
+		} else if len(metricAggs) > 0 {
+			agg["aggs"] = metricAggs
+		}
+		aggs = map[string]interface{}{elasticID(bucket["id"]): agg}
+	}
+	return aggs, nil
+}
+
+// elasticProcessBuckets walks the aggregation response, collecting the bucket
+// keys as labels until it reaches the innermost aggregation, which is then
+// turned into one series per metric.
+func elasticProcessBuckets(aggs map[string]interface{}, bucketAggs, metrics []interface{}, depth int, labels model.Metric, alias string, results *[]SnapshotData) {
+	bucketAgg, _ := bucketAggs[depth].(map[string]interface{})
+	agg, _ := aggs[elasticID(bucketAgg["id"])].(map[string]interface{})
+	if agg == nil {
+		return
+	}
+	field, _ := bucketAgg["field"].(string)
+	if bucketAgg["type"] == "filters" {
+		field = "filter"
+	}
+
+	// filters aggregations return their buckets keyed by label
+	var buckets []map[string]interface{}
+	switch b := agg["buckets"].(type) {
+	case []interface{}:
+		for _, bucket := range b {
+			if bucket, ok := bucket.(map[string]interface{}); ok {
+				buckets = append(buckets, bucket)
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(b))
+		for k := range b {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			bucket, ok := b[k].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			bucket["key"] = k
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	if depth < len(bucketAggs)-1 {
+		for _, bucket := range buckets {
+			nested := model.Metric{}
+			for k, v := range labels {
+				nested[k] = v
+			}
+			nested[model.LabelName(field)] = model.LabelValue(elasticKey(bucket))
+			elasticProcessBuckets(bucket, bucketAggs, metrics, depth+1, nested, alias, results)
+		}
+		return
+	}
+
+	if bucketAgg["type"] != "date_histogram" {
+		// non time series buckets are added as a single series per metric,
+		// keyed on the last bucket value
+		for _, bucket := range buckets {
+			nested := model.Metric{}
+			for k, v := range labels {
+				nested[k] = v
+			}
+			nested[model.LabelName(field)] = model.LabelValue(elasticKey(bucket))
+			elasticProcessMetrics([]map[string]interface{}{bucket}, metrics, nested, alias, results)
+		}
+		return
+	}
+	elasticProcessMetrics(buckets, metrics, labels, alias, results)
+}
+
+// elasticProcessMetrics converts date histogram buckets into one series per
+// visible metric (or per percentile / stat for multi-value metrics)
+func elasticProcessMetrics(buckets []map[string]interface{}, metrics []interface{}, labels model.Metric, alias string, results *[]SnapshotData) {
+	for _, m := range metrics {
+		metric, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if hide, _ := metric["hide"].(bool); hide {
+			continue
+		}
+		mType, _ := metric["type"].(string)
+		id := elasticID(metric["id"])
+		field, _ := metric["field"].(string)
+
+		// collect values, keyed by sub metric for multi-value aggregations
+		series := make(map[string][][]interface{})
+		var seriesOrder []string
+		add := func(name string, value interface{}, ts float64) {
+			if _, ok := series[name]; !ok {
+				seriesOrder = append(seriesOrder, name)
+			}
+			series[name] = append(series[name], []interface{}{value, ts})
+		}
+		for _, bucket := range buckets {
+			ts, _ := bucket["key"].(float64)
+			switch mType {
+			case "count":
+				add("", bucket["doc_count"], ts)
+			case "percentiles":
+				agg, _ := bucket[id].(map[string]interface{})
+				values, _ := agg["values"].(map[string]interface{})
+				percents := make([]string, 0, len(values))
+				for p := range values {
+					percents = append(percents, p)
+				}
+				sort.Strings(percents)
+				for _, p := range percents {
+					add("p"+p, values[p], ts)
+				}
+			case "extended_stats":
+				agg, _ := bucket[id].(map[string]interface{})
+				meta, _ := metric["meta"].(map[string]interface{})
+				stats := []string{"avg", "min", "max", "sum", "count", "std_deviation"}
+				for _, stat := range stats {
+					if len(meta) > 0 {
+						if enabled, _ := meta[stat].(bool); !enabled {
+							continue
+						}
+					}
+					add(stat, agg[stat], ts)
+				}
+			default:
+				agg, _ := bucket[id].(map[string]interface{})
+				add("", agg["value"], ts)
+			}
+		}
+
+		for _, sub := range seriesOrder {
+			name := elasticMetricNames[mType]
+			if len(name) == 0 {
+				name = mType
+			}
+			if len(field) > 0 && !elasticPipelineAggs[mType] && mType != "count" {
+				name = name + " " + field
+			}
+			if len(sub) > 0 {
+				name = name + " " + sub
+			}
+			*results = append(*results, SnapshotData{
+				Target:     elasticSeriesName(alias, name, field, labels),
+				Datapoints: series[sub],
+				Metric:     labels,
+			})
+		}
+	}
+}
+
+// elasticSeriesName names a series the way Grafana's Elasticsearch datasource
+// does: the alias pattern if set, otherwise the term values then metric name
+func elasticSeriesName(alias, metricName, field string, labels model.Metric) string {
+	if len(alias) > 0 {
+		return aliasRe.ReplaceAllStringFunc(alias, func(match string) string {
+			group := aliasRe.FindStringSubmatch(match)[1]
+			switch {
+			case group == "metric":
+				return metricName
+			case group == "field":
+				return field
+			case strings.HasPrefix(group, "term "):
+				return string(labels[model.LabelName(strings.TrimPrefix(group, "term "))])
+			}
+			return match
+		})
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, string(k))
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, k := range names {
+		parts = append(parts, string(labels[model.LabelName(k)]))
+	}
+	return strings.TrimSpace(strings.Join(append(parts, metricName), " "))
+}
+
+// elasticID normalises aggregation IDs, which may be stored as either strings
+// or numbers in the dashboard JSON
+func elasticID(id interface{}) string {
+	switch v := id.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// elasticKey returns the key of a bucket as a string
+func elasticKey(bucket map[string]interface{}) string {
+	if s, ok := bucket["key_as_string"].(string); ok {
+		return s
+	}
+	return elasticID(bucket["key"])
+}
+
+// elasticIndices expands an index pattern such as "[logstash-]YYYY.MM.DD" into
+// the comma separated list of indices covering the time range
+func elasticIndices(pattern, interval string, from, to time.Time) (string, error) {
+	var step func(time.Time) time.Time
+	var truncate func(time.Time) time.Time
+	switch interval {
+	case "":
+		return pattern, nil
+	case "Hourly":
+		truncate = func(t time.Time) time.Time { return t.Truncate(time.Hour) }
+		step = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case "Daily":
+		truncate = func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC) }
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case "Weekly":
+		truncate = func(t time.Time) time.Time {
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+		}
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case "Monthly":
+		truncate = func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC) }
+		step = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	case "Yearly":
+		truncate = func(t time.Time) time.Time { return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC) }
+		step = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	default:
+		return "", fmt.Errorf("Unsupported elasticsearch index interval: %q", interval)
+	}
+
+	var indices []string
+	for t := truncate(from.UTC()); !t.After(to.UTC()); t = step(t) {
+		indices = append(indices, elasticFormatIndex(pattern, t))
+	}
+	return strings.Join(indices, ","), nil
+}
+
+// elasticFormatIndex formats a single index name, copying the [bracketed]
+// literal part and replacing the Moment.js date tokens Grafana supports
+func elasticFormatIndex(pattern string, t time.Time) string {
+	// GGGG is the ISO week-numbering year, which weeks at the turn of the
+	// year may not be in the calendar year of
+	isoYear, week := t.ISOWeek()
+	tokens := []struct {
+		moment string
+		value  string
+	}{
+		{"YYYY", fmt.Sprintf("%04d", t.Year())},
+		{"GGGG", fmt.Sprintf("%04d", isoYear)},
+		{"MM", fmt.Sprintf("%02d", t.Month())},
+		{"DD", fmt.Sprintf("%02d", t.Day())},
+		{"HH", fmt.Sprintf("%02d", t.Hour())},
+		{"WW", fmt.Sprintf("%02d", week)},
+	}
+
+	var index bytes.Buffer
+	literal := false
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case ch == '[':
+			literal = true
+		case ch == ']':
+			literal = false
+		case literal:
+			index.WriteByte(ch)
+		default:
+			matched := false
+			for _, token := range tokens {
+				if strings.HasPrefix(pattern[i:], token.moment) {
+					index.WriteString(token.value)
+					i += len(token.moment) - 1
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				index.WriteByte(ch)
+			}
+		}
+	}
+	return index.String()
+}
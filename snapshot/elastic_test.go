@@ -0,0 +1,274 @@
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestElasticIndices(t *testing.T) {
+	// some vars
+	from := time.Date(2017, time.February, 05, 22, 0, 0, 0, time.UTC)
+	to := time.Date(2017, time.February, 07, 2, 0, 0, 0, time.UTC)
+	// patterns to test
+	var indexTests = []struct {
+		purpose  string
+		pattern  string
+		interval string
+		from, to time.Time // defaults to the above
+		expected string
+		valid    bool
+	}{
+		{
+			purpose:  "No interval",
+			pattern:  "logstash-*",
+			expected: "logstash-*",
+			valid:    true,
+		},
+		{
+			purpose:  "Daily interval",
+			pattern:  "[logstash-]YYYY.MM.DD",
+			interval: "Daily",
+			expected: "logstash-2017.02.05,logstash-2017.02.06,logstash-2017.02.07",
+			valid:    true,
+		},
+		{
+			purpose:  "Literal containing date tokens",
+			pattern:  "[metrics-MM-]YYYY.MM",
+			interval: "Monthly",
+			expected: "metrics-MM-2017.02",
+			valid:    true,
+		},
+		{
+			purpose:  "Weekly interval",
+			pattern:  "[logs-]GGGG.WW",
+			interval: "Weekly",
+			expected: "logs-2017.05,logs-2017.06",
+			valid:    true,
+		},
+		{
+			// Monday 2024-12-30 is the first day of ISO week 2025-W01
+			purpose:  "Weekly interval at the turn of the year",
+			pattern:  "[logstash-]GGGG.WW",
+			interval: "Weekly",
+			from:     time.Date(2024, time.December, 30, 6, 0, 0, 0, time.UTC),
+			to:       time.Date(2025, time.January, 6, 6, 0, 0, 0, time.UTC),
+			expected: "logstash-2025.01,logstash-2025.02",
+			valid:    true,
+		},
+		{
+			purpose:  "Unknown interval",
+			pattern:  "[logs-]YYYY",
+			interval: "Fortnightly",
+			valid:    false,
+		},
+	}
+	// test
+	for _, it := range indexTests {
+		itFrom, itTo := from, to
+		if !it.from.IsZero() {
+			itFrom, itTo = it.from, it.to
+		}
+		out, err := elasticIndices(it.pattern, it.interval, itFrom, itTo)
+		if it.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", it.purpose, err.Error())
+		} else if !it.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", it.purpose)
+		} else if out != it.expected {
+			t.Errorf("Test \"%s\" expected %q, got %q", it.purpose, it.expected, out)
+		}
+	}
+}
+
+func TestElasticBuildAggs(t *testing.T) {
+	// some vars
+	r := TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	var aggTests = []struct {
+		purpose    string
+		bucketAggs string
+		metrics    string
+		jsonData   map[string]interface{}
+		expected   string
+		valid      bool
+	}{
+		{
+			purpose:    "Date histogram with an auto interval",
+			bucketAggs: `[{"id": "2", "type": "date_histogram", "settings": {"interval": "auto"}}]`,
+			metrics:    `[{"id": "1", "type": "count"}]`,
+			expected: `{"2": {"date_histogram": {"field": "@timestamp", "interval": "60000ms", "min_doc_count": 0, "format": "epoch_millis",
+				"extended_bounds": {"min": 0, "max": 3600000}}}}`,
+			valid: true,
+		},
+		{
+			purpose:    "Elasticsearch 7 fixed interval",
+			bucketAggs: `[{"id": "2", "type": "date_histogram", "field": "ts", "settings": {"interval": "5m"}}]`,
+			metrics:    `[{"id": "1", "type": "count"}]`,
+			jsonData:   map[string]interface{}{"esVersion": 70.0},
+			expected: `{"2": {"date_histogram": {"field": "ts", "fixed_interval": "5m", "min_doc_count": 0, "format": "epoch_millis",
+				"extended_bounds": {"min": 0, "max": 3600000}}}}`,
+			valid: true,
+		},
+		{
+			purpose:    "Terms nesting a date histogram with metrics",
+			bucketAggs: `[{"id": "3", "type": "terms", "field": "host", "settings": {"size": "10"}}, {"id": "4", "type": "date_histogram"}]`,
+			metrics:    `[{"id": "1", "type": "count"}, {"id": "2", "type": "avg", "field": "value"}, {"id": "5", "type": "derivative", "field": "2"}, {"id": "6", "type": "cumulative_sum", "field": "1"}]`,
+			expected: `{"3": {"terms": {"field": "host", "size": 10, "order": {"_term": "desc"}}, "aggs": {
+				"4": {"date_histogram": {"field": "@timestamp", "interval": "60000ms", "min_doc_count": 0, "format": "epoch_millis",
+					"extended_bounds": {"min": 0, "max": 3600000}}, "aggs": {
+					"2": {"avg": {"field": "value"}},
+					"5": {"derivative": {"buckets_path": "2"}},
+					"6": {"cumulative_sum": {"buckets_path": "_count"}}
+				}}
+			}}}`,
+			valid: true,
+		},
+		{
+			purpose:    "Filters",
+			bucketAggs: `[{"id": "2", "type": "filters", "settings": {"filters": [{"query": "status:500", "label": "errors"}, {"query": "*"}]}}]`,
+			metrics:    `[{"id": "1", "type": "count"}]`,
+			expected: `{"2": {"filters": {"filters": {
+				"errors": {"query_string": {"analyze_wildcard": true, "query": "status:500"}},
+				"*": {"query_string": {"analyze_wildcard": true, "query": "*"}}
+			}}}}`,
+			valid: true,
+		},
+		{
+			purpose:    "Null metrics and filters are skipped",
+			bucketAggs: `[{"id": "2", "type": "filters", "settings": {"filters": [null, {"query": "*"}]}}]`,
+			metrics:    `[null, "odd", {"id": "1", "type": "count"}, {"id": "3", "type": "derivative", "field": "1"}]`,
+			expected: `{"2": {"filters": {"filters": {
+				"*": {"query_string": {"analyze_wildcard": true, "query": "*"}}
+			}}, "aggs": {"3": {"derivative": {"buckets_path": "_count"}}}}}`,
+			valid: true,
+		},
+		{
+			purpose:    "No bucket aggregations",
+			bucketAggs: `[]`,
+			metrics:    `[{"id": "1", "type": "count"}]`,
+			valid:      false,
+		},
+		{
+			purpose:    "Null bucket aggregation",
+			bucketAggs: `[null, {"id": "2", "type": "date_histogram"}]`,
+			metrics:    `[{"id": "1", "type": "count"}]`,
+			valid:      false,
+		},
+		{
+			purpose:    "Unsupported bucket aggregation",
+			bucketAggs: `[{"id": "2", "type": "geohash_grid", "field": "location"}]`,
+			metrics:    `[{"id": "1", "type": "count"}]`,
+			valid:      false,
+		},
+	}
+	// test
+	for _, at := range aggTests {
+		var bucketAggs, metrics []interface{}
+		json.Unmarshal([]byte(at.bucketAggs), &bucketAggs)
+		json.Unmarshal([]byte(at.metrics), &metrics)
+		out, err := elasticBuildAggs(bucketAggs, metrics, "@timestamp", r, time.Minute, at.jsonData)
+		if at.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", at.purpose, err.Error())
+			continue
+		} else if !at.valid {
+			if err == nil {
+				t.Errorf("Test \"%s\" unexpectedly passed", at.purpose)
+			}
+			continue
+		}
+		// compare as json, which the aggregations are sent as
+		var expected, got interface{}
+		json.Unmarshal([]byte(at.expected), &expected)
+		b, _ := json.Marshal(out)
+		json.Unmarshal(b, &got)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Test \"%s\" expected aggregations %s, got %s", at.purpose, at.expected, b)
+		}
+	}
+}
+
+func TestElasticQuery(t *testing.T) {
+	var header, search map[string]interface{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/datasources/proxy/1/_msearch" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		lines := bufio.NewScanner(r.Body)
+		for _, v := range []*map[string]interface{}{&header, &search} {
+			lines.Scan()
+			json.Unmarshal(lines.Bytes(), v)
+		}
+		w.Write([]byte(`{"responses": [{"aggregations": {"3": {"buckets": [
+			{"key": "a", "doc_count": 3, "4": {"buckets": [
+				{"key": 60000, "doc_count": 1, "2": {"value": 0.5}, "5": {"value": null}},
+				{"key": 120000, "doc_count": 2, "2": {"value": 0.7}, "5": {"value": 0.2}}
+			]}},
+			{"key": "b", "doc_count": 1, "4": {"buckets": [
+				null, {"key": 60000, "doc_count": 1, "2": {"value": 0.1}}
+			]}}
+		]}}}]}`))
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	var target map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"refId": "A",
+		"query": "service:api",
+		"bucketAggs": [{"id": "3", "type": "terms", "field": "host"}, {"id": "4", "type": "date_histogram", "field": "@timestamp"}],
+		"metrics": [{"id": "1", "type": "count"}, {"id": "2", "type": "avg", "field": "value"}, {"id": "5", "type": "derivative", "field": "2"}, {"id": "6", "type": "max", "field": "value", "hide": true}, null]
+	}`), &target)
+	datasource := map[string]interface{}{
+		"id":       1.0,
+		"database": "[logs-]YYYY.MM.DD",
+		"jsonData": map[string]interface{}{"interval": "Daily", "timeField": "@timestamp"},
+	}
+	r := TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	data, err := sc.fetchDataPointsElastic(context.Background(), target, datasource, r, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	// the search is of the time range's indices, filtered by the query
+	if header["index"] != "logs-1970.01.01" {
+		t.Errorf("Expected index \"logs-1970.01.01\", got %v", header["index"])
+	}
+	filter, _ := search["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"].([]interface{})
+	if len(filter) != 2 || !reflect.DeepEqual(filter[1], map[string]interface{}{"query_string": map[string]interface{}{"analyze_wildcard": true, "query": "service:api"}}) {
+		t.Errorf("Expected the search to filter by the query, got %v", filter)
+	}
+
+	// each term has a series per visible metric
+	expected := []struct {
+		target     string
+		datapoints [][]interface{}
+	}{
+		{"a Count", [][]interface{}{{1.0, 60000.0}, {2.0, 120000.0}}},
+		{"a Average value", [][]interface{}{{0.5, 60000.0}, {0.7, 120000.0}}},
+		{"a Derivative", [][]interface{}{{nil, 60000.0}, {0.2, 120000.0}}},
+		{"b Count", [][]interface{}{{1.0, 60000.0}}},
+		{"b Average value", [][]interface{}{{0.1, 60000.0}}},
+		{"b Derivative", [][]interface{}{{nil, 60000.0}}},
+	}
+	if len(data) != len(expected) {
+		t.Fatalf("Expected %d series, got %+v", len(expected), data)
+	}
+	for idx, series := range expected {
+		if data[idx].Target != series.target || !reflect.DeepEqual(data[idx].Datapoints, series.datapoints) {
+			t.Errorf("Expected series %d %q with %v, got %q with %v", idx, series.target, series.datapoints, data[idx].Target, data[idx].Datapoints)
+		}
+	}
+	if data[0].Metric["host"] != "a" {
+		t.Errorf("Expected the series to be labelled by host, got %v", data[0].Metric)
+	}
+}
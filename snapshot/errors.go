@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned, wrapped, for failures callers may want to handle
+// differently, found with errors.Is
+var (
+	// ErrDashboardNotFound is returned when Grafana has no dashboard with
+	// the TakeConfig's slug or UID
+	ErrDashboardNotFound = errors.New("Dashboard not found")
+	// ErrUnauthorized is returned when Grafana or the snapshot host rejects
+	// the credentials, or they don't permit the request
+	ErrUnauthorized = errors.New("Unauthorized")
+	// ErrUploadFailed is returned when posting a snapshot to the snapshot
+	// host, or uploading it to a Storage, fails
+	ErrUploadFailed = errors.New("Upload failed")
+)
+
+// DatasourceQueryError is returned, wrapped in a TakeError, when a panel
+// target's datasource query fails, found with errors.As
+type DatasourceQueryError struct {
+	// Panel is the ID of the panel, and Target the refId of its target
+	Panel  int
+	Target string
+	// Datasource is the name of the datasource queried
+	Datasource string
+	Err        error
+}
+
+func (e *DatasourceQueryError) Error() string {
+	return fmt.Sprintf("Query of panel %d target %q failed: %s", e.Panel, e.Target, e.Err.Error())
+}
+
+// Unwrap returns the error querying the datasource
+func (e *DatasourceQueryError) Unwrap() error {
+	return e.Err
+}
+
+// uploadError is an upload failure, which is ErrUploadFailed as well as the
+// error it wraps, e.g. ErrUnauthorized
+type uploadError struct {
+	err error
+}
+
+func (e *uploadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *uploadError) Is(target error) bool {
+	return target == ErrUploadFailed
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// uploadFailed marks err as an upload failure
+func uploadFailed(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &uploadError{err: err}
+}
+
+// unauthorized returns an error wrapping ErrUnauthorized if a response status
+// shows the credentials of a request to host were rejected, or else nil
+func unauthorized(host string, status int) error {
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return fmt.Errorf("%w: %s responded %d %s", ErrUnauthorized, host, status, http.StatusText(status))
+	}
+	return nil
+}
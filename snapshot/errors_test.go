@@ -0,0 +1,71 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestErrors(t *testing.T) {
+	RegisterFetcher("failing", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+		return nil, errors.New("datasource down")
+	}))
+	defer RegisterFetcher("failing", nil)
+
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("failing", map[string]interface{}{
+		"uid": "failing",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 7.0, "datasource": "down", "targets": []interface{}{map[string]interface{}{"refId": "B"}}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "down", "type": "failing"})
+	srv.AddDashboard("text", map[string]interface{}{"uid": "text", "panels": []interface{}{}})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	take := func(uid string) error {
+		_, err := sc.Take(&TakeConfig{DashUID: uid, From: &from, To: &to})
+		return err
+	}
+
+	if err = take("missing"); !errors.Is(err, ErrDashboardNotFound) {
+		t.Errorf("Expected ErrDashboardNotFound, got %v", err)
+	}
+	var queryErr *DatasourceQueryError
+	if err = take("failing"); !errors.As(err, &queryErr) {
+		t.Errorf("Expected a DatasourceQueryError, got %v", err)
+	} else if queryErr.Panel != 7 || queryErr.Target != "B" || queryErr.Datasource != "down" || queryErr.Err.Error() != "datasource down" {
+		t.Errorf("Expected panel 7 target \"B\" of \"down\" to fail, got %+v", queryErr)
+	}
+
+	// the snapshot host's credentials are rejected after the dashboard is
+	// read with Grafana's
+	snapSrv := snapshottest.NewServer()
+	defer snapSrv.Close()
+	snapSrv.APIKey = "other"
+	sc, err = NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey, SnapshotAddr: snapSrv.Addr(), SnapshotAPIKey: "wrong"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	err = take("text")
+	if !errors.Is(err, ErrUnauthorized) || !errors.Is(err, ErrUploadFailed) {
+		t.Errorf("Expected ErrUnauthorized and ErrUploadFailed, got %v", err)
+	}
+	srv.APIKey = "other"
+	if err = take("text"); !errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrUploadFailed) {
+		t.Errorf("Expected only ErrUnauthorized, got %v", err)
+	}
+
+	// responses without a dashboard fail rather than panicking
+	if _, err = parseDashboard(`{}`); err == nil {
+		t.Error("Expected a response without a dashboard to fail")
+	}
+}
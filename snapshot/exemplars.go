@@ -0,0 +1,96 @@
+package snapshot
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// prometheusExemplars is an /api/v1/query_exemplars result: the exemplars
+// of each series matching the query
+type prometheusExemplars []struct {
+	SeriesLabels map[string]string `json:"seriesLabels"`
+	Exemplars    []struct {
+		Labels    map[string]string `json:"labels"`
+		Value     string            `json:"value"`
+		Timestamp float64           `json:"timestamp"`
+	} `json:"exemplars"`
+}
+
+// fetchExemplars gets the exemplars of a Prometheus target's query over the
+// time range, as a data frame which Grafana shows alongside the series
+func (sc *SnapClient) fetchExemplars(ctx context.Context, target, datasource map[string]interface{}, r TimeRange) (*SnapshotData, error) {
+	params := url.Values{
+		"query": {target["expr"].(string)},
+		"start": {r.From.Format(time.RFC3339Nano)},
+		"end":   {r.To.Format(time.RFC3339Nano)},
+	}
+	var result prometheusExemplars
+	if err := sc.prometheusAPIGet(ctx, datasource, "api/v1/query_exemplars", params, &result); err != nil {
+		return nil, err
+	}
+	refID, _ := target["refId"].(string)
+	return result.frame(refID), nil
+}
+
+// frame converts the exemplars into a data frame of their time and value,
+// and their series' and their own labels
+func (e prometheusExemplars) frame(refID string) *SnapshotData {
+	var times, values []interface{}
+	labels := make(map[string][]interface{})
+	for _, series := range e {
+		for _, exemplar := range series.Exemplars {
+			value, err := strconv.ParseFloat(exemplar.Value, 64)
+			if err != nil {
+				continue
+			}
+			row := len(times)
+			times = append(times, exemplar.Timestamp*1000)
+			values = append(values, value)
+			for _, set := range []map[string]string{series.SeriesLabels, exemplar.Labels} {
+				for name, v := range set {
+					if _, ok := labels[name]; !ok {
+						labels[name] = make([]interface{}, row, row+1)
+					}
+					labels[name] = append(labels[name][:row], v)
+				}
+			}
+			// exemplars without a label have an empty value for it
+			for name, column := range labels {
+				if len(column) == row {
+					labels[name] = append(column, "")
+				}
+			}
+		}
+	}
+	if len(times) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := []interface{}{
+		map[string]interface{}{"name": "Time", "type": "time", "values": times},
+		map[string]interface{}{"name": "Value", "type": "number", "values": values},
+	}
+	for _, name := range names {
+		column := labels[name]
+		for idx := range column {
+			if column[idx] == nil {
+				column[idx] = ""
+			}
+		}
+		fields = append(fields, map[string]interface{}{"name": name, "type": "string", "values": column})
+	}
+	return &SnapshotData{Frame: map[string]interface{}{
+		"name":   "exemplar",
+		"refId":  refID,
+		"meta":   map[string]interface{}{"dataTopic": "annotations"},
+		"fields": fields,
+	}}
+}
@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestExemplarFrame(t *testing.T) {
+	var exemplars prometheusExemplars
+	json.Unmarshal([]byte(`[
+		{"seriesLabels": {"service": "api"}, "exemplars": [
+			{"labels": {"trace_id": "abc"}, "value": "0.5", "timestamp": 1600000000.5},
+			{"labels": {"trace_id": "def", "span_id": "1"}, "value": "0.7", "timestamp": 1600000001}
+		]},
+		{"seriesLabels": {"service": "web"}, "exemplars": [
+			{"labels": {"trace_id": "ghi"}, "value": "NaN?", "timestamp": 1600000002}
+		]}
+	]`), &exemplars)
+
+	data := exemplars.frame("A")
+	if data == nil {
+		t.Fatal("Expected a frame")
+	}
+	if data.Frame["name"] != "exemplar" || data.Frame["refId"] != "A" {
+		t.Errorf("Expected an exemplar frame of \"A\", got %v", data.Frame)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"name": "Time", "type": "time", "values": []interface{}{1600000000500.0, 1600000001000.0}},
+		map[string]interface{}{"name": "Value", "type": "number", "values": []interface{}{0.5, 0.7}},
+		map[string]interface{}{"name": "service", "type": "string", "values": []interface{}{"api", "api"}},
+		map[string]interface{}{"name": "span_id", "type": "string", "values": []interface{}{"", "1"}},
+		map[string]interface{}{"name": "trace_id", "type": "string", "values": []interface{}{"abc", "def"}},
+	}
+	if !reflect.DeepEqual(data.Frame["fields"], expected) {
+		t.Errorf("Expected fields %v, got %v", expected, data.Frame["fields"])
+	}
+
+	if prometheusExemplars(nil).frame("A") != nil {
+		t.Error("Expected no frame without exemplars")
+	}
+}
+
+func TestExemplarsUnsupported(t *testing.T) {
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	from, to := time.Unix(0, 0).UTC(), time.Unix(3600, 0).UTC()
+	srv.AddDashboard("exemplars", map[string]interface{}{
+		"uid": "exemplars",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "datasource": "prom", "targets": []interface{}{
+				map[string]interface{}{"refId": "A", "expr": "up", "exemplar": true},
+			}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "prom", "type": "prometheus"})
+	srv.AddSeries("up", snapshottest.Series{Points: []snapshottest.Point{{Time: from.Add(time.Minute), Value: 1}}})
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	// the Server has no exemplars endpoint, which is warned of
+	snapshot, err := sc.Take(&TakeConfig{DashUID: "exemplars", From: &from, To: &to})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if snapshot.Datapoints != 1 || len(snapshot.Warnings) != 1 || !strings.HasPrefix(snapshot.Warnings[0], "Could not get exemplars") {
+		t.Errorf("Expected the series and a warning, got %d data points and warnings %v", snapshot.Datapoints, snapshot.Warnings)
+	}
+}
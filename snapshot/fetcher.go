@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TimeRange is the absolute time range a snapshot is taken over
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// DatasourceFetcher fetches the data points for a single panel target from a
+// datasource. The target and datasource are the decoded JSON definitions from
+// the dashboard and the Grafana datasources API respectively.
+type DatasourceFetcher interface {
+	Fetch(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error)
+}
+
+// FetcherFunc is an adapter allowing an ordinary function to be used as a
+// DatasourceFetcher
+type FetcherFunc func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error)
+
+// Fetch calls f(ctx, target, datasource, r, step)
+func (f FetcherFunc) Fetch(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	return f(ctx, target, datasource, r, step)
+}
+
+var (
+	fetchersMu sync.RWMutex
+	fetchers   = make(map[string]DatasourceFetcher)
+)
+
+// RegisterFetcher makes a DatasourceFetcher available for datasources of the
+// given type (e.g. "prometheus", or a plugin ID). Registering a type that
+// already has a fetcher replaces it, so the built in fetchers can be
+// overridden. Panels whose datasource type has no fetcher are skipped.
+func RegisterFetcher(datasourceType string, f DatasourceFetcher) {
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	if f == nil {
+		delete(fetchers, datasourceType)
+		return
+	}
+	fetchers[datasourceType] = f
+}
+
+func lookupFetcher(datasourceType string) (DatasourceFetcher, bool) {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	f, ok := fetchers[datasourceType]
+	return f, ok
+}
+
+type contextKey int
+
+const clientContextKey contextKey = 0
+
+func contextWithClient(ctx context.Context, sc *SnapClient) context.Context {
+	return context.WithValue(ctx, clientContextKey, sc)
+}
+
+// ClientFromContext returns the SnapClient taking the snapshot, for use by
+// DatasourceFetcher implementations which need to query through the Grafana
+// datasource proxy. It returns nil if ctx did not come from a SnapClient.
+func ClientFromContext(ctx context.Context) *SnapClient {
+	sc, _ := ctx.Value(clientContextKey).(*SnapClient)
+	return sc
+}
+
+// clientFetcher adapts the SnapClient's built in fetch methods to the
+// DatasourceFetcher interface
+type clientFetcher func(sc *SnapClient, ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error)
+
+func (f clientFetcher) Fetch(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	sc := ClientFromContext(ctx)
+	if sc == nil {
+		return nil, errors.New("No SnapClient found in fetch context")
+	}
+	return f(sc, ctx, target, datasource, r, step)
+}
+
+func init() {
+	RegisterFetcher("prometheus", clientFetcher((*SnapClient).fetchDataPointsPrometheus))
+	RegisterFetcher("elasticsearch", clientFetcher((*SnapClient).fetchDataPointsElastic))
+	RegisterFetcher("influxdb", clientFetcher((*SnapClient).fetchDataPointsInflux))
+	RegisterFetcher("loki", clientFetcher((*SnapClient).fetchDataPointsLoki))
+	RegisterFetcher("postgres", clientFetcher((*SnapClient).fetchDataPointsSQL))
+	RegisterFetcher("grafana-postgresql-datasource", clientFetcher((*SnapClient).fetchDataPointsSQL))
+	RegisterFetcher("mysql", clientFetcher((*SnapClient).fetchDataPointsSQL))
+	RegisterFetcher("grafana-azure-monitor-datasource", clientFetcher((*SnapClient).fetchDataPointsAzure))
+	RegisterFetcher("opentsdb", clientFetcher((*SnapClient).fetchDataPointsOpenTSDB))
+	RegisterFetcher("tempo", clientFetcher((*SnapClient).fetchTraceTempo))
+	RegisterFetcher("jaeger", clientFetcher((*SnapClient).fetchTraceJaeger))
+}
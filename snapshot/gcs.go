@@ -0,0 +1,249 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// GCSConfig configures uploading snapshot artifacts to a Google Cloud Storage
+// bucket
+type GCSConfig struct {
+	Bucket string
+	// NameTemplate is a text/template for the object name of each file,
+	// evaluated against StorageKeyData. Defaults to
+	// DefaultStorageKeyTemplate.
+	NameTemplate string
+	// CredentialsFile is a service account key file to authenticate with.
+	// Without one, tokens are requested from the metadata server, which
+	// gives GKE workload identity's or the GCE instance's service account.
+	CredentialsFile string
+	// Endpoint, if set, is used instead of https://storage.googleapis.com/
+	Endpoint *url.URL
+}
+
+// The scope of the tokens requested for uploading
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCSConfigFromEnv builds a GCSConfig for bucket from the
+// GOOGLE_APPLICATION_CREDENTIALS environment variable
+func GCSConfigFromEnv(bucket string) (*GCSConfig, error) {
+	return &GCSConfig{
+		Bucket:          bucket,
+		CredentialsFile: os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"),
+	}, nil
+}
+
+func processGCSConfig(configIn *GCSConfig) (*GCSConfig, error) {
+	configOut := *configIn
+
+	if len(configIn.Bucket) == 0 {
+		return nil, errors.New("Missing required GCSConfig field: \"Bucket\"")
+	}
+	if len(configOut.NameTemplate) == 0 {
+		configOut.NameTemplate = DefaultStorageKeyTemplate
+	}
+	if configOut.Endpoint == nil {
+		configOut.Endpoint = &url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/"}
+	}
+	return &configOut, nil
+}
+
+// gcsServiceAccount is the part of a service account key file needed to
+// request tokens
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+// GCS is a Storage uploading snapshot artifacts to a Google Cloud Storage
+// bucket
+type GCS struct {
+	config       *GCSConfig
+	client       *http.Client
+	nameTemplate *template.Template
+	account      *gcsServiceAccount
+	// metadataAddr is the metadata server, used without a service account
+	metadataAddr string
+	token        tokenCache
+}
+
+// NewGCS creates a GCS for uploading to the configured bucket
+func NewGCS(config *GCSConfig) (*GCS, error) {
+	c, err := processGCSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	nameTemplate, err := parseKeyTemplate("GCSConfig NameTemplate", c.NameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	g := &GCS{config: c, client: &http.Client{Timeout: 5 * time.Minute}, nameTemplate: nameTemplate}
+	g.token.fetch = g.accessToken
+	if len(c.CredentialsFile) > 0 {
+		if g.account, err = readServiceAccount(c.CredentialsFile); err != nil {
+			return nil, err
+		}
+	} else {
+		g.metadataAddr = "http://metadata.google.internal/"
+		if host := os.Getenv("GCE_METADATA_HOST"); len(host) > 0 {
+			g.metadataAddr = "http://" + host + "/"
+		}
+	}
+	return g, nil
+}
+
+// readServiceAccount reads a service account key file
+func readServiceAccount(path string) (*gcsServiceAccount, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read GCS credentials file: %s", err.Error())
+	}
+	account := &gcsServiceAccount{}
+	if err = json.Unmarshal(b, account); err != nil {
+		return nil, fmt.Errorf("Could not decode GCS credentials file: %s", err.Error())
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if len(account.ClientEmail) == 0 || block == nil {
+		return nil, errors.New("GCS credentials file is not a service account key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse GCS service account key: %s", err.Error())
+	}
+	var ok bool
+	if account.key, ok = key.(*rsa.PrivateKey); !ok {
+		return nil, errors.New("GCS service account key is not an RSA key")
+	}
+	if len(account.TokenURI) == 0 {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return account, nil
+}
+
+// Upload uploads body as the object named by the NameTemplate evaluated
+// against data, returning its gs:// URL
+func (g *GCS) Upload(ctx context.Context, data *StorageKeyData, body []byte, contentType string) (string, error) {
+	name, err := storageKey(g.nameTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	reqURL := *g.config.Endpoint
+	reqURL.Path = strings.TrimSuffix(reqURL.Path, "/") + "/upload/storage/v1/b/" + g.config.Bucket + "/o"
+	reqURL.RawQuery = url.Values{"uploadType": {"media"}, "name": {name}}.Encode()
+
+	// a token which has been revoked is replaced once
+	for attempt := 0; ; attempt++ {
+		token, err := g.token.get(ctx, attempt > 0)
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequest("POST", reqURL.String(), bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			var gcsErr struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(respBody, &gcsErr) == nil && len(gcsErr.Error.Message) > 0 {
+				return "", uploadFailed(fmt.Errorf("Failed to upload %q to GCS: %s: %s", name, resp.Status, gcsErr.Error.Message))
+			}
+			return "", uploadFailed(fmt.Errorf("Failed to upload %q to GCS: %s", name, resp.Status))
+		}
+		return "gs://" + g.config.Bucket + "/" + name, nil
+	}
+}
+
+// accessToken requests an OAuth2 access token for uploading, returning it and
+// how long it lasts
+func (g *GCS) accessToken(ctx context.Context) (string, time.Duration, error) {
+	var req *http.Request
+	var err error
+	if g.account != nil {
+		assertion, err := g.account.assertion(time.Now())
+		if err != nil {
+			return "", 0, err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		if req, err = http.NewRequest("POST", g.account.TokenURI, strings.NewReader(form.Encode())); err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		if req, err = http.NewRequest("GET", g.metadataAddr+"computeMetadata/v1/instance/service-accounts/default/token?scopes="+url.QueryEscape(gcsScope), nil); err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+	resp, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", 0, fmt.Errorf("Failed to get GCS access token: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string  `json:"access_token"`
+		ExpiresIn   float64 `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("Failed to get GCS access token: %s", resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil || len(token.AccessToken) == 0 {
+		return "", 0, errors.New("Failed to get GCS access token: no token in response")
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// assertion returns the signed JWT exchanged for an access token
+func (a *gcsServiceAccount) assertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   a.ClientEmail,
+		"scope": gcsScope,
+		"aud":   a.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
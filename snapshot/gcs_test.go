@@ -0,0 +1,124 @@
+package snapshot
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGCSUpload(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	// tokens are numbered, and the first is rejected, as if it was revoked
+	tokens := 0
+	var uploaded []string
+	gcs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			parts := strings.Split(r.FormValue("assertion"), ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+			hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		case "/upload/storage/v1/b/snapshots/o":
+			if r.Header.Get("Authorization") == "Bearer token-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			uploaded = append(uploaded, r.URL.Query().Get("name")+" "+r.Header.Get("Authorization")+" "+string(body))
+			w.Write([]byte(`{"kind": "storage#object"}`))
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "The specified bucket does not exist."}}`))
+			return
+		}
+		tokens++
+		w.Write([]byte(`{"access_token": "token-` + strconv.Itoa(tokens) + `", "expires_in": 3599, "token_type": "Bearer"}`))
+	}))
+	defer gcs.Close()
+	gcsURL, _ := url.Parse(gcs.URL + "/")
+
+	dir, err := ioutil.TempDir("", "snapshot-gcs")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "snapshots@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    gcs.URL + "/token",
+	})
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	ioutil.WriteFile(credentialsFile, credentials, 0600)
+
+	from := time.Date(2017, time.February, 5, 6, 0, 0, 0, time.UTC)
+	data := &StorageKeyData{SnapshotNameData: SnapshotNameData{DashUID: "abc", From: from, To: from.Add(time.Hour)}, File: "snapshot.json"}
+
+	// service account
+	g, err := NewGCS(&GCSConfig{Bucket: "snapshots", NameTemplate: "{{.DashUID}}/{{.File}}", CredentialsFile: credentialsFile, Endpoint: gcsURL})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	object, err := g.Upload(context.Background(), data, []byte("{}"), "application/json")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if object != "gs://snapshots/abc/snapshot.json" {
+		t.Errorf("Expected object gs://snapshots/abc/snapshot.json, got %q", object)
+	}
+	if _, err = g.Upload(context.Background(), data, []byte("{}"), "application/json"); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	expected := []string{"abc/snapshot.json Bearer token-2 {}", "abc/snapshot.json Bearer token-2 {}"}
+	if strings.Join(uploaded, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the revoked token to be replaced and the new one reused, got %q", uploaded)
+	}
+
+	// metadata server
+	g, err = NewGCS(&GCSConfig{Bucket: "snapshots", Endpoint: gcsURL})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	g.metadataAddr = gcs.URL + "/"
+	if object, err = g.Upload(context.Background(), data, []byte("{}"), "application/json"); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if object != "gs://snapshots/abc/20170205T060000-20170205T070000/snapshot.json" {
+		t.Errorf("Expected object named by the default template, got %q", object)
+	}
+
+	// missing bucket
+	g, _ = NewGCS(&GCSConfig{Bucket: "missing", CredentialsFile: credentialsFile, Endpoint: gcsURL})
+	if _, err = g.Upload(context.Background(), data, []byte("{}"), "application/json"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected upload to a missing bucket to fail with GCS's message, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// GrafanaAPI is what a SnapClient reads dashboards, datasources and
+// annotations from, and posts snapshots to. Each method returns the JSON
+// Grafana's API would. By default they're requested from the Config's
+// GrafanaAddr and SnapshotAddr, but Config.GrafanaAPI can replace them, e.g.
+// to read dashboards from disk, or in tests. Datasource queries, alert states
+// and library panels are still requested from Grafana.
+type GrafanaAPI interface {
+	// Dashboard returns the dashboard with the TakeConfig's DashUID or
+	// DashSlug, as returned by /api/dashboards/uid/<uid>, and an error
+	// wrapping ErrDashboardNotFound if there isn't one
+	Dashboard(ctx context.Context, config *TakeConfig) ([]byte, error)
+	// Datasources returns the list of datasources, as returned by
+	// /api/datasources
+	Datasources(ctx context.Context) ([]byte, error)
+	// Annotations returns the annotations matching an /api/annotations query
+	Annotations(ctx context.Context, query url.Values) ([]byte, error)
+	// PostSnapshot posts an encoded snapshot to the snapshot host's
+	// /api/snapshots, returning its response. getBody returns the encoded
+	// snapshot for each attempt, and contentLength is its length, or -1 if
+	// it's unknown. External is set for snapshots published to an external
+	// snapshot service.
+	PostSnapshot(ctx context.Context, getBody func() (io.ReadCloser, error), contentLength int64, external bool) ([]byte, error)
+}
+
+// httpGrafanaAPI is the GrafanaAPI of Grafana's HTTP API, requested with the
+// SnapClient's credentials and client
+type httpGrafanaAPI struct {
+	sc *SnapClient
+}
+
+// grafanaAPI returns the configured GrafanaAPI, or else Grafana's HTTP API
+func (sc *SnapClient) grafanaAPI() GrafanaAPI {
+	if sc.config.GrafanaAPI != nil {
+		return sc.config.GrafanaAPI
+	}
+	return &httpGrafanaAPI{sc: sc}
+}
+
+func (api *httpGrafanaAPI) Dashboard(ctx context.Context, config *TakeConfig) ([]byte, error) {
+	sc := api.sc
+	ctx, cancel := withTimeout(ctx, sc.config.DashboardTimeout)
+	defer cancel()
+
+	// Get dashboard def, finding its UID from its slug on Grafanas which no
+	// longer find dashboards by slug
+	uid := config.DashUID
+	if len(uid) == 0 && sc.grafana(ctx).atLeast(slugRemovedMajor, 0) {
+		var err error
+		if uid, err = sc.dashboardUID(ctx, config.DashSlug); err != nil {
+			return nil, err
+		}
+	}
+	reqURL := *sc.config.GrafanaAddr
+	if len(uid) > 0 {
+		reqURL.Path = reqURL.Path + "api/dashboards/uid/" + uid
+	} else {
+		reqURL.Path = reqURL.Path + "api/dashboards/db/" + config.DashSlug
+	}
+
+	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if req, err = sc.grafanaAuth(req); err != nil {
+		return nil, err
+	}
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = unauthorized("Grafana", resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %q", ErrDashboardNotFound, config.dashboardID())
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (api *httpGrafanaAPI) Datasources(ctx context.Context) ([]byte, error) {
+	sc := api.sc
+	ctx, cancel := withTimeout(ctx, sc.config.DashboardTimeout)
+	defer cancel()
+
+	// Get datasource defs
+	reqURL := *sc.config.GrafanaAddr
+	reqURL.Path = reqURL.Path + "api/datasources"
+
+	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if req, err = sc.grafanaAuth(req); err != nil {
+		return nil, err
+	}
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = unauthorized("Grafana", resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, errors.New("Unexpected status code: " + resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (api *httpGrafanaAPI) Annotations(ctx context.Context, query url.Values) ([]byte, error) {
+	return api.sc.grafanaGet(ctx, "api/annotations", query)
+}
+
+// PostSnapshot doesn't send external snapshots the Grafana credentials if the
+// snapshot host isn't Grafana
+func (api *httpGrafanaAPI) PostSnapshot(ctx context.Context, getBody func() (io.ReadCloser, error), contentLength int64, external bool) ([]byte, error) {
+	sc := api.sc
+	ctx, cancel := withTimeout(ctx, sc.config.UploadTimeout)
+	defer cancel()
+
+	// Post Snapshot
+	reqURL := *sc.config.SnapshotAddr
+	reqURL.Path = reqURL.Path + "api/snapshots"
+	sc.config.Logger.Info("Posting snapshot", "url", reqURL.String())
+
+	req, err := http.NewRequest(http.MethodPost, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body, err = getBody(); err != nil {
+		return nil, err
+	}
+	req.GetBody = getBody
+	req.ContentLength = contentLength
+	// snapshots aren't created by failed posts, so they can be retried
+	req = req.WithContext(contextWithRetry(ctx))
+	if !external || !sc.config.sharedSnapshotAuth || sc.config.SnapshotAddr.Host == sc.config.GrafanaAddr.Host {
+		if req, err = sc.snapshotAuth(req); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = unauthorized("The snapshot host", resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Unexpected status code when posting snapshot: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fileGrafanaAPI is a GrafanaAPI serving a fixed dashboard and datasources,
+// as if read from files, and recording the snapshot posted
+type fileGrafanaAPI struct {
+	dashboard   string
+	datasources string
+	posted      map[string]interface{}
+}
+
+func (api *fileGrafanaAPI) Dashboard(ctx context.Context, config *TakeConfig) ([]byte, error) {
+	return []byte(api.dashboard), nil
+}
+
+func (api *fileGrafanaAPI) Datasources(ctx context.Context) ([]byte, error) {
+	return []byte(api.datasources), nil
+}
+
+func (api *fileGrafanaAPI) Annotations(ctx context.Context, query url.Values) ([]byte, error) {
+	return []byte(`[{"time": 1000, "text": "deploy"}]`), nil
+}
+
+func (api *fileGrafanaAPI) PostSnapshot(ctx context.Context, getBody func() (io.ReadCloser, error), contentLength int64, external bool) ([]byte, error) {
+	body, err := getBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	b, _ := ioutil.ReadAll(body)
+	if err = json.Unmarshal(b, &api.posted); err != nil {
+		return nil, err
+	}
+	return []byte(`{"key": "abc", "url": "http://grafana/dashboard/snapshot/abc"}`), nil
+}
+
+func TestGrafanaAPI(t *testing.T) {
+	RegisterFetcher("api-test", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+		return []SnapshotData{{Target: target["refId"].(string), Datapoints: [][]interface{}{{1.0, 1000.0}}}}, nil
+	}))
+	defer RegisterFetcher("api-test", nil)
+
+	api := &fileGrafanaAPI{
+		dashboard: `{"dashboard": {"title": "From disk", "panels": [{"id": 1, "title": "Panel", "datasource": "test", "targets": [{"refId": "A"}]}],
+			"annotations": {"list": [{"name": "Deploys", "enable": true, "datasource": "-- Grafana --", "type": "tags", "tags": ["deploy"]}]}}}`,
+		datasources: `[{"name": "test", "type": "api-test", "isDefault": true}]`,
+	}
+	// nothing listens on Grafana's address, so only the GrafanaAPI is used
+	// for the dashboard, datasources, annotations and post
+	grafanaURL, _ := url.Parse("http://127.0.0.1:1/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", GrafanaAPI: api})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+	snapshot, err := sc.Take(&TakeConfig{DashUID: "disk", From: &from, To: &to})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if snapshot.Key != "abc" || snapshot.Title != "From disk" {
+		t.Errorf("Expected snapshot \"abc\" of \"From disk\", got %q of %q", snapshot.Key, snapshot.Title)
+	}
+	dashboard, _ := api.posted["dashboard"].(map[string]interface{})
+	panels, _ := dashboard["panels"].([]interface{})
+	if len(panels) != 1 || len(panels[0].(map[string]interface{})["snapshotData"].([]interface{})) != 1 {
+		t.Errorf("Expected the panel's data to be posted, got %v", panels)
+	}
+	annotations, _ := dashboard["annotations"].(map[string]interface{})
+	if list, _ := annotations["list"].([]interface{}); len(list) != 1 || len(list[0].(map[string]interface{})["snapshotData"].([]interface{})) != 1 {
+		t.Errorf("Expected the annotations to be posted, got %v", dashboard["annotations"])
+	}
+}
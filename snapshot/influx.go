@@ -0,0 +1,302 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// InfluxQL functions which wrap the selected field, e.g. mean("value")
+var influxWrappingFuncs = map[string]bool{
+	"count": true, "distinct": true, "integral": true, "mean": true,
+	"median": true, "mode": true, "spread": true, "stddev": true, "sum": true,
+	"bottom": true, "first": true, "last": true, "max": true, "min": true,
+	"percentile": true, "top": true, "derivative": true, "spread_derivative": true,
+	"non_negative_derivative": true, "difference": true, "non_negative_difference": true,
+	"moving_average": true, "cumulative_sum": true, "elapsed": true,
+	"holt_winters": true, "holt_winters_with_fit": true,
+}
+
+func (sc *SnapClient) fetchDataPointsInflux(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	var query string
+	if raw, _ := target["rawQuery"].(bool); raw {
+		query, _ = target["query"].(string)
+	} else {
+		query = influxBuildQuery(target)
+	}
+	if len(strings.TrimSpace(query)) == 0 {
+		return nil, errors.New("InfluxDB target has no query")
+	}
+
+	// Replace time range and interval macros
+	query = strings.Replace(query, "$timeFilter", influxTimeFilter(r), -1)
+	interval := influxDuration(step)
+	for _, macro := range []string{"$__interval", "$interval"} {
+		query = strings.Replace(query, macro, interval, -1)
+	}
+	query = strings.Replace(query, "time(auto)", "time("+interval+")", -1)
+
+	// Query through the proxy
+	database, _ := datasource["database"].(string)
+	params := url.Values{}
+	params.Set("db", database)
+	params.Set("q", query)
+	params.Set("epoch", "ms")
+	respBody, err := sc.DatasourceProxyRequest(ctx, "GET", datasource, "query", params, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var influxResp struct {
+		Results []struct {
+			Error  string `json:"error"`
+			Series []struct {
+				Name    string            `json:"name"`
+				Tags    map[string]string `json:"tags"`
+				Columns []string          `json:"columns"`
+				Values  [][]interface{}   `json:"values"`
+			} `json:"series"`
+		} `json:"results"`
+		Error string `json:"error"`
+	}
+	if err = json.Unmarshal(respBody, &influxResp); err != nil {
+		return nil, fmt.Errorf("Could not decode InfluxDB response: %s", err.Error())
+	}
+	if len(influxResp.Error) > 0 {
+		return nil, fmt.Errorf("InfluxDB query failed: %s", influxResp.Error)
+	}
+
+	alias, _ := target["alias"].(string)
+	var results []SnapshotData
+	for _, result := range influxResp.Results {
+		if len(result.Error) > 0 {
+			return nil, fmt.Errorf("InfluxDB query failed: %s", result.Error)
+		}
+		for _, series := range result.Series {
+			metric := model.Metric{}
+			for k, v := range series.Tags {
+				metric[model.LabelName(k)] = model.LabelValue(v)
+			}
+			// one snapshot series per value column
+			for col := 1; col < len(series.Columns); col++ {
+				datapoints := make([][]interface{}, len(series.Values))
+				for idx, row := range series.Values {
+					if len(row) <= col {
+						datapoints[idx] = []interface{}{nil, row[0]}
+						continue
+					}
+					datapoints[idx] = []interface{}{row[col], row[0]}
+				}
+				results = append(results, SnapshotData{
+					Target:     influxSeriesName(alias, series.Name, series.Columns[col], series.Tags),
+					Datapoints: datapoints,
+					Metric:     metric,
+				})
+			}
+		}
+	}
+	return results, nil
+}
+
+// influxBuildQuery renders the query editor model (select, measurement, tags,
+// groupBy) of a non-raw InfluxDB target into an InfluxQL statement
+func influxBuildQuery(target map[string]interface{}) string {
+	// SELECT
+	var selects []string
+	selectLists, _ := target["select"].([]interface{})
+	for _, sl := range selectLists {
+		parts, _ := sl.([]interface{})
+		expr := ""
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			pType, _ := part["type"].(string)
+			params := influxParams(part["params"])
+			switch {
+			case pType == "field":
+				if len(params) > 0 {
+					expr = influxQuoteIdent(params[0])
+				}
+			case influxWrappingFuncs[pType]:
+				expr = pType + "(" + strings.Join(append([]string{expr}, params...), ", ") + ")"
+			case pType == "math":
+				if len(params) > 0 {
+					expr = expr + " " + params[0]
+				}
+			case pType == "alias":
+				if len(params) > 0 {
+					expr = expr + " AS " + influxQuoteIdent(params[0])
+				}
+			}
+		}
+		if len(expr) > 0 {
+			selects = append(selects, expr)
+		}
+	}
+	if len(selects) == 0 {
+		selects = []string{"mean(\"value\")"}
+	}
+
+	// FROM
+	measurement, _ := target["measurement"].(string)
+	if len(measurement) == 0 {
+		measurement = "measurement"
+	}
+	from := influxQuoteIdent(measurement)
+	if policy, _ := target["policy"].(string); len(policy) > 0 && policy != "default" {
+		from = influxQuoteIdent(policy) + "." + from
+	}
+
+	// WHERE
+	var where []string
+	tags, _ := target["tags"].([]interface{})
+	for idx, t := range tags {
+		tag, _ := t.(map[string]interface{})
+		key, _ := tag["key"].(string)
+		value, _ := tag["value"].(string)
+		operator, _ := tag["operator"].(string)
+		if len(operator) == 0 {
+			if strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+				operator = "=~"
+			} else {
+				operator = "="
+			}
+		}
+		if operator != "=~" && operator != "!~" {
+			value = "'" + strings.Replace(value, "'", "\\'", -1) + "'"
+		}
+		cond := influxQuoteIdent(key) + " " + operator + " " + value
+		if idx > 0 {
+			condition, _ := tag["condition"].(string)
+			if len(condition) == 0 {
+				condition = "AND"
+			}
+			cond = condition + " " + cond
+		}
+		where = append(where, cond)
+	}
+	whereClause := "$timeFilter"
+	if len(where) > 0 {
+		whereClause = "(" + strings.Join(where, " ") + ") AND $timeFilter"
+	}
+
+	// GROUP BY
+	var groupBy []string
+	fill := ""
+	groupParts, _ := target["groupBy"].([]interface{})
+	for _, g := range groupParts {
+		part, _ := g.(map[string]interface{})
+		params := influxParams(part["params"])
+		param := ""
+		if len(params) > 0 {
+			param = params[0]
+		}
+		switch part["type"] {
+		case "time":
+			if param == "auto" || len(param) == 0 {
+				param = "$__interval"
+			}
+			groupBy = append(groupBy, "time("+param+")")
+		case "tag":
+			groupBy = append(groupBy, influxQuoteIdent(param))
+		case "fill":
+			fill = " fill(" + param + ")"
+		}
+	}
+
+	query := "SELECT " + strings.Join(selects, ", ") + " FROM " + from + " WHERE " + whereClause
+	if len(groupBy) > 0 {
+		query = query + " GROUP BY " + strings.Join(groupBy, ", ")
+	}
+	return query + fill
+}
+
+// influxParams converts query part parameters, which may be strings or
+// numbers, into strings
+func influxParams(raw interface{}) []string {
+	list, _ := raw.([]interface{})
+	params := make([]string, 0, len(list))
+	for _, p := range list {
+		switch v := p.(type) {
+		case string:
+			params = append(params, v)
+		case float64:
+			params = append(params, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	return params
+}
+
+func influxQuoteIdent(ident string) string {
+	if ident == "*" {
+		return ident
+	}
+	return "\"" + strings.Replace(ident, "\"", "\\\"", -1) + "\""
+}
+
+// influxTimeFilter renders the $timeFilter macro for the snapshot time range
+func influxTimeFilter(r TimeRange) string {
+	from := r.From.UnixNano() / int64(time.Millisecond)
+	to := r.To.UnixNano() / int64(time.Millisecond)
+	return fmt.Sprintf("time >= %dms and time <= %dms", from, to)
+}
+
+// influxDuration formats a step as an InfluxQL duration literal
+func influxDuration(step time.Duration) string {
+	if step%time.Second == 0 {
+		return strconv.FormatInt(int64(step/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(int64(step/time.Millisecond), 10) + "ms"
+}
+
+var influxAliasRe = regexp.MustCompile(`\$(\w+)|\[\[([\s\S]+?)\]\]`)
+
+// influxSeriesName names a series the way Grafana's InfluxDB datasource does:
+// the alias pattern if set, otherwise measurement.column {tag: value}
+func influxSeriesName(alias, measurement, column string, tags map[string]string) string {
+	if len(alias) > 0 {
+		return influxAliasRe.ReplaceAllStringFunc(alias, func(match string) string {
+			groups := influxAliasRe.FindStringSubmatch(match)
+			group := groups[1]
+			if len(group) == 0 {
+				group = groups[2]
+			}
+			switch {
+			case group == "m" || group == "measurement":
+				return measurement
+			case group == "col":
+				return column
+			case strings.HasPrefix(group, "tag_"):
+				if value, ok := tags[strings.TrimPrefix(group, "tag_")]; ok {
+					return value
+				}
+			}
+			return match
+		})
+	}
+
+	name := measurement
+	if column != "value" {
+		name = name + "." + column
+	}
+	if len(tags) > 0 {
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for idx, k := range keys {
+			pairs[idx] = k + ": " + tags[k]
+		}
+		name = name + " {" + strings.Join(pairs, ", ") + "}"
+	}
+	return name
+}
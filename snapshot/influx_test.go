@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInfluxBuildQuery(t *testing.T) {
+	// targets to test
+	var queryTests = []struct {
+		purpose  string
+		target   string
+		expected string
+	}{
+		{
+			purpose:  "Default target",
+			target:   `{"measurement": "cpu"}`,
+			expected: `SELECT mean("value") FROM "cpu" WHERE $timeFilter`,
+		},
+		{
+			purpose: "Complete target",
+			target: `{
+				"measurement": "cpu",
+				"policy": "autogen",
+				"select": [[
+					{"type": "field", "params": ["usage_idle"]},
+					{"type": "mean", "params": []},
+					{"type": "math", "params": ["* -1"]},
+					{"type": "alias", "params": ["idle"]}
+				]],
+				"tags": [
+					{"key": "host", "operator": "=~", "value": "/^web/"},
+					{"key": "cpu", "operator": "=", "value": "cpu-total", "condition": "AND"}
+				],
+				"groupBy": [
+					{"type": "time", "params": ["auto"]},
+					{"type": "tag", "params": ["host"]},
+					{"type": "fill", "params": ["null"]}
+				]
+			}`,
+			expected: `SELECT mean("usage_idle") * -1 AS "idle" FROM "autogen"."cpu" WHERE ("host" =~ /^web/ AND "cpu" = 'cpu-total') AND $timeFilter GROUP BY time($__interval), "host" fill(null)`,
+		},
+	}
+	// test
+	for _, qt := range queryTests {
+		var target map[string]interface{}
+		if err := json.Unmarshal([]byte(qt.target), &target); err != nil {
+			t.Fatalf("Test \"%s\" has invalid target json: %s", qt.purpose, err.Error())
+		}
+		if out := influxBuildQuery(target); out != qt.expected {
+			t.Errorf("Test \"%s\" expected:\n%s\nActual:\n%s", qt.purpose, qt.expected, out)
+		}
+	}
+}
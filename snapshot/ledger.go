@@ -0,0 +1,278 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LedgerEntry records a snapshot posted to the snapshot host, with the keys
+// needed to delete it later. A ledger file holds an entry per line.
+type LedgerEntry struct {
+	Key       string `json:"key"`
+	DeleteKey string `json:"deleteKey"`
+	DeleteURL string `json:"deleteUrl"`
+	URL       string `json:"url"`
+	Name      string `json:"name"`
+	// External is set for snapshots published to an external snapshot
+	// service, which can only be deleted by their DeleteURL
+	External bool `json:"external,omitempty"`
+	// SnapshotAddr is the snapshot host the snapshot was posted to
+	SnapshotAddr string    `json:"snapshotAddr"`
+	Created      time.Time `json:"created"`
+	// Expires is when the snapshot host deletes the snapshot, or zero for
+	// never
+	Expires time.Time `json:"expires"`
+}
+
+// ReadLedger reads the entries of a ledger file written with
+// Config.LedgerPath
+func ReadLedger(path string) ([]LedgerEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry LedgerEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Could not decode ledger line %d: %s", line, err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ledgerEntry builds the ledger entry of a snapshot posted with the name and
+// expiry at now
+func (sc *SnapClient) ledgerEntry(posted *Snapshot, name string, expires time.Duration, now time.Time) LedgerEntry {
+	entry := LedgerEntry{
+		Key:          posted.Key,
+		DeleteKey:    posted.DeleteKey,
+		DeleteURL:    posted.DeleteURL,
+		URL:          posted.URL,
+		Name:         name,
+		External:     posted.External,
+		SnapshotAddr: sc.config.SnapshotAddr.String(),
+		Created:      now,
+	}
+	if posted.External {
+		entry.URL = posted.ExternalURL
+		entry.DeleteURL = posted.ExternalDeleteURL
+	}
+	if expires > 0 {
+		entry.Expires = now.Add(expires)
+	}
+	return entry
+}
+
+// record appends a posted snapshot to the ledger, if one is configured,
+// replacing the entry of any snapshot it replaced by taking its key. The
+// snapshot already exists if this fails, so the error is logged with its keys
+// rather than returned.
+func (sc *SnapClient) record(posted *Snapshot, name string, expires time.Duration) {
+	if len(sc.config.LedgerPath) == 0 {
+		return
+	}
+	entry := sc.ledgerEntry(posted, name, expires, time.Now())
+	sc.ledgerMu.Lock()
+	err := recordEntry(sc.config.LedgerPath, entry)
+	sc.ledgerMu.Unlock()
+	if err != nil {
+		sc.config.Logger.Error("Failed to record snapshot in ledger", "path", sc.config.LedgerPath, "key", entry.Key, "deleteKey", entry.DeleteKey, "deleteUrl", entry.DeleteURL, "error", err)
+	}
+}
+
+// recordEntry appends entry to the ledger at path, or rewrites the ledger if
+// it has an entry with the same key, which mustn't be pruned as it would
+// delete the new snapshot
+func recordEntry(path string, entry LedgerEntry) error {
+	entries, err := ReadLedger(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var kept []LedgerEntry
+	for _, e := range entries {
+		if e.Key != entry.Key || e.SnapshotAddr != entry.SnapshotAddr {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) < len(entries) {
+		return writeLedger(path, append(kept, entry))
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return appendLine(path, line)
+}
+
+// appendLine appends a line to the file at path, creating it if needed. The
+// line is written at once, so lines appended by other processes aren't
+// interleaved with it.
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeLedger replaces the ledger at path with entries
+func writeLedger(path string, entries []LedgerEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf.Bytes()); err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// pruneLedger deletes the snapshots in the ledger selected by the PruneConfig,
+// by their delete URLs, and removes them from the ledger
+func (sc *SnapClient) pruneLedger(ctx context.Context, c *PruneConfig) ([]SnapshotSummary, error) {
+	sc.ledgerMu.Lock()
+	defer sc.ledgerMu.Unlock()
+
+	entries, err := ReadLedger(c.LedgerPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read ledger: %s", err.Error())
+	}
+	summaries := make([]SnapshotSummary, len(entries))
+	for idx, entry := range entries {
+		summaries[idx] = entry.summary()
+	}
+	selected := pruneSelect(summaries, c, time.Now())
+	if c.DryRun {
+		return selected, nil
+	}
+
+	deleted := make(map[string]bool)
+	var pruned []SnapshotSummary
+	for _, s := range selected {
+		entry := ledgerEntryByKey(entries, s.Key)
+		if err = sc.deleteEntry(ctx, entry); err != nil {
+			err = fmt.Errorf("Failed to delete snapshot %q: %s", s.Key, err.Error())
+			break
+		}
+		deleted[s.Key] = true
+		pruned = append(pruned, s)
+	}
+	if len(deleted) == 0 {
+		return nil, err
+	}
+
+	// keep the entries which weren't deleted
+	var kept []LedgerEntry
+	for _, entry := range entries {
+		if !deleted[entry.Key] {
+			kept = append(kept, entry)
+		}
+	}
+	if writeErr := writeLedger(c.LedgerPath, kept); writeErr != nil && err == nil {
+		err = fmt.Errorf("Could not update ledger: %s", writeErr.Error())
+	}
+	return pruned, err
+}
+
+func ledgerEntryByKey(entries []LedgerEntry, key string) LedgerEntry {
+	for _, entry := range entries {
+		if entry.Key == key {
+			return entry
+		}
+	}
+	return LedgerEntry{}
+}
+
+// summary describes the ledger entry as a snapshot listed by the host
+func (e LedgerEntry) summary() SnapshotSummary {
+	s := SnapshotSummary{
+		Name:     e.Name,
+		Key:      e.Key,
+		External: e.External,
+		Expires:  e.Expires,
+		Created:  e.Created,
+		Updated:  e.Created,
+	}
+	if e.External {
+		s.ExternalURL = e.URL
+	}
+	return s
+}
+
+// deleteEntry deletes a ledger entry's snapshot. Snapshots on the snapshot
+// host are deleted by their delete key with the snapshot credentials, and
+// others by requesting their delete URL. Snapshots which are already gone,
+// such as expired ones, count as deleted.
+func (sc *SnapClient) deleteEntry(ctx context.Context, entry LedgerEntry) error {
+	deleteURL, err := url.Parse(entry.DeleteURL)
+	if err != nil || len(entry.DeleteURL) == 0 {
+		if len(entry.DeleteKey) == 0 {
+			return errors.New("Ledger entry has no delete URL or key")
+		}
+		deleteURL = nil
+	}
+
+	var status int
+	if deleteURL == nil || len(deleteURL.Host) == 0 || deleteURL.Host == sc.config.SnapshotAddr.Host {
+		_, status, err = sc.snapshotRequest(ctx, "GET", "api/snapshots-delete/"+entry.DeleteKey, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		ctx, cancel := withTimeout(ctx, sc.config.DashboardTimeout)
+		defer cancel()
+		req, err := http.NewRequest("GET", deleteURL.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := sc.httpClient().Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+	}
+	if status != http.StatusOK && status != http.StatusNotFound {
+		return fmt.Errorf("Unexpected status code when deleting snapshot: %d", status)
+	}
+	return nil
+}
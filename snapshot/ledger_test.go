@@ -0,0 +1,126 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-ledger")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	ledger := filepath.Join(dir, "ledger.jsonl")
+
+	// an external snapshot service, whose delete URLs need no credentials
+	var deleted []string
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("Authorization")) > 0 {
+			t.Errorf("External host was sent credentials")
+		}
+		deleted = append(deleted, r.URL.Path)
+	}))
+	defer external.Close()
+	// Grafana, which creates snapshots and deletes them by delete key
+	posts := 0
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Method, "POST") {
+			posts++
+			key := "key" + strconv.Itoa(posts)
+			w.Write([]byte(`{"key": "` + key + `", "deleteKey": "delete-` + key + `", "url": "/dashboard/snapshot/` + key + `", "deleteUrl": "/api/snapshots-delete/delete-` + key + `"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer XXXXX" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		deleted = append(deleted, r.URL.Path)
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL)
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", LedgerPath: ledger})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	// two local snapshots, one expiring
+	if _, err = sc.Upload([]byte(`{"dashboard": {"title": "Dash"}, "name": "daily 1", "expires": 3600}`)); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = sc.Upload([]byte(`{"dashboard": {"title": "Dash"}, "name": "adhoc"}`)); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	// and an external one
+	sc.record(&Snapshot{
+		Key:               "ext",
+		DeleteKey:         "delete-ext",
+		External:          true,
+		ExternalURL:       external.URL + "/dashboard/snapshot/ext",
+		ExternalDeleteURL: external.URL + "/api/snapshots-delete/delete-ext",
+	}, "daily 2", 0)
+
+	entries, err := ReadLedger(ledger)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"key1", "key2", "ext"}) {
+		t.Fatalf("Expected ledger keys [key1 key2 ext], got %v", keys)
+	}
+	if entries[0].Expires.IsZero() || !entries[1].Expires.IsZero() {
+		t.Errorf("Expected only the first entry to expire, got %v and %v", entries[0].Expires, entries[1].Expires)
+	}
+	if entries[2].DeleteURL != external.URL+"/api/snapshots-delete/delete-ext" {
+		t.Errorf("Expected the external delete URL, got %q", entries[2].DeleteURL)
+	}
+
+	// dry run leaves the ledger alone
+	pruned, err := sc.Prune(&PruneConfig{NamePrefix: "daily", DryRun: true, LedgerPath: ledger})
+	if err != nil || len(pruned) != 2 || len(deleted) != 0 {
+		t.Errorf("Dry run expected 2 snapshots and no deletes, got %d, %v and %v", len(pruned), deleted, err)
+	}
+	// nothing was created over an hour ago
+	pruned, err = sc.Prune(&PruneConfig{OlderThan: time.Hour, LedgerPath: ledger})
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("Older than expected no snapshots, got %d and %v", len(pruned), err)
+	}
+	pruned, err = sc.Prune(&PruneConfig{NamePrefix: "daily", LedgerPath: ledger})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	expected := []string{"/api/snapshots-delete/delete-key1", "/api/snapshots-delete/delete-ext"}
+	if len(pruned) != 2 || !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes %v, got %v", expected, deleted)
+	}
+	entries, err = ReadLedger(ledger)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if len(entries) != 1 || entries[0].Key != "key2" {
+		t.Errorf("Expected only key2 left in the ledger, got %v", entries)
+	}
+
+	// a snapshot replacing another by taking its key replaces its entry
+	sc.record(&Snapshot{Key: "nightly", DeleteKey: "delete-nightly-1"}, "nightly 1", 0)
+	sc.record(&Snapshot{Key: "nightly", DeleteKey: "delete-nightly-2"}, "nightly 2", 0)
+	entries, err = ReadLedger(ledger)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if len(entries) != 2 || entries[1].Key != "nightly" || entries[1].DeleteKey != "delete-nightly-2" {
+		t.Errorf("Expected key2 and the replacing nightly entry in the ledger, got %v", entries)
+	}
+}
@@ -0,0 +1,20 @@
+package snapshot
+
+// Logger receives a SnapClient's diagnostic messages, with alternating key
+// value pairs of context. Its methods match those of log/slog's Logger, so a
+// *slog.Logger can be used directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger discards messages, so the package is silent unless a Logger is
+// configured
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
@@ -0,0 +1,139 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func (sc *SnapClient) fetchDataPointsLoki(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	expr, _ := target["expr"].(string)
+	if len(expr) == 0 {
+		return nil, errors.New("Loki target has no expression")
+	}
+
+	// Loki takes nanosecond timestamps and a step in seconds
+	params := url.Values{}
+	params.Set("query", expr)
+	params.Set("start", strconv.FormatInt(r.From.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(r.To.UnixNano(), 10))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	// logs queries get the newest lines
+	params.Set("limit", strconv.Itoa(sc.maxLogLines(target, datasource)))
+	params.Set("direction", "backward")
+	respBody, err := sc.DatasourceProxyRequest(ctx, "GET", datasource, "loki/api/v1/query_range", params, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var lokiResp struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(respBody, &lokiResp); err != nil {
+		return nil, fmt.Errorf("Could not decode Loki response: %s", err.Error())
+	}
+	if lokiResp.Status != "success" {
+		return nil, fmt.Errorf("Loki query failed: %s", lokiResp.Error)
+	}
+	if lokiResp.Data.ResultType == lokiStreams {
+		var streams []lokiStream
+		if err = json.Unmarshal(lokiResp.Data.Result, &streams); err != nil {
+			return nil, fmt.Errorf("Could not decode Loki streams: %s", err.Error())
+		}
+		refID, _ := target["refId"].(string)
+		return lokiLogFrames(streams, refID), nil
+	}
+	if lokiResp.Data.ResultType != model.ValMatrix.String() {
+		return nil, fmt.Errorf("Unexpected Loki result type: got %q, want %q or %q", lokiResp.Data.ResultType, model.ValMatrix, lokiStreams)
+	}
+	var matrix model.Matrix
+	if err = json.Unmarshal(lokiResp.Data.Result, &matrix); err != nil {
+		return nil, fmt.Errorf("Could not decode Loki matrix: %s", err.Error())
+	}
+
+	return matrixToSnapshotData(matrix), nil
+}
+
+// The Loki result type of logs queries
+const lokiStreams = "streams"
+
+// lokiStream is a stream of a Loki logs query result: its labels, and its
+// lines' nanosecond timestamps and text
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// maxLogLines returns the most log lines to get for a logs query: the
+// target's max lines, else the datasource's, no more than the Config's
+func (sc *SnapClient) maxLogLines(target, datasource map[string]interface{}) int {
+	limit := sc.config.MaxLogLines
+	if limit == 0 {
+		limit = defaultMaxLogLines
+	}
+	jsonData, _ := datasource["jsonData"].(map[string]interface{})
+	for _, v := range []interface{}{target["maxLines"], jsonData["maxLines"]} {
+		var lines int
+		switch n := v.(type) {
+		case float64:
+			lines = int(n)
+		case string:
+			lines, _ = strconv.Atoi(n)
+		}
+		if lines > 0 {
+			if lines < limit {
+				limit = lines
+			}
+			break
+		}
+	}
+	return limit
+}
+
+// lokiLogFrames converts log streams into data frames of their lines, with
+// their labels, which Grafana's logs panel shows
+func lokiLogFrames(streams []lokiStream, refID string) []SnapshotData {
+	data := make([]SnapshotData, 0, len(streams))
+	for _, stream := range streams {
+		times := make([]interface{}, 0, len(stream.Values))
+		lines := make([]interface{}, 0, len(stream.Values))
+		ids := make([]interface{}, 0, len(stream.Values))
+		nanos := make([]interface{}, 0, len(stream.Values))
+		for _, value := range stream.Values {
+			ns, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			times = append(times, float64(ns/int64(time.Millisecond)))
+			lines = append(lines, value[1])
+			ids = append(ids, fmt.Sprintf("%s_%d", value[0], len(ids)))
+			nanos = append(nanos, value[0])
+		}
+		labels := stream.Stream
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		data = append(data, SnapshotData{Frame: map[string]interface{}{
+			"refId": refID,
+			"meta":  map[string]interface{}{"preferredVisualisationType": "logs"},
+			"fields": []interface{}{
+				map[string]interface{}{"name": "ts", "type": "time", "values": times},
+				map[string]interface{}{"name": "line", "type": "string", "labels": labels, "values": lines},
+				map[string]interface{}{"name": "id", "type": "string", "values": ids},
+				map[string]interface{}{"name": "tsNs", "type": "time", "values": nanos},
+			},
+		}})
+	}
+	return data
+}
@@ -0,0 +1,139 @@
+package snapshot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLokiLogs(t *testing.T) {
+	var query url.Values
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "streams", "result": [
+			{"stream": {"app": "api"}, "values": [["2000000000", "second"], ["1000000000", "first"]]}
+		]}}`))
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", MaxLogLines: 500})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	var limitTests = []struct {
+		purpose    string
+		target     map[string]interface{}
+		datasource map[string]interface{}
+		expected   string
+	}{
+		{
+			purpose:  "Config's max lines",
+			expected: "500",
+		},
+		{
+			purpose:  "Target's fewer max lines",
+			target:   map[string]interface{}{"maxLines": 20.0},
+			expected: "20",
+		},
+		{
+			purpose:    "Datasource's fewer max lines",
+			datasource: map[string]interface{}{"jsonData": map[string]interface{}{"maxLines": "50"}},
+			expected:   "50",
+		},
+		{
+			purpose:  "Target's more max lines",
+			target:   map[string]interface{}{"maxLines": 5000.0},
+			expected: "500",
+		},
+	}
+	for _, lt := range limitTests {
+		target := map[string]interface{}{"refId": "A", "expr": `{app="api"}`}
+		for k, v := range lt.target {
+			target[k] = v
+		}
+		datasource := map[string]interface{}{"id": 1.0, "type": "loki"}
+		for k, v := range lt.datasource {
+			datasource[k] = v
+		}
+		data, err := sc.fetchDataPointsLoki(context.Background(), target, datasource, TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}, time.Minute)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", lt.purpose, err.Error())
+			continue
+		}
+		if limit := query.Get("limit"); limit != lt.expected || query.Get("direction") != "backward" {
+			t.Errorf("Test \"%s\" expected the newest %s lines, got %s %s", lt.purpose, lt.expected, query.Get("direction"), limit)
+		}
+		if len(data) != 1 || data[0].Frame == nil {
+			t.Errorf("Test \"%s\" expected a logs frame, got %+v", lt.purpose, data)
+			continue
+		}
+		fields, _ := data[0].Frame["fields"].([]interface{})
+		line, _ := fields[1].(map[string]interface{})
+		if !reflect.DeepEqual(line["values"], []interface{}{"second", "first"}) || !reflect.DeepEqual(line["labels"], map[string]string{"app": "api"}) {
+			t.Errorf("Test \"%s\" expected the stream's lines, got %v", lt.purpose, line)
+		}
+	}
+}
+
+func TestLokiMetrics(t *testing.T) {
+	var query url.Values
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/datasources/proxy/2/loki/api/v1/query_range" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query = r.URL.Query()
+		switch query.Get("query") {
+		case `rate({app="api"}[1m])`:
+			w.Write([]byte(`{"status": "success", "data": {"resultType": "matrix", "result": [
+				{"metric": {"app": "api"}, "values": [[60, "0.5"], [120, "NaN"]]}
+			]}}`))
+		case `sum(count_over_time({app="api"}[1h]))`:
+			w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [
+				{"metric": {}, "value": [3600, "12"]}
+			]}}`))
+		default:
+			w.Write([]byte(`{"status": "error", "error": "parse error"}`))
+		}
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	datasource := map[string]interface{}{"id": 2.0}
+	r := TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	fetch := func(expr string) ([]SnapshotData, error) {
+		return sc.fetchDataPointsLoki(context.Background(), map[string]interface{}{"refId": "A", "expr": expr}, datasource, r, 30*time.Second)
+	}
+
+	// range queries return a matrix, with NaNs as nulls
+	data, err := fetch(`rate({app="api"}[1m])`)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if query.Get("start") != "0" || query.Get("end") != "3600000000000" || query.Get("step") != "30" {
+		t.Errorf("Expected nanosecond times and a step in seconds, got %v", query)
+	}
+	points := [][]interface{}{{0.5, 60000.0}, {nil, 120000.0}}
+	if len(data) != 1 || data[0].Metric["app"] != "api" || !reflect.DeepEqual(data[0].Datapoints, points) {
+		t.Errorf("Expected series {app=\"api\"} with %v, got %+v", points, data)
+	}
+
+	// other results fail
+	if _, err = fetch(`sum(count_over_time({app="api"}[1h]))`); err == nil || err.Error() != `Unexpected Loki result type: got "vector", want "matrix" or "streams"` {
+		t.Errorf("Expected the vector result to fail, got %v", err)
+	}
+	if _, err = fetch(`{app=`); err == nil || err.Error() != "Loki query failed: parse error" {
+		t.Errorf("Expected the failed query to fail, got %v", err)
+	}
+	if _, err = fetch(""); err == nil {
+		t.Error("Expected a target without an expression to fail")
+	}
+}
@@ -0,0 +1,32 @@
+package snapshot
+
+import "time"
+
+// The stages taking a snapshot can fail at, as passed to
+// Metrics.SnapshotFailed and set in TakeError
+const (
+	// StageConfig is an invalid TakeConfig
+	StageConfig = "config"
+	// StageDashboard is fetching the dashboard, its datasources or its
+	// template variables' options from Grafana
+	StageDashboard = "dashboard"
+	// StageQuery is fetching panel data from a datasource
+	StageQuery = "query"
+	// StageUpload is posting the snapshot to the snapshot host, or writing
+	// it to the TakeConfig's OutputPath
+	StageUpload = "upload"
+)
+
+// Metrics receives measurements of the snapshots a SnapClient takes, e.g. to
+// export them to a monitoring system. Methods may be called concurrently.
+type Metrics interface {
+	// SnapshotTaken is called after each snapshot is taken, with the size
+	// of its payload in bytes and how long it took to take
+	SnapshotTaken(size int, duration time.Duration)
+	// SnapshotFailed is called when taking a snapshot fails, with the
+	// stage it failed at
+	SnapshotFailed(stage string)
+	// QueryDone is called after each panel target's datasource query, with
+	// the datasource type, how long it took and any error
+	QueryDone(datasourceType string, duration time.Duration, err error)
+}
@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SnapshotNameData is what a SnapshotName template is evaluated against, e.g.
+// "{{.DashTitle}} {{.From.Format \"2006-01-02\"}} {{.Vars.cluster}}"
+type SnapshotNameData struct {
+	DashTitle string
+	DashSlug  string
+	DashUID   string
+	// From and To are in the snapshot's Location
+	From time.Time
+	To   time.Time
+	// Vars are the text of the dashboard's template variables, multiple
+	// values comma separated
+	Vars map[string]string
+}
+
+// parseNameTemplate parses a SnapshotName as a template if it has any actions
+func parseNameTemplate(name string) (*template.Template, error) {
+	if !strings.Contains(name, "{{") {
+		return nil, nil
+	}
+	tmpl, err := template.New("SnapshotName").Option("missingkey=zero").Parse(name)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SnapshotName template: %s", err.Error())
+	}
+	return tmpl, nil
+}
+
+// snapshotName returns the SnapshotName, evaluating it if it's a template
+func (c *TakeConfig) snapshotName(dashboard map[string]interface{}, values map[string]variableValue) (string, error) {
+	if c.nameTemplate == nil {
+		return c.SnapshotName, nil
+	}
+	data := SnapshotNameData{
+		DashSlug: c.DashSlug,
+		From:     c.From.In(c.Location),
+		To:       c.To.In(c.Location),
+		Vars:     variableText(values),
+	}
+	data.DashTitle, _ = dashboard["title"].(string)
+	data.DashUID, _ = dashboard["uid"].(string)
+	if len(data.DashUID) == 0 {
+		data.DashUID = c.DashUID
+	}
+	var name bytes.Buffer
+	if err := c.nameTemplate.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("Failed to evaluate SnapshotName template: %s", err.Error())
+	}
+	return name.String(), nil
+}
+
+// variableText returns the text of variables' values, multiple values comma
+// separated
+func variableText(values map[string]variableValue) map[string]string {
+	text := make(map[string]string, len(values))
+	for name, value := range values {
+		text[name] = strings.Join(value.Text, ",")
+	}
+	return text
+}
@@ -0,0 +1,56 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotName(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	from := time.Date(2017, 7, 1, 23, 30, 0, 0, time.UTC)
+	to := time.Date(2017, 7, 2, 23, 30, 0, 0, time.UTC)
+	dashboard := map[string]interface{}{"title": "Payments", "uid": "pay123"}
+	values := map[string]variableValue{
+		"cluster": {Text: []string{"eu-1"}, Value: []string{"eu-1"}},
+		"node":    {Text: []string{"a", "b"}, Value: []string{"a", "b"}, Multi: true},
+	}
+	nameTests := []struct {
+		purpose  string
+		name     string
+		expected string
+	}{
+		{
+			purpose:  "Plain name",
+			name:     "Daily payments",
+			expected: "Daily payments",
+		},
+		{
+			purpose:  "Default name",
+			name:     "",
+			expected: "2017-07-03 payments",
+		},
+		{
+			purpose:  "Template",
+			name:     `{{.DashTitle}} {{.From.Format "2006-01-02"}} {{.Vars.cluster}}`,
+			expected: "Payments 2017-07-02 eu-1",
+		},
+		{
+			purpose:  "Template with multi-value and missing variables",
+			name:     `{{.DashUID}} {{.DashSlug}} {{.Vars.node}}{{.Vars.missing}}`,
+			expected: "pay123 payments a,b",
+		},
+	}
+	// test
+	for _, nt := range nameTests {
+		c, err := processTakeConfig(&TakeConfig{DashSlug: "payments", From: &from, To: &to, Location: london, SnapshotName: nt.name})
+		if err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", nt.purpose, err.Error())
+		}
+		name, err := c.snapshotName(dashboard, values)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", nt.purpose, err.Error())
+		} else if name != nt.expected {
+			t.Errorf("Test \"%s\" expected name %q, got %q", nt.purpose, nt.expected, name)
+		}
+	}
+}
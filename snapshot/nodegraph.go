@@ -0,0 +1,140 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// graphEdge is an edge of a node graph, e.g. of a service map, from the
+// source node to the target
+type graphEdge struct {
+	source, target string
+	// calls is how many requests the source made to the target
+	calls float64
+}
+
+// fetchJaegerDependencies gets the service dependencies Jaeger found in the
+// time range as a node graph
+func (sc *SnapClient) fetchJaegerDependencies(ctx context.Context, target, datasource map[string]interface{}, r TimeRange) ([]SnapshotData, error) {
+	// Jaeger takes the end of the range and how far it looks back from it in
+	// milliseconds
+	params := url.Values{
+		"endTs":    {strconv.FormatInt(r.To.UnixNano()/1e6, 10)},
+		"lookback": {strconv.FormatInt(r.To.Sub(r.From).Milliseconds(), 10)},
+	}
+	respBody, err := sc.DatasourceProxyRequest(ctx, "GET", datasource, "api/dependencies", params, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var jaegerResp struct {
+		Data []struct {
+			Parent    string  `json:"parent"`
+			Child     string  `json:"child"`
+			CallCount float64 `json:"callCount"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(respBody, &jaegerResp); err != nil {
+		return nil, fmt.Errorf("Could not decode Jaeger dependencies: %s", err.Error())
+	}
+	edges := make([]graphEdge, len(jaegerResp.Data))
+	for idx, dependency := range jaegerResp.Data {
+		edges[idx] = graphEdge{source: dependency.Parent, target: dependency.Child, calls: dependency.CallCount}
+	}
+	refID, _ := target["refId"].(string)
+	return nodeGraphFrames(edges, refID), nil
+}
+
+// fetchTempoServiceMap gets the service map of a Tempo datasource over the
+// time range as a node graph, from the service graph metrics in the Prometheus
+// datasource it's linked to, as Grafana does
+func (sc *SnapClient) fetchTempoServiceMap(ctx context.Context, target, datasource map[string]interface{}, r TimeRange) ([]SnapshotData, error) {
+	jsonData, _ := datasource["jsonData"].(map[string]interface{})
+	serviceMap, _ := jsonData["serviceMap"].(map[string]interface{})
+	uid, _ := serviceMap["datasourceUid"].(string)
+	sc.datasourceMu.Lock()
+	prometheus, ok := sc.datasourceCache[sc.datasourceUIDs[uid]].(map[string]interface{})
+	sc.datasourceMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Tempo datasource %q has no service map datasource", datasource["name"])
+	}
+
+	params := url.Values{
+		"query": {fmt.Sprintf("sum by (client, server) (increase(traces_service_graph_request_total[%ds]))", int64(r.To.Sub(r.From).Seconds()))},
+		"time":  {r.To.Format(time.RFC3339Nano)},
+	}
+	var result struct {
+		Result model.Vector `json:"result"`
+	}
+	if err := sc.prometheusAPIGet(ctx, prometheus, "api/v1/query", params, &result); err != nil {
+		return nil, err
+	}
+	edges := make([]graphEdge, len(result.Result))
+	for idx, sample := range result.Result {
+		edges[idx] = graphEdge{source: string(sample.Metric["client"]), target: string(sample.Metric["server"]), calls: float64(sample.Value)}
+	}
+	refID, _ := target["refId"].(string)
+	return nodeGraphFrames(edges, refID), nil
+}
+
+// nodeGraphFrames converts a graph's edges into the nodes and edges data
+// frames Grafana's node graph panel shows. Each node's main stat is the calls
+// it received.
+func nodeGraphFrames(edges []graphEdge, refID string) []SnapshotData {
+	received := make(map[string]float64)
+	for _, edge := range edges {
+		if _, ok := received[edge.source]; !ok {
+			received[edge.source] = 0
+		}
+		received[edge.target] += edge.calls
+	}
+	ids := make([]string, 0, len(received))
+	for id := range received {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	nodeIDs, nodeCalls := []interface{}{}, []interface{}{}
+	for _, id := range ids {
+		nodeIDs = append(nodeIDs, id)
+		nodeCalls = append(nodeCalls, received[id])
+	}
+
+	edgeIDs, sources, targets, edgeCalls := []interface{}{}, []interface{}{}, []interface{}{}, []interface{}{}
+	for _, edge := range edges {
+		edgeIDs = append(edgeIDs, edge.source+"_"+edge.target)
+		sources = append(sources, edge.source)
+		targets = append(targets, edge.target)
+		edgeCalls = append(edgeCalls, edge.calls)
+	}
+
+	meta := map[string]interface{}{"preferredVisualisationType": "nodeGraph"}
+	return []SnapshotData{
+		{Frame: map[string]interface{}{
+			"name":  "Nodes",
+			"refId": refID,
+			"meta":  meta,
+			"fields": []interface{}{
+				map[string]interface{}{"name": "id", "type": "string", "values": nodeIDs},
+				map[string]interface{}{"name": "title", "type": "string", "values": nodeIDs},
+				map[string]interface{}{"name": "mainstat", "type": "number", "config": map[string]interface{}{"displayName": "Calls"}, "values": nodeCalls},
+			},
+		}},
+		{Frame: map[string]interface{}{
+			"name":  "Edges",
+			"refId": refID,
+			"meta":  meta,
+			"fields": []interface{}{
+				map[string]interface{}{"name": "id", "type": "string", "values": edgeIDs},
+				map[string]interface{}{"name": "source", "type": "string", "values": sources},
+				map[string]interface{}{"name": "target", "type": "string", "values": targets},
+				map[string]interface{}{"name": "mainstat", "type": "number", "config": map[string]interface{}{"displayName": "Calls"}, "values": edgeCalls},
+			},
+		}},
+	}
+}
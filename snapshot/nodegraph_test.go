@@ -0,0 +1,88 @@
+package snapshot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNodeGraph(t *testing.T) {
+	var query url.Values
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		switch r.URL.Path {
+		case "/api/datasources/proxy/1/api/dependencies":
+			// Jaeger
+			w.Write([]byte(`{"data": [{"parent": "web", "child": "api", "callCount": 10}, {"parent": "api", "child": "db", "callCount": 4}]}`))
+		case "/api/datasources/proxy/3/api/v1/query":
+			// Tempo's service graph metrics in Prometheus
+			w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [
+				{"metric": {"client": "web", "server": "api"}, "value": [3600, "10"]},
+				{"metric": {"client": "api", "server": "db"}, "value": [3600, "4"]}
+			]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	sc.datasourceCache = map[string]interface{}{"Prom": map[string]interface{}{"id": 3.0, "name": "Prom", "uid": "prom"}}
+	sc.datasourceUIDs = map[string]string{"prom": "Prom"}
+	r := TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+
+	var graphTests = []struct {
+		purpose    string
+		fetch      func(sc *SnapClient, ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error)
+		queryType  string
+		datasource map[string]interface{}
+	}{
+		{
+			purpose:    "Jaeger dependency graph",
+			fetch:      (*SnapClient).fetchTraceJaeger,
+			queryType:  "dependencyGraph",
+			datasource: map[string]interface{}{"id": 1.0},
+		},
+		{
+			purpose:   "Tempo service map",
+			fetch:     (*SnapClient).fetchTraceTempo,
+			queryType: "serviceMap",
+			datasource: map[string]interface{}{"id": 2.0, "jsonData": map[string]interface{}{
+				"serviceMap": map[string]interface{}{"datasourceUid": "prom"},
+			}},
+		},
+	}
+	for _, gt := range graphTests {
+		target := map[string]interface{}{"refId": "A", "queryType": gt.queryType}
+		data, err := gt.fetch(sc, context.Background(), target, gt.datasource, r, time.Minute)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", gt.purpose, err.Error())
+			continue
+		}
+		if len(data) != 2 || data[0].Frame["name"] != "Nodes" || data[1].Frame["name"] != "Edges" {
+			t.Errorf("Test \"%s\" expected nodes and edges frames, got %+v", gt.purpose, data)
+			continue
+		}
+		nodes, _ := data[0].Frame["fields"].([]interface{})
+		ids, _ := nodes[0].(map[string]interface{})
+		calls, _ := nodes[2].(map[string]interface{})
+		if !reflect.DeepEqual(ids["values"], []interface{}{"api", "db", "web"}) || !reflect.DeepEqual(calls["values"], []interface{}{10.0, 4.0, 0.0}) {
+			t.Errorf("Test \"%s\" expected nodes api, db and web, got %v with calls %v", gt.purpose, ids["values"], calls["values"])
+		}
+		edges, _ := data[1].Frame["fields"].([]interface{})
+		sources, _ := edges[1].(map[string]interface{})
+		if !reflect.DeepEqual(sources["values"], []interface{}{"web", "api"}) {
+			t.Errorf("Test \"%s\" expected edges from web and api, got %v", gt.purpose, sources["values"])
+		}
+	}
+	if query.Get("time") != r.To.Format(time.RFC3339Nano) {
+		t.Errorf("Expected the service map at the end of the range, got %q", query.Get("time"))
+	}
+}
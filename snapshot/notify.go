@@ -0,0 +1,36 @@
+package snapshot
+
+import (
+	"context"
+	"time"
+)
+
+// Notifier announces the result of taking a snapshot. Webhook, Slack and SMTP
+// are Notifiers.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// The Status of a Notification
+const (
+	NotifySuccess = "success"
+	NotifyFailure = "failure"
+)
+
+// Notification is the result of taking a snapshot of a dashboard
+type Notification struct {
+	// Status is NotifySuccess, or NotifyFailure with the Error
+	Status string `json:"status"`
+	// Org is the name of the org the dashboard is in, if there are several
+	Org       string    `json:"org,omitempty"`
+	Dashboard string    `json:"dashboard"`
+	Title     string    `json:"title,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Error     string    `json:"error,omitempty"`
+	// Images and Report are the paths of any files exported with the
+	// snapshot
+	Images []string `json:"images,omitempty"`
+	Report string   `json:"report,omitempty"`
+}
@@ -0,0 +1,155 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+var openTSDBAliasRe = regexp.MustCompile(`\$tag_(\w+)`)
+
+func (sc *SnapClient) fetchDataPointsOpenTSDB(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	metricName, _ := target["metric"].(string)
+	if len(metricName) == 0 {
+		return nil, errors.New("OpenTSDB target has no metric")
+	}
+
+	// Build the sub query
+	query := map[string]interface{}{
+		"metric":     metricName,
+		"aggregator": "sum",
+	}
+	if aggregator, _ := target["aggregator"].(string); len(aggregator) > 0 {
+		query["aggregator"] = aggregator
+	}
+	if disabled, _ := target["disableDownsampling"].(bool); !disabled {
+		interval, _ := target["downsampleInterval"].(string)
+		if len(interval) == 0 {
+			interval = openTSDBInterval(step)
+		}
+		downsampler, _ := target["downsampleAggregator"].(string)
+		if len(downsampler) == 0 {
+			downsampler = "avg"
+		}
+		downsample := interval + "-" + downsampler
+		if fill, _ := target["downsampleFillPolicy"].(string); len(fill) > 0 && fill != "none" {
+			downsample = downsample + "-" + fill
+		}
+		query["downsample"] = downsample
+	}
+	if rate, _ := target["shouldComputeRate"].(bool); rate {
+		query["rate"] = true
+		rateOptions := map[string]interface{}{}
+		if counter, _ := target["isCounter"].(bool); counter {
+			rateOptions["counter"] = true
+			if counterMax, _ := target["counterMax"].(string); len(counterMax) > 0 {
+				rateOptions["counterMax"], _ = strconv.ParseFloat(counterMax, 64)
+			}
+			if resetValue, _ := target["counterResetValue"].(string); len(resetValue) > 0 {
+				rateOptions["resetValue"], _ = strconv.ParseFloat(resetValue, 64)
+			}
+		}
+		query["rateOptions"] = rateOptions
+	}
+	if filters, ok := target["filters"].([]interface{}); ok && len(filters) > 0 {
+		query["filters"] = filters
+	} else if tags, ok := target["tags"].(map[string]interface{}); ok {
+		query["tags"] = tags
+	}
+
+	reqBody := map[string]interface{}{
+		"start":   r.From.UnixNano() / int64(time.Millisecond),
+		"end":     r.To.UnixNano() / int64(time.Millisecond),
+		"queries": []interface{}{query},
+	}
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := sc.DatasourceProxyRequest(ctx, "POST", datasource, "api/query", nil, bytes.NewReader(b), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	var openTSDBResp []struct {
+		Metric string             `json:"metric"`
+		Tags   map[string]string  `json:"tags"`
+		DPS    map[string]float64 `json:"dps"`
+	}
+	if err = json.Unmarshal(respBody, &openTSDBResp); err != nil {
+		return nil, fmt.Errorf("Could not decode OpenTSDB response: %s", err.Error())
+	}
+
+	alias, _ := target["alias"].(string)
+	results := make([]SnapshotData, len(openTSDBResp))
+	for idx, series := range openTSDBResp {
+		// dps is keyed on unix seconds
+		timestamps := make([]int64, 0, len(series.DPS))
+		for ts := range series.DPS {
+			t, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid OpenTSDB timestamp %q", ts)
+			}
+			timestamps = append(timestamps, t)
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+		datapoints := make([][]interface{}, len(timestamps))
+		for i, t := range timestamps {
+			datapoints[i] = []interface{}{series.DPS[strconv.FormatInt(t, 10)], float64(t * 1000)}
+		}
+
+		metric := model.Metric{model.MetricNameLabel: model.LabelValue(series.Metric)}
+		for k, v := range series.Tags {
+			metric[model.LabelName(k)] = model.LabelValue(v)
+		}
+		results[idx] = SnapshotData{
+			Target:     openTSDBSeriesName(alias, series.Metric, series.Tags),
+			Datapoints: datapoints,
+			Metric:     metric,
+		}
+	}
+	return results, nil
+}
+
+// openTSDBInterval formats the step as an OpenTSDB downsample interval
+func openTSDBInterval(step time.Duration) string {
+	if step < time.Second {
+		return strconv.FormatInt(int64(step/time.Millisecond), 10) + "ms"
+	}
+	return strconv.FormatInt(int64(step/time.Second), 10) + "s"
+}
+
+// openTSDBSeriesName names a series the way Grafana's OpenTSDB datasource
+// does: the alias with $tag_name substituted, otherwise metric{tag=value}
+func openTSDBSeriesName(alias, metric string, tags map[string]string) string {
+	if len(alias) > 0 {
+		return openTSDBAliasRe.ReplaceAllStringFunc(alias, func(match string) string {
+			tag := openTSDBAliasRe.FindStringSubmatch(match)[1]
+			if value, ok := tags[tag]; ok {
+				return value
+			}
+			return match
+		})
+	}
+	if len(tags) == 0 {
+		return metric
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for idx, k := range keys {
+		pairs[idx] = k + "=" + tags[k]
+	}
+	return metric + "{" + strings.Join(pairs, ", ") + "}"
+}
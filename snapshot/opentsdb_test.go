@@ -0,0 +1,118 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpenTSDBQuery(t *testing.T) {
+	var query map[string]interface{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/datasources/proxy/1/api/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&query)
+		// dps is an object, so its order isn't the points'
+		w.Write([]byte(`[{"metric": "sys.cpu", "tags": {"host": "a"}, "dps": {"120": 2, "60": 1, "1000": 3}}]`))
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	datasource := map[string]interface{}{"id": 1.0}
+	r := TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+
+	// targets to test
+	var queryTests = []struct {
+		purpose  string
+		target   string
+		expected string // the sub query
+	}{
+		{
+			purpose:  "Default downsampling",
+			target:   `{"metric": "sys.cpu"}`,
+			expected: `{"metric": "sys.cpu", "aggregator": "sum", "downsample": "60s-avg"}`,
+		},
+		{
+			purpose: "Downsample options",
+			target: `{"metric": "sys.cpu", "aggregator": "max", "downsampleInterval": "5m", "downsampleAggregator": "min",
+				"downsampleFillPolicy": "zero", "tags": {"host": "*"}}`,
+			expected: `{"metric": "sys.cpu", "aggregator": "max", "downsample": "5m-min-zero", "tags": {"host": "*"}}`,
+		},
+		{
+			purpose:  "No fill policy",
+			target:   `{"metric": "sys.cpu", "downsampleFillPolicy": "none"}`,
+			expected: `{"metric": "sys.cpu", "aggregator": "sum", "downsample": "60s-avg"}`,
+		},
+		{
+			purpose:  "Rate",
+			target:   `{"metric": "sys.cpu", "disableDownsampling": true, "shouldComputeRate": true}`,
+			expected: `{"metric": "sys.cpu", "aggregator": "sum", "rate": true, "rateOptions": {}}`,
+		},
+		{
+			purpose: "Counter rate",
+			target: `{"metric": "sys.cpu", "disableDownsampling": true, "shouldComputeRate": true, "isCounter": true,
+				"counterMax": "65535", "counterResetValue": "1", "filters": [{"type": "wildcard", "tagk": "host", "filter": "*"}]}`,
+			expected: `{"metric": "sys.cpu", "aggregator": "sum", "rate": true, "rateOptions": {"counter": true, "counterMax": 65535, "resetValue": 1},
+				"filters": [{"type": "wildcard", "tagk": "host", "filter": "*"}]}`,
+		},
+	}
+	// test
+	for _, qt := range queryTests {
+		var target, expected map[string]interface{}
+		json.Unmarshal([]byte(qt.target), &target)
+		json.Unmarshal([]byte(qt.expected), &expected)
+		query = nil
+		data, err := sc.fetchDataPointsOpenTSDB(context.Background(), target, datasource, r, time.Minute)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", qt.purpose, err.Error())
+			continue
+		}
+		if query["start"] != 0.0 || query["end"] != 3600000.0 {
+			t.Errorf("Test \"%s\" expected the time range in milliseconds, got %v to %v", qt.purpose, query["start"], query["end"])
+		}
+		queries, _ := query["queries"].([]interface{})
+		if len(queries) != 1 || !reflect.DeepEqual(queries[0], expected) {
+			t.Errorf("Test \"%s\" expected query %v, got %v", qt.purpose, expected, queries)
+		}
+		// the points are in time order, in milliseconds
+		points := [][]interface{}{{1.0, 60000.0}, {2.0, 120000.0}, {3.0, 1000000.0}}
+		if len(data) != 1 || data[0].Target != "sys.cpu{host=a}" || !reflect.DeepEqual(data[0].Datapoints, points) {
+			t.Errorf("Test \"%s\" expected series \"sys.cpu{host=a}\" with %v, got %+v", qt.purpose, points, data)
+		}
+	}
+
+	if _, err = sc.fetchDataPointsOpenTSDB(context.Background(), map[string]interface{}{}, datasource, r, time.Minute); err == nil {
+		t.Error("Expected a target without a metric to fail")
+	}
+}
+
+func TestOpenTSDBSeriesName(t *testing.T) {
+	// names to test
+	var nameTests = []struct {
+		purpose  string
+		alias    string
+		tags     map[string]string
+		expected string
+	}{
+		{"No tags", "", nil, "sys.cpu"},
+		{"Tags in order", "", map[string]string{"host": "a", "dc": "eu"}, "sys.cpu{dc=eu, host=a}"},
+		{"Alias", "$tag_host in $tag_dc", map[string]string{"host": "a", "dc": "eu"}, "a in eu"},
+		{"Alias of a missing tag", "$tag_host $tag_rack", map[string]string{"host": "a"}, "a $tag_rack"},
+	}
+	// test
+	for _, nt := range nameTests {
+		if out := openTSDBSeriesName(nt.alias, "sys.cpu", nt.tags); out != nt.expected {
+			t.Errorf("Test \"%s\" expected %q, got %q", nt.purpose, nt.expected, out)
+		}
+	}
+}
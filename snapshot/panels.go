@@ -0,0 +1,498 @@
+package snapshot
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// dashboardPanels returns every panel in the dashboard. Dashboards from
+// schemaVersion 16 keep panels in dashboard.panels, with the panels of
+// collapsed rows nested inside the row panel; older dashboards keep them in
+// dashboard.rows[].panels. Both layouts are walked.
+func dashboardPanels(dashboard map[string]interface{}) []map[string]interface{} {
+	var panels []map[string]interface{}
+
+	// legacy row based layout
+	rows, _ := dashboard["rows"].([]interface{})
+	for _, r := range rows {
+		row, _ := r.(map[string]interface{})
+		panels = append(panels, panelList(row["panels"])...)
+	}
+
+	// grid layout
+	for _, panel := range panelList(dashboard["panels"]) {
+		panels = append(panels, panel)
+		if panel["type"] == "row" {
+			panels = append(panels, panelList(panel["panels"])...)
+		}
+	}
+	return panels
+}
+
+// panelList converts a decoded JSON panel array into panel maps
+func panelList(raw interface{}) []map[string]interface{} {
+	list, _ := raw.([]interface{})
+	panels := make([]map[string]interface{}, 0, len(list))
+	for _, p := range list {
+		if panel, ok := p.(map[string]interface{}); ok {
+			panels = append(panels, panel)
+		}
+	}
+	return panels
+}
+
+// Width of the Grafana dashboard grid
+const gridColumns = 24
+
+// Width in pixels assumed for the dashboard when estimating panel widths, as
+// on a typical full HD screen
+const dashboardWidth = 1920
+
+// panelMaxDataPoints returns the panel's maxDataPoints, or else its width in
+// pixels, which Grafana queries a data point per pixel for by default
+func panelMaxDataPoints(panel map[string]interface{}) int {
+	switch maxDataPoints := panel["maxDataPoints"].(type) {
+	case float64:
+		if maxDataPoints > 0 {
+			return int(maxDataPoints)
+		}
+	case string:
+		if n, err := strconv.Atoi(maxDataPoints); err == nil && n > 0 {
+			return n
+		}
+	}
+	// grid layout panels are gridPos.w of 24 columns wide, legacy row
+	// panels span of 12
+	if gridPos, ok := panel["gridPos"].(map[string]interface{}); ok {
+		if w, ok := gridPos["w"].(float64); ok && w > 0 {
+			return int(math.Ceil(w / gridColumns * dashboardWidth))
+		}
+	}
+	if span, ok := panel["span"].(float64); ok && span > 0 {
+		return int(math.Ceil(span / 12 * dashboardWidth))
+	}
+	return dashboardWidth
+}
+
+// expandRepeatedPanels replaces each panel with a "repeat" variable by a copy
+// per selected value of that variable, each with scopedVars set, as Grafana
+// does when rendering the dashboard. The copies are plain panels so the
+// snapshot viewer shows them as they were.
+func expandRepeatedPanels(dashboard map[string]interface{}, values map[string]variableValue) {
+	nextID := maxPanelID(dashboard) + 1
+
+	// legacy row based layout
+	rows, _ := dashboard["rows"].([]interface{})
+	for _, r := range rows {
+		row, _ := r.(map[string]interface{})
+		if row["panels"] != nil {
+			row["panels"] = repeatPanelList(row["panels"], values, &nextID)
+		}
+	}
+
+	// grid layout, including panels inside collapsed rows
+	for _, panel := range panelList(dashboard["panels"]) {
+		if panel["type"] == "row" && panel["panels"] != nil {
+			panel["panels"] = repeatPanelList(panel["panels"], values, &nextID)
+		}
+	}
+	if dashboard["panels"] != nil {
+		dashboard["panels"] = repeatPanelList(dashboard["panels"], values, &nextID)
+	}
+}
+
+// repeatPanelList returns the panel list with repeated panels expanded in
+// place
+func repeatPanelList(raw interface{}, values map[string]variableValue, nextID *float64) []interface{} {
+	var out []interface{}
+	for _, panel := range panelList(raw) {
+		varName, _ := panel["repeat"].(string)
+		value, ok := values[varName]
+		if len(varName) == 0 || panel["type"] == "row" || !ok || len(value.Value) == 0 {
+			out = append(out, panel)
+			continue
+		}
+		delete(panel, "repeat")
+
+		direction, _ := panel["repeatDirection"].(string)
+		maxPerRow, _ := panel["maxPerRow"].(float64)
+		if maxPerRow <= 0 {
+			maxPerRow = 4
+		}
+		gridPos, _ := panel["gridPos"].(map[string]interface{})
+		x, _ := gridPos["x"].(float64)
+		y, _ := gridPos["y"].(float64)
+		w, _ := gridPos["w"].(float64)
+		h, _ := gridPos["h"].(float64)
+		count := float64(len(value.Value))
+		perRow := math.Min(count, maxPerRow)
+		if direction != "v" && gridPos != nil {
+			w = math.Max(math.Floor(gridColumns/perRow), 1)
+		}
+
+		for idx := range value.Value {
+			copied := panel
+			if idx > 0 {
+				copied = copyJSON(panel).(map[string]interface{})
+				copied["id"] = *nextID
+				copied["repeatPanelId"] = panel["id"]
+				*nextID++
+			}
+			// keep any values scoped by a repeated row
+			setScopedVar(copied, varName, variableOptionText(value, idx), value.Value[idx])
+			if gridPos != nil {
+				pos := map[string]interface{}{"w": w, "h": h}
+				if direction == "v" {
+					pos["x"] = x
+					pos["y"] = y + float64(idx)*h
+				} else {
+					pos["x"] = math.Mod(float64(idx), perRow) * w
+					pos["y"] = y + math.Floor(float64(idx)/perRow)*h
+				}
+				copied["gridPos"] = pos
+			}
+			out = append(out, copied)
+		}
+	}
+	if out == nil {
+		out = []interface{}{}
+	}
+	return out
+}
+
+// expandRepeatedRows replaces each row with a "repeat" variable by a copy of
+// the row and its panels per selected value of that variable, with scopedVars
+// set on the row and each of its panels.
+func expandRepeatedRows(dashboard map[string]interface{}, values map[string]variableValue) {
+	nextID := maxPanelID(dashboard) + 1
+
+	// legacy row based layout
+	if rows, ok := dashboard["rows"].([]interface{}); ok {
+		var out []interface{}
+		for _, r := range rows {
+			row, _ := r.(map[string]interface{})
+			varName, _ := row["repeat"].(string)
+			value, ok := values[varName]
+			if len(varName) == 0 || !ok || len(value.Value) == 0 {
+				out = append(out, r)
+				continue
+			}
+			delete(row, "repeat")
+			for idx := range value.Value {
+				copied := row
+				if idx > 0 {
+					copied = copyJSON(row).(map[string]interface{})
+					for _, panel := range panelList(copied["panels"]) {
+						panel["repeatPanelId"] = panel["id"]
+						panel["id"] = nextID
+						nextID++
+					}
+				}
+				text := variableOptionText(value, idx)
+				setScopedVar(copied, varName, text, value.Value[idx])
+				for _, panel := range panelList(copied["panels"]) {
+					setScopedVar(panel, varName, text, value.Value[idx])
+				}
+				out = append(out, copied)
+			}
+		}
+		dashboard["rows"] = out
+	}
+
+	// grid layout, where a row's panels follow it unless it is collapsed
+	panels := panelList(dashboard["panels"])
+	if panels == nil {
+		return
+	}
+	var out []interface{}
+	shift := float64(0)
+	for i := 0; i < len(panels); i++ {
+		panel := panels[i]
+		varName, _ := panel["repeat"].(string)
+		value, ok := values[varName]
+		if panel["type"] != "row" || len(varName) == 0 || !ok || len(value.Value) == 0 {
+			shiftPanel(panel, shift)
+			out = append(out, panel)
+			continue
+		}
+		delete(panel, "repeat")
+
+		// collect the row's panels and the height of the row
+		group := []map[string]interface{}{panel}
+		for i+1 < len(panels) && panels[i+1]["type"] != "row" {
+			i++
+			group = append(group, panels[i])
+		}
+		rowPos, _ := panel["gridPos"].(map[string]interface{})
+		rowY, _ := rowPos["y"].(float64)
+		height := float64(1)
+		for _, p := range group {
+			pos, _ := p["gridPos"].(map[string]interface{})
+			y, _ := pos["y"].(float64)
+			h, _ := pos["h"].(float64)
+			height = math.Max(height, y+h-rowY)
+		}
+
+		for idx := range value.Value {
+			text := variableOptionText(value, idx)
+			for _, p := range group {
+				copied := p
+				if idx > 0 {
+					copied = copyJSON(p).(map[string]interface{})
+					copied["repeatPanelId"] = p["id"]
+					copied["id"] = nextID
+					nextID++
+					for _, nested := range panelList(copied["panels"]) {
+						nested["repeatPanelId"] = nested["id"]
+						nested["id"] = nextID
+						nextID++
+					}
+				}
+				setScopedVar(copied, varName, text, value.Value[idx])
+				for _, nested := range panelList(copied["panels"]) {
+					setScopedVar(nested, varName, text, value.Value[idx])
+					shiftPanel(nested, shift+float64(idx)*height)
+				}
+				shiftPanel(copied, shift+float64(idx)*height)
+				out = append(out, copied)
+			}
+		}
+		shift += float64(len(value.Value)-1) * height
+	}
+	dashboard["panels"] = out
+}
+
+// shiftPanel moves a panel down the grid
+func shiftPanel(panel map[string]interface{}, shift float64) {
+	if pos, ok := panel["gridPos"].(map[string]interface{}); ok && shift != 0 {
+		y, _ := pos["y"].(float64)
+		pos["y"] = y + shift
+	}
+}
+
+// setScopedVar adds a variable value to the panel or row's scopedVars
+func setScopedVar(panel map[string]interface{}, name, text, value string) {
+	scopedVars, ok := panel["scopedVars"].(map[string]interface{})
+	if !ok {
+		scopedVars = make(map[string]interface{})
+		panel["scopedVars"] = scopedVars
+	}
+	scopedVars[name] = map[string]interface{}{
+		"text":     text,
+		"value":    value,
+		"selected": true,
+	}
+}
+
+// variableOptionText returns the text of the idx'th selected value
+func variableOptionText(value variableValue, idx int) string {
+	if idx < len(value.Text) {
+		return value.Text[idx]
+	}
+	return value.Value[idx]
+}
+
+// maxPanelID returns the highest panel id used in the dashboard
+func maxPanelID(dashboard map[string]interface{}) float64 {
+	max := float64(0)
+	for _, panel := range dashboardPanels(dashboard) {
+		if id, ok := panel["id"].(float64); ok && id > max {
+			max = id
+		}
+	}
+	return max
+}
+
+// copyJSON deep copies a decoded JSON value
+func copyJSON(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			out[k] = copyJSON(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = copyJSON(item)
+		}
+		return out
+	}
+	return v
+}
+
+// PanelFilter selects the panels of a dashboard to snapshot. Each pattern is
+// either a panel ID, or a glob (as path.Match) matched against panel titles,
+// ignoring case. A row which matches stands for all of its panels.
+type PanelFilter struct {
+	// Include, if set, keeps only the panels matching one of these patterns
+	Include []string
+	// Exclude drops the panels matching any of these patterns
+	Exclude []string
+}
+
+func processPanelFilter(filterIn *PanelFilter) (*PanelFilter, error) {
+	filterOut := &PanelFilter{}
+	for _, pattern := range append(append([]string{}, filterIn.Include...), filterIn.Exclude...) {
+		if _, err := path.Match(strings.ToLower(pattern), ""); err != nil {
+			return nil, fmt.Errorf("Invalid PanelFilter pattern %q: %s", pattern, err.Error())
+		}
+	}
+	filterOut.Include = filterIn.Include
+	filterOut.Exclude = filterIn.Exclude
+	return filterOut, nil
+}
+
+// panelMatches reports whether the panel has one of the patterns' IDs, or its
+// title matches one of their globs
+func panelMatches(panel map[string]interface{}, title string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if id, err := strconv.ParseFloat(pattern, 64); err == nil {
+			if panel["id"] == id {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(title)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPanels removes the panels the filter doesn't select from the
+// dashboard, and any rows left empty, returning how many panels are left
+func filterPanels(dashboard map[string]interface{}, values map[string]variableValue, f *PanelFilter) int {
+	// whether the panel, or the row it's in if any, matches the patterns
+	matches := func(panel, row map[string]interface{}, patterns []string) bool {
+		for _, p := range []map[string]interface{}{panel, row} {
+			if p == nil {
+				continue
+			}
+			title, _ := p["title"].(string)
+			if panelMatches(p, interpolate(title, values, panelScopedVars(p), "text"), patterns) {
+				return true
+			}
+		}
+		return false
+	}
+	keep := func(panel, row map[string]interface{}) bool {
+		if len(f.Include) > 0 && !matches(panel, row, f.Include) {
+			return false
+		}
+		return !matches(panel, row, f.Exclude)
+	}
+	kept := 0
+	filter := func(raw interface{}, row map[string]interface{}) []interface{} {
+		out := []interface{}{}
+		for _, panel := range panelList(raw) {
+			if keep(panel, row) {
+				out = append(out, panel)
+				kept++
+			}
+		}
+		return out
+	}
+
+	// legacy row based layout
+	if rows, ok := dashboard["rows"].([]interface{}); ok {
+		keptRows := []interface{}{}
+		for _, row := range panelList(rows) {
+			if row["panels"] = filter(row["panels"], row); len(row["panels"].([]interface{})) > 0 {
+				keptRows = append(keptRows, row)
+			}
+		}
+		dashboard["rows"] = keptRows
+	}
+
+	// grid layout, where a row holds the panels after it until the next row,
+	// or its own panels if collapsed
+	if dashboard["panels"] == nil {
+		return kept
+	}
+	var out []interface{}
+	var row map[string]interface{}
+	rowStart := 0
+	for _, panel := range panelList(dashboard["panels"]) {
+		if panel["type"] == "row" {
+			// drop the previous row if none of its panels were kept
+			if row != nil && len(out) == rowStart+1 && len(panelList(row["panels"])) == 0 {
+				out = out[:rowStart]
+			}
+			row = panel
+			if row["panels"] != nil {
+				row["panels"] = filter(row["panels"], row)
+			}
+			rowStart = len(out)
+			out = append(out, row)
+			continue
+		}
+		if keep(panel, row) {
+			out = append(out, panel)
+			kept++
+		}
+	}
+	if row != nil && len(out) == rowStart+1 && len(panelList(row["panels"])) == 0 {
+		out = out[:rowStart]
+	}
+	if out == nil {
+		out = []interface{}{}
+	}
+	dashboard["panels"] = out
+	return kept
+}
+
+// What's done with panels' links, for TakeConfig.PanelLinks
+const (
+	// PanelLinksDrop removes them, as they lead to the live dashboards
+	PanelLinksDrop = "drop"
+	// PanelLinksKeep keeps them as they are
+	PanelLinksKeep = "keep"
+	// PanelLinksAbsolute keeps them, making links relative to Grafana, e.g.
+	// /d/abc/other, absolute, so they still work from the snapshot host
+	PanelLinksAbsolute = "absolute"
+)
+
+// setPanelLinks does with the panel's links, and its data links, as the mode
+// says, resolving relative links against grafanaAddr
+func setPanelLinks(panel map[string]interface{}, mode string, grafanaAddr *url.URL) {
+	switch mode {
+	case PanelLinksKeep:
+	case PanelLinksAbsolute:
+		fieldConfig, _ := panel["fieldConfig"].(map[string]interface{})
+		defaults, _ := fieldConfig["defaults"].(map[string]interface{})
+		for _, links := range []interface{}{panel["links"], defaults["links"]} {
+			list, _ := links.([]interface{})
+			for _, l := range list {
+				if link, ok := l.(map[string]interface{}); ok {
+					if u, ok := link["url"].(string); ok {
+						link["url"] = absoluteLink(u, grafanaAddr)
+					}
+				}
+			}
+		}
+	default:
+		if _, ok := panel["links"]; ok || panel["targets"] != nil {
+			panel["links"] = []interface{}{}
+		}
+	}
+}
+
+// absoluteLink returns a link relative to Grafana as an absolute URL. Links
+// from the root, e.g. /d/abc, are under Grafana's sub path if it has one, as
+// Grafana serves them.
+func absoluteLink(link string, grafanaAddr *url.URL) string {
+	u, err := url.Parse(link)
+	if err != nil || u.IsAbs() || len(u.Host) > 0 || len(link) == 0 {
+		return link
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		u.Path = strings.TrimSuffix(grafanaAddr.Path, "/") + u.Path
+	}
+	return grafanaAddr.ResolveReference(u).String()
+}
@@ -0,0 +1,297 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDashboardPanels(t *testing.T) {
+	// layouts to test
+	var layoutTests = []struct {
+		purpose   string
+		dashboard string
+		expected  []float64 // panel IDs, in order
+	}{
+		{
+			purpose:   "Legacy rows",
+			dashboard: `{"schemaVersion": 14, "rows": [{"panels": [{"id": 1}, {"id": 2}]}, {"panels": [{"id": 3}]}, {"title": "empty"}, null]}`,
+			expected:  []float64{1, 2, 3},
+		},
+		{
+			purpose:   "Grid panels",
+			dashboard: `{"schemaVersion": 16, "panels": [{"id": 1}, {"id": 2, "type": "row", "collapsed": false, "panels": []}, {"id": 3}]}`,
+			expected:  []float64{1, 2, 3},
+		},
+		{
+			purpose:   "Collapsed rows",
+			dashboard: `{"schemaVersion": 27, "panels": [{"id": 1}, {"id": 2, "type": "row", "collapsed": true, "panels": [{"id": 3}, {"id": 4}]}, {"id": 5}]}`,
+			expected:  []float64{1, 2, 3, 4, 5},
+		},
+		{
+			purpose:   "Neither rows nor panels",
+			dashboard: `{"schemaVersion": 10, "title": "empty"}`,
+			expected:  nil,
+		},
+		{
+			purpose:   "Null rows and panels",
+			dashboard: `{"schemaVersion": 14, "rows": null, "panels": null}`,
+			expected:  nil,
+		},
+	}
+	// test
+	for _, lt := range layoutTests {
+		var dashboard map[string]interface{}
+		if err := json.Unmarshal([]byte(lt.dashboard), &dashboard); err != nil {
+			t.Fatalf("Test \"%s\" has invalid json: %s", lt.purpose, err.Error())
+		}
+		var ids []float64
+		for _, panel := range dashboardPanels(dashboard) {
+			ids = append(ids, panel["id"].(float64))
+		}
+		if !reflect.DeepEqual(ids, lt.expected) {
+			t.Errorf("Test \"%s\" expected panels %v, got %v", lt.purpose, lt.expected, ids)
+		}
+	}
+}
+
+func TestExpandRepeatedPanels(t *testing.T) {
+	// some vars
+	var dashboard map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"templating": {"list": [
+			{"name": "instance", "multi": true, "current": {"text": "a + b + c", "value": ["a", "b", "c"]}},
+			{"name": "all", "includeAll": true, "current": {"text": "All", "value": "$__all"}, "options": [
+				{"text": "All", "value": "$__all"},
+				{"text": "x", "value": "x"},
+				{"text": "y", "value": "y"}
+			]}
+		]},
+		"panels": [
+			{"id": 1, "type": "graph", "repeat": "instance", "gridPos": {"x": 0, "y": 0, "w": 24, "h": 8}},
+			{"id": 2, "type": "graph", "repeat": "all", "repeatDirection": "v", "gridPos": {"x": 0, "y": 8, "w": 12, "h": 4}},
+			{"id": 3, "type": "text"}
+		]
+	}`), &dashboard)
+
+	values := resolveVariables(dashboard, nil)
+	expandRepeatedPanels(dashboard, values)
+
+	var expected []interface{}
+	json.Unmarshal([]byte(`[
+		{"id": 1, "type": "graph", "gridPos": {"x": 0, "y": 0, "w": 8, "h": 8},
+			"scopedVars": {"instance": {"text": "a", "value": "a", "selected": true}}},
+		{"id": 4, "type": "graph", "repeatPanelId": 1, "gridPos": {"x": 8, "y": 0, "w": 8, "h": 8},
+			"scopedVars": {"instance": {"text": "b", "value": "b", "selected": true}}},
+		{"id": 5, "type": "graph", "repeatPanelId": 1, "gridPos": {"x": 16, "y": 0, "w": 8, "h": 8},
+			"scopedVars": {"instance": {"text": "c", "value": "c", "selected": true}}},
+		{"id": 2, "type": "graph", "repeatDirection": "v", "gridPos": {"x": 0, "y": 8, "w": 12, "h": 4},
+			"scopedVars": {"all": {"text": "x", "value": "x", "selected": true}}},
+		{"id": 6, "type": "graph", "repeatDirection": "v", "repeatPanelId": 2, "gridPos": {"x": 0, "y": 12, "w": 12, "h": 4},
+			"scopedVars": {"all": {"text": "y", "value": "y", "selected": true}}},
+		{"id": 3, "type": "text"}
+	]`), &expected)
+
+	if !reflect.DeepEqual(dashboard["panels"], expected) {
+		out, _ := json.MarshalIndent(dashboard["panels"], "", "  ")
+		t.Errorf("Repeated panels DeepEqual compare failed")
+		t.Logf("Actual:\n%s", out)
+	}
+}
+
+func TestExpandRepeatedRows(t *testing.T) {
+	// some vars
+	var dashboard map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"panels": [
+			{"id": 1, "type": "row", "repeat": "dc", "gridPos": {"x": 0, "y": 0, "w": 24, "h": 1}},
+			{"id": 2, "type": "graph", "gridPos": {"x": 0, "y": 1, "w": 24, "h": 8}},
+			{"id": 3, "type": "row", "gridPos": {"x": 0, "y": 9, "w": 24, "h": 1}},
+			{"id": 4, "type": "graph", "gridPos": {"x": 0, "y": 10, "w": 24, "h": 8}}
+		],
+		"rows": [
+			{"title": "$dc", "repeat": "dc", "panels": [{"id": 5, "type": "graph"}]}
+		]
+	}`), &dashboard)
+	values := map[string]variableValue{
+		"dc": {Text: []string{"East", "West"}, Value: []string{"east", "west"}},
+	}
+
+	expandRepeatedRows(dashboard, values)
+
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"panels": [
+			{"id": 1, "type": "row", "gridPos": {"x": 0, "y": 0, "w": 24, "h": 1},
+				"scopedVars": {"dc": {"text": "East", "value": "east", "selected": true}}},
+			{"id": 2, "type": "graph", "gridPos": {"x": 0, "y": 1, "w": 24, "h": 8},
+				"scopedVars": {"dc": {"text": "East", "value": "east", "selected": true}}},
+			{"id": 7, "repeatPanelId": 1, "type": "row", "gridPos": {"x": 0, "y": 9, "w": 24, "h": 1},
+				"scopedVars": {"dc": {"text": "West", "value": "west", "selected": true}}},
+			{"id": 8, "repeatPanelId": 2, "type": "graph", "gridPos": {"x": 0, "y": 10, "w": 24, "h": 8},
+				"scopedVars": {"dc": {"text": "West", "value": "west", "selected": true}}},
+			{"id": 3, "type": "row", "gridPos": {"x": 0, "y": 18, "w": 24, "h": 1}},
+			{"id": 4, "type": "graph", "gridPos": {"x": 0, "y": 19, "w": 24, "h": 8}}
+		],
+		"rows": [
+			{"title": "$dc", "panels": [{"id": 5, "type": "graph",
+				"scopedVars": {"dc": {"text": "East", "value": "east", "selected": true}}}],
+				"scopedVars": {"dc": {"text": "East", "value": "east", "selected": true}}},
+			{"title": "$dc", "panels": [{"id": 6, "repeatPanelId": 5, "type": "graph",
+				"scopedVars": {"dc": {"text": "West", "value": "west", "selected": true}}}],
+				"scopedVars": {"dc": {"text": "West", "value": "west", "selected": true}}}
+		]
+	}`), &expected)
+
+	if !reflect.DeepEqual(dashboard, expected) {
+		out, _ := json.MarshalIndent(dashboard, "", "  ")
+		t.Errorf("Repeated rows DeepEqual compare failed")
+		t.Logf("Actual:\n%s", out)
+	}
+
+	// rows without a position are still repeated
+	dashboard, expected = nil, nil
+	json.Unmarshal([]byte(`{"panels": [{"id": 1, "type": "row", "repeat": "dc"}, {"id": 2, "type": "graph"}]}`), &dashboard)
+	expandRepeatedRows(dashboard, values)
+	json.Unmarshal([]byte(`{"panels": [
+		{"id": 1, "type": "row", "scopedVars": {"dc": {"text": "East", "value": "east", "selected": true}}},
+		{"id": 2, "type": "graph", "scopedVars": {"dc": {"text": "East", "value": "east", "selected": true}}},
+		{"id": 3, "repeatPanelId": 1, "type": "row", "scopedVars": {"dc": {"text": "West", "value": "west", "selected": true}}},
+		{"id": 4, "repeatPanelId": 2, "type": "graph", "scopedVars": {"dc": {"text": "West", "value": "west", "selected": true}}}
+	]}`), &expected)
+	if !reflect.DeepEqual(dashboard, expected) {
+		out, _ := json.MarshalIndent(dashboard, "", "  ")
+		t.Errorf("Repeated rows without gridPos DeepEqual compare failed")
+		t.Logf("Actual:\n%s", out)
+	}
+}
+
+func TestFilterPanels(t *testing.T) {
+	// a grid layout dashboard, with an open and a collapsed row
+	dashboardJSON := `{
+		"templating": {"list": [{"name": "svc", "current": {"text": "api", "value": "api"}}]},
+		"panels": [
+			{"id": 1, "type": "graph", "title": "Overview"},
+			{"id": 2, "type": "row", "title": "SLOs"},
+			{"id": 3, "type": "graph", "title": "$svc availability"},
+			{"id": 4, "type": "graph", "title": "$svc latency"},
+			{"id": 5, "type": "row", "title": "Debug", "collapsed": true, "panels": [
+				{"id": 6, "type": "graph", "title": "Goroutines"}
+			]}
+		]
+	}`
+	// configs to test
+	filterTests := []struct {
+		purpose  string
+		filter   *PanelFilter
+		expected []float64 // the IDs of the panels left, rows included
+	}{
+		{
+			purpose:  "Include a row",
+			filter:   &PanelFilter{Include: []string{"slos"}},
+			expected: []float64{2, 3, 4},
+		},
+		{
+			purpose:  "Include by ID and interpolated title",
+			filter:   &PanelFilter{Include: []string{"1", "api lat*"}},
+			expected: []float64{1, 2, 4},
+		},
+		{
+			purpose:  "Exclude a collapsed row",
+			filter:   &PanelFilter{Exclude: []string{"Debug"}},
+			expected: []float64{1, 2, 3, 4},
+		},
+		{
+			purpose:  "Include a collapsed row's panel",
+			filter:   &PanelFilter{Include: []string{"6"}},
+			expected: []float64{5, 6},
+		},
+		{
+			purpose:  "Include and exclude",
+			filter:   &PanelFilter{Include: []string{"SLOs"}, Exclude: []string{"*availability"}},
+			expected: []float64{2, 4},
+		},
+		{
+			purpose:  "Nothing matches",
+			filter:   &PanelFilter{Include: []string{"missing"}},
+			expected: nil,
+		},
+	}
+	// test
+	for _, ft := range filterTests {
+		var dashboard map[string]interface{}
+		json.Unmarshal([]byte(dashboardJSON), &dashboard)
+		values := resolveVariables(dashboard, nil)
+		filterPanels(dashboard, values, ft.filter)
+		var ids []float64
+		for _, panel := range dashboardPanels(dashboard) {
+			ids = append(ids, panel["id"].(float64))
+		}
+		if !reflect.DeepEqual(ids, ft.expected) {
+			t.Errorf("Test \"%s\" expected panels %v, got %v", ft.purpose, ft.expected, ids)
+		}
+	}
+
+	// legacy rows are dropped when left empty
+	var dashboard map[string]interface{}
+	json.Unmarshal([]byte(`{"rows": [
+		{"title": "SLOs", "panels": [{"id": 1, "title": "Availability"}]},
+		{"title": "Debug", "panels": [{"id": 2, "title": "Goroutines"}]}
+	]}`), &dashboard)
+	if kept := filterPanels(dashboard, nil, &PanelFilter{Include: []string{"SLOs"}}); kept != 1 || len(dashboard["rows"].([]interface{})) != 1 {
+		t.Errorf("Legacy rows expected 1 panel in 1 row, got %d panels in %v", kept, dashboard["rows"])
+	}
+}
+
+func TestSetPanelLinks(t *testing.T) {
+	grafanaAddr, _ := url.Parse("https://grafana.example.com/grafana/")
+	panel := func() map[string]interface{} {
+		var p map[string]interface{}
+		json.Unmarshal([]byte(`{
+			"targets": [],
+			"links": [{"title": "Other", "url": "/d/abc/other?orgId=1"}, {"title": "Docs", "url": "https://docs.example.com/"}],
+			"fieldConfig": {"defaults": {"links": [{"title": "Explore", "url": "explore?left=x"}]}}
+		}`), &p)
+		return p
+	}
+	links := func(p map[string]interface{}) []string {
+		var urls []string
+		fieldConfig, _ := p["fieldConfig"].(map[string]interface{})
+		defaults, _ := fieldConfig["defaults"].(map[string]interface{})
+		for _, list := range []interface{}{p["links"], defaults["links"]} {
+			for _, l := range list.([]interface{}) {
+				urls = append(urls, l.(map[string]interface{})["url"].(string))
+			}
+		}
+		return urls
+	}
+	var linkTests = []struct {
+		purpose  string
+		mode     string
+		expected []string
+	}{
+		{
+			purpose:  "Dropped by default",
+			mode:     "",
+			expected: []string{"explore?left=x"},
+		},
+		{
+			purpose:  "Kept",
+			mode:     PanelLinksKeep,
+			expected: []string{"/d/abc/other?orgId=1", "https://docs.example.com/", "explore?left=x"},
+		},
+		{
+			purpose:  "Made absolute",
+			mode:     PanelLinksAbsolute,
+			expected: []string{"https://grafana.example.com/grafana/d/abc/other?orgId=1", "https://docs.example.com/", "https://grafana.example.com/grafana/explore?left=x"},
+		},
+	}
+	for _, lt := range linkTests {
+		p := panel()
+		setPanelLinks(p, lt.mode, grafanaAddr)
+		if out := links(p); !reflect.DeepEqual(out, lt.expected) {
+			t.Errorf("Test \"%s\" expected links %v, got %v", lt.purpose, lt.expected, out)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// pdfDocument writes a PDF of pages of text in the standard Helvetica fonts
+// and images, which is all a report needs, so no PDF library is needed
+type pdfDocument struct {
+	buf bytes.Buffer
+	// offsets are where each object starts, by object number - 1
+	offsets []int
+	pages   []int
+	catalog int
+	tree    int
+}
+
+// The fonts of a pdfDocument's pages
+const (
+	pdfFont     = "/F1"
+	pdfBoldFont = "/F2"
+)
+
+func newPDFDocument() *pdfDocument {
+	d := &pdfDocument{}
+	d.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	d.catalog = d.reserve()
+	d.tree = d.reserve()
+	d.object(d.catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", d.tree))
+	return d
+}
+
+// reserve returns the number of an object to be written later
+func (d *pdfDocument) reserve() int {
+	d.offsets = append(d.offsets, 0)
+	return len(d.offsets)
+}
+
+func (d *pdfDocument) object(num int, body string) {
+	d.offsets[num-1] = d.buf.Len()
+	fmt.Fprintf(&d.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func (d *pdfDocument) stream(num int, dict string, data []byte) {
+	d.offsets[num-1] = d.buf.Len()
+	fmt.Fprintf(&d.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", num, dict, len(data))
+	d.buf.Write(data)
+	d.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// image writes img as an image object, with any transparency drawn over
+// white, returning its object number
+func (d *pdfDocument) image(img image.Image) (int, error) {
+	bounds := img.Bounds()
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	row := make([]byte, 0, bounds.Dx()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			// colors are alpha premultiplied, so white shows through
+			// by what's transparent
+			white := 0xffff - a
+			row = append(row, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+		if _, err := zw.Write(row); err != nil {
+			return 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	num := d.reserve()
+	d.stream(num, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode", bounds.Dx(), bounds.Dy()), data.Bytes())
+	return num, nil
+}
+
+// page adds a page of width by height points drawn by content, which can
+// use the fonts and the images by their object numbers as /Im<number>
+func (d *pdfDocument) page(width, height float64, content string, images ...int) {
+	var xobjects strings.Builder
+	for _, img := range images {
+		fmt.Fprintf(&xobjects, " /Im%d %d 0 R", img, img)
+	}
+	contentNum := d.reserve()
+	d.stream(contentNum, "", []byte(content))
+	num := d.reserve()
+	d.object(num, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Contents %d 0 R /Resources << /Font << %s %s >> /XObject <<%s >> >> >>",
+		d.tree, pdfNumber(width), pdfNumber(height), contentNum,
+		pdfFont+" << /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		pdfBoldFont+" << /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		xobjects.String()))
+	d.pages = append(d.pages, num)
+}
+
+// bytes finishes the document, returning it
+func (d *pdfDocument) bytes() []byte {
+	kids := make([]string, len(d.pages))
+	for idx, page := range d.pages {
+		kids[idx] = fmt.Sprintf("%d 0 R", page)
+	}
+	d.object(d.tree, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	xref := d.buf.Len()
+	fmt.Fprintf(&d.buf, "xref\n0 %d\n0000000000 65535 f \n", len(d.offsets)+1)
+	for _, offset := range d.offsets {
+		fmt.Fprintf(&d.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&d.buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.offsets)+1, d.catalog, xref)
+	return d.buf.Bytes()
+}
+
+// pdfText returns the content drawing a line of text at x, y in font
+func pdfText(font string, size, x, y float64, text string) string {
+	return fmt.Sprintf("BT %s %s Tf %s %s Td %s Tj ET\n", font, pdfNumber(size), pdfNumber(x), pdfNumber(y), pdfString(text))
+}
+
+// pdfString quotes s as a PDF string. The standard fonts only have Latin-1
+// characters, so others are replaced with "?".
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ' || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfNumber formats n without an exponent, which PDF doesn't allow
+func pdfNumber(n float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", n), "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,103 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the rate of datasource queries a SnapClient makes through
+// Grafana, across all the snapshots it takes
+type RateLimit struct {
+	// QueriesPerSecond is the sustained rate of queries
+	QueriesPerSecond float64
+	// Burst is how many queries can be made at once after being idle.
+	// Defaults to 1.
+	Burst int
+}
+
+func processRateLimit(configIn *RateLimit) (*RateLimit, error) {
+	if configIn.QueriesPerSecond <= 0 {
+		return nil, errors.New("RateLimit field \"QueriesPerSecond\" must be positive")
+	}
+	if configIn.Burst < 0 {
+		return nil, errors.New("RateLimit field \"Burst\" cannot be negative")
+	}
+	configOut := *configIn
+	if configOut.Burst == 0 {
+		configOut.Burst = 1
+	}
+	return &configOut, nil
+}
+
+// limiter is a token bucket, refilled at rate tokens per second up to burst
+type limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(r *RateLimit) *limiter {
+	return &limiter{rate: r.QueriesPerSecond, burst: float64(r.Burst), tokens: float64(r.Burst), last: time.Now()}
+}
+
+// wait blocks until a token is available, or ctx is done
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// take the token now, waiting for it to be refilled if it's borrowed
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give back the unused token
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateLimitTransport waits for the limiter before each datasource query
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	limiter *limiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isDatasourceQuery(req) {
+		if err := t.limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// isDatasourceQuery reports whether a request queries a datasource, through
+// the datasource proxy or the tsdb query API, or directly
+func isDatasourceQuery(req *http.Request) bool {
+	if direct, _ := req.Context().Value(directQueryKey{}).(bool); direct {
+		return true
+	}
+	return strings.Contains(req.URL.Path, "/api/datasources/proxy/") || strings.HasSuffix(req.URL.Path, "/api/tsdb/query") || strings.HasSuffix(req.URL.Path, "/api/ds/query")
+}
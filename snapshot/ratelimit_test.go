@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	rateTests := []struct {
+		purpose  string
+		path     string
+		requests int
+		minimum  time.Duration // the least time the requests should take
+		maximum  time.Duration // the most time the requests should take
+	}{
+		{
+			purpose:  "Burst isn't limited",
+			path:     "api/datasources/proxy/1/api/v1/query",
+			requests: 2,
+			maximum:  40 * time.Millisecond,
+		},
+		{
+			purpose:  "Queries after the burst are limited",
+			path:     "api/datasources/proxy/1/api/v1/query",
+			requests: 6,
+			minimum:  200 * time.Millisecond,
+			maximum:  400 * time.Millisecond,
+		},
+		{
+			purpose:  "Tsdb queries are limited",
+			path:     "api/tsdb/query",
+			requests: 6,
+			minimum:  200 * time.Millisecond,
+			maximum:  400 * time.Millisecond,
+		},
+		{
+			purpose:  "Other requests aren't limited",
+			path:     "api/search",
+			requests: 6,
+			maximum:  40 * time.Millisecond,
+		},
+	}
+	// test
+	for _, rt := range rateTests {
+		sc, err := NewSnapClient(&Config{GrafanaAddr: serverURL, GrafanaAPIKey: "XXXXX", RateLimit: &RateLimit{QueriesPerSecond: 20, Burst: 2}})
+		if err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", rt.purpose, err.Error())
+		}
+		start := time.Now()
+		for idx := 0; idx < rt.requests; idx++ {
+			if _, err = sc.grafanaGet(context.Background(), rt.path, nil); err != nil {
+				t.Errorf("Test \"%s\" unexpectedly failed: %s", rt.purpose, err.Error())
+			}
+		}
+		if elapsed := time.Since(start); elapsed < rt.minimum || elapsed > rt.maximum {
+			t.Errorf("Test \"%s\" expected the requests to take %s to %s, took %s", rt.purpose, rt.minimum, rt.maximum, elapsed)
+		}
+	}
+
+	// waiting is cancelled with the request
+	l := newLimiter(&RateLimit{QueriesPerSecond: 1, Burst: 1})
+	l.wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); err == nil {
+		t.Errorf("Cancelled wait unexpectedly passed")
+	}
+}
@@ -0,0 +1,138 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RenderConfig for rendering a snapshot on the snapshot host to a PNG image,
+// with Grafana's image renderer
+type RenderConfig struct {
+	// PanelID, if set, renders only that panel, rather than the whole
+	// dashboard
+	PanelID int
+	// Width and Height are the size of the image in pixels. They default to
+	// 1000x500 for a panel, and 1920 wide for a dashboard, which is as tall
+	// as its panels need.
+	Width  int
+	Height int
+	// Theme is "light" or "dark". Defaults to Grafana's default theme.
+	Theme string
+	// Timeout limits rendering the image, which can be slow. Defaults to
+	// 60s, and negative disables the limit.
+	Timeout time.Duration
+}
+
+// The default size of rendered panels and dashboards
+const (
+	defaultPanelWidth      = 1000
+	defaultPanelHeight     = 500
+	defaultDashboardWidth  = 1920
+	defaultDashboardHeight = -1
+	defaultRenderTimeout   = 60 * time.Second
+)
+
+func processRenderConfig(configIn *RenderConfig) (*RenderConfig, error) {
+	configOut := &RenderConfig{}
+
+	if configIn.PanelID < 0 {
+		return nil, errors.New("RenderConfig field \"PanelID\" cannot be negative")
+	}
+	configOut.PanelID = configIn.PanelID
+	if configIn.Width < 0 || configIn.Height < 0 {
+		return nil, errors.New("RenderConfig fields \"Width\" and \"Height\" cannot be negative")
+	}
+	configOut.Width, configOut.Height = configIn.Width, configIn.Height
+	if configOut.PanelID > 0 {
+		configOut.Width = defaultInt(configOut.Width, defaultPanelWidth)
+		configOut.Height = defaultInt(configOut.Height, defaultPanelHeight)
+	} else {
+		configOut.Width = defaultInt(configOut.Width, defaultDashboardWidth)
+		configOut.Height = defaultInt(configOut.Height, defaultDashboardHeight)
+	}
+	switch configIn.Theme {
+	case "", "light", "dark":
+		configOut.Theme = configIn.Theme
+	default:
+		return nil, fmt.Errorf("Unknown RenderConfig \"Theme\" %q, expected light or dark", configIn.Theme)
+	}
+	configOut.Timeout = defaultDuration(configIn.Timeout, defaultRenderTimeout)
+	return configOut, nil
+}
+
+func defaultInt(value, def int) int {
+	if value == 0 {
+		return def
+	}
+	return value
+}
+
+// Render is for rendering a snapshot on the snapshot host to a PNG image, by
+// its key. The snapshot host must be Grafana with an image renderer.
+func (sc *SnapClient) Render(key string, config *RenderConfig) ([]byte, error) {
+	return sc.RenderWithContext(context.Background(), key, config)
+}
+
+// RenderWithContext is for rendering a snapshot, using ctx for the request
+// to the snapshot host
+func (sc *SnapClient) RenderWithContext(ctx context.Context, key string, config *RenderConfig) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("Missing snapshot key")
+	}
+	c, err := processRenderConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	reqURL := *sc.config.SnapshotAddr
+	query := url.Values{}
+	if c.PanelID > 0 {
+		reqURL.Path = reqURL.Path + "render/dashboard-solo/snapshot/" + key
+		query.Set("panelId", strconv.Itoa(c.PanelID))
+	} else {
+		reqURL.Path = reqURL.Path + "render/dashboard/snapshot/" + key
+	}
+	query.Set("width", strconv.Itoa(c.Width))
+	query.Set("height", strconv.Itoa(c.Height))
+	if len(c.Theme) > 0 {
+		query.Set("theme", c.Theme)
+	}
+	reqURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if req, err = sc.snapshotAuth(req); err != nil {
+		return nil, err
+	}
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = unauthorized("The snapshot host", resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code when rendering snapshot: %s", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "image/png") {
+		return nil, fmt.Errorf("Expected a PNG image when rendering snapshot, got %q; is the image renderer installed?", contentType)
+	}
+	return body, nil
+}
@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	var requested string
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path + "?" + r.URL.RawQuery
+		if r.Header.Get("Authorization") != "Bearer XXXXX" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("theme") == "dark" {
+			// as Grafana without an image renderer responds
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("PNG"))
+	}))
+	defer host.Close()
+	hostURL, _ := url.Parse(host.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: hostURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	renderTests := []struct {
+		purpose  string
+		key      string
+		in       *RenderConfig
+		valid    bool
+		expected string
+	}{
+		{
+			purpose:  "Dashboard",
+			key:      "abc",
+			in:       &RenderConfig{},
+			valid:    true,
+			expected: "/render/dashboard/snapshot/abc?height=-1&width=1920",
+		},
+		{
+			purpose:  "Panel",
+			key:      "abc",
+			in:       &RenderConfig{PanelID: 4, Theme: "light"},
+			valid:    true,
+			expected: "/render/dashboard-solo/snapshot/abc?height=500&panelId=4&theme=light&width=1000",
+		},
+		{
+			purpose:  "No image renderer",
+			key:      "abc",
+			in:       &RenderConfig{Theme: "dark"},
+			valid:    false,
+			expected: "/render/dashboard/snapshot/abc?height=-1&theme=dark&width=1920",
+		},
+		{
+			purpose: "Unknown theme",
+			key:     "abc",
+			in:      &RenderConfig{Theme: "blue"},
+			valid:   false,
+		},
+		{
+			purpose: "Missing key",
+			in:      &RenderConfig{},
+			valid:   false,
+		},
+	}
+	// test
+	for _, rt := range renderTests {
+		requested = ""
+		image, err := sc.Render(rt.key, rt.in)
+		if rt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", rt.purpose, err.Error())
+		} else if !rt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", rt.purpose)
+		} else if rt.valid && string(image) != "PNG" {
+			t.Errorf("Test \"%s\" expected the image, got %q", rt.purpose, image)
+		}
+		if requested != rt.expected {
+			t.Errorf("Test \"%s\" expected request %q, got %q", rt.purpose, rt.expected, requested)
+		}
+	}
+}
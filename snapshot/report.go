@@ -0,0 +1,159 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"sort"
+	"strings"
+)
+
+// The layout of report pages, in points: A4 landscape with a half inch margin
+const (
+	reportWidth      = 842
+	reportHeight     = 595
+	reportMargin     = 36
+	reportTitleSize  = 16
+	reportTextSize   = 10
+	reportLineHeight = 14
+	// roughly how many characters of Helvetica fit across a page
+	reportLineChars = 140
+)
+
+// Report is for exporting a snapshot as a PDF report, with a page for each
+// panel headed by the dashboard's title, time range and variable values. Each
+// panel is rendered on the snapshot host, like Render, with config's size and
+// theme.
+func (sc *SnapClient) Report(snap *Snapshot, config *RenderConfig) ([]byte, error) {
+	return sc.ReportWithContext(context.Background(), snap, config)
+}
+
+// ReportWithContext is for exporting a snapshot as a PDF report, using ctx for
+// the requests to the snapshot host
+func (sc *SnapClient) ReportWithContext(ctx context.Context, snap *Snapshot, config *RenderConfig) ([]byte, error) {
+	if len(snap.PanelIDs) == 0 {
+		return nil, errors.New("Snapshot has no panels to report")
+	}
+	header := reportHeader(snap)
+	doc := newPDFDocument()
+	for _, panelID := range snap.PanelIDs {
+		render := RenderConfig{}
+		if config != nil {
+			render = *config
+		}
+		render.PanelID = panelID
+		b, err := sc.RenderWithContext(ctx, snap.Key, &render)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to render panel %d: %w", panelID, err)
+		}
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode image of panel %d: %s", panelID, err.Error())
+		}
+		imgNum, err := doc.image(img)
+		if err != nil {
+			return nil, err
+		}
+
+		// the header, then the panel's title and row above its image,
+		// scaled down to fit the rest of the page
+		var content strings.Builder
+		y := float64(reportHeight - reportMargin - reportTitleSize)
+		content.WriteString(pdfText(pdfBoldFont, reportTitleSize, reportMargin, y, snap.Title))
+		y -= reportLineHeight
+		for _, line := range header {
+			y -= reportLineHeight
+			content.WriteString(pdfText(pdfFont, reportTextSize, reportMargin, y, line))
+		}
+		section := snap.PanelTitles[panelID]
+		if row := snap.PanelRows[panelID]; len(row) > 0 {
+			section = row + " / " + section
+		}
+		y -= 2 * reportLineHeight
+		content.WriteString(pdfText(pdfBoldFont, reportTextSize+2, reportMargin, y, section))
+		y -= reportLineHeight / 2
+
+		bounds := img.Bounds()
+		w, h := float64(bounds.Dx()), float64(bounds.Dy())
+		scale := 1.0
+		if maxW := float64(reportWidth - 2*reportMargin); w*scale > maxW {
+			scale = maxW / w
+		}
+		if maxH := y - reportMargin; h*scale > maxH {
+			scale = maxH / h
+		}
+		w, h = w*scale, h*scale
+		fmt.Fprintf(&content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", pdfNumber(w), pdfNumber(h), pdfNumber(reportMargin), pdfNumber(y-h), imgNum)
+		doc.page(reportWidth, reportHeight, content.String(), imgNum)
+	}
+	return doc.bytes(), nil
+}
+
+// reportHeader returns the lines under a report's title: its time range, and
+// its variables' values wrapped to fit the page
+func reportHeader(snap *Snapshot) []string {
+	const layout = "2006-01-02 15:04:05 MST"
+	lines := []string{snap.From.Format(layout) + " to " + snap.To.Format(layout)}
+
+	names := make([]string, 0, len(snap.Vars))
+	for name := range snap.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	line := ""
+	for _, name := range names {
+		v := name + "=" + snap.Vars[name]
+		if len(line) > 0 && len(line)+len(v)+2 > reportLineChars {
+			lines = append(lines, line)
+			line = ""
+		}
+		if len(line) > 0 {
+			line += ", "
+		}
+		line += v
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// setPanels sets the IDs and titles of the dashboard's panels, other than
+// rows, and the titles of the rows they're in
+func (s *Snapshot) setPanels(dashboard map[string]interface{}) {
+	s.PanelTitles = make(map[int]string)
+	s.PanelRows = make(map[int]string)
+	add := func(panel map[string]interface{}, row string) {
+		id, ok := panel["id"].(float64)
+		if !ok {
+			return
+		}
+		s.PanelIDs = append(s.PanelIDs, int(id))
+		s.PanelTitles[int(id)], _ = panel["title"].(string)
+		if len(row) > 0 {
+			s.PanelRows[int(id)] = row
+		}
+	}
+
+	// legacy rows hold their panels, while the panels of expanded grid
+	// layout rows follow them
+	for _, row := range panelList(dashboard["rows"]) {
+		title, _ := row["title"].(string)
+		for _, panel := range panelList(row["panels"]) {
+			add(panel, title)
+		}
+	}
+	row := ""
+	for _, panel := range panelList(dashboard["panels"]) {
+		if panel["type"] != "row" {
+			add(panel, row)
+			continue
+		}
+		row, _ = panel["title"].(string)
+		for _, nested := range panelList(panel["panels"]) {
+			add(nested, row)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var encoded bytes.Buffer
+	png.Encode(&encoded, img)
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("panelId") == "9" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(encoded.Bytes())
+	}))
+	defer host.Close()
+	hostURL, _ := url.Parse(host.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: hostURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	from := time.Date(2017, time.February, 5, 6, 0, 0, 0, time.UTC)
+	snap := &Snapshot{
+		Key:         "abc",
+		Title:       "Weekly (report)",
+		From:        from,
+		To:          from.Add(7 * 24 * time.Hour),
+		Vars:        map[string]string{"env": "prod", "region": "eu-west-1,eu-west-2"},
+		PanelIDs:    []int{2, 3},
+		PanelTitles: map[int]string{2: "Requests", 3: "Usage"},
+		PanelRows:   map[int]string{3: "Disk"},
+	}
+	report, err := sc.Report(snap, &RenderConfig{})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	pdf := string(report)
+	if !strings.HasPrefix(pdf, "%PDF-") {
+		t.Errorf("Expected a PDF, got %q", pdf[:10])
+	}
+	if pages := strings.Count(pdf, "/Type /Page "); pages != 2 {
+		t.Errorf("Expected 2 pages, got %d", pages)
+	}
+	for _, text := range []string{"(Weekly \\(report\\))", "(2017-02-05 06:00:00 UTC to 2017-02-12 06:00:00 UTC)", "(env=prod, region=eu-west-1,eu-west-2)", "(Requests)", "(Disk / Usage)"} {
+		if !strings.Contains(pdf, text) {
+			t.Errorf("Expected report to contain %s", text)
+		}
+	}
+	// the cross reference table must point at the objects
+	xref, _ := strconv.Atoi(strings.Fields(pdf[strings.LastIndex(pdf, "startxref"):])[1])
+	if !strings.HasPrefix(pdf[xref:], "xref\n") {
+		t.Errorf("Expected startxref to point at the cross reference table")
+	}
+	for idx, entry := range strings.Split(pdf[xref:], "\n")[3:] {
+		if !strings.HasSuffix(entry, " n ") {
+			break
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		if obj := strconv.Itoa(idx+1) + " 0 obj"; !strings.HasPrefix(pdf[offset:], obj) {
+			t.Errorf("Expected offset %d to be %q", offset, obj)
+		}
+	}
+
+	snap.PanelIDs = append(snap.PanelIDs, 9)
+	if _, err = sc.Report(snap, &RenderConfig{}); err == nil {
+		t.Errorf("Expected a panel failing to render to fail the report")
+	}
+}
+
+func TestSetPanels(t *testing.T) {
+	panelTests := []struct {
+		purpose   string
+		dashboard string
+		ids       []int
+		rows      map[int]string
+	}{
+		{
+			purpose:   "Grid layout",
+			dashboard: `{"panels": [{"id": 1, "title": "A"}, {"id": 2, "type": "row", "title": "Expanded"}, {"id": 3, "title": "B"}, {"id": 4, "type": "row", "title": "Collapsed", "panels": [{"id": 5, "title": "C"}]}]}`,
+			ids:       []int{1, 3, 5},
+			rows:      map[int]string{3: "Expanded", 5: "Collapsed"},
+		},
+		{
+			purpose:   "Legacy rows",
+			dashboard: `{"rows": [{"title": "First", "panels": [{"id": 1, "title": "A"}]}, {"panels": [{"id": 2, "title": "B"}]}]}`,
+			ids:       []int{1, 2},
+			rows:      map[int]string{1: "First"},
+		},
+	}
+	// test
+	for _, pt := range panelTests {
+		var dashboard map[string]interface{}
+		json.Unmarshal([]byte(pt.dashboard), &dashboard)
+		s := &Snapshot{}
+		s.setPanels(dashboard)
+		if !reflect.DeepEqual(s.PanelIDs, pt.ids) {
+			t.Errorf("Test \"%s\" expected panels %v, got %v", pt.purpose, pt.ids, s.PanelIDs)
+		}
+		if !reflect.DeepEqual(s.PanelRows, pt.rows) {
+			t.Errorf("Test \"%s\" expected rows %v, got %v", pt.purpose, pt.rows, s.PanelRows)
+		}
+	}
+}
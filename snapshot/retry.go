@@ -0,0 +1,137 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryConfig configures retrying GET requests, and snapshot posts, which fail
+// with a network error or a retryable status code, e.g. while Grafana or a
+// datasource proxy is being deployed
+type RetryConfig struct {
+	// Attempts is the most times a request is made, including the first.
+	// Defaults to 3.
+	Attempts int
+	// Backoff is the delay before the first retry, which doubles for each
+	// retry after it. Defaults to 1s.
+	Backoff time.Duration
+	// MaxBackoff is the longest delay between attempts. Defaults to 30s.
+	MaxBackoff time.Duration
+	// StatusCodes are the response status codes which are retried.
+	// Defaults to 502, 503 and 504.
+	StatusCodes []int
+}
+
+func processRetryConfig(configIn *RetryConfig) (*RetryConfig, error) {
+	configOut := &RetryConfig{}
+
+	// Attempts
+	if configIn.Attempts < 0 {
+		return nil, errors.New("RetryConfig field \"Attempts\" cannot be negative")
+	} else if configIn.Attempts == 0 {
+		configOut.Attempts = 3
+	} else {
+		configOut.Attempts = configIn.Attempts
+	}
+	// Backoff
+	if configIn.Backoff < 0 || configIn.MaxBackoff < 0 {
+		return nil, errors.New("RetryConfig fields \"Backoff\" and \"MaxBackoff\" cannot be negative")
+	}
+	configOut.Backoff = configIn.Backoff
+	if configOut.Backoff == 0 {
+		configOut.Backoff = time.Second
+	}
+	configOut.MaxBackoff = configIn.MaxBackoff
+	if configOut.MaxBackoff == 0 {
+		configOut.MaxBackoff = 30 * time.Second
+	}
+	// StatusCodes
+	configOut.StatusCodes = configIn.StatusCodes
+	if len(configOut.StatusCodes) == 0 {
+		configOut.StatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	return configOut, nil
+}
+
+type retryKey struct{}
+
+// contextWithRetry marks requests made with ctx as safe to retry, for
+// requests other than GETs
+func contextWithRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, true)
+}
+
+// retryTransport retries requests as configured by a RetryConfig
+type retryTransport struct {
+	next   http.RoundTripper
+	config *RetryConfig
+	logger Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable, _ := req.Context().Value(retryKey{}).(bool)
+	if !strings.EqualFold(req.Method, "GET") && !retryable {
+		return t.next.RoundTrip(req)
+	}
+	// the body is sent again with each attempt
+	if req.Body != nil && req.GetBody == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	backoff := t.config.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt == t.config.Attempts || !t.shouldRetry(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		delay := backoff
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && after > 0 {
+				delay = time.Duration(after) * time.Second
+			}
+			resp.Body.Close()
+		}
+		if delay > t.config.MaxBackoff {
+			delay = t.config.MaxBackoff
+		}
+		t.logger.Warn("Retrying request", "method", req.Method, "url", req.URL.String(), "attempt", attempt, "reason", reason, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.WithContext(req.Context())
+			req.Body = body
+		}
+		backoff *= 2
+	}
+}
+
+func (t *retryTransport) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	for _, code := range t.config.StatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
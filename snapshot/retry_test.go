@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	retryTests := []struct {
+		purpose  string
+		method   string
+		retry    bool // mark the request as retryable
+		failures int  // responses failing with 503 before success
+		expected int  // expected status code
+		requests int  // expected number of requests made
+	}{
+		{
+			purpose:  "GET succeeds after retries",
+			method:   "GET",
+			failures: 2,
+			expected: http.StatusOK,
+			requests: 3,
+		},
+		{
+			purpose:  "GET gives up after attempts",
+			method:   "GET",
+			failures: 5,
+			expected: http.StatusServiceUnavailable,
+			requests: 3,
+		},
+		{
+			purpose:  "POST isn't retried",
+			method:   "POST",
+			failures: 1,
+			expected: http.StatusServiceUnavailable,
+			requests: 1,
+		},
+		{
+			purpose:  "Retryable POST is retried with its body",
+			method:   "POST",
+			retry:    true,
+			failures: 1,
+			expected: http.StatusOK,
+			requests: 2,
+		},
+	}
+	// test
+	for _, tt := range retryTests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if body, _ := ioutil.ReadAll(r.Body); r.Method == "POST" && string(body) != "body" {
+				t.Errorf("Test \"%s\" request %d had body %q", tt.purpose, requests, body)
+			}
+			if requests <= tt.failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		retry, _ := processRetryConfig(&RetryConfig{Backoff: time.Millisecond})
+		sc := &SnapClient{config: &Config{Retry: retry, Logger: nopLogger{}}}
+
+		req, _ := http.NewRequest(tt.method, server.URL, nil)
+		if tt.method == "POST" {
+			req, _ = http.NewRequest(tt.method, server.URL, strings.NewReader("body"))
+		}
+		if tt.retry {
+			req = req.WithContext(contextWithRetry(context.Background()))
+		}
+		resp, err := sc.httpClient().Do(req)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Test \"%s\" expected status %d, got %d", tt.purpose, tt.expected, resp.StatusCode)
+			}
+		}
+		if requests != tt.requests {
+			t.Errorf("Test \"%s\" expected %d requests, got %d", tt.purpose, tt.requests, requests)
+		}
+		server.Close()
+	}
+}
+
+func TestRetryGrafanaAPI(t *testing.T) {
+	requests := map[string]int{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if requests[r.URL.Path] == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/dashboards/uid/abc":
+			w.Write([]byte(`{"dashboard": {"uid": "abc"}}`))
+		case "/api/datasources":
+			w.Write([]byte(`[{"name": "prom", "type": "prometheus"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{
+		GrafanaAddr:    grafanaURL,
+		GrafanaAPIKey:  "XXXXX",
+		GrafanaVersion: "9.1.0",
+		Retry:          &RetryConfig{Backoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	// the dashboard and datasources are got again after a 503
+	if _, err = sc.getDashboardDef(context.Background(), &TakeConfig{DashUID: "abc"}); err != nil {
+		t.Errorf("Expected the dashboard to be retried, got %s", err.Error())
+	}
+	if _, err = sc.getDatasourceDefs(context.Background()); err != nil {
+		t.Errorf("Expected the datasources to be retried, got %s", err.Error())
+	}
+	for _, path := range []string{"/api/dashboards/uid/abc", "/api/datasources"} {
+		if requests[path] != 2 {
+			t.Errorf("Expected %q to be requested twice, got %d", path, requests[path])
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// S3Config configures uploading snapshot artifacts, such as the snapshot's
+// JSON and its rendered images, to an S3 bucket for keeping longer than the
+// snapshot host does
+type S3Config struct {
+	Bucket string
+	// Region is the bucket's region. Defaults to "us-east-1".
+	Region string
+	// Endpoint, if set, is an S3 compatible service to use instead of AWS,
+	// e.g. MinIO, whose buckets are addressed by path
+	Endpoint *url.URL
+	// AccessKeyID and SecretAccessKey are the credentials to sign requests
+	// with, and SessionToken is set for temporary credentials
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// KeyTemplate is a text/template for the object key of each file,
+	// evaluated against StorageKeyData. Defaults to
+	// DefaultStorageKeyTemplate.
+	KeyTemplate string
+	// SSE is the server side encryption of the objects: "AES256", or
+	// "aws:kms" with the SSEKMSKeyID key, or the bucket's key if it's not
+	// set. Defaults to the bucket's default encryption.
+	SSE         string
+	SSEKMSKeyID string
+	// TLS, if set, configures the TLS connections to the endpoint
+	TLS *TLSConfig
+}
+
+// S3ConfigFromEnv builds an S3Config for bucket from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION (or
+// AWS_DEFAULT_REGION) and AWS_ENDPOINT_URL environment variables
+func S3ConfigFromEnv(bucket string) (*S3Config, error) {
+	config := &S3Config{
+		Bucket:          bucket,
+		Region:          os.Getenv("AWS_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if len(config.Region) == 0 {
+		config.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); len(endpoint) > 0 {
+		eURL, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid AWS_ENDPOINT_URL: %s", err.Error())
+		}
+		config.Endpoint = eURL
+	}
+	return config, nil
+}
+
+func processS3Config(configIn *S3Config) (*S3Config, error) {
+	configOut := *configIn
+
+	if len(configIn.Bucket) == 0 {
+		return nil, errors.New("Missing required S3Config field: \"Bucket\"")
+	}
+	if len(configOut.Region) == 0 {
+		configOut.Region = "us-east-1"
+	}
+	if len(configIn.AccessKeyID) == 0 || len(configIn.SecretAccessKey) == 0 {
+		return nil, errors.New("Missing required S3Config fields: \"AccessKeyID\" and \"SecretAccessKey\"")
+	}
+	if len(configOut.KeyTemplate) == 0 {
+		configOut.KeyTemplate = DefaultStorageKeyTemplate
+	}
+	switch configIn.SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		return nil, fmt.Errorf("Unknown S3Config \"SSE\" %q, expected AES256 or aws:kms", configIn.SSE)
+	}
+	if len(configIn.SSEKMSKeyID) > 0 && configIn.SSE != "aws:kms" {
+		return nil, errors.New("S3Config field \"SSEKMSKeyID\" requires \"SSE\" to be aws:kms")
+	}
+	if configIn.TLS != nil {
+		tlsConfig, err := processTLSConfig(configIn.TLS)
+		if err != nil {
+			return nil, err
+		}
+		configOut.TLS = tlsConfig
+	}
+	return &configOut, nil
+}
+
+// S3 is a Storage uploading snapshot artifacts to an S3 bucket
+type S3 struct {
+	config      *S3Config
+	client      *http.Client
+	keyTemplate *template.Template
+}
+
+// NewS3 creates an S3 for uploading to the configured bucket
+func NewS3(config *S3Config) (*S3, error) {
+	c, err := processS3Config(config)
+	if err != nil {
+		return nil, err
+	}
+	keyTemplate, err := parseKeyTemplate("S3Config KeyTemplate", c.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	if c.TLS != nil {
+		tlsConfig, err := c.TLS.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	return &S3{config: c, client: client, keyTemplate: keyTemplate}, nil
+}
+
+// Upload uploads body as the object keyed by the KeyTemplate evaluated
+// against data, returning its s3:// URL
+func (s *S3) Upload(ctx context.Context, data *StorageKeyData, body []byte, contentType string) (string, error) {
+	objectKey, err := storageKey(s.keyTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	// AWS buckets are addressed by host name, others by path
+	var reqURL url.URL
+	if s.config.Endpoint != nil {
+		reqURL = *s.config.Endpoint
+		reqURL.Path = strings.TrimSuffix(reqURL.Path, "/") + "/" + s.config.Bucket + "/" + objectKey
+	} else {
+		reqURL = url.URL{Scheme: "https", Host: s.config.Bucket + ".s3." + s.config.Region + ".amazonaws.com", Path: "/" + objectKey}
+	}
+	reqURL.RawPath = s3EscapePath(reqURL.Path)
+
+	req, err := http.NewRequest("PUT", reqURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(s.config.SSE) > 0 {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.config.SSE)
+	}
+	if len(s.config.SSEKMSKeyID) > 0 {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.config.SSEKMSKeyID)
+	}
+	if len(s.config.SessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	s.sign(req, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// S3 describes errors in XML
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		if xml.Unmarshal(respBody, &s3Err) == nil && len(s3Err.Code) > 0 {
+			return "", uploadFailed(fmt.Errorf("Failed to upload %q to S3: %s: %s", objectKey, s3Err.Code, s3Err.Message))
+		}
+		return "", uploadFailed(fmt.Errorf("Failed to upload %q to S3: %s", objectKey, resp.Status))
+	}
+	return "s3://" + s.config.Bucket + "/" + objectKey, nil
+}
+
+// sign signs req with AWS Signature Version 4, covering its host and headers.
+// The X-Amz-Content-Sha256 header must already be set to its payload's hash.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := now.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.config.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), s.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath escapes an object path as S3 signs it: everything but
+// unreserved characters and "/"
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
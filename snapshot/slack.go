@@ -0,0 +1,199 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SlackConfig configures posting the result of each snapshot to Slack, either
+// with an incoming webhook or as a bot to a channel
+type SlackConfig struct {
+	WebhookURL *url.URL
+	// Token is a bot token with the chat:write scope, and files:write to
+	// post thumbnails, for posting to the Channel
+	Token   string
+	Channel string
+	// Thumbnail posts the snapshot's first image, if it was rendered, with
+	// the message. Incoming webhooks can't post files, so it needs a Token.
+	Thumbnail bool
+	// APIAddr, if set, is used instead of https://slack.com/api/
+	APIAddr *url.URL
+}
+
+// SlackConfigFromEnv builds a SlackConfig for channel from the
+// SLACK_WEBHOOK_URL and SLACK_BOT_TOKEN environment variables
+func SlackConfigFromEnv(channel string) (*SlackConfig, error) {
+	config := &SlackConfig{
+		Token:   os.Getenv("SLACK_BOT_TOKEN"),
+		Channel: channel,
+	}
+	if webhookURL := os.Getenv("SLACK_WEBHOOK_URL"); len(webhookURL) > 0 {
+		parsed, err := url.Parse(webhookURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SLACK_WEBHOOK_URL: %s", err.Error())
+		}
+		config.WebhookURL = parsed
+	}
+	return config, nil
+}
+
+func processSlackConfig(configIn *SlackConfig) (*SlackConfig, error) {
+	configOut := *configIn
+
+	if configIn.WebhookURL == nil && len(configIn.Token) == 0 {
+		return nil, errors.New("Missing required SlackConfig field: \"WebhookURL\" or \"Token\"")
+	}
+	if len(configIn.Token) > 0 && len(configIn.Channel) == 0 {
+		return nil, errors.New("Missing required SlackConfig field: \"Channel\"")
+	}
+	if configIn.Thumbnail && len(configIn.Token) == 0 {
+		return nil, errors.New("SlackConfig field \"Thumbnail\" requires a \"Token\"")
+	}
+	if configOut.APIAddr == nil {
+		configOut.APIAddr = &url.URL{Scheme: "https", Host: "slack.com", Path: "/api/"}
+	}
+	return &configOut, nil
+}
+
+// Slack is a Notifier posting each Notification to Slack
+type Slack struct {
+	config *SlackConfig
+	client *http.Client
+}
+
+// NewSlack creates a Slack posting to the configured webhook or channel
+func NewSlack(config *SlackConfig) (*Slack, error) {
+	c, err := processSlackConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Slack{config: c, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+// Notify posts a message linking to the snapshot, or saying why it failed
+func (s *Slack) Notify(ctx context.Context, n *Notification) error {
+	text := slackMessage(n)
+	if s.config.Thumbnail && n.Status == NotifySuccess && len(n.Images) > 0 {
+		return s.postFile(ctx, n.Images[0], text)
+	}
+	if len(s.config.Token) == 0 {
+		body, _ := json.Marshal(map[string]string{"text": text})
+		req, err := http.NewRequest("POST", s.config.WebhookURL.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("Failed to post to Slack: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("Failed to post to Slack: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+		}
+		return nil
+	}
+	return s.call(ctx, "chat.postMessage", url.Values{"channel": {s.config.Channel}, "text": {text}}, nil)
+}
+
+// slackMessage describes a notification in Slack's mrkdwn
+func slackMessage(n *Notification) string {
+	name := n.Title
+	if len(name) == 0 {
+		name = n.Dashboard
+	}
+	if len(n.Org) > 0 {
+		name = n.Org + "/" + name
+	}
+	timeRange := n.From.Format("2006-01-02 15:04 MST") + " to " + n.To.Format("2006-01-02 15:04 MST")
+	if n.Status != NotifySuccess {
+		return fmt.Sprintf(":x: Failed to snapshot *%s* (%s): %s", slackEscape(name), timeRange, slackEscape(n.Error))
+	}
+	if len(n.URL) > 0 {
+		return fmt.Sprintf("Snapshot of <%s|%s> (%s)", n.URL, slackEscape(name), timeRange)
+	}
+	return fmt.Sprintf("Snapshot of *%s* (%s)", slackEscape(name), timeRange)
+}
+
+// slackEscape escapes the characters Slack treats as markup
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// postFile uploads an image to the channel, with the message as its comment
+func (s *Slack) postFile(ctx context.Context, path, text string) error {
+	image, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var upload struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	form := url.Values{"filename": {filepath.Base(path)}, "length": {strconv.Itoa(len(image))}}
+	if err = s.call(ctx, "files.getUploadURLExternal", form, &upload); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", upload.UploadURL, bytes.NewReader(image))
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Failed to upload image to Slack: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to upload image to Slack: %s", resp.Status)
+	}
+	files, _ := json.Marshal([]map[string]string{{"id": upload.FileID, "title": filepath.Base(path)}})
+	form = url.Values{"files": {string(files)}, "channel_id": {s.config.Channel}, "initial_comment": {text}}
+	return s.call(ctx, "files.completeUploadExternal", form, nil)
+}
+
+// call calls a Slack Web API method as the bot, decoding its response into
+// result if it's not nil
+func (s *Slack) call(ctx context.Context, method string, form url.Values, result interface{}) error {
+	req, err := http.NewRequest("POST", s.config.APIAddr.String()+method, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.config.Token)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Failed to call Slack %s: %s", method, err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to call Slack %s: %s", method, resp.Status)
+	}
+	// Slack answers errors with a 200 and "ok" false
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err = json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("Could not decode Slack %s response: %s", method, err.Error())
+	}
+	if !status.OK {
+		return fmt.Errorf("Failed to call Slack %s: %s", method, status.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(body, result)
+	}
+	return nil
+}
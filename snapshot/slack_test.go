@@ -0,0 +1,127 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackNotify(t *testing.T) {
+	var posted []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hook" {
+			body, _ := ioutil.ReadAll(r.Body)
+			posted = append(posted, "hook "+string(body))
+			w.Write([]byte("ok"))
+			return
+		}
+		if r.URL.Path == "/upload/F1" {
+			body, _ := ioutil.ReadAll(r.Body)
+			posted = append(posted, "upload "+string(body))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer xoxb-token" {
+			w.Write([]byte(`{"ok": false, "error": "invalid_auth"}`))
+			return
+		}
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/api/chat.postMessage":
+			posted = append(posted, "message "+r.FormValue("channel")+" "+r.FormValue("text"))
+		case "/api/files.getUploadURLExternal":
+			w.Write([]byte(`{"ok": true, "upload_url": "http://` + r.Host + `/upload/F1", "file_id": "F1"}`))
+			return
+		case "/api/files.completeUploadExternal":
+			posted = append(posted, "file "+r.FormValue("channel_id")+" "+r.FormValue("files")+" "+r.FormValue("initial_comment"))
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer slack.Close()
+	hookURL, _ := url.Parse(slack.URL + "/hook")
+	apiAddr, _ := url.Parse(slack.URL + "/api/")
+
+	dir, err := ioutil.TempDir("", "snapshot-slack")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "my-dash.png")
+	ioutil.WriteFile(image, []byte("png"), 0644)
+
+	from := time.Date(2017, time.February, 5, 6, 0, 0, 0, time.UTC)
+	success := &Notification{Status: NotifySuccess, Dashboard: "my-dash", Title: "My <Dash>", URL: "http://grafana/dashboard/snapshot/abc", From: from, To: from.Add(time.Hour), Images: []string{image}}
+	failure := &Notification{Status: NotifyFailure, Org: "staging", Dashboard: "my-dash", From: from, To: from.Add(time.Hour), Error: "Dashboard not found"}
+	notifyTests := []struct {
+		purpose      string
+		config       *SlackConfig
+		notification *Notification
+		valid        bool
+		expected     []string
+	}{
+		{
+			purpose:      "Incoming webhook",
+			config:       &SlackConfig{WebhookURL: hookURL},
+			notification: success,
+			valid:        true,
+			expected:     []string{`hook {"text":"Snapshot of \u003chttp://grafana/dashboard/snapshot/abc|My \u0026lt;Dash\u0026gt;\u003e (2017-02-05 06:00 UTC to 2017-02-05 07:00 UTC)"}`},
+		},
+		{
+			purpose:      "Bot failure",
+			config:       &SlackConfig{Token: "xoxb-token", Channel: "#reports"},
+			notification: failure,
+			valid:        true,
+			expected:     []string{"message #reports :x: Failed to snapshot *staging/my-dash* (2017-02-05 06:00 UTC to 2017-02-05 07:00 UTC): Dashboard not found"},
+		},
+		{
+			purpose:      "Bot thumbnail",
+			config:       &SlackConfig{Token: "xoxb-token", Channel: "C123", Thumbnail: true},
+			notification: success,
+			valid:        true,
+			expected: []string{
+				"upload png",
+				`file C123 [{"id":"F1","title":"my-dash.png"}] Snapshot of <http://grafana/dashboard/snapshot/abc|My &lt;Dash&gt;> (2017-02-05 06:00 UTC to 2017-02-05 07:00 UTC)`,
+			},
+		},
+		{
+			purpose:      "Invalid token",
+			config:       &SlackConfig{Token: "xoxb-revoked", Channel: "C123"},
+			notification: success,
+			valid:        false,
+		},
+		{
+			purpose:      "Thumbnail without token",
+			config:       &SlackConfig{WebhookURL: hookURL, Thumbnail: true},
+			notification: success,
+			valid:        false,
+		},
+		{
+			purpose:      "Token without channel",
+			config:       &SlackConfig{Token: "xoxb-token"},
+			notification: success,
+			valid:        false,
+		},
+	}
+	// test
+	for _, nt := range notifyTests {
+		posted = nil
+		nt.config.APIAddr = apiAddr
+		s, err := NewSlack(nt.config)
+		if err == nil {
+			err = s.Notify(context.Background(), nt.notification)
+		}
+		if nt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", nt.purpose, err.Error())
+		} else if !nt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", nt.purpose)
+		} else if nt.valid && strings.Join(posted, "\n") != strings.Join(nt.expected, "\n") {
+			t.Errorf("Test \"%s\" expected %q, got %q", nt.purpose, nt.expected, posted)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SMTPConfig configures emailing the result of each snapshot, with any files
+// exported with it attached
+type SMTPConfig struct {
+	// Addr is the mail server's host and port. Port 465 is connected to with
+	// TLS, and other ports are upgraded with STARTTLS if the server offers
+	// it.
+	Addr string
+	// Username and Password, if set, authenticate with PLAIN auth, which
+	// needs TLS unless the server is on localhost
+	Username string
+	Password string
+	From     string
+	To       []string
+	// Timeout limits sending each email. Defaults to 60s.
+	Timeout time.Duration
+}
+
+// SMTPConfigFromEnv builds an SMTPConfig for the mail server at addr from the
+// SMTP_USERNAME and SMTP_PASSWORD environment variables
+func SMTPConfigFromEnv(addr string) (*SMTPConfig, error) {
+	return &SMTPConfig{
+		Addr:     addr,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}, nil
+}
+
+func processSMTPConfig(configIn *SMTPConfig) (*SMTPConfig, error) {
+	configOut := *configIn
+
+	if _, _, err := net.SplitHostPort(configIn.Addr); err != nil {
+		return nil, fmt.Errorf("Invalid SMTPConfig field \"Addr\": %s", err.Error())
+	}
+	if len(configIn.From) == 0 {
+		return nil, errors.New("Missing required SMTPConfig field: \"From\"")
+	}
+	if len(configIn.To) == 0 {
+		return nil, errors.New("Missing required SMTPConfig field: \"To\"")
+	}
+	configOut.Timeout = defaultDuration(configOut.Timeout, time.Minute)
+	return &configOut, nil
+}
+
+// SMTP is a Notifier emailing each Notification
+type SMTP struct {
+	config *SMTPConfig
+}
+
+// NewSMTP creates an SMTP emailing the configured recipients
+func NewSMTP(config *SMTPConfig) (*SMTP, error) {
+	c, err := processSMTPConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &SMTP{config: c}, nil
+}
+
+// Notify emails the snapshot's URL, or why it failed, with its images and
+// report attached
+func (s *SMTP) Notify(ctx context.Context, n *Notification) error {
+	msg, err := s.message(n, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = s.send(ctx, msg); err != nil {
+		return fmt.Errorf("Failed to send email: %s", err.Error())
+	}
+	return nil
+}
+
+// message returns the email for a notification, as a multipart message if it
+// has attachments
+func (s *SMTP) message(n *Notification, now time.Time) ([]byte, error) {
+	name := n.Title
+	if len(name) == 0 {
+		name = n.Dashboard
+	}
+	if len(n.Org) > 0 {
+		name = n.Org + "/" + name
+	}
+	subject := "Snapshot of " + name
+	var text bytes.Buffer
+	fmt.Fprintf(&text, "Dashboard: %s\r\n", name)
+	fmt.Fprintf(&text, "Time range: %s to %s\r\n", n.From.Format("2006-01-02 15:04:05 MST"), n.To.Format("2006-01-02 15:04:05 MST"))
+	if n.Status != NotifySuccess {
+		subject = "Failed to snapshot " + name
+		fmt.Fprintf(&text, "Error: %s\r\n", n.Error)
+	} else if len(n.URL) > 0 {
+		fmt.Fprintf(&text, "Snapshot: %s\r\n", n.URL)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	attachments := append([]string{}, n.Images...)
+	if len(n.Report) > 0 {
+		attachments = append(attachments, n.Report)
+	}
+	if len(attachments) == 0 {
+		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		msg.Write(text.Bytes())
+		return msg.Bytes(), nil
+	}
+
+	random := make([]byte, 12)
+	rand.Read(random)
+	boundary := "snapshot-" + base64.RawURLEncoding.EncodeToString(random)
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	msg.Write(text.Bytes())
+	for _, path := range attachments {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		fmt.Fprintf(&msg, "--%s\r\n", boundary)
+		fmt.Fprintf(&msg, "Content-Type: %s\r\n", contentType)
+		fmt.Fprintf(&msg, "Content-Disposition: %s\r\n", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(path)}))
+		msg.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		// base64 bodies are wrapped at 76 characters
+		encoded := base64.StdEncoding.EncodeToString(b)
+		for len(encoded) > 76 {
+			msg.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		msg.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&msg, "--%s--\r\n", boundary)
+	return msg.Bytes(), nil
+}
+
+// send sends a message to the recipients through the mail server
+func (s *SMTP) send(ctx context.Context, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	host, port, _ := net.SplitHostPort(s.config.Addr)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if port == "465" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && port != "465" {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if len(s.config.Username) > 0 {
+		if err = c.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(s.config.From); err != nil {
+		return err
+	}
+	for _, to := range s.config.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveSMTP answers one SMTP session on listener, sending the recipients and
+// message it receives on received
+func serveSMTP(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost ESMTP\r\n"))
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"):
+			conn.Write([]byte("250-localhost\r\n250 8BITMIME\r\n"))
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpts = append(rcpts, strings.TrimSpace(line)[8:])
+			conn.Write([]byte("250 OK\r\n"))
+		case cmd == "DATA":
+			conn.Write([]byte("354 Go ahead\r\n"))
+			var data []string
+			for {
+				line, err = r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				data = append(data, line)
+			}
+			received <- strings.Join(rcpts, ",") + "\n" + strings.Join(data, "")
+			conn.Write([]byte("250 OK\r\n"))
+		case cmd == "QUIT":
+			conn.Write([]byte("221 Bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("250 OK\r\n"))
+		}
+	}
+}
+
+func TestSMTPNotify(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go serveSMTP(listener, received)
+
+	dir, err := ioutil.TempDir("", "snapshot-smtp")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	report := filepath.Join(dir, "weekly.pdf")
+	ioutil.WriteFile(report, []byte("%PDF-1.4"), 0644)
+
+	s, err := NewSMTP(&SMTPConfig{Addr: listener.Addr().String(), From: "grafana@myorg.com", To: []string{"ops@myorg.com", "dev@myorg.com"}})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	from := time.Date(2017, time.February, 5, 6, 0, 0, 0, time.UTC)
+	n := &Notification{Status: NotifySuccess, Dashboard: "my-dash", Title: "Weekly Café", URL: "http://grafana/dashboard/snapshot/abc", From: from, To: from.Add(time.Hour), Report: report}
+	if err = s.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	msg := <-received
+	for _, expected := range []string{
+		"<ops@myorg.com>,<dev@myorg.com>\n",
+		"Subject: =?utf-8?q?Snapshot_of_Weekly_Caf=C3=A9?=\r\n",
+		"Snapshot: http://grafana/dashboard/snapshot/abc\r\n",
+		"Time range: 2017-02-05 06:00:00 UTC to 2017-02-05 07:00:00 UTC\r\n",
+		"Content-Type: application/pdf\r\nContent-Disposition: attachment; filename=weekly.pdf\r\nContent-Transfer-Encoding: base64\r\n\r\nJVBERi0xLjQ=\r\n",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("Expected the email to contain %q, got %q", expected, msg)
+		}
+	}
+
+	configTests := []struct {
+		purpose string
+		config  *SMTPConfig
+	}{
+		{purpose: "Missing port", config: &SMTPConfig{Addr: "smtp.myorg.com", From: "grafana@myorg.com", To: []string{"ops@myorg.com"}}},
+		{purpose: "Missing from", config: &SMTPConfig{Addr: "smtp.myorg.com:587", To: []string{"ops@myorg.com"}}},
+		{purpose: "Missing to", config: &SMTPConfig{Addr: "smtp.myorg.com:587", From: "grafana@myorg.com"}},
+	}
+	for _, ct := range configTests {
+		if _, err = NewSMTP(ct.config); err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", ct.purpose)
+		}
+	}
+}
@@ -1,30 +1,54 @@
 package snapshot
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/url"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"log"
 	"github.com/prometheus/client_golang/api"
 	"github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 )
 
+// The datasource name Grafana gives panels whose targets each query their own
+// datasource
+const mixedDatasource = "-- Mixed --"
+
 // SnapClient is for taking multiple snapshots of a Grafana instance and posting
 // them to a snapshot host
 type SnapClient struct {
-	config          *Config
+	config *Config
+	// datasourceCache maps the datasources last got from Grafana to their
+	// names, and datasourceUIDs their UIDs to their names
+	datasourceMu    sync.Mutex
 	datasourceCache map[string]interface{}
+	datasourceUIDs  map[string]string
+	// baseTransport makes the client's connections, and client makes every
+	// request to Grafana, its datasources and the snapshot host through it,
+	// so no other client in the process is affected by its settings
+	baseTransport http.RoundTripper
+	client        *http.Client
+	// limiter limits the rate of datasource queries, if configured
+	limiter *limiter
+	// ledgerMu serialises writes to the ledger file
+	ledgerMu sync.Mutex
+	// grafanaInfo is the Grafana detected, once it's known, or what was
+	// assumed when it couldn't be, until grafanaRetry
+	grafanaMu    sync.Mutex
+	grafanaInfo  *grafanaInfo
+	grafanaRetry time.Time
 }
 
 // Snapshot is returned on a successful Take call
@@ -33,14 +57,58 @@ type Snapshot struct {
 	Key       string `json:"key"`
 	DeleteURL string `json:"deleteUrl"`
 	DeleteKey string `json:"deleteKey"`
+	// Panels is the number of panels with data in the snapshot, and
+	// Datapoints the number of data points in them
+	Panels     int `json:"panels"`
+	Datapoints int `json:"datapoints"`
+	// PayloadBytes is the size of the encoded snapshot
+	PayloadBytes int64 `json:"payloadBytes"`
+	// External is set for snapshots published to an external snapshot
+	// service, whose URL and delete URL are ExternalURL and
+	// ExternalDeleteURL
+	External          bool   `json:"external,omitempty"`
+	ExternalURL       string `json:"externalUrl,omitempty"`
+	ExternalDeleteURL string `json:"externalDeleteUrl,omitempty"`
+	// PanelsSkipped is the number of panels with targets but no data, as
+	// none of their datasources are supported, and Targets the number of
+	// panel targets queried
+	PanelsSkipped int `json:"panelsSkipped"`
+	Targets       int `json:"targets"`
+	// Durations is how long each stage of taking the snapshot took, keyed
+	// by StageDashboard, StageQuery and StageUpload, and Duration how long
+	// it took in all
+	Durations map[string]time.Duration `json:"durations"`
+	Duration  time.Duration            `json:"duration"`
+	// Warnings describes problems which didn't stop the snapshot being
+	// taken, such as targets of unsupported datasources
+	Warnings []string `json:"warnings,omitempty"`
+	// PanelIDs are the IDs of the snapshot's panels, other than rows, e.g.
+	// to Render each of them, and PanelTitles and PanelRows their titles
+	// and the titles of the rows they're in
+	PanelIDs    []int          `json:"panelIds,omitempty"`
+	PanelTitles map[int]string `json:"panelTitles,omitempty"`
+	PanelRows   map[int]string `json:"panelRows,omitempty"`
+	// Title is the dashboard's title, From and To the snapshot's time range
+	// and Vars the text of the dashboard's variables, e.g. for a Report
+	Title string            `json:"title,omitempty"`
+	From  time.Time         `json:"from"`
+	To    time.Time         `json:"to"`
+	Vars  map[string]string `json:"vars,omitempty"`
+	// CSVFiles are the files the panels' data points were written to, if
+	// the TakeConfig's CSVDir is set
+	CSVFiles []string `json:"csvFiles,omitempty"`
 }
 
-type snapshotData struct {
+// SnapshotData is a single series of data points embedded in a snapshot panel
+type SnapshotData struct {
 	Target     string          `json:"target"`
 	Datapoints [][]interface{} `json:"datapoints"`
 	// Metric is a set of labels (e.g. instance=alp) which is retained
 	// so that we can replace labels according to target.legendFormat.
 	Metric model.Metric `json:"-"`
+	// Frame, if set, is a Grafana data frame put in the snapshot in place
+	// of the series, for data which isn't a time series, e.g. exemplars
+	Frame map[string]interface{} `json:"-"`
 }
 
 // NewSnapClient takes a Config, validates it, and returns a SnapClient
@@ -49,202 +117,915 @@ func NewSnapClient(config *Config) (*SnapClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &SnapClient{c, nil}, nil
+	sc := &SnapClient{config: c}
+	if c.RateLimit != nil {
+		sc.limiter = newLimiter(c.RateLimit)
+	}
+	if c.Transport != nil {
+		sc.baseTransport = c.Transport
+	} else if sc.baseTransport, err = newTransport(c); err != nil {
+		return nil, err
+	}
+	if c.Cassette != nil {
+		if sc.baseTransport, err = newCassetteTransport(sc.baseTransport, c.Cassette); err != nil {
+			return nil, err
+		}
+	}
+	sc.client = &http.Client{Transport: sc.transport()}
+	return sc, nil
+}
+
+// newTransport returns a copy of the default transport, connecting through the
+// configured proxy and with the TLS config
+func newTransport(c *Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.ProxyURL != nil {
+		transport.Proxy = http.ProxyURL(c.ProxyURL)
+	}
+	if c.TLS != nil {
+		tlsConfig, err := c.TLS.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
+
+// transport returns the RoundTripper for requests to Grafana and the snapshot
+// host, which retries them, limits their rate and logs them in debug mode as
+// configured
+func (sc *SnapClient) transport() http.RoundTripper {
+	transport := http.DefaultTransport
+	if sc.baseTransport != nil {
+		transport = sc.baseTransport
+	}
+	if sc.config.Debug {
+		transport = &debugTransport{next: transport, logger: sc.config.Logger}
+	}
+	if sc.limiter != nil {
+		transport = &rateLimitTransport{next: transport, limiter: sc.limiter}
+	}
+	if sc.config.TokenProvider != nil || sc.config.SnapshotTokenProvider != nil {
+		transport = &tokenRefreshTransport{next: transport}
+	}
+	if sc.config.Retry != nil {
+		transport = &retryTransport{next: transport, config: sc.config.Retry, logger: sc.config.Logger}
+	}
+	return transport
+}
+
+// httpClient returns the client for requests to Grafana and the snapshot host
+func (sc *SnapClient) httpClient() *http.Client {
+	if sc.client != nil {
+		return sc.client
+	}
+	return &http.Client{Transport: sc.transport()}
 }
 
 // Take is for taking a snapshot
-// TODO: Should take context
 func (sc *SnapClient) Take(config *TakeConfig) (*Snapshot, error) {
-	// process and validate config
-	c, err := processTakeConfig(config)
-	if err != nil {
-		return nil, err
-	}
+	return sc.TakeWithContext(context.Background(), config)
+}
 
-	// get dashboard
-	rawDashString, err := sc.getDashboardDef(c)
+// TakeWithContext is for taking a snapshot, using ctx for all requests made
+// to Grafana and its datasources
+func (sc *SnapClient) TakeWithContext(ctx context.Context, config *TakeConfig) (*Snapshot, error) {
+	ctx, cancel := withTimeout(ctx, sc.config.TakeTimeout)
+	defer cancel()
+	start := time.Now()
+	snapshot, size, stage, err := sc.take(ctx, config)
+	if snapshot != nil {
+		snapshot.Duration = time.Since(start)
+	}
+	if metrics := sc.config.Metrics; metrics != nil {
+		if err != nil {
+			metrics.SnapshotFailed(stage)
+		} else {
+			metrics.SnapshotTaken(size, time.Since(start))
+		}
+	}
 	if err != nil {
-		return nil, err
+		return nil, &TakeError{Stage: stage, Err: err}
 	}
+	return snapshot, nil
+}
+
+// TakeError is returned by Take when taking a snapshot fails, with the stage
+// it failed at, so callers can tell e.g. an invalid TakeConfig, which will
+// fail again, from a datasource query or upload failure, which may not
+type TakeError struct {
+	// Stage is StageConfig, StageDashboard, StageQuery or StageUpload
+	Stage string
+	Err   error
+}
+
+func (e *TakeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error taking the snapshot
+func (e *TakeError) Unwrap() error {
+	return e.Err
+}
+
+// take takes a snapshot, returning the size of its payload, or the stage it
+// failed at
+func (sc *SnapClient) take(ctx context.Context, config *TakeConfig) (*Snapshot, int, string, error) {
+	ctx = contextWithClient(ctx, sc)
+	ctx, warnings := contextWithWarnings(ctx)
+	durations := make(map[string]time.Duration)
 
-	// Get available datasources and map them to their names
-	datasourceMap, err := sc.getDatasourceDefs()
+	// process and validate config
+	c, err := processTakeConfig(config)
 	if err != nil {
-		return nil, err
+		return nil, 0, StageConfig, err
+	}
+	if len(c.OnQueryError) == 0 {
+		c.OnQueryError = sc.config.OnQueryError
 	}
-	sc.datasourceCache = datasourceMap
 
-	// Replace all templated variables
-	subbedDashString, err := sc.substituteVars(c, rawDashString)
+	// get dashboard, with its variables resolved
+	stageStart := time.Now()
+	c.progress(StageDashboard, 0, 1)
+	dashboard, values, datasourceMap, err := sc.prepareDashboard(ctx, c)
 	if err != nil {
-		return nil, err
+		return nil, 0, StageDashboard, err
+	}
+	if c.MutateDashboard != nil {
+		if err = c.MutateDashboard(dashboard); err != nil {
+			return nil, 0, StageDashboard, fmt.Errorf("MutateDashboard failed: %s", err.Error())
+		}
 	}
+	if c.SnapshotName, err = c.snapshotName(dashboard, values); err != nil {
+		return nil, 0, StageDashboard, err
+	}
+	c.progress(StageDashboard, 1, 1)
+	durations[StageDashboard] = time.Since(stageStart)
 
-	// Unmarshal it
-	var dash map[string]interface{}
-	if err = json.Unmarshal([]byte(subbedDashString), &dash); err != nil {
-		return nil, fmt.Errorf("Could not decode dashboard json: %s", err.Error())
+	// For each panel in dashboard, query its targets' data concurrently
+	stageStart = time.Now()
+	unified := sc.unifiedAlerting(ctx)
+	if err = sc.snapshotAnnotations(ctx, c, dashboard, unified); err != nil {
+		return nil, 0, StageQuery, err
 	}
-	if dash["dashboard"] == nil {
-		return nil, fmt.Errorf(dash["message"].(string))
-	}
-
-	// For each row in dashboard...
-	for _, row := range dash["dashboard"].(map[string]interface{})["rows"].([]interface{}) {
-		// For each panel in row...
-		for _, p := range row.(map[string]interface{})["panels"].([]interface{}) {
-			panel := p.(map[string]interface{})
-			// Get the datasource and targets
-			datasourceName := panel["datasource"].(string)
-			targets := panel["targets"].([]interface{})
-			// For each target in panel...
-			for _, t := range targets {
-				target := t.(map[string]interface{})
-				// Calculate “step” like Grafana. For the original code, see:
-				// https://github.com/grafana/grafana/blob/79138e211fac98bf1d12f1645ecd9fab5846f4fb/public/app/plugins/datasource/prometheus/datasource.ts#L83
-				intervalFactor := float64(1)
-				if target["intervalFactor"] != nil {
-					intervalFactor = target["intervalFactor"].(float64)
-				}
-				interval := time.Second * 30
-				if target["interval"] != nil && target["interval"].(string) != "" {
-					log.Printf(target["interval"].(string))
-					interval, err = time.ParseDuration(target["interval"].(string))
-				}
-				if err != nil {
-					return nil, err
-				}
-				step := interval.Seconds() * intervalFactor
-				// Lookup datasource
-				datasource := datasourceMap[datasourceName].(map[string]interface{})
-
-				// Fetch data points from datasource proxy
-				var dataPoints []snapshotData
-				switch datasource["type"].(string) {
-				case "prometheus":
-					dataPoints, err = sc.fetchDataPointsPrometheus(c, target, datasource, step)
-					if err != nil {
-						return nil, err
-					}
-				case "elasticsearch":
-					dataPoints, err = sc.fetchDataPointsElastic(c, target, datasource, step)
-					if err != nil {
-						return nil, err
-					}
-				default:
-					// unsupported
-					continue
-				}
-				var snapshotData []interface{}
-				// build snapshot data
-				for idx, dp := range dataPoints {
-					if target["legendFormat"] != nil && target["legendFormat"].(string) != "" {
-						dp.Target = sc.renderTemplate(target["legendFormat"].(string), dp.Metric)
-					} else {
-						dp.Target = dp.Metric.String()
-					}
-					dataPoints[idx] = dp
-					snapshotData = append(snapshotData, dp)
-				}
-				if snapshotData == nil {
-					snapshotData = []interface{}{}
-				}
-				// insert snapshot data into panels
-				panel["snapshotData"] = snapshotData
-				panel["targets"] = []interface{}{}
-				panel["links"] = []interface{}{}
-				panel["datasource"] = []interface{}{}
-			}
+	sc.snapshotAlertStates(ctx, dashboard, unified)
+	panels := dashboardPanels(dashboard)
+	panelQueries := make([][]*panelQuery, len(panels))
+	var queries []*panelQuery
+	skipped := 0
+	for idx, panel := range panels {
+		if panelQueries[idx], err = sc.panelQueries(ctx, c, dashboard, values, datasourceMap, panel); err != nil {
+			return nil, 0, StageQuery, err
+		}
+		if targets, _ := panel["targets"].([]interface{}); len(targets) > 0 && len(panelQueries[idx]) == 0 {
+			skipped++
+		}
+		queries = append(queries, panelQueries[idx]...)
+	}
+	c.progress(StageQuery, 0, len(queries))
+	queryDone := func(done int) {
+		c.progress(StageQuery, done, len(queries))
+	}
+	if err = sc.runQueries(ctx, queries, c.MaxConcurrency, c.OnQueryError == QueryErrorWarn, queryDone); err != nil {
+		return nil, 0, StageQuery, err
+	}
+	if c.MaxPoints > 0 {
+		for _, q := range queries {
+			downsample(q.dataPoints, c.DownsampleMethod, c.MaxPoints)
+		}
+	}
+	for idx, panel := range panels {
+		sc.setSnapshotData(panel, panelQueries[idx])
+		setPanelLinks(panel, c.PanelLinks, sc.config.GrafanaAddr)
+	}
+	durations[StageQuery] = time.Since(stageStart)
+	// legacy rows have titles too
+	for _, row := range panelList(dashboard["rows"]) {
+		if title, ok := row["title"].(string); ok {
+			row["title"] = interpolate(title, values, panelScopedVars(row), "text")
 		}
 	}
 
 	// Build Snapshot
 	snapshot := make(map[string]interface{})
 	// remove templating
-	dash["dashboard"].(map[string]interface{})["templating"].(map[string]interface{})["list"] = []interface{}{}
+	dashboard["templating"] = map[string]interface{}{"list": []interface{}{}}
+	// show it in the configured time zone
+	if c.Location == time.UTC {
+		dashboard["timezone"] = "utc"
+	} else if c.Location != time.Local {
+		dashboard["timezone"] = c.Location.String()
+	}
 	// update time range
-	dash["dashboard"].(map[string]interface{})["time"].(map[string]interface{})["from"] = c.From.Format(time.RFC3339Nano)
-	dash["dashboard"].(map[string]interface{})["time"].(map[string]interface{})["to"] = c.To.Format(time.RFC3339Nano)
-	snapshot["dashboard"] = dash["dashboard"]
+	dashboard["time"] = map[string]interface{}{
+		"from": c.From.Format(time.RFC3339Nano),
+		"to":   c.To.Format(time.RFC3339Nano),
+	}
+	snapshot["dashboard"] = dashboard
 	snapshot["expires"] = (c.Expires / time.Second)
 	snapshot["name"] = c.SnapshotName
-	b, err := json.Marshal(snapshot)
+	if c.External {
+		snapshot["external"] = true
+	}
+	if len(c.Key) > 0 {
+		snapshot["key"] = c.Key
+	}
+	if len(c.DeleteKey) > 0 {
+		snapshot["deleteKey"] = c.DeleteKey
+	}
+
+	// Check the snapshot isn't too large for the snapshot host
+	stageStart = time.Now()
+	c.progress(StageUpload, 0, 1)
+	if c.MutateSnapshot != nil {
+		if err = c.MutateSnapshot(snapshot); err != nil {
+			return nil, 0, StageUpload, fmt.Errorf("MutateSnapshot failed: %s", err.Error())
+		}
+	}
+	if c.MaxPayloadBytes > 0 {
+		if err = sc.fitPayload(c, snapshot, panels, panelQueries); err != nil {
+			return nil, 0, StageUpload, err
+		}
+	}
+
+	// the data points are written as they're snapshotted
+	var csvFiles []string
+	if len(c.CSVDir) > 0 {
+		if csvFiles, err = writeCSVFiles(c.CSVDir, dashboard, c.Location); err != nil {
+			return nil, 0, StageUpload, fmt.Errorf("Failed to write CSV files: %s", err.Error())
+		}
+	}
+
+	// Write Snapshot to file instead of posting it
+	var result *Snapshot
+	if len(c.OutputPath) > 0 {
+		sc.config.Logger.Info("Writing snapshot", "path", c.OutputPath)
+		size, err := writeJSONFile(c.OutputPath, snapshot)
+		if err != nil {
+			return nil, 0, StageUpload, err
+		}
+		sc.config.Logger.Info("Wrote snapshot", "path", c.OutputPath, "bytes", size)
+		result = &Snapshot{PayloadBytes: size}
+	} else {
+		// the snapshot is encoded as it's posted, rather than all at once,
+		// unless it replaces one, when it's encoded before that's deleted so
+		// it isn't deleted for a snapshot which can't be posted
+		var size int64
+		getBody, contentLength := encodedBody(snapshot, &size), int64(-1)
+		replacing := len(c.Key) > 0 || len(c.DeleteKey) > 0
+		if replacing {
+			if getBody, contentLength, err = bufferedBody(snapshot); err != nil {
+				return nil, 0, StageUpload, err
+			}
+			size = contentLength
+			if err = sc.deleteExisting(ctx, c.Key, c.DeleteKey); err != nil {
+				return nil, 0, StageUpload, err
+			}
+		}
+		if result, err = sc.postSnapshot(ctx, getBody, contentLength, c.External); err != nil {
+			if replacing {
+				sc.config.Logger.Error("Failed to post the snapshot after deleting the one it replaces, leaving its key without a snapshot", "key", c.Key, "deleteKey", c.DeleteKey, "error", err)
+			}
+			return nil, 0, StageUpload, err
+		}
+		result.PayloadBytes = atomic.LoadInt64(&size)
+		sc.config.Logger.Info("Posted snapshot", "key", result.Key, "bytes", result.PayloadBytes)
+		sc.record(result, c.SnapshotName, c.Expires)
+	}
+	c.progress(StageUpload, 1, 1)
+	durations[StageUpload] = time.Since(stageStart)
 
-	// Post Snapshot
-	reqURL := *sc.config.SnapshotAddr
-	reqURL.Path = reqURL.Path + "api/snapshots"
-	log.Printf("Posting snapshot to: %s", reqURL.String())
+	result.Panels, result.Datapoints = snapshotStats(dashboard)
+	result.PanelsSkipped = skipped
+	result.Targets = len(queries)
+	result.Durations = durations
+	result.Warnings = warnings.warnings()
+	result.setPanels(dashboard)
+	result.Title, _ = dashboard["title"].(string)
+	result.From, result.To = c.From.In(c.Location), c.To.In(c.Location)
+	result.Vars = variableText(values)
+	result.CSVFiles = csvFiles
+	return result, int(result.PayloadBytes), "", nil
+}
 
-	req, err := http.NewRequest("post", reqURL.String(), bytes.NewReader(b))
+// The fewest data points per series downsampling to fit MaxPayloadBytes
+// leaves
+const minFitPoints = 10
+
+// fitPayload checks the encoded snapshot is no larger than MaxPayloadBytes,
+// downsampling its series until it is if DownsampleToFit is set
+func (sc *SnapClient) fitPayload(c *TakeConfig, snapshot map[string]interface{}, panels []map[string]interface{}, panelQueries [][]*panelQuery) error {
+	size, err := encodedSize(snapshot)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	for size > c.MaxPayloadBytes && c.DownsampleToFit {
+		longest := 0
+		for _, queries := range panelQueries {
+			for _, q := range queries {
+				for _, series := range q.dataPoints {
+					if len(series.Datapoints) > longest {
+						longest = len(series.Datapoints)
+					}
+				}
+			}
+		}
+		// aim under the limit, as most of the payload is data points
+		points := int(float64(longest) * float64(c.MaxPayloadBytes) / float64(size) * 0.9)
+		if points < minFitPoints || points >= longest {
+			break
+		}
+		sc.config.Logger.Info("Downsampling snapshot to fit the payload limit", "bytes", size, "limit", c.MaxPayloadBytes, "points", points)
+		for idx, panel := range panels {
+			for _, q := range panelQueries[idx] {
+				downsample(q.dataPoints, c.DownsampleMethod, points)
+			}
+			sc.setSnapshotData(panel, panelQueries[idx])
+		}
+		if size, err = encodedSize(snapshot); err != nil {
+			return err
+		}
+	}
+	if size > c.MaxPayloadBytes {
+		return fmt.Errorf("Snapshot payload of %d bytes is over the %d byte limit: downsample it with MaxPoints or DownsampleToFit, or take a shorter time range", size, c.MaxPayloadBytes)
+	}
+	return nil
+}
+
+// snapshotStats counts the panels with snapshot data, and their data points,
+// including the rows of tables
+func snapshotStats(dashboard map[string]interface{}) (int, int) {
+	panels, datapoints := 0, 0
+	for _, panel := range dashboardPanels(dashboard) {
+		data, ok := panel["snapshotData"].([]interface{})
+		if !ok {
+			continue
+		}
+		panels++
+		for _, d := range data {
+			if series, ok := d.(SnapshotData); ok {
+				datapoints += len(series.Datapoints)
+			} else if table, ok := d.(map[string]interface{}); ok && table["type"] == "table" {
+				rows, _ := table["rows"].([]interface{})
+				datapoints += len(rows)
+			}
+		}
 	}
-	req.Header.Add("Authorization", "Bearer "+sc.config.SnapshotAPIKey)
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := (&http.Client{}).Do(req)
+	return panels, datapoints
+}
+
+// Validate is for checking a snapshot can be taken without taking it: the
+// dashboard exists, and each panel target's datasource exists and is
+// supported
+func (sc *SnapClient) Validate(config *TakeConfig) error {
+	return sc.ValidateWithContext(context.Background(), config)
+}
+
+// ValidateWithContext is for validating a snapshot, using ctx for all requests
+// made to Grafana and its datasources
+func (sc *SnapClient) ValidateWithContext(ctx context.Context, config *TakeConfig) error {
+	ctx = contextWithClient(ctx, sc)
+	c, err := processTakeConfig(config)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Unexpected status code when posting snapshot: %s", resp.Status)
+	dashboard, _, datasourceMap, err := sc.prepareDashboard(ctx, c)
+	if err != nil {
+		return err
 	}
-	// read body
-	body, err := ioutil.ReadAll(resp.Body)
+
+	// check every target, reporting all problems at once
+	var problems []string
+	for _, panel := range dashboardPanels(dashboard) {
+		datasourceName := datasourceRef(panel["datasource"])
+		targets, _ := panel["targets"].([]interface{})
+		for _, t := range targets {
+			target, _ := t.(map[string]interface{})
+			if !c.queryTarget(panel, target) {
+				continue
+			}
+			datasource, err := targetDatasource(c, dashboard, datasourceMap, datasourceName, target)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("panel %q: %s", panel["title"], err.Error()))
+				continue
+			}
+			datasourceType, _ := datasource["type"].(string)
+			if _, ok := lookupFetcher(datasourceType); !ok {
+				problems = append(problems, fmt.Sprintf("panel %q: unsupported datasource type %q", panel["title"], datasource["type"]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Dashboard %q has invalid panels: %s", c.dashboardID(), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// prepareDashboard gets the dashboard and datasources from Grafana, resolves
+// the dashboard's template variables, and expands its repeated rows and
+// panels
+func (sc *SnapClient) prepareDashboard(ctx context.Context, c *TakeConfig) (map[string]interface{}, map[string]variableValue, map[string]interface{}, error) {
+	// get dashboard
+	rawDashString, err := sc.getDashboardDef(ctx, c)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+
+	// Unmarshal it
+	dashboard, err := parseDashboard(rawDashString)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Library panels' queries are in their library element
+	if err = sc.resolveLibraryPanels(ctx, dashboard); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Get available datasources mapped to their names
+	datasourceMap, err := sc.datasources(ctx, dashboard)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Resolve template variables and expand repeated rows and panels
+	values := resolveVariables(dashboard, c.Vars)
+	resolveIntervalVariables(dashboard, values, TimeRange{From: *c.From, To: *c.To})
+	if err = sc.refreshVariables(ctx, c, dashboard, datasourceMap, values); err != nil {
+		return nil, nil, nil, err
+	}
+	expandRepeatedRows(dashboard, values)
+	expandRepeatedPanels(dashboard, values)
+	if c.PanelFilter != nil && filterPanels(dashboard, values, c.PanelFilter) == 0 {
+		return nil, nil, nil, fmt.Errorf("No panels of dashboard %q match the PanelFilter", c.dashboardID())
+	}
+	return dashboard, values, datasourceMap, nil
+}
+
+// postSnapshot posts an encoded snapshot to the snapshot host. getBody returns
+// the encoded snapshot for each attempt, and contentLength is its length, or
+// -1 if it's unknown and sent chunked.
+func (sc *SnapClient) postSnapshot(ctx context.Context, getBody func() (io.ReadCloser, error), contentLength int64, external bool) (*Snapshot, error) {
+	body, err := sc.grafanaAPI().PostSnapshot(ctx, getBody, contentLength, external)
+	if err != nil {
+		return nil, uploadFailed(err)
 	}
 	// parse body
 	var snapshotResponse Snapshot
 	if err = json.Unmarshal(body, &snapshotResponse); err != nil {
 		return nil, err
 	}
+	snapshotResponse.URL = ViewerURL(snapshotResponse.URL, sc.config.ViewerParams)
+	if external {
+		snapshotResponse.External = true
+		snapshotResponse.ExternalURL = snapshotResponse.URL
+		snapshotResponse.ExternalDeleteURL = snapshotResponse.DeleteURL
+	}
 
 	return &snapshotResponse, nil
 }
 
-func (sc *SnapClient) getDashboardDef(config *TakeConfig) (string, error) {
-	// Get dashboard def
-	reqURL := *sc.config.GrafanaAddr
-	reqURL.Path = reqURL.Path + "api/dashboards/db/" + config.DashSlug
+// ViewerURL adds query parameters to the URL of a snapshot, as Config's
+// ViewerParams are. Parameters without a value, like kiosk, are added bare.
+func ViewerURL(rawURL string, params url.Values) string {
+	if len(rawURL) == 0 || len(params) == 0 {
+		return rawURL
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var query []string
+	for _, name := range names {
+		values := params[name]
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		for _, value := range values {
+			if len(value) == 0 {
+				query = append(query, url.QueryEscape(name))
+			} else {
+				query = append(query, url.QueryEscape(name)+"="+url.QueryEscape(value))
+			}
+		}
+	}
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	return rawURL + sep + strings.Join(query, "&")
+}
+
+// panelQuery is the datasource query of a panel target
+type panelQuery struct {
+	panelID        int
+	target         map[string]interface{}
+	datasource     map[string]interface{}
+	datasourceType string
+	fetcher        DatasourceFetcher
+	timeRange      TimeRange
+	step           time.Duration
+	// dataPoints are the query's results
+	dataPoints []SnapshotData
+}
+
+// panelQueries builds the queries for each of a panel's targets, skipping
+// those whose datasource type has no fetcher
+func (sc *SnapClient) panelQueries(ctx context.Context, c *TakeConfig, dashboard map[string]interface{}, values map[string]variableValue, datasourceMap, panel map[string]interface{}) ([]*panelQuery, error) {
+	// The snapshot has no template variables, so substitute them in the title
+	scoped := panelScopedVars(panel)
+	if title, ok := panel["title"].(string); ok {
+		panel["title"] = interpolate(title, values, scoped, "text")
+	}
+
+	// Get the datasource and targets, panels such as text and rows have none
+	targets, ok := panel["targets"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	datasourceName := datasourceRef(panel["datasource"])
+	panelMinInterval := ""
+	if interval, ok := panel["interval"].(string); ok {
+		panelMinInterval = interpolate(interval, values, scoped, "text")
+	}
+	timeRange := TimeRange{From: *c.From, To: *c.To}
+	var queries []*panelQuery
+	// For each target in panel...
+	for _, t := range targets {
+		target, ok := t.(map[string]interface{})
+		if !ok || !c.queryTarget(panel, target) {
+			continue
+		}
+		targetDatasourceRef := target["datasource"]
+		datasource, err := targetDatasource(c, dashboard, datasourceMap, datasourceName, target)
+		if err != nil {
+			return nil, err
+		}
+		datasourceType, _ := datasource["type"].(string)
+
+		// Substitute template variables, formatted for the datasource
+		target = interpolateValue(target, values, scoped, datasourceVariableFormat(datasourceType)).(map[string]interface{})
+		target["datasource"] = targetDatasourceRef
+		// Calculate “step” like Grafana: the panel's interval for its max
+		// data points, no smaller than the panel's or datasource's min
+		// interval, then adjusted by the target's min interval and interval
+		// factor. For the original code, see:
+		// https://github.com/grafana/grafana/blob/v6.7.4/public/app/plugins/datasource/prometheus/datasource.ts#L310
+		lowLimit := panelMinInterval
+		if len(lowLimit) == 0 {
+			jsonData, _ := datasource["jsonData"].(map[string]interface{})
+			lowLimit, _ = jsonData["timeInterval"].(string)
+		}
+		var minInterval time.Duration
+		if len(lowLimit) > 0 {
+			if minInterval, err = parseInterval(lowLimit); err != nil {
+				return nil, err
+			}
+		}
+		interval := panelInterval(timeRange, panelMaxDataPoints(panel), minInterval)
+		targetMinInterval := interval
+		if s := targetInterval(target); len(s) > 0 {
+			if targetMinInterval, err = parseInterval(s); err != nil {
+				return nil, err
+			}
+		}
+		intervalFactor := float64(1)
+		if factor, ok := target["intervalFactor"].(float64); ok && factor > 0 {
+			intervalFactor = factor
+		}
+		step := queryStep(timeRange, interval, targetMinInterval, intervalFactor)
+		maxDataPoints := sc.config.MaxDataPoints
+		if maxDataPoints == 0 {
+			maxDataPoints = defaultMaxDataPoints
+		}
+		if widened, ok := limitStep(timeRange, step, maxDataPoints); ok {
+			sc.warn(ctx, "Widening query step to stay under max data points", "panel", panel["title"], "refId", target["refId"], "step", step, "widened", widened, "maxDataPoints", maxDataPoints)
+			step = widened
+		}
+
+		// Substitute Grafana's built in interval and range variables, where a
+		// target's min interval stands for the datasource's scrape interval
+		scrape := scrapeInterval(datasource)
+		if len(targetInterval(target)) > 0 {
+			scrape = targetMinInterval
+		}
+		builtins := builtinVariables(timeRange, step, scrape)
+		target = interpolateValue(target, builtins, nil, "").(map[string]interface{})
 
-	req, err := http.NewRequest("get", reqURL.String(), nil)
+		// Fetch data points with the fetcher registered for the datasource type
+		fetcher, ok := lookupFetcher(datasourceType)
+		if !ok {
+			sc.warn(ctx, "Skipping target: unsupported datasource type", "panel", panel["title"], "type", datasourceType)
+			continue
+		}
+		panelID, _ := panel["id"].(float64)
+		queries = append(queries, &panelQuery{
+			panelID:        int(panelID),
+			target:         target,
+			datasource:     datasource,
+			datasourceType: datasourceType,
+			fetcher:        fetcher,
+			timeRange:      timeRange,
+			step:           step,
+		})
+	}
+	return queries, nil
+}
+
+// runQueries runs the queries with at most concurrency running at once. If
+// one fails, the rest are cancelled and its error is returned, unless warn is
+// set, in which case the error is warned of and the query left without data.
+// onDone, if set, is called with the number done after each succeeds or is
+// warned of.
+func (sc *SnapClient) runQueries(ctx context.Context, queries []*panelQuery, concurrency int, warn bool, onDone func(done int)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var once sync.Once
+	var doneMu sync.Mutex
+	done := 0
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, query := range queries {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(q *panelQuery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := sc.runQuery(ctx, q)
+			if err != nil && warn && ctx.Err() == nil {
+				sc.warn(ctx, "Query failed", "panel", q.panelID, "refId", q.target["refId"], "error", errors.Unwrap(err))
+				q.dataPoints = nil
+				err = nil
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			if onDone != nil {
+				doneMu.Lock()
+				done++
+				onDone(done)
+				doneMu.Unlock()
+			}
+		}(query)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// runQuery fetches a query's data points, returning a DatasourceQueryError if
+// it fails
+func (sc *SnapClient) runQuery(ctx context.Context, q *panelQuery) error {
+	start := time.Now()
+	queryCtx, cancel := withTimeout(ctx, sc.config.QueryTimeout)
+	dataPoints, err := q.fetcher.Fetch(queryCtx, q.target, q.datasource, q.timeRange, q.step)
+	cancel()
+	if metrics := sc.config.Metrics; metrics != nil {
+		metrics.QueryDone(q.datasourceType, time.Since(start), err)
+	}
+	q.dataPoints = dataPoints
 	if err != nil {
-		return "", err
+		refID, _ := q.target["refId"].(string)
+		name, _ := q.datasource["name"].(string)
+		return &DatasourceQueryError{Panel: q.panelID, Target: refID, Datasource: name, Err: err}
 	}
-	req.Header.Add("Authorization", "Bearer "+sc.config.GrafanaAPIKey)
-	resp, err := (&http.Client{}).Do(req)
+	return nil
+}
+
+// setSnapshotData replaces a panel's targets with the data fetched for them,
+// in the order of the targets
+func (sc *SnapClient) setSnapshotData(panel map[string]interface{}, queries []*panelQuery) {
+	if _, ok := panel["targets"].([]interface{}); !ok {
+		return
+	}
+	tables := 0
+	for _, q := range queries {
+		if tableTarget(panel, q.target) {
+			tables++
+		}
+	}
+	panelData := []interface{}{}
+	for _, q := range queries {
+		// tables' value columns are told apart by refId when there are several
+		if tableTarget(panel, q.target) {
+			valueColumn := "Value"
+			if tables > 1 {
+				valueColumn = fmt.Sprintf("Value #%v", q.target["refId"])
+			}
+			var series []SnapshotData
+			for _, dp := range q.dataPoints {
+				if dp.Frame != nil {
+					panelData = append(panelData, dp.Frame)
+				} else {
+					series = append(series, dp)
+				}
+			}
+			panelData = append(panelData, seriesTable(series, valueColumn))
+			continue
+		}
+		// build snapshot data
+		for _, dp := range q.dataPoints {
+			if dp.Frame != nil {
+				panelData = append(panelData, dp.Frame)
+				continue
+			}
+			// fetchers may have already named the series
+			if len(dp.Target) == 0 {
+				if q.target["legendFormat"] != nil && q.target["legendFormat"].(string) != "" {
+					dp.Target = sc.renderTemplate(q.target["legendFormat"].(string), dp.Metric)
+				} else {
+					dp.Target = dp.Metric.String()
+				}
+			}
+			panelData = append(panelData, dp)
+		}
+	}
+	// insert snapshot data into panels
+	panel["snapshotData"] = panelData
+	panel["targets"] = []interface{}{}
+	panel["datasource"] = []interface{}{}
+}
+
+// tableTarget reports whether a target's data goes in the snapshot as a table
+// rather than as time series: if its format is "table", or it's an instant
+// query of a table panel, which Grafana shows a row of each series
+func tableTarget(panel, target map[string]interface{}) bool {
+	if target["format"] == "table" {
+		return true
+	}
+	instant, _ := target["instant"].(bool)
+	return instant && (panel["type"] == "table" || panel["type"] == "table-old")
+}
+
+// seriesTable encodes series as a table like Grafana's, of a row of each data
+// point's time, its series' labels and its value
+func seriesTable(data []SnapshotData, valueColumn string) map[string]interface{} {
+	labelSet := make(map[model.LabelName]bool)
+	for _, series := range data {
+		for name := range series.Metric {
+			labelSet[name] = true
+		}
+	}
+	labels := make([]string, 0, len(labelSet))
+	for name := range labelSet {
+		labels = append(labels, string(name))
+	}
+	sort.Strings(labels)
+
+	columns := []interface{}{map[string]interface{}{"text": "Time", "type": "time"}}
+	for _, label := range labels {
+		columns = append(columns, map[string]interface{}{"text": label})
+	}
+	columns = append(columns, map[string]interface{}{"text": valueColumn})
+	rows := []interface{}{}
+	for _, series := range data {
+		for _, dp := range series.Datapoints {
+			if len(dp) < 2 {
+				continue
+			}
+			row := []interface{}{dp[1]}
+			for _, label := range labels {
+				row = append(row, string(series.Metric[model.LabelName(label)]))
+			}
+			rows = append(rows, append(row, dp[0]))
+		}
+	}
+	return map[string]interface{}{"type": "table", "columns": columns, "rows": rows}
+}
+
+// targetDatasource returns the datasource a panel target queries
+func targetDatasource(c *TakeConfig, dashboard, datasourceMap map[string]interface{}, panelDatasourceName string, target map[string]interface{}) (map[string]interface{}, error) {
+	// Lookup datasource, mixed panels set it per target
+	datasourceName := panelDatasourceName
+	if panelDatasourceName == mixedDatasource {
+		datasourceName = datasourceRef(target["datasource"])
+	}
+	// and either may reference a datasource variable
+	datasourceName, err := resolveDatasourceName(datasourceName, dashboard, c.Vars, datasourceMap)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	// panels using the instance's default datasource have none set
+	if len(datasourceName) == 0 || datasourceName == "default" {
+		if datasourceName = defaultDatasourceName(datasourceMap); len(datasourceName) == 0 {
+			return nil, errors.New("No default datasource")
+		}
+	}
+	datasource, ok := datasourceMap[datasourceName].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Unknown datasource: %q", datasourceName)
+	}
+	return datasource, nil
+}
+
+func (sc *SnapClient) getDashboardDef(ctx context.Context, config *TakeConfig) (string, error) {
+	body, err := sc.grafanaAPI().Dashboard(ctx, config)
 	if err != nil {
 		return "", err
 	}
 	return string(body), nil
 }
 
-func (sc *SnapClient) getDatasourceDefs() (map[string]interface{}, error) {
-	// Get datasource defs
-	reqURL := *sc.config.GrafanaAddr
-	reqURL.Path = reqURL.Path + "api/datasources"
+// parseDashboard returns the dashboard in Grafana's response for it
+func parseDashboard(rawDashString string) (map[string]interface{}, error) {
+	var dash map[string]interface{}
+	if err := json.Unmarshal([]byte(rawDashString), &dash); err != nil {
+		return nil, fmt.Errorf("Could not decode dashboard json: %s", err.Error())
+	}
+	dashboard, ok := dash["dashboard"].(map[string]interface{})
+	if !ok {
+		if message, _ := dash["message"].(string); len(message) > 0 {
+			return nil, fmt.Errorf("Grafana returned no dashboard: %s", message)
+		}
+		return nil, errors.New("Grafana returned no dashboard")
+	}
+	return dashboard, nil
+}
+
+// datasources returns the datasources mapped to their names, from the cache if
+// it has every datasource the dashboard refers to by name or UID, or else from
+// Grafana, so datasources added or renamed since are found
+func (sc *SnapClient) datasources(ctx context.Context, dashboard map[string]interface{}) (map[string]interface{}, error) {
+	sc.datasourceMu.Lock()
+	defer sc.datasourceMu.Unlock()
+	if sc.datasourceCache != nil {
+		missing := ""
+		for _, ref := range dashboardDatasourceRefs(dashboard) {
+			if _, ok := sc.datasourceCache[ref]; ok {
+				continue
+			}
+			if _, ok := sc.datasourceUIDs[ref]; !ok {
+				missing = ref
+				break
+			}
+		}
+		if len(missing) == 0 {
+			return sc.datasourceCache, nil
+		}
+		sc.config.Logger.Debug("Datasource not cached, refreshing datasources", "datasource", missing)
+	}
 
-	req, err := http.NewRequest("get", reqURL.String(), nil)
+	datasourceMap, err := sc.getDatasourceDefs(ctx)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+sc.config.GrafanaAPIKey)
-	resp, err := (&http.Client{}).Do(req)
-	if err != nil {
-		return nil, err
+	sc.datasourceCache = datasourceMap
+	sc.datasourceUIDs = make(map[string]string)
+	for name, ds := range datasourceMap {
+		if uid, _ := ds.(map[string]interface{})["uid"].(string); len(uid) > 0 {
+			sc.datasourceUIDs[uid] = name
+		}
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, errors.New("Unexpected status code: " + resp.Status)
+	return datasourceMap, nil
+}
+
+// dashboardDatasourceRefs returns the names and UIDs of the datasources the
+// dashboard's panels, targets and template variables refer to, other than
+// through datasource variables
+func dashboardDatasourceRefs(dashboard map[string]interface{}) []string {
+	var refs []string
+	add := func(v interface{}) {
+		ref := datasourceRef(v)
+		if _, isVar := variableRef(ref); len(ref) > 0 && !isVar && ref != "default" && ref != mixedDatasource {
+			refs = append(refs, ref)
+		}
 	}
-	// read body
-	body, err := ioutil.ReadAll(resp.Body)
+	for _, panel := range dashboardPanels(dashboard) {
+		add(panel["datasource"])
+		targets, _ := panel["targets"].([]interface{})
+		for _, t := range targets {
+			target, _ := t.(map[string]interface{})
+			add(target["datasource"])
+		}
+	}
+	templating, _ := dashboard["templating"].(map[string]interface{})
+	list, _ := templating["list"].([]interface{})
+	for _, v := range list {
+		variable, _ := v.(map[string]interface{})
+		add(variable["datasource"])
+	}
+	return refs
+}
+
+func (sc *SnapClient) getDatasourceDefs(ctx context.Context) (map[string]interface{}, error) {
+	body, err := sc.grafanaAPI().Datasources(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -262,60 +1043,169 @@ func (sc *SnapClient) getDatasourceDefs() (map[string]interface{}, error) {
 	return datasourceMap, nil
 }
 
-func (sc *SnapClient) substituteVars(config *TakeConfig, dashboardString string) (string, error) {
-	for k, v := range config.Vars {
-		vk := "$" + k
-		dashboardString = strings.Replace(dashboardString, vk, v, -1)
+// DatasourceProxyRequest sends a request through the Grafana datasource proxy
+// for the given datasource, or directly to it if it's one of the Config's
+// DirectDatasources, and returns the response body. It is intended for use
+// by DatasourceFetcher implementations.
+func (sc *SnapClient) DatasourceProxyRequest(ctx context.Context, method string, datasource map[string]interface{}, path string, query url.Values, body io.Reader, contentType string) ([]byte, error) {
+	reqURL, err := sc.datasourceURL(datasource)
+	if err != nil {
+		return nil, err
+	}
+	reqURL.Path = reqURL.Path + strings.TrimPrefix(path, "/")
+	reqURL.RawQuery = query.Encode()
+	sc.config.Logger.Debug("Requesting data points", "url", reqURL.String())
+
+	req, err := http.NewRequest(method, reqURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if req, err = sc.datasourceAuth(req, datasource); err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Add("Content-Type", contentType)
 	}
-	return dashboardString, nil
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// read body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = unauthorized(sc.datasourceHost(datasource), resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		if sc.directDatasource(datasource) != nil {
+			return nil, fmt.Errorf("Unexpected status code from datasource: %s: %s", resp.Status, string(respBody))
+		}
+		return nil, fmt.Errorf("Unexpected status code from datasource proxy: %s: %s", resp.Status, string(respBody))
+	}
+	return respBody, nil
 }
 
-// Implementation of CancelableTransport (https://gowalker.org/github.com/prometheus/client_golang/api/prometheus#CancelableTransport)
-// Required to intercept the api requests and add the auth header for going
-// through the Grafana datasource proxy
+// grafanaProxyTransport is the RoundTripper of the Prometheus API client,
+// required to intercept its requests and add the auth header for going
+// through the Grafana datasource proxy, or directly to the datasource
 type grafanaProxyTransport struct {
-	http.Transport
-	grafanaAPIKey string
+	sc         *SnapClient
+	datasource map[string]interface{}
+	next       http.RoundTripper
 }
 
-// Adds the Grafana API key auth header to any request
+// Adds the Grafana, or direct datasource, auth header to any request
 func (gpt *grafanaProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Add("Authorization", "Bearer "+gpt.grafanaAPIKey)
-	return (&http.Transport{}).RoundTrip(req)
+	req, err := gpt.sc.datasourceAuth(req, gpt.datasource)
+	if err != nil {
+		return nil, err
+	}
+	return gpt.next.RoundTrip(req)
 }
 
-func (sc *SnapClient) fetchDataPointsPrometheus(config *TakeConfig, target, datasource map[string]interface{}, step float64) ([]snapshotData, error) {
-	reqURL := *sc.config.GrafanaAddr
-	reqURL.Path = reqURL.Path + "api/datasources/proxy/" + strconv.Itoa(int(datasource["id"].(float64)))
-	log.Printf("Requesting data points from: %s", reqURL.String())
+func (sc *SnapClient) fetchDataPointsPrometheus(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	reqURL, err := sc.datasourceURL(datasource)
+	if err != nil {
+		return nil, err
+	}
+	sc.config.Logger.Debug("Requesting data points", "url", reqURL.String())
 
-	// Use our Grafana proxy transport with configured API key
-	transport := grafanaProxyTransport{grafanaAPIKey: sc.config.GrafanaAPIKey}
+	// Use our Grafana proxy transport with configured credentials
+	transport := grafanaProxyTransport{sc: sc, datasource: datasource, next: sc.httpClient().Transport}
 	client, err := api.NewClient(api.Config{Address: reqURL.String(), RoundTripper: &transport})
 	if err != nil {
 		return nil, err
 	}
 	api := v1.NewAPI(client)
 
-	// Query
-	val, err := api.QueryRange(context.Background(), target["expr"].(string), v1.Range{
-		Start: *config.From,
-		End:   *config.To,
-		Step:  time.Duration(step) * time.Second,
-	})
-	if err != nil {
-		return nil, err
+	// Instant queries, as stat panels and tables make, are evaluated at the
+	// end of the time range
+	if instant, _ := target["instant"].(bool); instant && target["range"] != true {
+		val, err := api.Query(ctx, target["expr"].(string), r.To)
+		if err != nil {
+			return nil, err
+		}
+		return instantToSnapshotData(val)
 	}
 
-	if val.Type() != model.ValMatrix {
-		return nil, fmt.Errorf("Unexpected value type: got %q, want %q", val.Type(), model.ValMatrix)
+	// Query, in chunks of ranges Prometheus will resolve at the step
+	var matrix model.Matrix
+	for _, chunk := range rangeChunks(r, step) {
+		val, err := api.QueryRange(ctx, target["expr"].(string), chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		if val.Type() != model.ValMatrix {
+			return nil, fmt.Errorf("Unexpected value type: got %q, want %q", val.Type(), model.ValMatrix)
+		}
+		chunkMatrix, ok := val.(model.Matrix)
+		if !ok {
+			return nil, fmt.Errorf("Bug: val.Type() == model.ValMatrix, but type assertion failed")
+		}
+		matrix = mergeMatrices(matrix, chunkMatrix)
 	}
-	matrix, ok := val.(model.Matrix)
-	if !ok {
-		return nil, fmt.Errorf("Bug: val.Type() == model.ValMatrix, but type assertion failed")
+
+	data := matrixToSnapshotData(matrix)
+
+	// Exemplars, where enabled, are kept so their trace links still work, but
+	// not every Prometheus serves them
+	if exemplar, _ := target["exemplar"].(bool); exemplar {
+		frame, err := sc.fetchExemplars(ctx, target, datasource, r)
+		if err != nil {
+			sc.warn(ctx, "Could not get exemplars", "refId", target["refId"], "error", err)
+		} else if frame != nil {
+			data = append(data, *frame)
+		}
 	}
+	return data, nil
+}
 
-	results := make([]snapshotData, matrix.Len())
+// rangeChunks splits a time range into ranges of at most maxSeriesPoints
+// steps, the most Prometheus resolves a series to, each starting a step after
+// the last ends so no point is queried twice
+func rangeChunks(r TimeRange, step time.Duration) []v1.Range {
+	if step <= 0 {
+		return []v1.Range{{Start: r.From, End: r.To, Step: step}}
+	}
+	span := step * time.Duration(maxSeriesPoints-1)
+	var chunks []v1.Range
+	for start := r.From; ; {
+		end := start.Add(span)
+		if !end.Before(r.To) {
+			return append(chunks, v1.Range{Start: start, End: r.To, Step: step})
+		}
+		chunks = append(chunks, v1.Range{Start: start, End: end, Step: step})
+		start = end.Add(step)
+	}
+}
+
+// mergeMatrices appends the values of each series in next to the same series
+// in matrix, keeping the order series are first seen in
+func mergeMatrices(matrix, next model.Matrix) model.Matrix {
+	streams := make(map[model.Fingerprint]*model.SampleStream, len(matrix))
+	for _, stream := range matrix {
+		streams[stream.Metric.Fingerprint()] = stream
+	}
+	for _, stream := range next {
+		if existing, ok := streams[stream.Metric.Fingerprint()]; ok {
+			existing.Values = append(existing.Values, stream.Values...)
+			continue
+		}
+		streams[stream.Metric.Fingerprint()] = stream
+		matrix = append(matrix, stream)
+	}
+	return matrix
+}
+
+// matrixToSnapshotData converts a Prometheus style range query result into
+// snapshot data, replacing NaN values with nulls
+func matrixToSnapshotData(matrix model.Matrix) []SnapshotData {
+	results := make([]SnapshotData, matrix.Len())
 	for idx, stream := range matrix {
 		datapoints := make([][]interface{}, len(stream.Values))
 		for idx, samplepair := range stream.Values {
@@ -326,17 +1216,36 @@ func (sc *SnapClient) fetchDataPointsPrometheus(config *TakeConfig, target, data
 			}
 		}
 
-		results[idx] = snapshotData{
+		results[idx] = SnapshotData{
 			Metric:     stream.Metric,
 			Datapoints: datapoints,
 		}
 	}
 
-	return results, nil
+	return results
 }
 
-func (sc *SnapClient) fetchDataPointsElastic(config *TakeConfig, target, datasource map[string]interface{}, step float64) ([]snapshotData, error) {
-	return nil, nil
+// instantToSnapshotData converts a Prometheus instant query result into
+// snapshot data of one data point per series
+func instantToSnapshotData(val model.Value) ([]SnapshotData, error) {
+	var matrix model.Matrix
+	switch v := val.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			matrix = append(matrix, &model.SampleStream{
+				Metric: sample.Metric,
+				Values: []model.SamplePair{{Timestamp: sample.Timestamp, Value: sample.Value}},
+			})
+		}
+	case *model.Scalar:
+		matrix = model.Matrix{{
+			Metric: model.Metric{},
+			Values: []model.SamplePair{{Timestamp: v.Timestamp, Value: v.Value}},
+		}}
+	default:
+		return nil, fmt.Errorf("Unexpected value type: got %q, want %q", val.Type(), model.ValVector)
+	}
+	return matrixToSnapshotData(matrix), nil
 }
 
 var aliasRe = regexp.MustCompile(`{{\s*(.+?)\s*}}`)
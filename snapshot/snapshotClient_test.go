@@ -0,0 +1,702 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+	"github.com/prometheus/common/model"
+)
+
+func TestRunQueries(t *testing.T) {
+	queryTests := []struct {
+		purpose     string
+		queries     int
+		concurrency int
+		fail        int // the query which fails, or -1
+		valid       bool
+	}{
+		{
+			purpose:     "Sequential",
+			queries:     5,
+			concurrency: 1,
+			fail:        -1,
+			valid:       true,
+		},
+		{
+			purpose:     "Concurrent",
+			queries:     20,
+			concurrency: 4,
+			fail:        -1,
+			valid:       true,
+		},
+		{
+			purpose:     "Failed query",
+			queries:     20,
+			concurrency: 4,
+			fail:        6,
+			valid:       false,
+		},
+	}
+	// test
+	for _, qt := range queryTests {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		var queries []*panelQuery
+		for idx := 0; idx < qt.queries; idx++ {
+			idx := idx
+			fetcher := FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					running--
+					mu.Unlock()
+				}()
+				if idx == qt.fail {
+					return nil, errors.New("query failed")
+				}
+				// later queries finish first
+				select {
+				case <-time.After(time.Duration(qt.queries-idx) * time.Millisecond):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				return []SnapshotData{{Target: string(rune('a' + idx))}}, nil
+			})
+			queries = append(queries, &panelQuery{fetcher: fetcher, target: map[string]interface{}{}})
+		}
+		sc := &SnapClient{config: &Config{QueryTimeout: time.Minute}}
+
+		var progress []int
+		onDone := func(done int) {
+			progress = append(progress, done)
+		}
+		err := sc.runQueries(context.Background(), queries, qt.concurrency, false, onDone)
+		if qt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", qt.purpose, err.Error())
+		} else if queryErr := (*DatasourceQueryError)(nil); !qt.valid && (!errors.As(err, &queryErr) || queryErr.Err.Error() != "query failed") {
+			t.Errorf("Test \"%s\" expected the failed query's error, got %v", qt.purpose, err)
+		}
+		if maxRunning > qt.concurrency {
+			t.Errorf("Test \"%s\" ran %d queries at once, more than %d", qt.purpose, maxRunning, qt.concurrency)
+		}
+		if !qt.valid {
+			continue
+		}
+		// progress is reported once per query, in order
+		for idx, done := range progress {
+			if done != idx+1 {
+				t.Errorf("Test \"%s\" reported progress %v", qt.purpose, progress)
+				break
+			}
+		}
+		if len(progress) != qt.queries {
+			t.Errorf("Test \"%s\" reported progress %d times, expected %d", qt.purpose, len(progress), qt.queries)
+		}
+		// results are kept in query order
+		panel := map[string]interface{}{"targets": []interface{}{}}
+		sc.setSnapshotData(panel, queries)
+		for idx, dp := range panel["snapshotData"].([]interface{}) {
+			if expected := string(rune('a' + idx)); dp.(SnapshotData).Target != expected {
+				t.Errorf("Test \"%s\" expected series %d to be %q, got %q", qt.purpose, idx, expected, dp.(SnapshotData).Target)
+			}
+		}
+	}
+}
+
+func TestFitPayload(t *testing.T) {
+	fitTests := []struct {
+		purpose string
+		config  *TakeConfig
+		valid   bool
+	}{
+		{
+			purpose: "Under the limit",
+			config:  &TakeConfig{MaxPayloadBytes: 1 << 20},
+			valid:   true,
+		},
+		{
+			purpose: "Over the limit",
+			config:  &TakeConfig{MaxPayloadBytes: 10 << 10},
+			valid:   false,
+		},
+		{
+			purpose: "Downsampled to fit",
+			config:  &TakeConfig{MaxPayloadBytes: 10 << 10, DownsampleToFit: true, DownsampleMethod: DownsampleLTTB},
+			valid:   true,
+		},
+		{
+			purpose: "Too small to fit",
+			config:  &TakeConfig{MaxPayloadBytes: 100, DownsampleToFit: true, DownsampleMethod: DownsampleLTTB},
+			valid:   false,
+		},
+	}
+	// test
+	for _, ft := range fitTests {
+		// a panel with a series of 5000 points, about 100KB encoded
+		datapoints := make([][]interface{}, 5000)
+		for idx := range datapoints {
+			datapoints[idx] = []interface{}{float64(idx % 7), float64(1486274400000 + idx*15000)}
+		}
+		query := &panelQuery{target: map[string]interface{}{}, dataPoints: []SnapshotData{{Target: "series", Datapoints: datapoints}}}
+		panel := map[string]interface{}{"targets": []interface{}{}}
+		panels := []map[string]interface{}{panel}
+		panelQueries := [][]*panelQuery{{query}}
+		sc := &SnapClient{config: &Config{Logger: nopLogger{}}}
+		sc.setSnapshotData(panel, panelQueries[0])
+		snapshot := map[string]interface{}{"dashboard": map[string]interface{}{"panels": []interface{}{panel}}}
+
+		err := sc.fitPayload(ft.config, snapshot, panels, panelQueries)
+		if ft.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", ft.purpose, err.Error())
+		} else if !ft.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", ft.purpose)
+		}
+		if size, _ := encodedSize(snapshot); ft.valid && size > ft.config.MaxPayloadBytes {
+			t.Errorf("Test \"%s\" left a payload of %d bytes", ft.purpose, size)
+		}
+	}
+}
+
+func TestExternalSnapshot(t *testing.T) {
+	// records the Authorization header of each snapshot posted
+	var auth []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"key": "abc", "deleteKey": "def", "url": "https://external/dashboard/snapshot/abc", "deleteUrl": "https://external/api/snapshots-delete/def"}`))
+	})
+	grafana := httptest.NewServer(handler)
+	defer grafana.Close()
+	external := httptest.NewServer(handler)
+	defer external.Close()
+	grafanaURL, _ := url.Parse(grafana.URL)
+	externalURL, _ := url.Parse(external.URL)
+
+	externalTests := []struct {
+		purpose  string
+		config   *Config
+		external bool
+		expected string
+	}{
+		{
+			purpose:  "Local snapshot",
+			config:   &Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"},
+			expected: "Bearer XXXXX",
+		},
+		{
+			purpose:  "Published by Grafana",
+			config:   &Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"},
+			external: true,
+			expected: "Bearer XXXXX",
+		},
+		{
+			purpose:  "External host without Grafana credentials",
+			config:   &Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", SnapshotAddr: externalURL},
+			external: true,
+			expected: "",
+		},
+		{
+			purpose:  "External host with its own credentials",
+			config:   &Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", SnapshotAddr: externalURL, SnapshotAPIKey: "ZZZZZ"},
+			external: true,
+			expected: "Bearer ZZZZZ",
+		},
+	}
+	// test
+	for _, et := range externalTests {
+		auth = nil
+		sc, err := NewSnapClient(et.config)
+		if err != nil {
+			t.Fatalf("Test \"%s\" unexpectedly failed: %s", et.purpose, err.Error())
+		}
+		getBody := func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader([]byte(`{"external": true}`))), nil
+		}
+		posted, err := sc.postSnapshot(context.Background(), getBody, -1, et.external)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", et.purpose, err.Error())
+			continue
+		}
+		if len(auth) != 1 || auth[0] != et.expected {
+			t.Errorf("Test \"%s\" expected Authorization %q, got %q", et.purpose, et.expected, auth)
+		}
+		if posted.External != et.external {
+			t.Errorf("Test \"%s\" expected External %t, got %t", et.purpose, et.external, posted.External)
+		}
+		if et.external && (posted.ExternalURL != posted.URL || posted.ExternalDeleteURL != posted.DeleteURL) {
+			t.Errorf("Test \"%s\" expected external URLs from the response, got %q and %q", et.purpose, posted.ExternalURL, posted.ExternalDeleteURL)
+		}
+	}
+}
+
+func TestTakeHooks(t *testing.T) {
+	RegisterFetcher("hooks-test", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+		return []SnapshotData{{Target: target["refId"].(string), Datapoints: [][]interface{}{{1.0, 1000.0}}}}, nil
+	}))
+	defer RegisterFetcher("hooks-test", nil)
+
+	// Grafana with a dashboard of two panels, recording the snapshot posted
+	var posted map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/dashboards/uid/hooks":
+			w.Write([]byte(`{"dashboard": {"title": "Hooks", "panels": [
+				{"id": 1, "title": "Kept", "datasource": "test", "targets": [{"refId": "A"}, {"refId": "B"}]},
+				{"id": 2, "title": "Dropped", "datasource": "test", "targets": [{"refId": "C"}]}
+			]}}`))
+		case "/api/datasources":
+			w.Write([]byte(`[{"name": "test", "type": "hooks-test", "isDefault": true}]`))
+		case "/api/snapshots":
+			json.NewDecoder(r.Body).Decode(&posted)
+			w.Write([]byte(`{"key": "abc"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	sc, err := NewSnapClient(&Config{GrafanaAddr: serverURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+	var progress []string
+	config := &TakeConfig{
+		DashUID: "hooks",
+		From:    &from,
+		To:      &to,
+		// drop the second panel
+		MutateDashboard: func(dashboard map[string]interface{}) error {
+			panels := dashboard["panels"].([]interface{})
+			dashboard["panels"] = panels[:1]
+			return nil
+		},
+		MutateSnapshot: func(snapshot map[string]interface{}) error {
+			snapshot["name"] = "stamped"
+			return nil
+		},
+		OnProgress: func(stage string, done, total int) {
+			progress = append(progress, stage+" "+strconv.Itoa(done)+"/"+strconv.Itoa(total))
+		},
+	}
+	snapshot, err := sc.Take(config)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if posted["name"] != "stamped" {
+		t.Errorf("Expected the posted snapshot to be named \"stamped\", got %v", posted["name"])
+	}
+	panels := posted["dashboard"].(map[string]interface{})["panels"].([]interface{})
+	if len(panels) != 1 || panels[0].(map[string]interface{})["title"] != "Kept" {
+		t.Errorf("Expected only the kept panel to be posted, got %v", panels)
+	}
+	if snapshot.Targets != 2 || snapshot.Panels != 1 || snapshot.Datapoints != 2 {
+		t.Errorf("Expected 2 targets, 1 panel and 2 data points, got %d, %d and %d", snapshot.Targets, snapshot.Panels, snapshot.Datapoints)
+	}
+	expected := []string{"dashboard 0/1", "dashboard 1/1", "query 0/2", "query 1/2", "query 2/2", "upload 0/1", "upload 1/1"}
+	if !reflect.DeepEqual(progress, expected) {
+		t.Errorf("Expected progress %v, got %v", expected, progress)
+	}
+
+	// hook errors fail the snapshot at their stage
+	config.MutateSnapshot = func(snapshot map[string]interface{}) error {
+		return errors.New("no stamp")
+	}
+	_, err = sc.Take(config)
+	if takeErr, ok := err.(*TakeError); !ok || takeErr.Stage != StageUpload {
+		t.Errorf("Expected an upload stage TakeError, got %v", err)
+	}
+}
+
+func TestViewerURL(t *testing.T) {
+	urlTests := []struct {
+		purpose  string
+		url      string
+		params   string
+		expected string
+	}{
+		{
+			purpose:  "No params",
+			url:      "http://grafana.local/dashboard/snapshot/abc",
+			params:   "",
+			expected: "http://grafana.local/dashboard/snapshot/abc",
+		},
+		{
+			purpose:  "Bare and valued params",
+			url:      "http://grafana.local/dashboard/snapshot/abc",
+			params:   "kiosk&theme=light&orgId=2&var-env=prod",
+			expected: "http://grafana.local/dashboard/snapshot/abc?kiosk&orgId=2&theme=light&var-env=prod",
+		},
+		{
+			purpose:  "URL with a query",
+			url:      "https://snapshots.raintank.io/dashboard/snapshot/abc?orgId=1",
+			params:   "theme=dark",
+			expected: "https://snapshots.raintank.io/dashboard/snapshot/abc?orgId=1&theme=dark",
+		},
+		{
+			purpose:  "No URL",
+			url:      "",
+			params:   "kiosk",
+			expected: "",
+		},
+	}
+	// test
+	for _, ut := range urlTests {
+		params, err := url.ParseQuery(ut.params)
+		if err != nil {
+			t.Fatalf("Test \"%s\" has invalid params: %s", ut.purpose, err.Error())
+		}
+		if out := ViewerURL(ut.url, params); out != ut.expected {
+			t.Errorf("Test \"%s\" expected %q, got %q", ut.purpose, ut.expected, out)
+		}
+	}
+}
+
+func TestDatasourceCache(t *testing.T) {
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDatasource(map[string]interface{}{"name": "Prom A", "uid": "uid-a", "type": "prometheus"})
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	dashboardUsing := func(datasource interface{}) map[string]interface{} {
+		return map[string]interface{}{"panels": []interface{}{
+			map[string]interface{}{"datasource": datasource, "targets": []interface{}{map[string]interface{}{"refId": "A"}}},
+		}}
+	}
+	ctx := context.Background()
+
+	var cacheTests = []struct {
+		purpose    string
+		datasource interface{}
+		expected   []string
+	}{
+		{
+			purpose:    "First lookup",
+			datasource: "Prom A",
+			expected:   []string{"Prom A"},
+		},
+		{
+			purpose:    "Cached by UID",
+			datasource: map[string]interface{}{"type": "prometheus", "uid": "uid-a"},
+			expected:   []string{"Prom A"},
+		},
+		{
+			purpose:    "Datasource variables don't refresh",
+			datasource: "$ds",
+			expected:   []string{"Prom A"},
+		},
+		{
+			purpose:    "Refreshed on a miss",
+			datasource: map[string]interface{}{"type": "prometheus", "uid": "uid-b"},
+			expected:   []string{"Prom A", "Prom B"},
+		},
+	}
+	for i, ct := range cacheTests {
+		// added after the first lookup, so only found once refreshed
+		if i == 1 {
+			srv.AddDatasource(map[string]interface{}{"name": "Prom B", "uid": "uid-b", "type": "prometheus"})
+		}
+		datasourceMap, err := sc.datasources(ctx, dashboardUsing(ct.datasource))
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", ct.purpose, err.Error())
+			continue
+		}
+		names := make([]string, 0, len(datasourceMap))
+		for name := range datasourceMap {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, ct.expected) {
+			t.Errorf("Test \"%s\" expected datasources %v, got %v", ct.purpose, ct.expected, names)
+		}
+	}
+}
+
+func TestMixedDatasource(t *testing.T) {
+	var mu sync.Mutex
+	queried := make(map[string]string)
+	RegisterFetcher("mixed", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		queried[target["refId"].(string)] = datasource["name"].(string)
+		return nil, nil
+	}))
+	defer RegisterFetcher("mixed", nil)
+
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("mixed", map[string]interface{}{
+		"uid": "mixed",
+		"panels": []interface{}{
+			map[string]interface{}{
+				"id":         1.0,
+				"datasource": mixedDatasource,
+				"targets": []interface{}{
+					map[string]interface{}{"refId": "A", "datasource": "Mixed A"},
+					map[string]interface{}{"refId": "B", "datasource": map[string]interface{}{"type": "mixed", "uid": "uid-b"}},
+				},
+			},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "Mixed A", "uid": "uid-a", "type": "mixed", "isDefault": true})
+	srv.AddDatasource(map[string]interface{}{"name": "Mixed B", "uid": "uid-b", "type": "mixed"})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = sc.Take(&TakeConfig{DashUID: "mixed", From: &from, To: &to}); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	// each target is queried against its own datasource, by name or UID
+	expected := map[string]string{"A": "Mixed A", "B": "Mixed B"}
+	if !reflect.DeepEqual(queried, expected) {
+		t.Errorf("Expected targets queried against %v, got %v", expected, queried)
+	}
+}
+
+func TestInvalidTargets(t *testing.T) {
+	var queried []interface{}
+	RegisterFetcher("targets", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+		queried = append(queried, target["refId"])
+		return nil, nil
+	}))
+	defer RegisterFetcher("targets", nil)
+
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("targets", map[string]interface{}{
+		"uid": "targets",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "datasource": "targets", "targets": []interface{}{
+				nil, "A", map[string]interface{}{"refId": "B"},
+			}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "targets", "type": "targets"})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	// targets which aren't objects are skipped rather than panicking
+	if _, err = sc.Take(&TakeConfig{DashUID: "targets", From: &from, To: &to}); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(queried, []interface{}{"B"}) {
+		t.Errorf("Expected only target \"B\" to be queried, got %v", queried)
+	}
+}
+
+func TestPrometheusInstant(t *testing.T) {
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	from, to := time.Unix(0, 0).UTC(), time.Unix(3600, 0).UTC()
+	srv.AddDashboard("stat", map[string]interface{}{
+		"uid": "stat",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "type": "stat", "datasource": "prom", "targets": []interface{}{
+				map[string]interface{}{"refId": "A", "expr": "up", "instant": true},
+			}},
+			map[string]interface{}{"id": 2.0, "type": "graph", "datasource": "prom", "targets": []interface{}{
+				map[string]interface{}{"refId": "A", "expr": "up", "instant": true, "range": true},
+			}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "prom", "type": "prometheus"})
+	srv.AddSeries("up", snapshottest.Series{
+		Points: []snapshottest.Point{{Time: from.Add(time.Minute), Value: 1}, {Time: from.Add(2 * time.Minute), Value: 0}, {Time: to.Add(time.Minute), Value: 1}},
+	})
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = sc.Take(&TakeConfig{DashUID: "stat", From: &from, To: &to}); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	dashboard, _ := srv.Snapshots()[0]["dashboard"].(map[string]interface{})
+	panels, _ := dashboard["panels"].([]interface{})
+	datapoints := func(idx int) []interface{} {
+		panel, _ := panels[idx].(map[string]interface{})
+		data, _ := panel["snapshotData"].([]interface{})
+		if len(data) != 1 {
+			return nil
+		}
+		series, _ := data[0].(map[string]interface{})
+		points, _ := series["datapoints"].([]interface{})
+		return points
+	}
+	// the instant query has the last point before To
+	if points := datapoints(0); !reflect.DeepEqual(points, []interface{}{[]interface{}{0.0, 120000.0}}) {
+		t.Errorf("Expected the instant query's point at 2m, got %v", points)
+	}
+	// while targets with both run the range query
+	if points := datapoints(1); len(points) != 2 {
+		t.Errorf("Expected the range query's 2 points, got %v", points)
+	}
+}
+
+func TestPrometheusChunks(t *testing.T) {
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	day := 24 * time.Hour
+	from := time.Unix(0, 0).UTC()
+	to := from.Add(30 * day)
+	srv.AddSeries("up", snapshottest.Series{
+		Labels: map[string]string{"instance": "a"},
+		Points: []snapshottest.Point{{Time: from, Value: 1}, {Time: from.Add(10 * day), Value: 0}, {Time: from.Add(20 * day), Value: 1}, {Time: to, Value: 0}},
+	})
+	srv.AddSeries("up", snapshottest.Series{
+		Labels: map[string]string{"instance": "b"},
+		Points: []snapshottest.Point{{Time: from.Add(29 * day), Value: 1}},
+	})
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	// a minute's step over a month is 43,200 points, which Prometheus won't
+	// resolve at once
+	r := TimeRange{From: from, To: to}
+	chunks := rangeChunks(r, time.Minute)
+	if len(chunks) != 4 || !chunks[0].Start.Equal(from) || !chunks[3].End.Equal(to) {
+		t.Errorf("Expected 4 chunks from %s to %s, got %+v", from, to, chunks)
+	}
+	for idx := 1; idx < len(chunks); idx++ {
+		if !chunks[idx].Start.Equal(chunks[idx-1].End.Add(time.Minute)) {
+			t.Errorf("Expected chunk %d to start a step after the last, got %+v", idx, chunks)
+		}
+	}
+	data, err := sc.fetchDataPointsPrometheus(context.Background(), map[string]interface{}{"refId": "A", "expr": "up"}, map[string]interface{}{"id": 1.0}, r, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if len(data) != 2 || data[0].Metric["instance"] != "a" || data[1].Metric["instance"] != "b" {
+		t.Fatalf("Expected series \"a\" then \"b\", got %+v", data)
+	}
+	times := []interface{}{}
+	for _, point := range data[0].Datapoints {
+		times = append(times, point[1])
+	}
+	expected := []interface{}{0.0, float64(10 * day / time.Millisecond), float64(20 * day / time.Millisecond), float64(30 * day / time.Millisecond)}
+	if !reflect.DeepEqual(times, expected) {
+		t.Errorf("Expected points at %v, got %v", expected, times)
+	}
+}
+
+func TestSetSnapshotDataTables(t *testing.T) {
+	up := SnapshotData{
+		Metric:     model.Metric{"instance": "a", "job": "node"},
+		Datapoints: [][]interface{}{{1.0, 1000.0}, {0.0, 2000.0}},
+	}
+	down := SnapshotData{
+		Metric:     model.Metric{"instance": "b"},
+		Datapoints: [][]interface{}{{nil, 1000.0}},
+	}
+	var tableTests = []struct {
+		purpose  string
+		panel    string
+		targets  []map[string]interface{}
+		expected []interface{}
+	}{
+		{
+			purpose: "Time series",
+			panel:   "graph",
+			targets: []map[string]interface{}{{"refId": "A"}},
+			expected: []interface{}{
+				SnapshotData{Target: `{instance="a", job="node"}`, Metric: up.Metric, Datapoints: up.Datapoints},
+				SnapshotData{Target: `{instance="b"}`, Metric: down.Metric, Datapoints: down.Datapoints},
+			},
+		},
+		{
+			purpose: "Table format",
+			panel:   "stat",
+			targets: []map[string]interface{}{{"refId": "A", "format": "table"}},
+			expected: []interface{}{map[string]interface{}{
+				"type": "table",
+				"columns": []interface{}{
+					map[string]interface{}{"text": "Time", "type": "time"},
+					map[string]interface{}{"text": "instance"},
+					map[string]interface{}{"text": "job"},
+					map[string]interface{}{"text": "Value"},
+				},
+				"rows": []interface{}{
+					[]interface{}{1000.0, "a", "node", 1.0},
+					[]interface{}{2000.0, "a", "node", 0.0},
+					[]interface{}{1000.0, "b", "", nil},
+				},
+			}},
+		},
+		{
+			purpose: "Instant queries of several targets on a table panel",
+			panel:   "table",
+			targets: []map[string]interface{}{{"refId": "A", "instant": true}, {"refId": "B", "instant": true}},
+			expected: []interface{}{
+				map[string]interface{}{
+					"type": "table",
+					"columns": []interface{}{
+						map[string]interface{}{"text": "Time", "type": "time"},
+						map[string]interface{}{"text": "instance"},
+						map[string]interface{}{"text": "job"},
+						map[string]interface{}{"text": "Value #A"},
+					},
+					"rows": []interface{}{
+						[]interface{}{1000.0, "a", "node", 1.0},
+						[]interface{}{2000.0, "a", "node", 0.0},
+					},
+				},
+				map[string]interface{}{
+					"type": "table",
+					"columns": []interface{}{
+						map[string]interface{}{"text": "Time", "type": "time"},
+						map[string]interface{}{"text": "instance"},
+						map[string]interface{}{"text": "Value #B"},
+					},
+					"rows": []interface{}{
+						[]interface{}{1000.0, "b", nil},
+					},
+				},
+			},
+		},
+	}
+	sc := &SnapClient{}
+	for _, tt := range tableTests {
+		panel := map[string]interface{}{"type": tt.panel, "targets": []interface{}{}}
+		var queries []*panelQuery
+		for idx, target := range tt.targets {
+			data := []SnapshotData{up, down}
+			if len(tt.targets) > 1 {
+				data = data[idx : idx+1]
+			}
+			queries = append(queries, &panelQuery{target: target, dataPoints: data})
+		}
+		sc.setSnapshotData(panel, queries)
+		if !reflect.DeepEqual(panel["snapshotData"], tt.expected) {
+			t.Errorf("Test \"%s\" expected %v, got %v", tt.purpose, tt.expected, panel["snapshotData"])
+		}
+	}
+}
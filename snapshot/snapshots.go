@@ -0,0 +1,298 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SnapshotSummary describes a snapshot stored on the snapshot host, as
+// returned by List
+type SnapshotSummary struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Key         string    `json:"key"`
+	External    bool      `json:"external"`
+	ExternalURL string    `json:"externalUrl"`
+	Expires     time.Time `json:"expires"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// List is for listing the snapshots on the snapshot host which the snapshot
+// API key can see
+func (sc *SnapClient) List() ([]SnapshotSummary, error) {
+	return sc.ListWithContext(context.Background())
+}
+
+// ListWithContext is for listing snapshots, using ctx for the request made to
+// the snapshot host
+func (sc *SnapClient) ListWithContext(ctx context.Context) ([]SnapshotSummary, error) {
+	body, status, err := sc.snapshotRequest(ctx, "GET", "api/snapshots", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code when listing snapshots: %d", status)
+	}
+	var snapshots []SnapshotSummary
+	if err = json.Unmarshal(body, &snapshots); err != nil {
+		return nil, fmt.Errorf("Could not decode snapshot list json: %s", err.Error())
+	}
+	return snapshots, nil
+}
+
+// Delete is for deleting a snapshot from the snapshot host, by either its key
+// or its delete key
+func (sc *SnapClient) Delete(key string) error {
+	return sc.DeleteWithContext(context.Background(), key)
+}
+
+// DeleteWithContext is for deleting a snapshot, using ctx for the requests
+// made to the snapshot host
+func (sc *SnapClient) DeleteWithContext(ctx context.Context, key string) error {
+	if len(key) == 0 {
+		return errors.New("Missing snapshot key")
+	}
+
+	// Deleting by key requires the API key to own the snapshot or be an admin
+	_, status, err := sc.snapshotRequest(ctx, "DELETE", "api/snapshots/"+key, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("Unexpected status code when deleting snapshot: %d", status)
+	}
+
+	// otherwise it may be a delete key
+	_, status, err = sc.snapshotRequest(ctx, "GET", "api/snapshots-delete/"+key, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return fmt.Errorf("No snapshot found with key or delete key %q", key)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("Unexpected status code when deleting snapshot: %d", status)
+	}
+	return nil
+}
+
+// Export is for reading a snapshot from the snapshot host by its key, as the
+// JSON Grafana's snapshot API returns, which Upload can post again
+func (sc *SnapClient) Export(key string) ([]byte, error) {
+	return sc.ExportWithContext(context.Background(), key)
+}
+
+// ExportWithContext is for reading a snapshot, using ctx for the request made
+// to the snapshot host
+func (sc *SnapClient) ExportWithContext(ctx context.Context, key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("Missing snapshot key")
+	}
+	body, status, err := sc.snapshotRequest(ctx, "GET", "api/snapshots/"+key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("No snapshot found with key %q", key)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code when exporting snapshot: %d", status)
+	}
+	return body, nil
+}
+
+// deleteExisting deletes any snapshot with the key or delete key, so that a
+// new snapshot can take them
+func (sc *SnapClient) deleteExisting(ctx context.Context, key, deleteKey string) error {
+	if len(key) > 0 {
+		_, status, err := sc.snapshotRequest(ctx, "DELETE", "api/snapshots/"+key, nil)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK && status != http.StatusNotFound {
+			return fmt.Errorf("Unexpected status code when replacing snapshot %q: %d", key, status)
+		}
+	}
+	if len(deleteKey) > 0 {
+		_, status, err := sc.snapshotRequest(ctx, "GET", "api/snapshots-delete/"+deleteKey, nil)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK && status != http.StatusNotFound {
+			return fmt.Errorf("Unexpected status code when replacing snapshot with delete key %q: %d", deleteKey, status)
+		}
+	}
+	return nil
+}
+
+// Prune is for deleting the snapshots on the snapshot host selected by the
+// PruneConfig. It returns the snapshots which were deleted, or with DryRun set
+// those which would have been.
+func (sc *SnapClient) Prune(config *PruneConfig) ([]SnapshotSummary, error) {
+	return sc.PruneWithContext(context.Background(), config)
+}
+
+// PruneWithContext is for pruning snapshots, using ctx for the requests made
+// to the snapshot host
+func (sc *SnapClient) PruneWithContext(ctx context.Context, config *PruneConfig) ([]SnapshotSummary, error) {
+	c, err := processPruneConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.LedgerPath) > 0 {
+		return sc.pruneLedger(ctx, c)
+	}
+	snapshots, err := sc.ListWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	selected := pruneSelect(snapshots, c, time.Now())
+	if c.DryRun {
+		return selected, nil
+	}
+	for idx, s := range selected {
+		if err = sc.DeleteWithContext(ctx, s.Key); err != nil {
+			return selected[:idx], fmt.Errorf("Failed to delete snapshot %q: %s", s.Key, err.Error())
+		}
+	}
+	return selected, nil
+}
+
+// pruneSelect returns the snapshots matching all of the prune criteria
+func pruneSelect(snapshots []SnapshotSummary, c *PruneConfig, now time.Time) []SnapshotSummary {
+	var selected []SnapshotSummary
+	for _, s := range snapshots {
+		if len(c.NamePrefix) > 0 && !strings.HasPrefix(s.Name, c.NamePrefix) {
+			continue
+		}
+		if c.OlderThan > 0 && !s.Created.Before(now.Add(-c.OlderThan)) {
+			continue
+		}
+		if c.Expired && (s.Expires.IsZero() || !s.Expires.Before(now)) {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	return selected
+}
+
+// Upload is for posting a snapshot saved earlier, either with
+// TakeConfig.OutputPath or exported from Grafana's snapshot API, to the
+// snapshot host. The snapshot's name and remaining expiry are kept.
+func (sc *SnapClient) Upload(data []byte) (*Snapshot, error) {
+	return sc.UploadWithContext(context.Background(), data)
+}
+
+// UploadWithContext is for uploading a saved snapshot, using ctx for the
+// request made to the snapshot host
+func (sc *SnapClient) UploadWithContext(ctx context.Context, data []byte) (*Snapshot, error) {
+	snapshot, err := savedSnapshot(data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	getBody := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	posted, err := sc.postSnapshot(ctx, getBody, int64(len(b)), false)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := snapshot["name"].(string)
+	expires, _ := snapshot["expires"].(int64)
+	sc.record(posted, name, time.Duration(expires)*time.Second)
+	return posted, nil
+}
+
+// savedSnapshot converts a saved snapshot into the body for creating it. Files
+// written by Take hold the body itself, while Grafana's snapshot API returns
+// the dashboard with a "meta" object holding the expiry time.
+func savedSnapshot(data []byte, now time.Time) (map[string]interface{}, error) {
+	var saved map[string]interface{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("Could not decode snapshot json: %s", err.Error())
+	}
+	dashboard, ok := saved["dashboard"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Snapshot json has no \"dashboard\"")
+	}
+
+	snapshot := map[string]interface{}{"dashboard": dashboard}
+	// name, defaulting to the dashboard title
+	if name, ok := saved["name"].(string); ok {
+		snapshot["name"] = name
+	} else if title, ok := dashboard["title"].(string); ok {
+		snapshot["name"] = title
+	}
+	// expiry in seconds, zero for never
+	if expires, ok := saved["expires"].(float64); ok {
+		snapshot["expires"] = int64(expires)
+	} else if meta, ok := saved["meta"].(map[string]interface{}); ok {
+		if s, ok := meta["expires"].(string); ok {
+			expires, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse snapshot expiry: %s", err.Error())
+			}
+			remaining := expires.Sub(now)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("Snapshot expired at %s", s)
+			}
+			// Grafana saves snapshots which never expire with an expiry
+			// 50 years away
+			if remaining < 10*365*24*time.Hour {
+				snapshot["expires"] = int64(remaining / time.Second)
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// snapshotRequest makes an authenticated request to the snapshot host's API,
+// returning the response body and status code
+func (sc *SnapClient) snapshotRequest(ctx context.Context, method, path string, body io.Reader) ([]byte, int, error) {
+	ctx, cancel := withTimeout(ctx, sc.config.DashboardTimeout)
+	defer cancel()
+
+	reqURL := *sc.config.SnapshotAddr
+	reqURL.Path = reqURL.Path + path
+
+	req, err := http.NewRequest(method, reqURL.String(), body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	if req, err = sc.snapshotAuth(req); err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = unauthorized("The snapshot host", resp.StatusCode); err != nil {
+		return nil, 0, err
+	}
+	return respBody, resp.StatusCode, nil
+}
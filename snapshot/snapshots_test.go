@@ -0,0 +1,303 @@
+package snapshot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestPruneSelect(t *testing.T) {
+	// some vars
+	now := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	snapshots := []SnapshotSummary{
+		{Key: "old-daily", Name: "daily 2017-01-01", Created: now.Add(-35 * day), Expires: now.Add(day)},
+		{Key: "new-daily", Name: "daily 2017-02-04", Created: now.Add(-day), Expires: now.Add(day)},
+		{Key: "old-adhoc", Name: "incident", Created: now.Add(-40 * day), Expires: now.Add(-day)},
+	}
+	// configs to test
+	var pruneTests = []struct {
+		purpose  string
+		config   *PruneConfig
+		expected []string
+	}{
+		{
+			purpose:  "Older than",
+			config:   &PruneConfig{OlderThan: 30 * day},
+			expected: []string{"old-daily", "old-adhoc"},
+		},
+		{
+			purpose:  "Name prefix",
+			config:   &PruneConfig{NamePrefix: "daily "},
+			expected: []string{"old-daily", "new-daily"},
+		},
+		{
+			purpose:  "Older than and name prefix",
+			config:   &PruneConfig{OlderThan: 30 * day, NamePrefix: "daily "},
+			expected: []string{"old-daily"},
+		},
+		{
+			purpose:  "Expired",
+			config:   &PruneConfig{Expired: true},
+			expected: []string{"old-adhoc"},
+		},
+	}
+	// test
+	for _, pt := range pruneTests {
+		var out []string
+		for _, s := range pruneSelect(snapshots, pt.config, now) {
+			out = append(out, s.Key)
+		}
+		if !reflect.DeepEqual(out, pt.expected) {
+			t.Errorf("Test \"%s\" expected %v, got %v", pt.purpose, pt.expected, out)
+		}
+	}
+}
+
+func TestSavedSnapshot(t *testing.T) {
+	// some vars
+	now := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.UTC)
+	// files to test
+	var savedTests = []struct {
+		purpose  string
+		data     string
+		expected map[string]interface{}
+		valid    bool
+	}{
+		{
+			purpose: "Written by Take",
+			data:    `{"dashboard": {"title": "Dash"}, "name": "My Snapshot", "expires": 3600}`,
+			expected: map[string]interface{}{
+				"dashboard": map[string]interface{}{"title": "Dash"},
+				"name":      "My Snapshot",
+				"expires":   int64(3600),
+			},
+			valid: true,
+		},
+		{
+			purpose: "Exported from Grafana",
+			data:    `{"dashboard": {"title": "Dash"}, "meta": {"isSnapshot": true, "expires": "2017-02-05T07:00:00Z"}}`,
+			expected: map[string]interface{}{
+				"dashboard": map[string]interface{}{"title": "Dash"},
+				"name":      "Dash",
+				"expires":   int64(3600),
+			},
+			valid: true,
+		},
+		{
+			purpose: "Exported from Grafana, never expires",
+			data:    `{"dashboard": {"title": "Dash"}, "meta": {"expires": "2067-02-05T06:00:00Z"}}`,
+			expected: map[string]interface{}{
+				"dashboard": map[string]interface{}{"title": "Dash"},
+				"name":      "Dash",
+			},
+			valid: true,
+		},
+		{
+			purpose: "Already expired",
+			data:    `{"dashboard": {"title": "Dash"}, "meta": {"expires": "2017-02-05T05:00:00Z"}}`,
+			valid:   false,
+		},
+		{
+			purpose: "Not a snapshot",
+			data:    `{"title": "Dash"}`,
+			valid:   false,
+		},
+	}
+	// test
+	for _, st := range savedTests {
+		out, err := savedSnapshot([]byte(st.data), now)
+		if st.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", st.purpose, err.Error())
+		} else if !st.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", st.purpose)
+		} else if !reflect.DeepEqual(out, st.expected) {
+			t.Errorf("Test \"%s\" expected %v, got %v", st.purpose, st.expected, out)
+		}
+	}
+}
+
+func TestDeleteExisting(t *testing.T) {
+	var requests []string
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/api/snapshots/nightly", "/api/snapshots-delete/nightly-delete":
+			w.Write([]byte(`{"message": "Snapshot deleted"}`))
+		case "/api/snapshots/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer host.Close()
+	hostURL, _ := url.Parse(host.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: hostURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	deleteTests := []struct {
+		purpose   string
+		key       string
+		deleteKey string
+		valid     bool
+		expected  []string
+	}{
+		{
+			purpose:  "No keys",
+			valid:    true,
+			expected: nil,
+		},
+		{
+			purpose:   "Existing snapshot",
+			key:       "nightly",
+			deleteKey: "nightly-delete",
+			valid:     true,
+			expected:  []string{"DELETE /api/snapshots/nightly", "GET /api/snapshots-delete/nightly-delete"},
+		},
+		{
+			purpose:  "No existing snapshot",
+			key:      "new",
+			valid:    true,
+			expected: []string{"DELETE /api/snapshots/new"},
+		},
+		{
+			purpose:  "Snapshot owned by another user",
+			key:      "forbidden",
+			valid:    false,
+			expected: []string{"DELETE /api/snapshots/forbidden"},
+		},
+	}
+	// test
+	for _, dt := range deleteTests {
+		requests = nil
+		err := sc.deleteExisting(context.Background(), dt.key, dt.deleteKey)
+		if dt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", dt.purpose, err.Error())
+		} else if !dt.valid && err == nil {
+			t.Errorf("Test \"%s\" expected to fail", dt.purpose)
+		}
+		if !reflect.DeepEqual(requests, dt.expected) {
+			t.Errorf("Test \"%s\" expected requests %v, got %v", dt.purpose, dt.expected, requests)
+		}
+	}
+}
+
+func TestReplaceSnapshot(t *testing.T) {
+	var requests []string
+	postStatus := http.StatusOK
+	snapHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.ToUpper(r.Method)
+		requests = append(requests, method+" "+r.URL.Path)
+		switch {
+		case method == "DELETE" && r.URL.Path == "/api/snapshots/nightly":
+			w.Write([]byte(`{"message": "Snapshot deleted"}`))
+		case method == "POST" && r.URL.Path == "/api/snapshots":
+			w.WriteHeader(postStatus)
+			w.Write([]byte(`{"key": "nightly", "url": "http://grafana/dashboard/snapshot/nightly"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer snapHost.Close()
+	snapURL, _ := url.Parse(snapHost.URL + "/")
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("nightly", map[string]interface{}{"uid": "nightly", "panels": []interface{}{}})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey, SnapshotAddr: snapURL, SnapshotAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	replaceTests := []struct {
+		purpose    string
+		config     TakeConfig
+		postStatus int
+		valid      bool
+		expected   []string
+	}{
+		{
+			purpose:  "Replaced",
+			config:   TakeConfig{Key: "nightly"},
+			valid:    true,
+			expected: []string{"DELETE /api/snapshots/nightly", "POST /api/snapshots"},
+		},
+		{
+			purpose:  "Over the payload limit",
+			config:   TakeConfig{Key: "nightly", MaxPayloadBytes: 10},
+			valid:    false,
+			expected: nil,
+		},
+		{
+			purpose: "Can't be encoded",
+			config: TakeConfig{Key: "nightly", MutateSnapshot: func(snapshot map[string]interface{}) error {
+				snapshot["unencodable"] = func() {}
+				return nil
+			}},
+			valid:    false,
+			expected: nil,
+		},
+		{
+			// the old snapshot is gone, which is logged
+			purpose:    "Post fails",
+			config:     TakeConfig{Key: "nightly"},
+			postStatus: http.StatusInternalServerError,
+			valid:      false,
+			expected:   []string{"DELETE /api/snapshots/nightly", "POST /api/snapshots"},
+		},
+	}
+	// test
+	for _, rt := range replaceTests {
+		requests = nil
+		postStatus = http.StatusOK
+		if rt.postStatus != 0 {
+			postStatus = rt.postStatus
+		}
+		config := rt.config
+		config.DashUID, config.From, config.To = "nightly", &from, &to
+		_, err := sc.Take(&config)
+		if rt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", rt.purpose, err.Error())
+		} else if !rt.valid && err == nil {
+			t.Errorf("Test \"%s\" expected to fail", rt.purpose)
+		}
+		if !reflect.DeepEqual(requests, rt.expected) {
+			t.Errorf("Test \"%s\" expected requests %v, got %v", rt.purpose, rt.expected, requests)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/snapshots/nightly" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"dashboard": {"title": "Nightly"}, "meta": {"isSnapshot": true}}`))
+	}))
+	defer host.Close()
+	hostURL, _ := url.Parse(host.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: hostURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	data, err := sc.Export("nightly")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = savedSnapshot(data, time.Now()); err != nil {
+		t.Errorf("Expected an exported snapshot to be uploadable, got: %s", err.Error())
+	}
+	if _, err = sc.Export("missing"); err == nil {
+		t.Errorf("Expected exporting a missing snapshot to fail")
+	}
+}
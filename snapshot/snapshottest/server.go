@@ -0,0 +1,353 @@
+// Package snapshottest provides a fake Grafana for testing code which takes
+// snapshots, like net/http/httptest does for HTTP servers. It serves canned
+// dashboards, library panels, datasources, annotations and Prometheus query
+// results, and records the snapshots posted to it.
+package snapshottest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is the API key the Server accepts, unless its own is changed
+const APIKey = "snapshottest"
+
+// Server is a fake Grafana, which is also its own snapshot host
+type Server struct {
+	*httptest.Server
+	// APIKey, if set, is the API key requests must be authenticated with.
+	// Defaults to APIKey.
+	APIKey string
+	// Version, if set, is the Grafana version the Server reports from
+	// /api/health and /api/frontend/settings. From version 8, dashboards
+	// can't be got by slug, like Grafana.
+	Version string
+
+	mu          sync.Mutex
+	dashboards  []map[string]interface{}
+	slugs       []string
+	datasources []map[string]interface{}
+	annotations []map[string]interface{}
+	library     map[string]map[string]interface{}
+	series      map[string][]Series
+	snapshots   []map[string]interface{}
+}
+
+// Series is a Prometheus time series returned for a query
+type Series struct {
+	Labels map[string]string
+	Points []Point
+}
+
+// Point is a data point of a Series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{APIKey: APIKey, library: make(map[string]map[string]interface{}), series: make(map[string][]Series)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Addr returns the Server's address, for a snapshot Config's GrafanaAddr
+func (s *Server) Addr() *url.URL {
+	addr, _ := url.Parse(s.URL + "/")
+	return addr
+}
+
+// AddDashboard serves a dashboard by its "uid" and slug. Its "id" is set if
+// it doesn't have one.
+func (s *Server) AddDashboard(slug string, dashboard map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := dashboard["id"]; !ok {
+		dashboard["id"] = float64(len(s.dashboards) + 1)
+	}
+	s.dashboards = append(s.dashboards, dashboard)
+	s.slugs = append(s.slugs, slug)
+}
+
+// AddDatasource serves a datasource, which needs a "name" and "type". Its "id"
+// is set if it doesn't have one.
+func (s *Server) AddDatasource(datasource map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := datasource["id"]; !ok {
+		datasource["id"] = float64(len(s.datasources) + 1)
+	}
+	s.datasources = append(s.datasources, datasource)
+}
+
+// AddAnnotation serves an annotation, which is found by queries whose time
+// range includes its "time", in epoch milliseconds, which are for its
+// "dashboardId" or "dashboardUID", if it has one, and whose tags it has all
+// of. Annotations with an "alertId" are alert annotations.
+func (s *Server) AddAnnotation(annotation map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.annotations = append(s.annotations, annotation)
+}
+
+// AddLibraryPanel serves a library panel with the UID, whose model is the
+// panel its dashboards show
+func (s *Server) AddLibraryPanel(uid string, model map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.library[uid] = model
+}
+
+// AddSeries serves a series for a Prometheus query of expr, through any
+// datasource's proxy. Points outside the query's time range are left out,
+// range queries of more than 11,000 steps fail as they do in Prometheus, and
+// instant queries get the last point at or before their time.
+func (s *Server) AddSeries(expr string, series Series) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.series[expr] = append(s.series[expr], series)
+}
+
+// Snapshots returns the snapshots posted to the Server, in order
+func (s *Server) Snapshots() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}{}, s.snapshots...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(s.APIKey) > 0 && r.Header.Get("Authorization") != "Bearer "+s.APIKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"message": "Invalid API key"})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/dashboards/uid/"):
+		s.serveDashboard(w, "uid", strings.TrimPrefix(path, "/api/dashboards/uid/"))
+	case strings.HasPrefix(path, "/api/dashboards/db/") && !s.atLeast(8):
+		s.serveDashboard(w, "slug", strings.TrimPrefix(path, "/api/dashboards/db/"))
+	case (path == "/api/health" || path == "/api/frontend/settings") && len(s.Version) > 0:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"version":   s.Version,
+			"buildInfo": map[string]interface{}{"version": s.Version},
+		})
+	case path == "/api/search":
+		s.serveSearch(w, r)
+	case path == "/api/datasources":
+		writeJSON(w, http.StatusOK, s.datasources)
+	case strings.HasPrefix(path, "/api/library-elements/"):
+		s.serveLibraryElement(w, strings.TrimPrefix(path, "/api/library-elements/"))
+	case path == "/api/annotations":
+		s.serveAnnotations(w, r)
+	case strings.HasPrefix(path, "/api/datasources/proxy/") && strings.HasSuffix(path, "/api/v1/query_range"):
+		s.serveQueryRange(w, r)
+	case strings.HasPrefix(path, "/api/datasources/proxy/") && strings.HasSuffix(path, "/api/v1/query"):
+		s.serveQuery(w, r)
+	case path == "/api/snapshots" && strings.EqualFold(r.Method, "POST"):
+		s.serveSnapshot(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"message": "Not found"})
+	}
+}
+
+func (s *Server) serveDashboard(w http.ResponseWriter, by, id string) {
+	for idx, dashboard := range s.dashboards {
+		if (by == "uid" && dashboard["uid"] == id) || (by == "slug" && s.slugs[idx] == id) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"dashboard": dashboard,
+				"meta":      map[string]interface{}{"slug": s.slugs[idx]},
+			})
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{"message": "Dashboard not found"})
+}
+
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	results := []interface{}{}
+	for idx, dashboard := range s.dashboards {
+		results = append(results, map[string]interface{}{
+			"id":    dashboard["id"],
+			"uid":   dashboard["uid"],
+			"title": dashboard["title"],
+			"uri":   "db/" + s.slugs[idx],
+			"url":   fmt.Sprintf("/d/%v/%s", dashboard["uid"], s.slugs[idx]),
+			"type":  "dash-db",
+		})
+	}
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	if limit > 0 && page > 0 {
+		start, end := (page-1)*limit, page*limit
+		if start > len(results) {
+			start = len(results)
+		}
+		if end > len(results) {
+			end = len(results)
+		}
+		results = results[start:end]
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (s *Server) serveLibraryElement(w http.ResponseWriter, uid string) {
+	model, ok := s.library[uid]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"message": "library element could not be found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result": map[string]interface{}{"uid": uid, "name": model["title"], "kind": 1, "model": model},
+	})
+}
+
+func (s *Server) serveAnnotations(w http.ResponseWriter, r *http.Request) {
+	from, _ := strconv.ParseFloat(r.FormValue("from"), 64)
+	to, _ := strconv.ParseFloat(r.FormValue("to"), 64)
+	found := []interface{}{}
+	for _, annotation := range s.annotations {
+		at, _ := annotation["time"].(float64)
+		if (from > 0 && at < from) || (to > 0 && at > to) {
+			continue
+		}
+		if id := r.FormValue("dashboardId"); len(id) > 0 && annotation["dashboardId"] != nil && strconv.FormatFloat(annotation["dashboardId"].(float64), 'f', -1, 64) != id {
+			continue
+		}
+		if uid := r.FormValue("dashboardUID"); len(uid) > 0 && annotation["dashboardUID"] != nil && annotation["dashboardUID"] != uid {
+			continue
+		}
+		if _, alert := annotation["alertId"]; alert != (r.FormValue("type") == "alert") && len(r.FormValue("type")) > 0 {
+			continue
+		}
+		tags, _ := annotation["tags"].([]interface{})
+		hasAll := true
+		for _, tag := range r.Form["tags"] {
+			has := false
+			for _, t := range tags {
+				has = has || t == tag
+			}
+			hasAll = hasAll && has
+		}
+		if hasAll {
+			found = append(found, annotation)
+		}
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+func (s *Server) serveQueryRange(w http.ResponseWriter, r *http.Request) {
+	start, err := time.Parse(time.RFC3339Nano, r.FormValue("start"))
+	end, endErr := time.Parse(time.RFC3339Nano, r.FormValue("end"))
+	if err != nil || endErr != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": "error", "errorType": "bad_data", "error": "invalid time range"})
+		return
+	}
+	// as Prometheus, resolving a series to more than 11,000 points fails
+	if step, err := strconv.ParseFloat(r.FormValue("step"), 64); err == nil && step > 0 && end.Sub(start).Seconds()/step > maxPoints {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": "error", "errorType": "bad_data", "error": "exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)"})
+		return
+	}
+	result := []interface{}{}
+	for _, series := range s.series[r.FormValue("query")] {
+		values := []interface{}{}
+		for _, point := range series.Points {
+			if point.Time.Before(start) || point.Time.After(end) {
+				continue
+			}
+			values = append(values, []interface{}{float64(point.Time.UnixNano()) / 1e9, strconv.FormatFloat(point.Value, 'f', -1, 64)})
+		}
+		metric := series.Labels
+		if metric == nil {
+			metric = map[string]string{}
+		}
+		result = append(result, map[string]interface{}{"metric": metric, "values": values})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "matrix", "result": result},
+	})
+}
+
+// maxPoints is the most points Prometheus resolves a series to
+const maxPoints = 11000
+
+// serveQuery serves instant queries with each series' last point at or
+// before the query's time
+func (s *Server) serveQuery(w http.ResponseWriter, r *http.Request) {
+	at, err := time.Parse(time.RFC3339Nano, r.FormValue("time"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": "error", "errorType": "bad_data", "error": "invalid time"})
+		return
+	}
+	result := []interface{}{}
+	for _, series := range s.series[r.FormValue("query")] {
+		var last *Point
+		for idx, point := range series.Points {
+			if !point.Time.After(at) && (last == nil || point.Time.After(last.Time)) {
+				last = &series.Points[idx]
+			}
+		}
+		if last == nil {
+			continue
+		}
+		metric := series.Labels
+		if metric == nil {
+			metric = map[string]string{}
+		}
+		result = append(result, map[string]interface{}{
+			"metric": metric,
+			"value":  []interface{}{float64(last.Time.UnixNano()) / 1e9, strconv.FormatFloat(last.Value, 'f', -1, 64)},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "vector", "result": result},
+	})
+}
+
+func (s *Server) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	var snapshot map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid snapshot: " + err.Error()})
+		return
+	}
+	s.snapshots = append(s.snapshots, snapshot)
+	key, _ := snapshot["key"].(string)
+	if len(key) == 0 {
+		key = "snapshot-" + strconv.Itoa(len(s.snapshots))
+	}
+	deleteKey, _ := snapshot["deleteKey"].(string)
+	if len(deleteKey) == 0 {
+		deleteKey = "delete-" + key
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":       key,
+		"deleteKey": deleteKey,
+		"url":       s.URL + "/dashboard/snapshot/" + key,
+		"deleteUrl": s.URL + "/api/snapshots-delete/" + deleteKey,
+	})
+}
+
+// atLeast reports whether the Server's Version is at least major
+func (s *Server) atLeast(major int) bool {
+	v, err := strconv.Atoi(strings.SplitN(s.Version, ".", 2)[0])
+	return err == nil && v >= major
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
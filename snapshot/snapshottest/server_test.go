@@ -0,0 +1,61 @@
+package snapshottest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot"
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestServer(t *testing.T) {
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+
+	from, to := time.Unix(0, 0).UTC(), time.Unix(3600, 0).UTC()
+	srv.AddDashboard("test-dash", map[string]interface{}{
+		"uid":   "test",
+		"title": "Test dashboard",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "title": "Up", "datasource": "prom", "targets": []interface{}{
+				map[string]interface{}{"refId": "A", "expr": "up"},
+			}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "prom", "type": "prometheus", "isDefault": true})
+	srv.AddSeries("up", snapshottest.Series{
+		Labels: map[string]string{"instance": "a"},
+		Points: []snapshottest.Point{{Time: from.Add(time.Minute), Value: 1}, {Time: to.Add(time.Minute), Value: 0}},
+	})
+
+	sc, err := snapshot.NewSnapClient(&snapshot.Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	snap, err := sc.Take(&snapshot.TakeConfig{DashUID: "test", From: &from, To: &to})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if snap.Panels != 1 || snap.Datapoints != 1 {
+		t.Errorf("Expected 1 panel with 1 data point, got %d with %d", snap.Panels, snap.Datapoints)
+	}
+	if snap.URL != srv.URL+"/dashboard/snapshot/snapshot-1" {
+		t.Errorf("Expected the snapshot to be served by the Server, got %q", snap.URL)
+	}
+	posted := srv.Snapshots()
+	if len(posted) != 1 {
+		t.Fatalf("Expected 1 snapshot to be posted, got %d", len(posted))
+	}
+	dashboard, _ := posted[0]["dashboard"].(map[string]interface{})
+	if dashboard["title"] != "Test dashboard" {
+		t.Errorf("Expected the dashboard to be posted, got %v", dashboard)
+	}
+
+	if _, err = sc.Take(&snapshot.TakeConfig{DashUID: "missing", From: &from, To: &to}); err == nil {
+		t.Error("Expected a missing dashboard to fail")
+	}
+	srv.APIKey = "other"
+	if _, err = sc.Take(&snapshot.TakeConfig{DashUID: "test", From: &from, To: &to}); err == nil {
+		t.Error("Expected an invalid API key to fail")
+	}
+}
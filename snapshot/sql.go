@@ -0,0 +1,164 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sqlMacroRe = regexp.MustCompile(`\$__(\w+)\(([^\)]*)\)`)
+
+func (sc *SnapClient) fetchDataPointsSQL(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	rawSQL, _ := target["rawSql"].(string)
+	if len(strings.TrimSpace(rawSQL)) == 0 {
+		return nil, errors.New("SQL target has no rawSql")
+	}
+	format, _ := target["format"].(string)
+	if len(format) == 0 {
+		format = "time_series"
+	}
+	if format != "time_series" {
+		return nil, fmt.Errorf("Unsupported SQL target format: %q, only \"time_series\" is supported", format)
+	}
+	refID, _ := target["refId"].(string)
+	if len(refID) == 0 {
+		refID = "A"
+	}
+
+	dsType, _ := datasource["type"].(string)
+	expanded, err := sqlExpandMacros(rawSQL, dsType, r.From, r.To, step)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := sc.tsdbQuery(ctx, r, datasource, step, []map[string]interface{}{
+		{
+			"refId":  refID,
+			"rawSql": expanded,
+			"format": format,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tsdbToSnapshotData(results[refID]), nil
+}
+
+// sqlExpandMacros expands Grafana's SQL time macros ($__timeFilter(col),
+// $__timeGroup(col, interval) etc.) for the given dialect and time range
+func sqlExpandMacros(rawSQL, dialect string, from, to time.Time, step time.Duration) (string, error) {
+	mysql := dialect == "mysql"
+	var expandErr error
+	expanded := sqlMacroRe.ReplaceAllStringFunc(rawSQL, func(match string) string {
+		groups := sqlMacroRe.FindStringSubmatch(match)
+		name := groups[1]
+		var args []string
+		for _, arg := range strings.Split(groups[2], ",") {
+			if arg = strings.TrimSpace(arg); len(arg) > 0 {
+				args = append(args, arg)
+			}
+		}
+		requireArgs := func(n int) bool {
+			if len(args) < n {
+				expandErr = fmt.Errorf("Macro %s needs %d argument(s), got %d", name, n, len(args))
+				return false
+			}
+			return true
+		}
+
+		switch name {
+		case "time":
+			if !requireArgs(1) {
+				return match
+			}
+			if mysql {
+				return "UNIX_TIMESTAMP(" + args[0] + ") as time_sec"
+			}
+			return args[0] + " AS \"time\""
+		case "timeEpoch":
+			if !requireArgs(1) {
+				return match
+			}
+			if mysql {
+				return "UNIX_TIMESTAMP(" + args[0] + ") as time_sec"
+			}
+			return "extract(epoch from " + args[0] + ") as \"time\""
+		case "timeFilter":
+			if !requireArgs(1) {
+				return match
+			}
+			return args[0] + " BETWEEN " + sqlTime(from, mysql) + " AND " + sqlTime(to, mysql)
+		case "timeFrom":
+			return sqlTime(from, mysql)
+		case "timeTo":
+			return sqlTime(to, mysql)
+		case "timeGroup", "timeGroupAlias":
+			if !requireArgs(2) {
+				return match
+			}
+			interval, err := sqlInterval(args[1], step)
+			if err != nil {
+				expandErr = err
+				return match
+			}
+			var group string
+			if mysql {
+				group = fmt.Sprintf("UNIX_TIMESTAMP(%s) DIV %d * %d", args[0], interval, interval)
+			} else {
+				group = fmt.Sprintf("floor(extract(epoch from %s)/%d)*%d", args[0], interval, interval)
+			}
+			if name == "timeGroupAlias" {
+				group = group + " AS \"time\""
+			}
+			return group
+		case "unixEpochFilter":
+			if !requireArgs(1) {
+				return match
+			}
+			return fmt.Sprintf("%s >= %d AND %s <= %d", args[0], from.Unix(), args[0], to.Unix())
+		case "unixEpochFrom":
+			return strconv.FormatInt(from.Unix(), 10)
+		case "unixEpochTo":
+			return strconv.FormatInt(to.Unix(), 10)
+		}
+		// leave unknown macros for Grafana to handle
+		return match
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	// non function macros
+	expanded = strings.Replace(expanded, "$__interval_ms", strconv.FormatInt(int64(step/time.Millisecond), 10), -1)
+	expanded = strings.Replace(expanded, "$__interval", influxDuration(step), -1)
+	return expanded, nil
+}
+
+// sqlTime renders a timestamp literal for the dialect
+func sqlTime(t time.Time, mysql bool) string {
+	if mysql {
+		return "FROM_UNIXTIME(" + strconv.FormatInt(t.Unix(), 10) + ")"
+	}
+	return "'" + t.UTC().Format(time.RFC3339) + "'"
+}
+
+// sqlInterval parses the interval argument of $__timeGroup into whole
+// seconds, where "auto" and "$__interval" mean the panel step
+func sqlInterval(arg string, step time.Duration) (int64, error) {
+	arg = strings.Trim(arg, "'\"")
+	if arg == "auto" || arg == "$__interval" {
+		if step < time.Second {
+			return 1, nil
+		}
+		return int64(step / time.Second), nil
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid $__timeGroup interval %q: %s", arg, err.Error())
+	}
+	return int64(d.Seconds()), nil
+}
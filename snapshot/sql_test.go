@@ -0,0 +1,59 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSQLExpandMacros(t *testing.T) {
+	// some vars
+	from := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.UTC)
+	to := time.Date(2017, time.February, 05, 12, 0, 0, 0, time.UTC)
+	// queries to test
+	var macroTests = []struct {
+		purpose  string
+		dialect  string
+		in       string
+		expected string
+		valid    bool
+	}{
+		{
+			purpose:  "Postgres time series",
+			dialect:  "postgres",
+			in:       "SELECT $__timeGroupAlias(ts, '5m'), avg(v) FROM t WHERE $__timeFilter(ts) GROUP BY 1",
+			expected: "SELECT floor(extract(epoch from ts)/300)*300 AS \"time\", avg(v) FROM t WHERE ts BETWEEN '2017-02-05T06:00:00Z' AND '2017-02-05T12:00:00Z' GROUP BY 1",
+			valid:    true,
+		},
+		{
+			purpose:  "MySQL time series with auto interval",
+			dialect:  "mysql",
+			in:       "SELECT $__timeGroup(ts, $__interval) as time_sec, v FROM t WHERE $__timeFilter(ts)",
+			expected: "SELECT UNIX_TIMESTAMP(ts) DIV 30 * 30 as time_sec, v FROM t WHERE ts BETWEEN FROM_UNIXTIME(1486274400) AND FROM_UNIXTIME(1486296000)",
+			valid:    true,
+		},
+		{
+			purpose:  "Epoch filter",
+			dialect:  "postgres",
+			in:       "WHERE $__unixEpochFilter(ts) LIMIT $__interval_ms",
+			expected: "WHERE ts >= 1486274400 AND ts <= 1486296000 LIMIT 30000",
+			valid:    true,
+		},
+		{
+			purpose: "Missing macro argument",
+			dialect: "postgres",
+			in:      "WHERE $__timeFilter()",
+			valid:   false,
+		},
+	}
+	// test
+	for _, mt := range macroTests {
+		out, err := sqlExpandMacros(mt.in, mt.dialect, from, to, 30*time.Second)
+		if mt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", mt.purpose, err.Error())
+		} else if !mt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", mt.purpose)
+		} else if out != mt.expected {
+			t.Errorf("Test \"%s\" expected:\n%s\nActual:\n%s", mt.purpose, mt.expected, out)
+		}
+	}
+}
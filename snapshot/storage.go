@@ -0,0 +1,85 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Storage is somewhere to keep snapshot artifacts, such as the snapshot's JSON
+// and its rendered images, for longer than the snapshot host does. S3, GCS and
+// AzureBlob are Storages.
+type Storage interface {
+	// Upload uploads body as the object named by the Storage's key template
+	// evaluated against data, returning the object's URL
+	Upload(ctx context.Context, data *StorageKeyData, body []byte, contentType string) (string, error)
+}
+
+// StorageKeyData is what a Storage's key template is evaluated against for
+// each file uploaded
+type StorageKeyData struct {
+	SnapshotNameData
+	// Key is the snapshot's key, and File the name of the file, e.g.
+	// "snapshot.json"
+	Key  string
+	File string
+}
+
+// DefaultStorageKeyTemplate keys the files of a snapshot by its dashboard and
+// time range
+const DefaultStorageKeyTemplate = `{{.DashSlug}}{{.DashUID}}/{{.From.Format "20060102T150405"}}-{{.To.Format "20060102T150405"}}/{{.File}}`
+
+// parseKeyTemplate parses the key template of the field
+func parseKeyTemplate(field, text string) (*template.Template, error) {
+	tmpl, err := template.New(field).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", field, err.Error())
+	}
+	return tmpl, nil
+}
+
+// storageKey evaluates a key template against data, without a leading "/"
+func storageKey(tmpl *template.Template, data *StorageKeyData) (string, error) {
+	var key bytes.Buffer
+	if err := tmpl.Execute(&key, data); err != nil {
+		return "", fmt.Errorf("Failed to evaluate %s: %s", tmpl.Name(), err.Error())
+	}
+	objectKey := strings.TrimLeft(key.String(), "/")
+	if len(objectKey) == 0 {
+		return "", fmt.Errorf("%s evaluated to an empty key", tmpl.Name())
+	}
+	return objectKey, nil
+}
+
+// tokenCache caches the access token a Storage authenticates with, fetching a
+// new one when it's about to expire
+type tokenCache struct {
+	fetch func(ctx context.Context) (string, time.Duration, error)
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// get returns the access token, fetching a new one if the last expires within
+// a minute, so it doesn't expire in flight, or refresh is set, e.g. after it
+// was rejected
+func (t *tokenCache) get(ctx context.Context, refresh bool) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !refresh && len(t.token) > 0 && time.Now().Add(time.Minute).Before(t.expires) {
+		return t.token, nil
+	}
+	token, lifetime, err := t.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	t.expires = time.Now().Add(lifetime)
+	return token, nil
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+)
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// encodedBody returns a function streaming v encoded as JSON, for use as a
+// request's body and GetBody. Each call encodes v again as it's read, rather
+// than holding the whole encoding in memory. size is set to the size of the
+// last complete encoding.
+func encodedBody(v interface{}, size *int64) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			cw := &countingWriter{w: pw}
+			err := json.NewEncoder(cw).Encode(v)
+			if err == nil {
+				atomic.StoreInt64(size, cw.n)
+			}
+			// the request's reader sees the encoding error, if any
+			pw.CloseWithError(err)
+		}()
+		return pr, nil
+	}
+}
+
+// bufferedBody encodes v as JSON into memory, returning a function reading
+// the encoding, for use as a request's body and GetBody, and its size. Unlike
+// encodedBody, encoding errors are returned before the request is made.
+func bufferedBody(v interface{}) (func() (io.ReadCloser, error), int64, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, 0, err
+	}
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	}, int64(buf.Len()), nil
+}
+
+// encodedSize returns the size of v encoded as JSON, without keeping the
+// encoding
+func encodedSize(v interface{}) (int64, error) {
+	cw := &countingWriter{w: ioutil.Discard}
+	err := json.NewEncoder(cw).Encode(v)
+	return cw.n, err
+}
+
+// writeJSONFile streams v encoded as indented JSON into the file at path,
+// returning the encoding's size
+func writeJSONFile(path string, v interface{}) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	buf := bufio.NewWriter(f)
+	cw := &countingWriter{w: buf}
+	enc := json.NewEncoder(cw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(v); err == nil {
+		err = buf.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return cw.n, err
+}
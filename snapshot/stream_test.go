@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamSnapshot(t *testing.T) {
+	snapshot := map[string]interface{}{
+		"name":      "streamed",
+		"dashboard": map[string]interface{}{"panels": []interface{}{map[string]interface{}{"id": float64(1)}}},
+	}
+	expected, _ := json.Marshal(snapshot)
+
+	// posted chunked, and encoded again when retried
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.ContentLength != -1 || len(r.TransferEncoding) == 0 || r.TransferEncoding[0] != "chunked" {
+			t.Errorf("Request %d wasn't chunked: length %d, encoding %v", requests, r.ContentLength, r.TransferEncoding)
+		}
+		if string(body) != string(expected)+"\n" {
+			t.Errorf("Request %d posted %q, expected %q", requests, body, expected)
+		}
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"key": "abc"}`))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	sc, err := NewSnapClient(&Config{GrafanaAddr: serverURL, GrafanaAPIKey: "XXXXX", Retry: &RetryConfig{Backoff: time.Millisecond}})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	var size int64
+	posted, err := sc.postSnapshot(context.Background(), encodedBody(snapshot, &size), -1, false)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if posted.Key != "abc" || requests != 2 {
+		t.Errorf("Expected key \"abc\" after 2 requests, got %q after %d", posted.Key, requests)
+	}
+	if n := atomic.LoadInt64(&size); n != int64(len(expected)+1) {
+		t.Errorf("Expected size %d, got %d", len(expected)+1, n)
+	}
+
+	// written to a file
+	dir, err := ioutil.TempDir("", "snapshot-stream")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+	n, err := writeJSONFile(path, snapshot)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	written, _ := ioutil.ReadFile(path)
+	indented, _ := json.MarshalIndent(snapshot, "", "  ")
+	if string(written) != string(indented)+"\n" || n != int64(len(written)) {
+		t.Errorf("Expected file %q of size %d, got %q of size %d", indented, len(indented)+1, written, n)
+	}
+}
@@ -0,0 +1,708 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Matches the $var, ${var} and [[var]] template variable syntaxes
+var variableRefRe = regexp.MustCompile(`^(?:\$(\w+)|\$\{(\w+)\}|\[\[(\w+)\]\])$`)
+
+// templateVariable returns the dashboard's template variable with the given
+// name, or nil if there is none
+func templateVariable(dashboard map[string]interface{}, name string) map[string]interface{} {
+	templating, _ := dashboard["templating"].(map[string]interface{})
+	list, _ := templating["list"].([]interface{})
+	for _, v := range list {
+		variable, _ := v.(map[string]interface{})
+		if variable["name"] == name {
+			return variable
+		}
+	}
+	return nil
+}
+
+// variableRef returns the name of the template variable referenced by s if s
+// consists of a single variable reference
+func variableRef(s string) (string, bool) {
+	groups := variableRefRe.FindStringSubmatch(s)
+	if groups == nil {
+		return "", false
+	}
+	for _, name := range groups[1:] {
+		if len(name) > 0 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// datasourceRef returns the name or UID a panel, target or variable refers to
+// its datasource by: its name, or from Grafana 8, an object of its UID and
+// type, e.g. {"type": "prometheus", "uid": "abc123"}
+func datasourceRef(v interface{}) string {
+	switch ds := v.(type) {
+	case string:
+		return ds
+	case map[string]interface{}:
+		uid, _ := ds["uid"].(string)
+		return uid
+	}
+	return ""
+}
+
+// datasourceNameByUID returns the name of the datasource with the name or UID
+// ref, or ref if there's none
+func datasourceNameByUID(ref string, datasourceMap map[string]interface{}) string {
+	if _, ok := datasourceMap[ref]; ok || len(ref) == 0 {
+		return ref
+	}
+	names := make([]string, 0, len(datasourceMap))
+	for dsName := range datasourceMap {
+		names = append(names, dsName)
+	}
+	sort.Strings(names)
+	for _, dsName := range names {
+		if ds, _ := datasourceMap[dsName].(map[string]interface{}); ds["uid"] == ref {
+			return dsName
+		}
+	}
+	return ref
+}
+
+// resolveDatasourceName resolves a panel or target datasource name or UID to
+// the name of a concrete datasource, including those which reference a
+// datasource template variable (e.g. "$ds"). The variable's value comes from
+// TakeConfig.Vars if set, then the variable's saved current value, and finally
+// the first datasource matching the variable's type and regex.
+func resolveDatasourceName(name string, dashboard map[string]interface{}, vars map[string]string, datasourceMap map[string]interface{}) (string, error) {
+	varName, ok := variableRef(name)
+	if !ok {
+		return datasourceNameByUID(name, datasourceMap), nil
+	}
+	variable := templateVariable(dashboard, varName)
+	if variable == nil {
+		return "", fmt.Errorf("Datasource %q references unknown template variable %q", name, varName)
+	}
+	if variable["type"] != "datasource" {
+		return "", fmt.Errorf("Datasource %q references template variable %q which is not a datasource variable", name, varName)
+	}
+	pluginType, _ := variable["query"].(string)
+
+	// override or saved value
+	value, ok := vars[varName]
+	if !ok {
+		if current, isMap := variable["current"].(map[string]interface{}); isMap {
+			value = variableCurrentString(current["value"])
+			if len(value) == 0 {
+				value = variableCurrentString(current["text"])
+			}
+		}
+	}
+	if value == "default" {
+		if dsName := defaultDatasourceName(datasourceMap); len(dsName) > 0 {
+			return dsName, nil
+		}
+	} else if len(value) > 0 {
+		// newer dashboards store the datasource uid
+		dsName := datasourceNameByUID(value, datasourceMap)
+		if _, ok := datasourceMap[dsName]; ok {
+			return dsName, nil
+		}
+	}
+
+	// fall back to the first matching datasource, as Grafana does
+	var re *regexp.Regexp
+	if pattern, _ := variable["regex"].(string); len(pattern) > 0 {
+		var err error
+		if re, err = regexp.Compile(strings.Trim(pattern, "/")); err != nil {
+			return "", fmt.Errorf("Invalid regex on template variable %q: %s", varName, err.Error())
+		}
+	}
+	names := make([]string, 0, len(datasourceMap))
+	for dsName := range datasourceMap {
+		names = append(names, dsName)
+	}
+	sort.Strings(names)
+	for _, dsName := range names {
+		ds := datasourceMap[dsName].(map[string]interface{})
+		if len(pluginType) > 0 && ds["type"] != pluginType {
+			continue
+		}
+		if re != nil && !re.MatchString(dsName) {
+			continue
+		}
+		return dsName, nil
+	}
+	return "", fmt.Errorf("No datasource found for template variable %q", varName)
+}
+
+// variableCurrentString returns a variable's current text or value, which may
+// be saved as a string or, for multi-value variables, a list of strings
+func variableCurrentString(v interface{}) string {
+	if strs := variableStrings(v); len(strs) > 0 {
+		return strs[0]
+	}
+	return ""
+}
+
+// variableValue is the selected text and value of a template variable.
+// Multi-value variables may have several of each. Multi is set for variables
+// allowing multiple or All selections, which datasources format differently.
+// When All is selected Value holds every option, unless the variable has a
+// custom all value, which is substituted instead.
+type variableValue struct {
+	Text     []string
+	Value    []string
+	Multi    bool
+	All      bool
+	AllValue string
+}
+
+// The value Grafana saves when a variable's "All" option is selected, and the
+// text it shows for it
+const (
+	allValue = "$__all"
+	allText  = "All"
+)
+
+// resolveVariables returns the selected value of each of the dashboard's
+// template variables. Values set in TakeConfig.Vars override the values saved
+// with the dashboard; multi-value variables take a comma separated list, and
+// variables including All take "All" or "$__all" to select it.
+func resolveVariables(dashboard map[string]interface{}, vars map[string]string) map[string]variableValue {
+	values := make(map[string]variableValue)
+	declared := make(map[string]bool)
+	templating, _ := dashboard["templating"].(map[string]interface{})
+	list, _ := templating["list"].([]interface{})
+	for _, v := range list {
+		variable, _ := v.(map[string]interface{})
+		name, _ := variable["name"].(string)
+		declared[name] = true
+		// ad hoc filters aren't referenced by name
+		if len(name) == 0 || variable["type"] == "adhoc" {
+			continue
+		}
+		multi, _ := variable["multi"].(bool)
+		includeAll, _ := variable["includeAll"].(bool)
+
+		var value variableValue
+		if override, ok := vars[name]; ok {
+			if multi {
+				value.Value = strings.Split(override, ",")
+			} else {
+				value.Value = []string{override}
+			}
+			value.Text = value.Value
+			if includeAll && override == allText {
+				value.Value = []string{allValue}
+			}
+		} else {
+			current, _ := variable["current"].(map[string]interface{})
+			value.Value = variableStrings(current["value"])
+			value.Text = variableStrings(current["text"])
+			// constants are hidden so their query is the value, and textboxes
+			// may only have their default value saved in the query
+			query := variableStrings(variable["query"])
+			if variable["type"] == "constant" || (variable["type"] == "textbox" && len(value.Value) == 0) {
+				value.Value = query
+			}
+			// multi-value text is saved joined with " + "
+			if len(value.Text) != len(value.Value) {
+				value.Text = value.Value
+			}
+		}
+
+		// expand "All" into the variable's options
+		if len(value.Value) == 1 && value.Value[0] == allValue {
+			value = allOptions(variable, panelList(variable["options"]))
+		}
+		value.Multi = multi || includeAll
+		values[name] = value
+	}
+	// overrides of variables the dashboard doesn't declare are substituted
+	// as they are, as custom variables
+	for name, override := range vars {
+		if !declared[name] {
+			values[name] = variableValue{Text: []string{override}, Value: []string{override}}
+		}
+	}
+	return values
+}
+
+// allOptions returns the value of a variable with All selected: each of its
+// options, along with its custom all value if it has one
+func allOptions(variable map[string]interface{}, options []map[string]interface{}) variableValue {
+	value := variableValue{All: true}
+	value.AllValue, _ = variable["allValue"].(string)
+	for _, option := range options {
+		optionValue := variableCurrentString(option["value"])
+		if optionValue == allValue || len(optionValue) == 0 {
+			continue
+		}
+		value.Value = append(value.Value, optionValue)
+		value.Text = append(value.Text, variableCurrentString(option["text"]))
+	}
+	return value
+}
+
+// variableStrings returns a variable's current text or value as a list.
+// Values saved as numbers or booleans are formatted as strings.
+func variableStrings(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case float64:
+		return []string{strconv.FormatFloat(value, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(value)}
+	case []interface{}:
+		strs := make([]string, 0, len(value))
+		for _, s := range value {
+			strs = append(strs, variableStrings(s)...)
+		}
+		return strs
+	}
+	return nil
+}
+
+// panelScopedVars returns the variable values a repeated panel was created
+// for, which take precedence over the dashboard wide values
+func panelScopedVars(panel map[string]interface{}) map[string]variableValue {
+	scoped := make(map[string]variableValue)
+	scopedVars, _ := panel["scopedVars"].(map[string]interface{})
+	for name, v := range scopedVars {
+		scopedVar, _ := v.(map[string]interface{})
+		scoped[name] = variableValue{
+			Text:  variableStrings(scopedVar["text"]),
+			Value: variableStrings(scopedVar["value"]),
+		}
+	}
+	return scoped
+}
+
+// Matches the $var, ${var}, ${var:format} and [[var]] template syntaxes
+var interpolateRe = regexp.MustCompile(`\$(\w+)|\$\{(\w+)(?::(\w+))?\}|\[\[(\w+)(?::(\w+))?\]\]`)
+
+// interpolate replaces the template variable references in s with their
+// values, preferring the panel's scoped values. Values are formatted with the
+// reference's ${var:format} if given, otherwise with the default format, which
+// is usually the datasource's (see datasourceVariableFormat). References to
+// unknown variables, including Grafana's $__ built ins, are left untouched.
+func interpolate(s string, values, scoped map[string]variableValue, format string) string {
+	return interpolateRe.ReplaceAllStringFunc(s, func(match string) string {
+		groups := interpolateRe.FindStringSubmatch(match)
+		name := groups[1] + groups[2] + groups[4]
+		value, ok := scoped[name]
+		if !ok {
+			if value, ok = values[name]; !ok {
+				return match
+			}
+		}
+		if refFormat := groups[3] + groups[5]; len(refFormat) > 0 {
+			return formatVariable(name, value, refFormat)
+		}
+		return formatVariable(name, value, format)
+	})
+}
+
+// interpolateValue returns a copy of a decoded JSON value with the template
+// variables in all of its strings interpolated
+func interpolateValue(v interface{}, values, scoped map[string]variableValue, format string) interface{} {
+	switch value := v.(type) {
+	case string:
+		return interpolate(value, values, scoped, format)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			out[k] = interpolateValue(item, values, scoped, format)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = interpolateValue(item, values, scoped, format)
+		}
+		return out
+	}
+	return v
+}
+
+// Formats for datasources which format multi-value variables themselves,
+// rather than with one of Grafana's named formats
+const (
+	prometheusFormat    = "prometheus"
+	sqlFormat           = "sql"
+	elasticsearchFormat = "elasticsearch"
+)
+
+// datasourceVariableFormat returns the format variables are interpolated with
+// by default in queries for a datasource type
+func datasourceVariableFormat(datasourceType string) string {
+	switch datasourceType {
+	case "prometheus", "loki":
+		return prometheusFormat
+	case "influxdb":
+		return "regex"
+	case "postgres", "grafana-postgresql-datasource", "mysql":
+		return sqlFormat
+	case "elasticsearch":
+		return elasticsearchFormat
+	}
+	return "glob"
+}
+
+// Characters escaped by Grafana's regex and lucene formats
+var (
+	regexSpecialRe  = regexp.MustCompile(`[\\^$*+?.()|{}\[\]/]`)
+	luceneSpecialRe = regexp.MustCompile(`[+\-&|!(){}\[\]^"~*?:\\/ ]`)
+	promSpecialRe   = regexp.MustCompile(`[$^*{}\[\]'+?.()|]`)
+)
+
+// formatVariable formats a variable's value with one of Grafana's variable
+// formats, see
+// https://grafana.com/docs/grafana/latest/dashboards/variables/variable-syntax/
+// Unknown formats fall back to glob.
+func formatVariable(name string, value variableValue, format string) string {
+	if value.All {
+		switch {
+		case format == "text":
+			return allText
+		case format == "queryparam":
+			return "var-" + url.QueryEscape(name) + "=" + url.QueryEscape(allValue)
+		case len(value.AllValue) > 0:
+			// custom all values, such as .*, are substituted unformatted
+			return value.AllValue
+		}
+	}
+	values := value.Value
+	multi := len(values) != 1
+	quoteEach := func(quote, escaped string) []string {
+		quoted := make([]string, len(values))
+		for idx, v := range values {
+			quoted[idx] = quote + strings.Replace(v, quote, escaped, -1) + quote
+		}
+		return quoted
+	}
+
+	switch format {
+	case prometheusFormat:
+		// regex escaped for use in =~ matchers, inside a PromQL string
+		if !value.Multi {
+			v := strings.Join(values, ",")
+			return strings.Replace(strings.Replace(v, "\\", "\\\\", -1), "'", "\\'", -1)
+		}
+		escaped := make([]string, len(values))
+		for idx, v := range values {
+			v = strings.Replace(v, "\\", "\\\\\\\\", -1)
+			escaped[idx] = promSpecialRe.ReplaceAllString(v, "\\\\$0")
+		}
+		if !multi {
+			return strings.Join(escaped, "")
+		}
+		return "(" + strings.Join(escaped, "|") + ")"
+	case sqlFormat:
+		if !value.Multi {
+			return strings.Join(values, ",")
+		}
+		return strings.Join(quoteEach("'", "''"), ",")
+	case elasticsearchFormat:
+		if !value.Multi {
+			return strings.Join(values, ",")
+		}
+		return formatVariable(name, value, "lucene")
+	case "csv", "raw":
+		return strings.Join(values, ",")
+	case "pipe":
+		return strings.Join(values, "|")
+	case "text":
+		return strings.Join(value.Text, " + ")
+	case "regex":
+		escaped := make([]string, len(values))
+		for idx, v := range values {
+			escaped[idx] = regexSpecialRe.ReplaceAllString(v, "\\$0")
+		}
+		if !multi {
+			return strings.Join(escaped, "")
+		}
+		return "(" + strings.Join(escaped, "|") + ")"
+	case "lucene":
+		escaped := make([]string, len(values))
+		for idx, v := range values {
+			escaped[idx] = luceneSpecialRe.ReplaceAllString(v, "\\$0")
+		}
+		if !multi {
+			return strings.Join(escaped, "")
+		}
+		return "(\"" + strings.Join(escaped, "\" OR \"") + "\")"
+	case "json":
+		var b []byte
+		if multi {
+			b, _ = json.Marshal(values)
+		} else {
+			b, _ = json.Marshal(values[0])
+		}
+		return string(b)
+	case "distributed":
+		// name=a,name=b except for the first value
+		distributed := make([]string, len(values))
+		for idx, v := range values {
+			if idx > 0 {
+				v = name + "=" + v
+			}
+			distributed[idx] = v
+		}
+		return strings.Join(distributed, ",")
+	case "singlequote":
+		return strings.Join(quoteEach("'", "\\'"), ",")
+	case "doublequote":
+		return strings.Join(quoteEach("\"", "\\\""), ",")
+	case "sqlstring":
+		return strings.Join(quoteEach("'", "''"), ",")
+	case "queryparam":
+		params := make([]string, len(values))
+		for idx, v := range values {
+			params[idx] = "var-" + url.QueryEscape(name) + "=" + url.QueryEscape(v)
+		}
+		return strings.Join(params, "&")
+	case "percentencode":
+		v := strings.Join(values, ",")
+		if multi {
+			v = "{" + v + "}"
+		}
+		return strings.Replace(url.QueryEscape(v), "+", "%20", -1)
+	}
+	// glob
+	if !multi {
+		return strings.Join(values, "")
+	}
+	return "{" + strings.Join(values, ",") + "}"
+}
+
+// Interval variables with auto enabled save this prefix and their name as the
+// value when auto is selected
+const autoIntervalPrefix = "$__auto_interval_"
+
+// resolveIntervalVariables sets the value of interval variables with "auto"
+// selected to the interval Grafana would calculate for the time range
+func resolveIntervalVariables(dashboard map[string]interface{}, values map[string]variableValue, r TimeRange) {
+	templating, _ := dashboard["templating"].(map[string]interface{})
+	for _, variable := range panelList(templating["list"]) {
+		name, _ := variable["name"].(string)
+		value, ok := values[name]
+		if variable["type"] != "interval" || !ok || len(value.Value) != 1 {
+			continue
+		}
+		if value.Value[0] != "auto" && !strings.HasPrefix(value.Value[0], autoIntervalPrefix) {
+			continue
+		}
+		count := 30
+		if autoCount, ok := variable["auto_count"].(float64); ok && autoCount > 0 {
+			count = int(autoCount)
+		}
+		minInterval := 10 * time.Second
+		if autoMin, ok := variable["auto_min"].(string); ok {
+			if d, err := model.ParseDuration(autoMin); err == nil {
+				minInterval = time.Duration(d)
+			}
+		}
+		interval := formatInterval(autoInterval(r, count, minInterval))
+		values[name] = variableValue{Text: []string{interval}, Value: []string{interval}}
+	}
+}
+
+// autoInterval divides the time range into count intervals, rounded to a
+// whole interval and no smaller than minInterval
+func autoInterval(r TimeRange, count int, minInterval time.Duration) time.Duration {
+	interval := r.To.Sub(r.From) / time.Duration(count)
+	if interval < minInterval {
+		return minInterval
+	}
+	return roundInterval(interval)
+}
+
+// panelInterval calculates a panel's query interval the way Grafana does:
+// the time range divided into maxDataPoints, rounded to a whole interval and
+// no smaller than minInterval
+func panelInterval(r TimeRange, maxDataPoints int, minInterval time.Duration) time.Duration {
+	interval := roundInterval(r.To.Sub(r.From) / time.Duration(maxDataPoints))
+	if interval < minInterval {
+		return minInterval
+	}
+	return interval
+}
+
+// Prometheus rejects queries which would return more points than this per
+// series
+const maxSeriesPoints = 11000
+
+// queryStep adjusts a panel's interval to a target's query step the way
+// Grafana's Prometheus datasource does: multiplied by the intervalFactor, no
+// smaller than the target's min interval or a second, and large enough to
+// stay under Prometheus' points limit
+func queryStep(r TimeRange, interval, minInterval time.Duration, intervalFactor float64) time.Duration {
+	rng := r.To.Sub(r.From)
+	if interval > 0 && float64(rng)/intervalFactor/float64(interval) > maxSeriesPoints {
+		interval = time.Duration(math.Ceil(rng.Seconds()/intervalFactor/maxSeriesPoints)) * time.Second
+	}
+	step := time.Duration(float64(interval) * intervalFactor)
+	if step < minInterval {
+		step = minInterval
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+	return step
+}
+
+// limitStep returns the step widened to whole seconds so the time range has
+// at most maxPoints of them, and whether it had to be
+func limitStep(r TimeRange, step time.Duration, maxPoints int) (time.Duration, bool) {
+	rng := r.To.Sub(r.From)
+	if maxPoints <= 0 || step <= 0 || float64(rng)/float64(step) <= float64(maxPoints) {
+		return step, false
+	}
+	return time.Duration(math.Ceil(rng.Seconds()/float64(maxPoints))) * time.Second, true
+}
+
+// roundInterval rounds an interval to one of the intervals Grafana's kbn
+// library would pick
+func roundInterval(interval time.Duration) time.Duration {
+	day := 24 * time.Hour
+	steps := []struct {
+		below, interval time.Duration
+	}{
+		{15 * time.Millisecond, 10 * time.Millisecond},
+		{35 * time.Millisecond, 20 * time.Millisecond},
+		{75 * time.Millisecond, 50 * time.Millisecond},
+		{150 * time.Millisecond, 100 * time.Millisecond},
+		{350 * time.Millisecond, 200 * time.Millisecond},
+		{750 * time.Millisecond, 500 * time.Millisecond},
+		{1500 * time.Millisecond, time.Second},
+		{3500 * time.Millisecond, 2 * time.Second},
+		{7500 * time.Millisecond, 5 * time.Second},
+		{12500 * time.Millisecond, 10 * time.Second},
+		{17500 * time.Millisecond, 15 * time.Second},
+		{25 * time.Second, 20 * time.Second},
+		{45 * time.Second, 30 * time.Second},
+		{90 * time.Second, time.Minute},
+		{3 * time.Minute, 2 * time.Minute},
+		{450 * time.Second, 5 * time.Minute},
+		{1050 * time.Second, 10 * time.Minute},
+		{35 * time.Minute, 30 * time.Minute},
+		{90 * time.Minute, time.Hour},
+		{150 * time.Minute, 2 * time.Hour},
+		{270 * time.Minute, 3 * time.Hour},
+		{9 * time.Hour, 6 * time.Hour},
+		{day, 12 * time.Hour},
+		{7 * day, day},
+		{21 * day, 7 * day},
+		{42 * day, 30 * day},
+	}
+	for _, step := range steps {
+		if interval < step.below {
+			return step.interval
+		}
+	}
+	return 365 * day
+}
+
+// parseInterval parses a target's min interval, which Grafana allows to be
+// prefixed with ">" and in units up to years
+func parseInterval(s string) (time.Duration, error) {
+	d, err := model.ParseDuration(strings.TrimPrefix(strings.TrimSpace(s), ">"))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid interval %q: %s", s, err.Error())
+	}
+	return time.Duration(d), nil
+}
+
+// targetInterval returns a target's min interval: its interval, or the step
+// older Prometheus targets and Loki targets set instead, which may be seconds
+func targetInterval(target map[string]interface{}) string {
+	for _, key := range []string{"interval", "step"} {
+		switch v := target[key].(type) {
+		case string:
+			if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds > 0 {
+				return strconv.FormatInt(int64(seconds*1000), 10) + "ms"
+			} else if err != nil && len(strings.TrimSpace(v)) > 0 {
+				return v
+			}
+		case float64:
+			if v > 0 {
+				return strconv.FormatInt(int64(v*1000), 10) + "ms"
+			}
+		}
+	}
+	return ""
+}
+
+// Grafana's default scrape interval when a datasource doesn't configure one
+const defaultScrapeInterval = 15 * time.Second
+
+// builtinVariables returns Grafana's global $__interval, $__rate_interval and
+// $__range variables for a target
+func builtinVariables(r TimeRange, step, scrape time.Duration) map[string]variableValue {
+	rng := r.To.Sub(r.From)
+	builtin := func(s string) variableValue {
+		return variableValue{Text: []string{s}, Value: []string{s}}
+	}
+	return map[string]variableValue{
+		"__interval":      builtin(formatInterval(step)),
+		"__interval_ms":   builtin(strconv.FormatInt(int64(step/time.Millisecond), 10)),
+		"__rate_interval": builtin(formatInterval(rateInterval(step, scrape))),
+		"__range":         builtin(formatInterval(rng.Round(time.Second))),
+		"__range_s":       builtin(strconv.FormatInt(int64(rng/time.Second), 10)),
+		"__range_ms":      builtin(strconv.FormatInt(int64(rng/time.Millisecond), 10)),
+	}
+}
+
+// rateInterval calculates $__rate_interval the way Grafana does: the larger
+// of four scrape intervals and the step plus one scrape interval
+func rateInterval(step, scrape time.Duration) time.Duration {
+	if step+scrape > 4*scrape {
+		return step + scrape
+	}
+	return 4 * scrape
+}
+
+// scrapeInterval returns the datasource's configured scrape interval
+// (jsonData.timeInterval), or Grafana's default
+func scrapeInterval(datasource map[string]interface{}) time.Duration {
+	jsonData, _ := datasource["jsonData"].(map[string]interface{})
+	if timeInterval, ok := jsonData["timeInterval"].(string); ok && len(timeInterval) > 0 {
+		if d, err := model.ParseDuration(timeInterval); err == nil && d > 0 {
+			return time.Duration(d)
+		}
+	}
+	return defaultScrapeInterval
+}
+
+// formatInterval formats a duration in a single unit, which all datasource
+// query languages accept
+func formatInterval(d time.Duration) string {
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+	for _, unit := range units {
+		if d >= unit.size && d%unit.size == 0 {
+			return strconv.FormatInt(int64(d/unit.size), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+}
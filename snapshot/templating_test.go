@@ -0,0 +1,474 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestResolveDatasourceName(t *testing.T) {
+	// some vars
+	var dashboard, datasourceMap map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"templating": {"list": [
+			{"name": "ds", "type": "datasource", "query": "prometheus", "current": {"text": "Prom B", "value": "Prom B"}},
+			{"name": "unset", "type": "datasource", "query": "prometheus", "regex": "/B$/"},
+			{"name": "bydefault", "type": "datasource", "query": "prometheus", "current": {"value": "default"}},
+			{"name": "byuid", "type": "datasource", "query": "prometheus", "current": {"value": "uid-a"}},
+			{"name": "instance", "type": "query"}
+		]}
+	}`), &dashboard)
+	json.Unmarshal([]byte(`{
+		"Prom A": {"name": "Prom A", "type": "prometheus", "uid": "uid-a", "isDefault": true},
+		"Prom B": {"name": "Prom B", "type": "prometheus", "uid": "uid-b"},
+		"Elastic": {"name": "Elastic", "type": "elasticsearch"}
+	}`), &datasourceMap)
+	// names to test
+	var resolveTests = []struct {
+		purpose  string
+		name     string
+		vars     map[string]string
+		expected string
+		valid    bool
+	}{
+		{
+			purpose:  "Plain datasource name",
+			name:     "Elastic",
+			expected: "Elastic",
+			valid:    true,
+		},
+		{
+			purpose:  "Saved current value",
+			name:     "$ds",
+			expected: "Prom B",
+			valid:    true,
+		},
+		{
+			purpose:  "Override from vars",
+			name:     "${ds}",
+			vars:     map[string]string{"ds": "Prom A"},
+			expected: "Prom A",
+			valid:    true,
+		},
+		{
+			purpose:  "First datasource matching type and regex",
+			name:     "[[unset]]",
+			expected: "Prom B",
+			valid:    true,
+		},
+		{
+			purpose:  "Default datasource",
+			name:     "$bydefault",
+			expected: "Prom A",
+			valid:    true,
+		},
+		{
+			purpose:  "Datasource uid",
+			name:     "$byuid",
+			expected: "Prom A",
+			valid:    true,
+		},
+		{
+			purpose:  "Plain datasource uid",
+			name:     "uid-b",
+			expected: "Prom B",
+			valid:    true,
+		},
+		{
+			purpose:  "Grafana 8 datasource reference",
+			name:     datasourceRef(map[string]interface{}{"type": "prometheus", "uid": "uid-a"}),
+			expected: "Prom A",
+			valid:    true,
+		},
+		{
+			purpose:  "Grafana 8 datasource reference to a variable",
+			name:     datasourceRef(map[string]interface{}{"type": "prometheus", "uid": "${ds}"}),
+			expected: "Prom B",
+			valid:    true,
+		},
+		{
+			purpose: "Not a datasource variable",
+			name:    "$instance",
+			valid:   false,
+		},
+		{
+			purpose: "Unknown variable",
+			name:    "$missing",
+			valid:   false,
+		},
+	}
+	// test
+	for _, rt := range resolveTests {
+		out, err := resolveDatasourceName(rt.name, dashboard, rt.vars, datasourceMap)
+		if rt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", rt.purpose, err.Error())
+		} else if !rt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", rt.purpose)
+		} else if out != rt.expected {
+			t.Errorf("Test \"%s\" expected %q, got %q", rt.purpose, rt.expected, out)
+		}
+	}
+}
+
+func TestBuiltinVariables(t *testing.T) {
+	// some vars
+	from := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.UTC)
+	r := TimeRange{From: from, To: from.Add(6 * time.Hour)}
+	// intervals to test
+	var builtinTests = []struct {
+		purpose  string
+		step     time.Duration
+		scrape   time.Duration
+		expected map[string]string
+	}{
+		{
+			purpose: "Rate interval from scrape interval",
+			step:    30 * time.Second,
+			scrape:  15 * time.Second,
+			expected: map[string]string{
+				"__interval":      "30s",
+				"__interval_ms":   "30000",
+				"__rate_interval": "1m",
+				"__range":         "6h",
+				"__range_s":       "21600",
+			},
+		},
+		{
+			purpose: "Rate interval from step",
+			step:    5 * time.Minute,
+			scrape:  time.Minute,
+			expected: map[string]string{
+				"__interval":      "5m",
+				"__rate_interval": "6m",
+			},
+		},
+		{
+			purpose: "Sub second step",
+			step:    1500 * time.Millisecond,
+			scrape:  time.Second,
+			expected: map[string]string{
+				"__interval":      "1500ms",
+				"__rate_interval": "4s",
+			},
+		},
+	}
+	// test
+	for _, bt := range builtinTests {
+		builtins := builtinVariables(r, bt.step, bt.scrape)
+		for name, expected := range bt.expected {
+			if out := builtins[name].Value[0]; out != expected {
+				t.Errorf("Test \"%s\" expected $%s to be %q, got %q", bt.purpose, name, expected, out)
+			}
+		}
+	}
+}
+
+func TestQueryStep(t *testing.T) {
+	// some vars
+	from := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.UTC)
+	// steps to test
+	var stepTests = []struct {
+		purpose        string
+		rng            time.Duration
+		panel          map[string]interface{}
+		minInterval    time.Duration // panel or datasource min interval
+		targetInterval time.Duration // target min interval
+		intervalFactor float64
+		expected       time.Duration
+	}{
+		{
+			purpose:  "Full width panel",
+			rng:      6 * time.Hour,
+			panel:    map[string]interface{}{"gridPos": map[string]interface{}{"w": float64(24)}},
+			expected: 10 * time.Second,
+		},
+		{
+			purpose:  "Half width panel",
+			rng:      6 * time.Hour,
+			panel:    map[string]interface{}{"gridPos": map[string]interface{}{"w": float64(12)}},
+			expected: 20 * time.Second,
+		},
+		{
+			purpose:  "Legacy row panel",
+			rng:      6 * time.Hour,
+			panel:    map[string]interface{}{"span": float64(6)},
+			expected: 20 * time.Second,
+		},
+		{
+			purpose:  "Max data points",
+			rng:      6 * time.Hour,
+			panel:    map[string]interface{}{"maxDataPoints": float64(100), "gridPos": map[string]interface{}{"w": float64(24)}},
+			expected: 5 * time.Minute,
+		},
+		{
+			purpose:     "Min interval",
+			rng:         6 * time.Hour,
+			panel:       map[string]interface{}{},
+			minInterval: time.Minute,
+			expected:    time.Minute,
+		},
+		{
+			purpose:        "Target min interval",
+			rng:            6 * time.Hour,
+			panel:          map[string]interface{}{},
+			targetInterval: 30 * time.Second,
+			expected:       30 * time.Second,
+		},
+		{
+			purpose:        "Interval factor",
+			rng:            6 * time.Hour,
+			panel:          map[string]interface{}{},
+			intervalFactor: 3,
+			expected:       30 * time.Second,
+		},
+		{
+			purpose:  "Prometheus points limit",
+			rng:      30 * 24 * time.Hour,
+			panel:    map[string]interface{}{"maxDataPoints": "1000000"},
+			expected: 236 * time.Second,
+		},
+		{
+			purpose:  "At least a second",
+			rng:      10 * time.Minute,
+			panel:    map[string]interface{}{},
+			expected: time.Second,
+		},
+	}
+	// test
+	for _, st := range stepTests {
+		r := TimeRange{From: from, To: from.Add(st.rng)}
+		interval := panelInterval(r, panelMaxDataPoints(st.panel), st.minInterval)
+		targetInterval := interval
+		if st.targetInterval > 0 {
+			targetInterval = st.targetInterval
+		}
+		intervalFactor := st.intervalFactor
+		if intervalFactor == 0 {
+			intervalFactor = 1
+		}
+		if out := queryStep(r, interval, targetInterval, intervalFactor); out != st.expected {
+			t.Errorf("Test \"%s\" expected step %s, got %s", st.purpose, st.expected, out)
+		}
+	}
+}
+
+func TestTargetInterval(t *testing.T) {
+	intervalTests := []struct {
+		purpose  string
+		target   map[string]interface{}
+		expected time.Duration
+	}{
+		{"Interval", map[string]interface{}{"interval": "30s"}, 30 * time.Second},
+		{"Min interval prefix", map[string]interface{}{"interval": ">1m"}, time.Minute},
+		{"Interval before step", map[string]interface{}{"interval": "30s", "step": "2m"}, 30 * time.Second},
+		{"Step", map[string]interface{}{"interval": "", "step": "2m"}, 2 * time.Minute},
+		{"Step in seconds", map[string]interface{}{"step": 60.0}, time.Minute},
+		{"Step in seconds as a string", map[string]interface{}{"step": "1.5"}, 1500 * time.Millisecond},
+		{"None", map[string]interface{}{}, 0},
+	}
+	for _, it := range intervalTests {
+		s := targetInterval(it.target)
+		if it.expected == 0 {
+			if len(s) > 0 {
+				t.Errorf("Test \"%s\" expected no interval, got %q", it.purpose, s)
+			}
+			continue
+		}
+		if d, err := parseInterval(s); err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", it.purpose, err.Error())
+		} else if d != it.expected {
+			t.Errorf("Test \"%s\" expected interval %s, got %s", it.purpose, it.expected, d)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	// some vars
+	values := map[string]variableValue{
+		"job":      {Text: []string{"node"}, Value: []string{"node"}},
+		"instance": {Text: []string{"host a", "host.b"}, Value: []string{"a:9100", "b.example:9100"}, Multi: true},
+		"single":   {Text: []string{"1.2"}, Value: []string{"1.2"}, Multi: true},
+		"quoted":   {Text: []string{"it's"}, Value: []string{"it's"}},
+		"all":      {Text: []string{"a", "b"}, Value: []string{"a", "b"}, Multi: true, All: true},
+		"custom":   {Text: []string{"a", "b"}, Value: []string{"a", "b"}, Multi: true, All: true, AllValue: ".*"},
+	}
+	// strings to test
+	var interpolateTests = []struct {
+		purpose  string
+		s        string
+		format   string
+		expected string
+	}{
+		{
+			purpose:  "Single value",
+			s:        `up{job="$job"}`,
+			format:   prometheusFormat,
+			expected: `up{job="node"}`,
+		},
+		{
+			purpose:  "Prometheus multi value",
+			s:        `up{instance=~"$instance"}`,
+			format:   prometheusFormat,
+			expected: `up{instance=~"(a:9100|b\\.example:9100)"}`,
+		},
+		{
+			purpose:  "Prometheus multi variable with one value",
+			s:        `up{instance=~"[[single]]"}`,
+			format:   prometheusFormat,
+			expected: `up{instance=~"1\\.2"}`,
+		},
+		{
+			purpose:  "Explicit format overrides default",
+			s:        `${instance:pipe} ${instance:csv} ${instance:text}`,
+			format:   prometheusFormat,
+			expected: `a:9100|b.example:9100 a:9100,b.example:9100 host a + host.b`,
+		},
+		{
+			purpose:  "Regex format",
+			s:        `/^${instance:regex}$/`,
+			expected: `/^(a:9100|b\.example:9100)$/`,
+		},
+		{
+			purpose:  "Glob by default",
+			s:        `$instance`,
+			expected: `{a:9100,b.example:9100}`,
+		},
+		{
+			purpose:  "SQL quoting",
+			s:        `host IN ($instance) AND note = ${quoted:sqlstring}`,
+			format:   sqlFormat,
+			expected: `host IN ('a:9100','b.example:9100') AND note = 'it''s'`,
+		},
+		{
+			purpose:  "Lucene",
+			s:        `host:$instance`,
+			format:   elasticsearchFormat,
+			expected: `host:("a\:9100" OR "b.example\:9100")`,
+		},
+		{
+			purpose:  "JSON, distributed and query params",
+			s:        `${instance:json} ${instance:distributed} ${job:queryparam}`,
+			expected: `["a:9100","b.example:9100"] a:9100,instance=b.example:9100 var-job=node`,
+		},
+		{
+			purpose:  "All without a custom all value",
+			s:        `up{env=~"$all"} ${all:text}`,
+			format:   prometheusFormat,
+			expected: `up{env=~"(a|b)"} All`,
+		},
+		{
+			purpose:  "Custom all value is not escaped",
+			s:        `up{env=~"$custom"} ${custom:csv} ${custom:queryparam}`,
+			format:   prometheusFormat,
+			expected: `up{env=~".*"} .* var-custom=%24__all`,
+		},
+		{
+			purpose:  "Unknown variables untouched",
+			s:        `rate(x[$__rate_interval]) $missing`,
+			expected: `rate(x[$__rate_interval]) $missing`,
+		},
+	}
+	// test
+	for _, it := range interpolateTests {
+		if out := interpolate(it.s, values, nil, it.format); out != it.expected {
+			t.Errorf("Test \"%s\" expected %q, got %q", it.purpose, it.expected, out)
+		}
+	}
+}
+
+func TestResolveVariables(t *testing.T) {
+	// some vars
+	var dashboard map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"templating": {"list": [
+			{"name": "const", "type": "constant", "query": "prod"},
+			{"name": "oldconst", "type": "constant", "query": "prod", "current": {"value": "stale"}},
+			{"name": "box", "type": "textbox", "query": "default"},
+			{"name": "savedbox", "type": "textbox", "query": "default", "current": {"text": "typed", "value": "typed"}},
+			{"name": "number", "type": "custom", "current": {"text": "5", "value": 5}},
+			{"name": "filters", "type": "adhoc", "filters": []},
+			{"type": "query"}
+		]}
+	}`), &dashboard)
+	expected := map[string]string{
+		"const":    "prod",
+		"oldconst": "prod",
+		"box":      "default",
+		"savedbox": "typed",
+		"number":   "5",
+	}
+	// test
+	values := resolveVariables(dashboard, nil)
+	if len(values) != len(expected) {
+		t.Errorf("Expected %d variables, got %d", len(expected), len(values))
+	}
+	for name, value := range expected {
+		if out := values[name]; len(out.Value) != 1 || out.Value[0] != value || out.Text[0] != value {
+			t.Errorf("Test \"%s\" expected %q, got %v", name, value, out)
+		}
+	}
+
+	// overrides of undeclared variables are still substituted, but not of
+	// ad hoc filters
+	values = resolveVariables(dashboard, map[string]string{"box": "set", "undeclared": "a,b", "filters": "x"})
+	expected["box"] = "set"
+	expected["undeclared"] = "a,b"
+	if len(values) != len(expected) {
+		t.Errorf("Expected %d variables with overrides, got %d", len(expected), len(values))
+	}
+	for name, value := range expected {
+		if out := values[name]; len(out.Value) != 1 || out.Value[0] != value || out.Text[0] != value {
+			t.Errorf("Test \"%s\" with overrides expected %q, got %v", name, value, out)
+		}
+	}
+}
+
+func TestResolveIntervalVariables(t *testing.T) {
+	// some vars
+	from := time.Date(2017, time.February, 05, 6, 0, 0, 0, time.UTC)
+	var dashboard map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"templating": {"list": [
+			{"name": "fixed", "type": "interval", "query": "1m,5m", "current": {"text": "5m", "value": "5m"}},
+			{"name": "auto", "type": "interval", "query": "1m,5m", "auto": true, "current": {"text": "auto", "value": "$__auto_interval_auto"}},
+			{"name": "fewer", "type": "interval", "query": "1m,5m", "auto": true, "auto_count": 10, "current": {"text": "auto", "value": "$__auto_interval_fewer"}},
+			{"name": "floor", "type": "interval", "query": "1m,5m", "auto": true, "auto_min": "1h", "current": {"text": "auto", "value": "$__auto_interval_floor"}}
+		]}
+	}`), &dashboard)
+	// ranges to test
+	var intervalTests = []struct {
+		purpose  string
+		r        TimeRange
+		expected map[string]string
+	}{
+		{
+			purpose: "Six hours",
+			r:       TimeRange{From: from, To: from.Add(6 * time.Hour)},
+			expected: map[string]string{
+				"fixed": "5m",
+				"auto":  "10m",
+				"fewer": "1h",
+				"floor": "1h",
+			},
+		},
+		{
+			purpose: "One week",
+			r:       TimeRange{From: from, To: from.Add(7 * 24 * time.Hour)},
+			expected: map[string]string{
+				"auto":  "6h",
+				"fewer": "12h",
+				"floor": "6h",
+			},
+		},
+	}
+	// test
+	for _, it := range intervalTests {
+		values := resolveVariables(dashboard, nil)
+		resolveIntervalVariables(dashboard, values, it.r)
+		for name, expected := range it.expected {
+			if out := values[name].Value[0]; out != expected {
+				t.Errorf("Test \"%s\" expected $%s to be %q, got %q", it.purpose, name, expected, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package snapshot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeLayout is the layout of absolute times accepted by ParseTime
+const TimeLayout = "2006-01-02 15:04:05"
+
+// ParseLocation returns the location for a Grafana time zone setting: "utc",
+// "browser" (or "local") and empty for the local time zone, or an IANA time
+// zone name such as "Europe/London"
+func ParseLocation(timezone string) (*time.Location, error) {
+	switch strings.ToLower(timezone) {
+	case "", "browser", "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	return time.LoadLocation(timezone)
+}
+
+// Matches Grafana's relative time syntax, e.g. now-7d/d: now, followed by
+// any number of offsets, and optionally rounded to a unit
+var relativeTimeRe = regexp.MustCompile(`^now((?:[+-]\d+[smhdwMy])*)(?:/([smhdwMy]))?$`)
+var relativeOffsetRe = regexp.MustCompile(`([+-])(\d+)([smhdwMy])`)
+
+// ParseTime parses an absolute time in the TimeLayout, an epoch in
+// milliseconds, or a Grafana style relative time such as "now-24h" or
+// "now-1d/d" relative to now. Relative times rounded to a unit round down to
+// its start, or with roundUp to its end, as Grafana does for the end of a
+// time range. Times are in now's location.
+func ParseTime(s string, now time.Time, roundUp bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	matches := relativeTimeRe.FindStringSubmatch(s)
+	if matches == nil {
+		if t, err := time.ParseInLocation(TimeLayout, s, now.Location()); err == nil {
+			return t, nil
+		}
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(0, ms*int64(time.Millisecond)).In(now.Location()), nil
+		}
+		return time.Time{}, fmt.Errorf("Invalid time %q, expected \"YYYY-MM-DD HH:mm:ss\", epoch milliseconds or a relative time like \"now-6h\"", s)
+	}
+
+	t := now
+	for _, offset := range relativeOffsetRe.FindAllStringSubmatch(matches[1], -1) {
+		n, _ := strconv.Atoi(offset[2])
+		if offset[1] == "-" {
+			n = -n
+		}
+		t = addTimeUnit(t, n, offset[3])
+	}
+	if unit := matches[2]; len(unit) > 0 {
+		t = startOfTimeUnit(t, unit)
+		if roundUp {
+			t = addTimeUnit(t, 1, unit).Add(-time.Millisecond)
+		}
+	}
+	return t, nil
+}
+
+// Grafana's named ranges, as the from and to of the range
+var namedTimeRanges = map[string][2]string{
+	"today":      {"now/d", "now/d"},
+	"yesterday":  {"now-1d/d", "now-1d/d"},
+	"this_week":  {"now/w", "now/w"},
+	"last_week":  {"now-1w/w", "now-1w/w"},
+	"this_month": {"now/M", "now/M"},
+	"last_month": {"now-1M/M", "now-1M/M"},
+	"this_year":  {"now/y", "now/y"},
+	"last_year":  {"now-1y/y", "now-1y/y"},
+}
+
+// The lengths of the duration units time.ParseDuration doesn't have. Months
+// and years are taken to be 30 and 365 days.
+var durationUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"M": 30 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+var durationRe = regexp.MustCompile(`^(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h|d|w|M|y))+$`)
+var durationPartRe = regexp.MustCompile(`(\d+(?:\.\d+)?)(ns|us|µs|ms|s|m|h|d|w|M|y)`)
+
+// ParseDuration parses a duration as time.ParseDuration does, but also with
+// days (d), weeks (w), months (M) and years (y), e.g. "30d" or "1w12h"
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	if !durationRe.MatchString(s) {
+		return 0, fmt.Errorf("Invalid duration %q, expected a duration like \"12h\", \"30d\" or \"2w\"", s)
+	}
+	var total time.Duration
+	for _, part := range durationPartRe.FindAllStringSubmatch(s, -1) {
+		unit, ok := durationUnits[part[2]]
+		if !ok {
+			d, err := time.ParseDuration(part[0])
+			if err != nil {
+				return 0, err
+			}
+			total += d
+			continue
+		}
+		n, err := strconv.ParseFloat(part[1], 64)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	return total, nil
+}
+
+// ParseTimeRange parses the from and to of a time range with ParseTime, or a
+// named range such as "today", "yesterday", "last_week" or "this_month" if
+// name is set
+func ParseTimeRange(name, from, to string, now time.Time) (TimeRange, error) {
+	if len(name) > 0 {
+		named, ok := namedTimeRanges[name]
+		if !ok {
+			return TimeRange{}, fmt.Errorf("Unknown time range %q", name)
+		}
+		from, to = named[0], named[1]
+	}
+	var r TimeRange
+	var err error
+	if r.From, err = ParseTime(from, now, false); err != nil {
+		return TimeRange{}, err
+	}
+	if r.To, err = ParseTime(to, now, true); err != nil {
+		return TimeRange{}, err
+	}
+	return r, nil
+}
+
+func addTimeUnit(t time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "s":
+		return t.Add(time.Duration(n) * time.Second)
+	case "m":
+		return t.Add(time.Duration(n) * time.Minute)
+	case "h":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "d":
+		return t.AddDate(0, 0, n)
+	case "w":
+		return t.AddDate(0, 0, 7*n)
+	case "M":
+		return t.AddDate(0, n, 0)
+	case "y":
+		return t.AddDate(n, 0, 0)
+	}
+	return t
+}
+
+// startOfTimeUnit rounds a time down to the start of its second, minute, etc.
+// Weeks start on Monday.
+func startOfTimeUnit(t time.Time, unit string) time.Time {
+	year, month, day := t.Date()
+	switch unit {
+	case "s":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	case "m":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, t.Location())
+	case "h":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case "d":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case "w":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, t.Location())
+	case "M":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case "y":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
@@ -0,0 +1,186 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	// some vars, a Wednesday
+	now := time.Date(2017, time.February, 8, 15, 4, 5, 0, time.UTC)
+	// times to test
+	var timeTests = []struct {
+		purpose  string
+		in       string
+		roundUp  bool
+		expected time.Time
+		valid    bool
+	}{
+		{
+			purpose:  "Absolute",
+			in:       "2017-01-23 12:34:56",
+			expected: time.Date(2017, time.January, 23, 12, 34, 56, 0, time.UTC),
+			valid:    true,
+		},
+		{
+			purpose:  "Epoch milliseconds",
+			in:       "1486566245000",
+			expected: now,
+			valid:    true,
+		},
+		{
+			purpose:  "Now",
+			in:       "now",
+			expected: now,
+			valid:    true,
+		},
+		{
+			purpose:  "Offset",
+			in:       "now-24h",
+			expected: now.Add(-24 * time.Hour),
+			valid:    true,
+		},
+		{
+			purpose:  "Several offsets",
+			in:       "now-1d+2h",
+			expected: now.Add(-22 * time.Hour),
+			valid:    true,
+		},
+		{
+			purpose:  "Rounded down",
+			in:       "now-7d/d",
+			expected: time.Date(2017, time.February, 1, 0, 0, 0, 0, time.UTC),
+			valid:    true,
+		},
+		{
+			purpose:  "Rounded up",
+			in:       "now-1d/d",
+			roundUp:  true,
+			expected: time.Date(2017, time.February, 7, 23, 59, 59, 999000000, time.UTC),
+			valid:    true,
+		},
+		{
+			purpose:  "Week starts on Monday",
+			in:       "now/w",
+			expected: time.Date(2017, time.February, 6, 0, 0, 0, 0, time.UTC),
+			valid:    true,
+		},
+		{
+			purpose:  "Month",
+			in:       "now-1M/M",
+			expected: time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC),
+			valid:    true,
+		},
+		{
+			purpose: "Unknown unit",
+			in:      "now-1q",
+			valid:   false,
+		},
+		{
+			purpose: "Not a time",
+			in:      "yesterday-ish",
+			valid:   false,
+		},
+	}
+	// test
+	for _, tt := range timeTests {
+		out, err := ParseTime(tt.in, now, tt.roundUp)
+		if tt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		} else if !tt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", tt.purpose)
+		} else if !out.Equal(tt.expected) {
+			t.Errorf("Test \"%s\" expected %s, got %s", tt.purpose, tt.expected, out)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2017, time.February, 8, 15, 4, 5, 0, time.UTC)
+	r, err := ParseTimeRange("yesterday", "ignored", "ignored", now)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if from := time.Date(2017, time.February, 7, 0, 0, 0, 0, time.UTC); !r.From.Equal(from) {
+		t.Errorf("Expected from %s, got %s", from, r.From)
+	}
+	if to := time.Date(2017, time.February, 7, 23, 59, 59, 999000000, time.UTC); !r.To.Equal(to) {
+		t.Errorf("Expected to %s, got %s", to, r.To)
+	}
+	if _, err = ParseTimeRange("fortnight", "", "", now); err == nil {
+		t.Errorf("Unknown range unexpectedly passed")
+	}
+}
+
+func TestParseTimeRangeLocation(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	// 2017-02-08 in UTC, but already 2017-02-09 in Tokyo
+	now := time.Date(2017, time.February, 8, 20, 0, 0, 0, time.UTC).In(tokyo)
+	r, err := ParseTimeRange("today", "", "", now)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if from := time.Date(2017, time.February, 9, 0, 0, 0, 0, tokyo); !r.From.Equal(from) {
+		t.Errorf("Expected from %s, got %s", from, r.From)
+	}
+	abs, err := ParseTime("2017-02-09 09:00:00", now, false)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if utc := time.Date(2017, time.February, 9, 0, 0, 0, 0, time.UTC); !abs.Equal(utc) {
+		t.Errorf("Expected %s, got %s", utc, abs)
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	locationTests := []struct {
+		purpose  string
+		in       string
+		valid    bool
+		expected *time.Location
+	}{
+		{purpose: "Dashboard default", in: "", valid: true, expected: time.Local},
+		{purpose: "Browser", in: "browser", valid: true, expected: time.Local},
+		{purpose: "UTC", in: "utc", valid: true, expected: time.UTC},
+		{purpose: "Unknown zone", in: "Not/AZone", valid: false},
+	}
+	for _, tt := range locationTests {
+		out, err := ParseLocation(tt.in)
+		if tt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		} else if !tt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", tt.purpose)
+		} else if tt.valid && out != tt.expected {
+			t.Errorf("Test \"%s\" expected %s, got %s", tt.purpose, tt.expected, out)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	durationTests := []struct {
+		purpose  string
+		in       string
+		valid    bool
+		expected time.Duration
+	}{
+		{purpose: "Go duration", in: "90m", valid: true, expected: 90 * time.Minute},
+		{purpose: "Days", in: "10d", valid: true, expected: 240 * time.Hour},
+		{purpose: "Weeks and hours", in: "1w12h", valid: true, expected: 180 * time.Hour},
+		{purpose: "Months", in: "1M", valid: true, expected: 720 * time.Hour},
+		{purpose: "Years", in: " 1y ", valid: true, expected: 8760 * time.Hour},
+		{purpose: "Fractional days", in: "1.5d", valid: true, expected: 36 * time.Hour},
+		{purpose: "Missing unit", in: "10", valid: false},
+		{purpose: "Unknown unit", in: "10x", valid: false},
+		{purpose: "Empty", in: "", valid: false},
+	}
+	for _, dt := range durationTests {
+		out, err := ParseDuration(dt.in)
+		if dt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", dt.purpose, err.Error())
+		} else if !dt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", dt.purpose)
+		} else if dt.valid && out != dt.expected {
+			t.Errorf("Test \"%s\" expected %s, got %s", dt.purpose, dt.expected, out)
+		}
+	}
+}
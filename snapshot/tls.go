@@ -0,0 +1,61 @@
+package snapshot
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig configures the TLS connections made to Grafana and the snapshot
+// host. It applies only to the SnapClient it's configured for.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of the certificate authorities to trust,
+	// instead of the system's
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and its key, to
+	// present to servers requiring client certificates
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name server certificates are verified
+	// against
+	ServerName string
+	// Insecure skips verifying server certificates. It should only be used
+	// for testing.
+	Insecure bool
+}
+
+func processTLSConfig(configIn *TLSConfig) (*TLSConfig, error) {
+	if (len(configIn.CertFile) == 0) != (len(configIn.KeyFile) == 0) {
+		return nil, errors.New("TLSConfig fields \"CertFile\" and \"KeyFile\" must be set together")
+	}
+	configOut := *configIn
+	return &configOut, nil
+}
+
+// clientConfig builds the crypto/tls config for connections
+func (c *TLSConfig) clientConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.Insecure,
+	}
+	if len(c.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CA file: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA file %q", c.CAFile)
+		}
+	}
+	if len(c.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "snapshot-tls")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = ioutil.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	serverURL, _ := url.Parse(server.URL)
+
+	tlsTests := []struct {
+		purpose string
+		tls     *TLSConfig
+		valid   bool
+	}{
+		{
+			purpose: "System CAs don't trust the test server",
+			tls:     nil,
+			valid:   false,
+		},
+		{
+			purpose: "CA file",
+			tls:     &TLSConfig{CAFile: caFile},
+			valid:   true,
+		},
+		{
+			purpose: "Insecure",
+			tls:     &TLSConfig{Insecure: true},
+			valid:   true,
+		},
+	}
+	// test
+	for _, tt := range tlsTests {
+		sc, err := NewSnapClient(&Config{GrafanaAddr: serverURL, GrafanaAPIKey: "XXXXX", TLS: tt.tls})
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+			continue
+		}
+		_, err = sc.grafanaGet(context.Background(), "api/health", nil)
+		if tt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+		} else if !tt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", tt.purpose)
+		}
+	}
+
+	if _, err = NewSnapClient(&Config{GrafanaAddr: serverURL, GrafanaAPIKey: "XXXXX", TLS: &TLSConfig{CertFile: caFile}}); err == nil {
+		t.Errorf("Client certificate without a key unexpectedly passed")
+	}
+
+	// an insecure client doesn't make other clients in the process insecure
+	if resp, err := http.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("Default client unexpectedly trusted the test server")
+	}
+}
@@ -0,0 +1,238 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// traceIDRe matches the trace IDs trace panels' targets query by
+var traceIDRe = regexp.MustCompile(`^[0-9a-fA-F]{1,32}$`)
+
+// traceSpan is a span of a trace, as Grafana's trace panel shows it
+type traceSpan struct {
+	traceID, spanID, parentSpanID string
+	operationName, serviceName    string
+	serviceTags, tags, logs       []interface{}
+	// startTime and duration are in milliseconds
+	startTime, duration float64
+}
+
+// traceQueryID returns the trace ID a trace panel target queries. Searches
+// aren't supported, as the trace panel shows a single trace.
+func traceQueryID(target map[string]interface{}) (string, error) {
+	query, _ := target["query"].(string)
+	query = strings.TrimSpace(query)
+	if queryType, _ := target["queryType"].(string); !traceIDRe.MatchString(query) || (len(queryType) > 0 && queryType != "traceql" && queryType != "traceId") {
+		return "", fmt.Errorf("Unsupported trace query %q, only trace IDs are supported", query)
+	}
+	return query, nil
+}
+
+func (sc *SnapClient) fetchTraceTempo(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	// service map queries are shown as a node graph
+	if target["queryType"] == "serviceMap" {
+		return sc.fetchTempoServiceMap(ctx, target, datasource, r)
+	}
+	traceID, err := traceQueryID(target)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := sc.DatasourceProxyRequest(ctx, "GET", datasource, "api/traces/"+url.PathEscape(traceID), url.Values{}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Tempo returns the trace as OTLP JSON, with base64 IDs
+	type otlpAttribute struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+	type otlpSpans struct {
+		Spans []struct {
+			TraceID           string          `json:"traceId"`
+			SpanID            string          `json:"spanId"`
+			ParentSpanID      string          `json:"parentSpanId"`
+			Name              string          `json:"name"`
+			StartTimeUnixNano string          `json:"startTimeUnixNano"`
+			EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+			Attributes        []otlpAttribute `json:"attributes"`
+		} `json:"spans"`
+	}
+	var trace struct {
+		Batches []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans                  []otlpSpans `json:"scopeSpans"`
+			InstrumentationLibrarySpans []otlpSpans `json:"instrumentationLibrarySpans"`
+		} `json:"batches"`
+	}
+	if err = json.Unmarshal(respBody, &trace); err != nil {
+		return nil, fmt.Errorf("Could not decode Tempo trace: %s", err.Error())
+	}
+	tags := func(attributes []otlpAttribute) []interface{} {
+		list := []interface{}{}
+		for _, a := range attributes {
+			// the value is the one set of stringValue, intValue and so on
+			for _, v := range a.Value {
+				list = append(list, map[string]interface{}{"key": a.Key, "value": v})
+			}
+		}
+		return list
+	}
+	hexID := func(id string) string {
+		b, err := base64.StdEncoding.DecodeString(id)
+		if err != nil {
+			return id
+		}
+		return hex.EncodeToString(b)
+	}
+
+	var spans []traceSpan
+	for _, batch := range trace.Batches {
+		serviceName := ""
+		for _, a := range batch.Resource.Attributes {
+			if a.Key == "service.name" {
+				serviceName, _ = a.Value["stringValue"].(string)
+			}
+		}
+		for _, scope := range append(batch.ScopeSpans, batch.InstrumentationLibrarySpans...) {
+			for _, span := range scope.Spans {
+				start, _ := strconv.ParseInt(span.StartTimeUnixNano, 10, 64)
+				end, _ := strconv.ParseInt(span.EndTimeUnixNano, 10, 64)
+				spans = append(spans, traceSpan{
+					traceID:       hexID(span.TraceID),
+					spanID:        hexID(span.SpanID),
+					parentSpanID:  hexID(span.ParentSpanID),
+					operationName: span.Name,
+					serviceName:   serviceName,
+					serviceTags:   tags(batch.Resource.Attributes),
+					tags:          tags(span.Attributes),
+					logs:          []interface{}{},
+					startTime:     float64(start) / 1e6,
+					duration:      float64(end-start) / 1e6,
+				})
+			}
+		}
+	}
+	refID, _ := target["refId"].(string)
+	return traceFrame(spans, refID), nil
+}
+
+func (sc *SnapClient) fetchTraceJaeger(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+	// dependency graph queries are shown as a node graph
+	if target["queryType"] == "dependencyGraph" {
+		return sc.fetchJaegerDependencies(ctx, target, datasource, r)
+	}
+	traceID, err := traceQueryID(target)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := sc.DatasourceProxyRequest(ctx, "GET", datasource, "api/traces/"+url.PathEscape(traceID), url.Values{}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Jaeger times are in microseconds
+	var jaegerResp struct {
+		Data []struct {
+			Spans []struct {
+				TraceID       string `json:"traceID"`
+				SpanID        string `json:"spanID"`
+				OperationName string `json:"operationName"`
+				References    []struct {
+					RefType string `json:"refType"`
+					SpanID  string `json:"spanID"`
+				} `json:"references"`
+				StartTime float64       `json:"startTime"`
+				Duration  float64       `json:"duration"`
+				Tags      []interface{} `json:"tags"`
+				Logs      []interface{} `json:"logs"`
+				ProcessID string        `json:"processID"`
+			} `json:"spans"`
+			Processes map[string]struct {
+				ServiceName string        `json:"serviceName"`
+				Tags        []interface{} `json:"tags"`
+			} `json:"processes"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(respBody, &jaegerResp); err != nil {
+		return nil, fmt.Errorf("Could not decode Jaeger trace: %s", err.Error())
+	}
+	if len(jaegerResp.Data) == 0 {
+		return nil, errors.New("Jaeger returned no trace")
+	}
+
+	var spans []traceSpan
+	trace := jaegerResp.Data[0]
+	for _, span := range trace.Spans {
+		parentSpanID := ""
+		for _, ref := range span.References {
+			if ref.RefType == "CHILD_OF" {
+				parentSpanID = ref.SpanID
+			}
+		}
+		process := trace.Processes[span.ProcessID]
+		spans = append(spans, traceSpan{
+			traceID:       span.TraceID,
+			spanID:        span.SpanID,
+			parentSpanID:  parentSpanID,
+			operationName: span.OperationName,
+			serviceName:   process.ServiceName,
+			serviceTags:   nonNil(process.Tags),
+			tags:          nonNil(span.Tags),
+			logs:          nonNil(span.Logs),
+			startTime:     span.StartTime / 1e3,
+			duration:      span.Duration / 1e3,
+		})
+	}
+	refID, _ := target["refId"].(string)
+	return traceFrame(spans, refID), nil
+}
+
+// nonNil returns list, or an empty list if it's nil, so it's encoded as []
+func nonNil(list []interface{}) []interface{} {
+	if list == nil {
+		return []interface{}{}
+	}
+	return list
+}
+
+// traceFrame converts a trace's spans into the data frame Grafana's trace
+// panel shows as a waterfall
+func traceFrame(spans []traceSpan, refID string) []SnapshotData {
+	columns := []string{"traceID", "spanID", "parentSpanID", "operationName", "serviceName", "serviceTags", "startTime", "duration", "logs", "tags"}
+	values := make([][]interface{}, len(columns))
+	for _, span := range spans {
+		row := []interface{}{span.traceID, span.spanID, span.parentSpanID, span.operationName, span.serviceName, span.serviceTags, span.startTime, span.duration, span.logs, span.tags}
+		for idx, v := range row {
+			values[idx] = append(values[idx], v)
+		}
+	}
+	fields := make([]interface{}, len(columns))
+	for idx, name := range columns {
+		fieldType := "string"
+		switch name {
+		case "startTime", "duration":
+			fieldType = "number"
+		case "serviceTags", "logs", "tags":
+			fieldType = "other"
+		}
+		fields[idx] = map[string]interface{}{"name": name, "type": fieldType, "values": nonNil(values[idx])}
+	}
+	return []SnapshotData{{Frame: map[string]interface{}{
+		"name":   "Trace",
+		"refId":  refID,
+		"meta":   map[string]interface{}{"preferredVisualisationType": "trace"},
+		"fields": fields,
+	}}}
+}
@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFetchTraces(t *testing.T) {
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/datasources/proxy/1/api/traces/0102":
+			// Tempo
+			w.Write([]byte(`{"batches": [{
+				"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+				"scopeSpans": [{"spans": [
+					{"traceId": "AQI=", "spanId": "AQ==", "name": "GET /", "startTimeUnixNano": "1000000000", "endTimeUnixNano": "1500000000"},
+					{"traceId": "AQI=", "spanId": "Ag==", "parentSpanId": "AQ==", "name": "query", "startTimeUnixNano": "1100000000", "endTimeUnixNano": "1200000000",
+						"attributes": [{"key": "db", "value": {"stringValue": "users"}}]}
+				]}]
+			}]}`))
+		case "/api/datasources/proxy/2/api/traces/0102":
+			// Jaeger
+			w.Write([]byte(`{"data": [{
+				"traceID": "0102",
+				"spans": [
+					{"traceID": "0102", "spanID": "01", "operationName": "GET /", "startTime": 1000000, "duration": 500000, "processID": "p1"},
+					{"traceID": "0102", "spanID": "02", "operationName": "query", "references": [{"refType": "CHILD_OF", "spanID": "01"}],
+						"startTime": 1100000, "duration": 100000, "tags": [{"key": "db", "value": "users"}], "processID": "p1"}
+				],
+				"processes": {"p1": {"serviceName": "api"}}
+			}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+
+	var traceTests = []struct {
+		purpose    string
+		fetch      func(sc *SnapClient, ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error)
+		datasource float64
+		query      string
+		valid      bool
+	}{
+		{
+			purpose:    "Tempo trace",
+			fetch:      (*SnapClient).fetchTraceTempo,
+			datasource: 1,
+			query:      "0102",
+			valid:      true,
+		},
+		{
+			purpose:    "Jaeger trace",
+			fetch:      (*SnapClient).fetchTraceJaeger,
+			datasource: 2,
+			query:      "0102",
+			valid:      true,
+		},
+		{
+			purpose:    "Search",
+			fetch:      (*SnapClient).fetchTraceTempo,
+			datasource: 1,
+			query:      `{ .service.name = "api" }`,
+			valid:      false,
+		},
+	}
+	for _, tt := range traceTests {
+		target := map[string]interface{}{"refId": "A", "query": tt.query}
+		data, err := tt.fetch(sc, context.Background(), target, map[string]interface{}{"id": tt.datasource}, TimeRange{}, time.Minute)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("Test \"%s\" unexpectedly passed", tt.purpose)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", tt.purpose, err.Error())
+			continue
+		}
+		if len(data) != 1 || data[0].Frame == nil {
+			t.Errorf("Test \"%s\" expected a trace frame, got %+v", tt.purpose, data)
+			continue
+		}
+		// both have the same spans
+		fields, _ := data[0].Frame["fields"].([]interface{})
+		columns := make(map[string]interface{})
+		for _, f := range fields {
+			field, _ := f.(map[string]interface{})
+			columns[field["name"].(string)] = field["values"]
+		}
+		expected := map[string][]interface{}{
+			"traceID":      {"0102", "0102"},
+			"spanID":       {"01", "02"},
+			"parentSpanID": {"", "01"},
+			"serviceName":  {"api", "api"},
+			"startTime":    {1000.0, 1100.0},
+			"duration":     {500.0, 100.0},
+		}
+		for name, values := range expected {
+			if !reflect.DeepEqual(columns[name], values) {
+				t.Errorf("Test \"%s\" expected %s %v, got %v", tt.purpose, name, values, columns[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// tsdbResult is a single query result returned by Grafana's /api/tsdb/query
+// endpoint, which runs queries for backend datasources (SQL, Azure, etc.)
+type tsdbResult struct {
+	RefID  string       `json:"refId"`
+	Error  string       `json:"error"`
+	Series []tsdbSeries `json:"series"`
+}
+
+// tsdbSeries is a series of a tsdb query result, of [value, timestamp] points
+type tsdbSeries struct {
+	Name   string            `json:"name"`
+	Tags   map[string]string `json:"tags"`
+	Points [][]interface{}   `json:"points"`
+}
+
+// dsQueryResult is a single query result returned by Grafana's /api/ds/query
+// endpoint, which replaces /api/tsdb/query from Grafana 8, as data frames
+type dsQueryResult struct {
+	Error  string `json:"error"`
+	Frames []struct {
+		Schema struct {
+			Name   string `json:"name"`
+			Fields []struct {
+				Name   string            `json:"name"`
+				Type   string            `json:"type"`
+				Labels map[string]string `json:"labels"`
+				Config struct {
+					DisplayNameFromDS string `json:"displayNameFromDS"`
+				} `json:"config"`
+			} `json:"fields"`
+		} `json:"schema"`
+		Data struct {
+			Values [][]interface{} `json:"values"`
+		} `json:"data"`
+	} `json:"frames"`
+}
+
+// tsdbResult converts the data frames of a result to series, one for each
+// number field of each frame with a time field
+func (r dsQueryResult) tsdbResult(refID string) tsdbResult {
+	result := tsdbResult{RefID: refID, Error: r.Error}
+	for _, frame := range r.Frames {
+		timeIdx := -1
+		for idx, field := range frame.Schema.Fields {
+			if field.Type == "time" && idx < len(frame.Data.Values) {
+				timeIdx = idx
+				break
+			}
+		}
+		if timeIdx == -1 {
+			continue
+		}
+		times := frame.Data.Values[timeIdx]
+		for idx, field := range frame.Schema.Fields {
+			if field.Type != "number" || idx >= len(frame.Data.Values) {
+				continue
+			}
+			name := field.Config.DisplayNameFromDS
+			if len(name) == 0 && len(field.Labels) == 0 && len(frame.Schema.Name) > 0 {
+				name = frame.Schema.Name
+			}
+			if len(name) == 0 {
+				name = field.Name
+				if len(field.Labels) > 0 {
+					name = labelSet(field.Labels).String()
+				}
+			}
+			points := make([][]interface{}, 0, len(times))
+			for row, t := range times {
+				if row < len(frame.Data.Values[idx]) {
+					points = append(points, []interface{}{frame.Data.Values[idx][row], t})
+				}
+			}
+			result.Series = append(result.Series, tsdbSeries{Name: name, Tags: field.Labels, Points: points})
+		}
+	}
+	return result
+}
+
+// labelSet converts labels to a LabelSet
+func labelSet(labels map[string]string) model.LabelSet {
+	set := model.LabelSet{}
+	for k, v := range labels {
+		set[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return set
+}
+
+// tsdbQuery posts queries to Grafana's backend query endpoint for the time
+// range of the snapshot: /api/ds/query from Grafana 8, or /api/tsdb/query
+// before. Each query must include a refId.
+func (sc *SnapClient) tsdbQuery(ctx context.Context, r TimeRange, datasource map[string]interface{}, step time.Duration, queries []map[string]interface{}) (map[string]tsdbResult, error) {
+	dsQuery := sc.grafana(ctx).atLeast(dsQueryMajor, 0)
+	for _, q := range queries {
+		if uid, ok := datasource["uid"].(string); ok && dsQuery {
+			q["datasource"] = map[string]interface{}{"uid": uid, "type": datasource["type"]}
+		}
+		q["datasourceId"] = datasource["id"]
+		q["intervalMs"] = int64(step / time.Millisecond)
+		if _, ok := q["maxDataPoints"]; !ok {
+			q["maxDataPoints"] = int64(r.To.Sub(r.From) / step)
+		}
+	}
+	reqBody := map[string]interface{}{
+		"from":    strconv.FormatInt(r.From.UnixNano()/int64(time.Millisecond), 10),
+		"to":      strconv.FormatInt(r.To.UnixNano()/int64(time.Millisecond), 10),
+		"queries": queries,
+	}
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	reqURL := *sc.config.GrafanaAddr
+	if dsQuery {
+		reqURL.Path = reqURL.Path + "api/ds/query"
+	} else {
+		reqURL.Path = reqURL.Path + "api/tsdb/query"
+	}
+	sc.config.Logger.Debug("Requesting data points", "url", reqURL.String())
+
+	req, err := http.NewRequest("POST", reqURL.String(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if req, err = sc.grafanaAuth(req); err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = unauthorized("Grafana", resp.StatusCode); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Unexpected status code from tsdb query: %s: %s", resp.Status, string(body))
+	}
+
+	var tsdbResp struct {
+		Results map[string]tsdbResult `json:"results"`
+	}
+	if dsQuery {
+		var dsResp struct {
+			Results map[string]dsQueryResult `json:"results"`
+		}
+		if err = json.Unmarshal(body, &dsResp); err != nil {
+			return nil, fmt.Errorf("Could not decode ds query response: %s", err.Error())
+		}
+		tsdbResp.Results = make(map[string]tsdbResult)
+		for refID, result := range dsResp.Results {
+			tsdbResp.Results[refID] = result.tsdbResult(refID)
+		}
+	} else if err = json.Unmarshal(body, &tsdbResp); err != nil {
+		return nil, fmt.Errorf("Could not decode tsdb query response: %s", err.Error())
+	}
+	for refID, result := range tsdbResp.Results {
+		if len(result.Error) > 0 {
+			return nil, fmt.Errorf("Query %q failed: %s", refID, result.Error)
+		}
+	}
+	return tsdbResp.Results, nil
+}
+
+// tsdbToSnapshotData converts the series of a tsdb query result, which
+// are already in [value, timestamp] form, into snapshot data
+func tsdbToSnapshotData(result tsdbResult) []SnapshotData {
+	results := make([]SnapshotData, len(result.Series))
+	for idx, series := range result.Series {
+		metric := model.Metric{}
+		for k, v := range series.Tags {
+			metric[model.LabelName(k)] = model.LabelValue(v)
+		}
+		datapoints := series.Points
+		if datapoints == nil {
+			datapoints = [][]interface{}{}
+		}
+		results[idx] = SnapshotData{
+			Target:     series.Name,
+			Datapoints: datapoints,
+			Metric:     metric,
+		}
+	}
+	return results
+}
@@ -0,0 +1,393 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+var (
+	labelNamesRe  = regexp.MustCompile(`^label_names\(\)\s*$`)
+	labelValuesRe = regexp.MustCompile(`^label_values\((?:(.+),\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\)\s*$`)
+	metricNamesRe = regexp.MustCompile(`^metrics\((.+)\)\s*$`)
+	queryResultRe = regexp.MustCompile(`^query_result\((.+)\)\s*$`)
+)
+
+// refreshVariables runs the query of "query" type template variables against
+// their datasource for the snapshot time range, replacing the options saved
+// with the dashboard. Variables are resolved in dependency order; all query
+// variables are refreshed when TakeConfig.RefreshVariables is set, otherwise
+// only those depending on a variable set in TakeConfig.Vars, so overrides
+// cascade. A saved selection which is no longer an option falls back to the
+// first option; values set in TakeConfig.Vars are kept.
+func (sc *SnapClient) refreshVariables(ctx context.Context, c *TakeConfig, dashboard, datasourceMap map[string]interface{}, values map[string]variableValue) error {
+	list, err := sortVariables(dashboard)
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]bool)
+	for name := range c.Vars {
+		changed[name] = true
+	}
+	for _, variable := range list {
+		name, _ := variable["name"].(string)
+		if variable["type"] != "query" {
+			continue
+		}
+		if !c.RefreshVariables {
+			dependsOnChanged := false
+			for _, dep := range variableDependencies(variable) {
+				dependsOnChanged = dependsOnChanged || changed[dep]
+			}
+			if !dependsOnChanged {
+				continue
+			}
+		}
+		changed[name] = true
+
+		// find the datasource
+		datasourceName, err := resolveDatasourceName(datasourceRef(variable["datasource"]), dashboard, c.Vars, datasourceMap)
+		if err != nil {
+			return err
+		}
+		if len(datasourceName) == 0 {
+			datasourceName = defaultDatasourceName(datasourceMap)
+		}
+		datasource, ok := datasourceMap[datasourceName].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Unknown datasource %q for template variable %q", datasourceName, name)
+		}
+		if datasource["type"] != "prometheus" {
+			sc.warn(ctx, "Not refreshing template variable: unsupported datasource type", "variable", name, "type", datasource["type"])
+			continue
+		}
+
+		// queries may reference the variables they depend on
+		query := variableQuery(variable["query"])
+		query = interpolate(query, values, nil, prometheusFormat)
+		results, err := sc.prometheusVariableQuery(ctx, c, datasource, query)
+		if err != nil {
+			return fmt.Errorf("Failed to refresh template variable %q: %s", name, err.Error())
+		}
+		options, err := variableOptions(variable, results)
+		if err != nil {
+			return err
+		}
+
+		// save the new options, and check the selection is still valid
+		saved := make([]interface{}, len(options))
+		for idx, option := range options {
+			saved[idx] = map[string]interface{}{"text": option.Text[0], "value": option.Value[0]}
+		}
+		variable["options"] = saved
+		if _, overridden := c.Vars[name]; overridden {
+			continue
+		}
+		current, _ := variable["current"].(map[string]interface{})
+		selected := variableStrings(current["value"])
+		if len(selected) == 1 && selected[0] == allValue {
+			all := allOptions(variable, panelList(saved))
+			all.Multi = true
+			values[name] = all
+			continue
+		}
+		valid := variableValue{Multi: values[name].Multi}
+		for _, s := range selected {
+			for _, option := range options {
+				if option.Value[0] == s {
+					valid.Text = append(valid.Text, option.Text[0])
+					valid.Value = append(valid.Value, s)
+				}
+			}
+		}
+		if len(valid.Value) == 0 && len(options) > 0 {
+			valid = options[0]
+		}
+		values[name] = valid
+	}
+	return nil
+}
+
+// variableDependencies returns the names of the variables referenced by a
+// variable's query, datasource and regex
+func variableDependencies(variable map[string]interface{}) []string {
+	datasource := datasourceRef(variable["datasource"])
+	regex, _ := variable["regex"].(string)
+	var deps []string
+	for _, s := range []string{variableQuery(variable["query"]), datasource, regex} {
+		for _, groups := range interpolateRe.FindAllStringSubmatch(s, -1) {
+			deps = append(deps, groups[1]+groups[2]+groups[4])
+		}
+	}
+	return deps
+}
+
+// sortVariables returns the dashboard's template variables ordered so each
+// variable comes after the variables it depends on, keeping the dashboard
+// order otherwise
+func sortVariables(dashboard map[string]interface{}) ([]map[string]interface{}, error) {
+	templating, _ := dashboard["templating"].(map[string]interface{})
+	list := panelList(templating["list"])
+	byName := make(map[string]map[string]interface{})
+	for _, variable := range list {
+		name, _ := variable["name"].(string)
+		byName[name] = variable
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var sorted []map[string]interface{}
+	var visit func(variable map[string]interface{}) error
+	visit = func(variable map[string]interface{}) error {
+		name, _ := variable["name"].(string)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("Template variable %q has a circular dependency", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range variableDependencies(variable) {
+			// references to unknown variables, e.g. built ins, are ignored
+			if depVariable, ok := byName[dep]; ok && dep != name {
+				if err := visit(depVariable); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = visited
+		sorted = append(sorted, variable)
+		return nil
+	}
+	for _, variable := range list {
+		if err := visit(variable); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// variableQuery returns a variable's query, which newer dashboards save as an
+// object
+func variableQuery(q interface{}) string {
+	switch query := q.(type) {
+	case string:
+		return query
+	case map[string]interface{}:
+		s, _ := query["query"].(string)
+		return s
+	}
+	return ""
+}
+
+// defaultDatasourceName returns the name of the instance's default datasource
+func defaultDatasourceName(datasourceMap map[string]interface{}) string {
+	for name, ds := range datasourceMap {
+		if isDefault, _ := ds.(map[string]interface{})["isDefault"].(bool); isDefault {
+			return name
+		}
+	}
+	return ""
+}
+
+// variableOptions filters the query results with the variable's regex, using
+// the first capture group as the value when there is one, and sorts them
+// according to the variable's sort setting
+func variableOptions(variable map[string]interface{}, results []string) ([]variableValue, error) {
+	var re *regexp.Regexp
+	if pattern, _ := variable["regex"].(string); len(pattern) > 0 {
+		pattern = strings.TrimPrefix(pattern, "/")
+		if idx := strings.LastIndex(pattern, "/"); idx >= 0 {
+			flags := pattern[idx+1:]
+			pattern = pattern[:idx]
+			if strings.Contains(flags, "i") {
+				pattern = "(?i)" + pattern
+			}
+		}
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("Invalid regex on template variable %q: %s", variable["name"], err.Error())
+		}
+	}
+
+	seen := make(map[string]bool)
+	var options []variableValue
+	for _, result := range results {
+		value := result
+		if re != nil {
+			matches := re.FindStringSubmatch(result)
+			if matches == nil {
+				continue
+			}
+			if len(matches) > 1 {
+				value = matches[1]
+			}
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		options = append(options, variableValue{Text: []string{value}, Value: []string{value}})
+	}
+
+	sortOrder, _ := variable["sort"].(float64)
+	less := func(i, j int) bool { return options[i].Value[0] < options[j].Value[0] }
+	switch sortOrder {
+	case 1, 2:
+	case 3, 4:
+		less = func(i, j int) bool {
+			a, _ := strconv.ParseFloat(options[i].Value[0], 64)
+			b, _ := strconv.ParseFloat(options[j].Value[0], 64)
+			return a < b
+		}
+	case 5, 6:
+		less = func(i, j int) bool {
+			return strings.ToLower(options[i].Value[0]) < strings.ToLower(options[j].Value[0])
+		}
+	default:
+		return options, nil
+	}
+	if int(sortOrder)%2 == 0 {
+		asc := less
+		less = func(i, j int) bool { return asc(j, i) }
+	}
+	sort.SliceStable(options, less)
+	return options, nil
+}
+
+// prometheusVariableQuery runs one of Grafana's Prometheus template variable
+// queries (label_names(), label_values(), metrics(), query_result()) and
+// returns the resulting values
+func (sc *SnapClient) prometheusVariableQuery(ctx context.Context, c *TakeConfig, datasource map[string]interface{}, query string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, sc.config.QueryTimeout)
+	defer cancel()
+
+	query = strings.TrimSpace(query)
+	timeParams := url.Values{}
+	timeParams.Set("start", strconv.FormatInt(c.From.Unix(), 10))
+	timeParams.Set("end", strconv.FormatInt(c.To.Unix(), 10))
+
+	switch {
+	case labelNamesRe.MatchString(query):
+		var names []string
+		err := sc.prometheusAPIGet(ctx, datasource, "api/v1/labels", timeParams, &names)
+		return names, err
+
+	case labelValuesRe.MatchString(query):
+		groups := labelValuesRe.FindStringSubmatch(query)
+		selector, label := strings.TrimSpace(groups[1]), groups[2]
+		if len(selector) == 0 {
+			var values []string
+			err := sc.prometheusAPIGet(ctx, datasource, "api/v1/label/"+label+"/values", timeParams, &values)
+			return values, err
+		}
+		timeParams.Set("match[]", selector)
+		var series []model.Metric
+		if err := sc.prometheusAPIGet(ctx, datasource, "api/v1/series", timeParams, &series); err != nil {
+			return nil, err
+		}
+		var values []string
+		for _, metric := range series {
+			if value, ok := metric[model.LabelName(label)]; ok {
+				values = append(values, string(value))
+			}
+		}
+		return values, nil
+
+	case metricNamesRe.MatchString(query):
+		re, err := regexp.Compile(strings.TrimSpace(metricNamesRe.FindStringSubmatch(query)[1]))
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		if err = sc.prometheusAPIGet(ctx, datasource, "api/v1/label/__name__/values", timeParams, &names); err != nil {
+			return nil, err
+		}
+		var matched []string
+		for _, name := range names {
+			if re.MatchString(name) {
+				matched = append(matched, name)
+			}
+		}
+		return matched, nil
+
+	case queryResultRe.MatchString(query):
+		params := url.Values{}
+		params.Set("query", queryResultRe.FindStringSubmatch(query)[1])
+		params.Set("time", strconv.FormatInt(c.To.Unix(), 10))
+		var result struct {
+			ResultType model.ValueType `json:"resultType"`
+			Result     model.Vector    `json:"result"`
+		}
+		if err := sc.prometheusAPIGet(ctx, datasource, "api/v1/query", params, &result); err != nil {
+			return nil, err
+		}
+		values := make([]string, len(result.Result))
+		for idx, sample := range result.Result {
+			values[idx] = prometheusResultText(sample)
+		}
+		return values, nil
+	}
+
+	// anything else is treated as a series selector, as Grafana does
+	timeParams.Set("match[]", query)
+	var series []model.Metric
+	if err := sc.prometheusAPIGet(ctx, datasource, "api/v1/series", timeParams, &series); err != nil {
+		return nil, err
+	}
+	values := make([]string, len(series))
+	for idx, metric := range series {
+		values[idx] = metric.String()
+	}
+	return values, nil
+}
+
+// prometheusResultText formats an instant query sample the way Grafana does
+// for query_result() variables: metric{label="value",...} value timestamp
+func prometheusResultText(sample *model.Sample) string {
+	names := make([]string, 0, len(sample.Metric))
+	for name := range sample.Metric {
+		if name != model.MetricNameLabel {
+			names = append(names, string(name))
+		}
+	}
+	sort.Strings(names)
+	labels := make([]string, len(names))
+	for idx, name := range names {
+		labels[idx] = name + "=\"" + string(sample.Metric[model.LabelName(name)]) + "\""
+	}
+	return fmt.Sprintf("%s{%s} %s %d", sample.Metric[model.MetricNameLabel], strings.Join(labels, ","), sample.Value, int64(sample.Timestamp))
+}
+
+// prometheusAPIGet makes a GET request to the Prometheus HTTP API through the
+// datasource proxy and decodes the "data" field of the response into v
+func (sc *SnapClient) prometheusAPIGet(ctx context.Context, datasource map[string]interface{}, path string, params url.Values, v interface{}) error {
+	body, err := sc.DatasourceProxyRequest(ctx, "GET", datasource, path, params, nil, "")
+	if err != nil {
+		return err
+	}
+	var apiResp struct {
+		Status string          `json:"status"`
+		Error  string          `json:"error"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(body, &apiResp); err != nil {
+		return fmt.Errorf("Could not decode Prometheus response: %s", err.Error())
+	}
+	if apiResp.Status != "success" {
+		return errors.New("Prometheus query failed: " + apiResp.Error)
+	}
+	return json.Unmarshal(apiResp.Data, v)
+}
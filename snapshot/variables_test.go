@@ -0,0 +1,133 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVariableOptions(t *testing.T) {
+	// some vars
+	results := []string{"node-10:9100", "node-9:9100", "node-2:9100", "node-9:9100", "db-1:9100"}
+	// variables to test
+	var optionTests = []struct {
+		purpose  string
+		variable map[string]interface{}
+		expected []string
+		valid    bool
+	}{
+		{
+			purpose:  "No regex or sort",
+			variable: map[string]interface{}{},
+			expected: []string{"node-10:9100", "node-9:9100", "node-2:9100", "db-1:9100"},
+			valid:    true,
+		},
+		{
+			purpose:  "Capture group, alphabetical descending",
+			variable: map[string]interface{}{"regex": "/node-(\\d+)/", "sort": float64(2)},
+			expected: []string{"9", "2", "10"},
+			valid:    true,
+		},
+		{
+			purpose:  "Capture group, numerical ascending",
+			variable: map[string]interface{}{"regex": "/node-(\\d+)/", "sort": float64(3)},
+			expected: []string{"2", "9", "10"},
+			valid:    true,
+		},
+		{
+			purpose:  "Case insensitive regex",
+			variable: map[string]interface{}{"regex": "/^DB/i"},
+			expected: []string{"db-1:9100"},
+			valid:    true,
+		},
+		{
+			purpose:  "Invalid regex",
+			variable: map[string]interface{}{"regex": "/(/"},
+			valid:    false,
+		},
+	}
+	// test
+	for _, ot := range optionTests {
+		options, err := variableOptions(ot.variable, results)
+		if ot.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", ot.purpose, err.Error())
+			continue
+		} else if !ot.valid {
+			if err == nil {
+				t.Errorf("Test \"%s\" unexpectedly passed", ot.purpose)
+			}
+			continue
+		}
+		var out []string
+		for _, option := range options {
+			out = append(out, option.Value[0])
+		}
+		if !reflect.DeepEqual(out, ot.expected) {
+			t.Errorf("Test \"%s\" expected %v, got %v", ot.purpose, ot.expected, out)
+		}
+	}
+}
+
+func TestSortVariables(t *testing.T) {
+	variable := func(name, query string) interface{} {
+		return map[string]interface{}{"name": name, "type": "query", "query": query}
+	}
+	// dashboards to test
+	var sortTests = []struct {
+		purpose  string
+		list     []interface{}
+		expected []string
+		valid    bool
+	}{
+		{
+			purpose:  "Independent variables keep their order",
+			list:     []interface{}{variable("b", "up"), variable("a", "up")},
+			expected: []string{"b", "a"},
+			valid:    true,
+		},
+		{
+			purpose: "Dependent variable listed first",
+			list: []interface{}{
+				variable("instance", "label_values(up{job=\"$job\", env=~\"${env:regex}\"}, instance)"),
+				variable("job", "label_values(up{env=\"[[env]]\"}, job)"),
+				variable("env", "label_values(env)"),
+			},
+			expected: []string{"env", "job", "instance"},
+			valid:    true,
+		},
+		{
+			purpose:  "Built in and unknown variables are ignored",
+			list:     []interface{}{variable("a", "rate(up[$__rate_interval]) > $missing")},
+			expected: []string{"a"},
+			valid:    true,
+		},
+		{
+			purpose: "Circular dependency",
+			list: []interface{}{
+				variable("a", "label_values(up{b=\"$b\"}, a)"),
+				variable("b", "label_values(up{a=\"$a\"}, b)"),
+			},
+			valid: false,
+		},
+	}
+	// test
+	for _, st := range sortTests {
+		dashboard := map[string]interface{}{"templating": map[string]interface{}{"list": st.list}}
+		sorted, err := sortVariables(dashboard)
+		if st.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", st.purpose, err.Error())
+			continue
+		} else if !st.valid {
+			if err == nil {
+				t.Errorf("Test \"%s\" unexpectedly passed", st.purpose)
+			}
+			continue
+		}
+		var out []string
+		for _, v := range sorted {
+			out = append(out, v["name"].(string))
+		}
+		if !reflect.DeepEqual(out, st.expected) {
+			t.Errorf("Test \"%s\" expected %v, got %v", st.purpose, st.expected, out)
+		}
+	}
+}
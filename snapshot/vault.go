@@ -0,0 +1,318 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultConfig configures reading the Grafana and snapshot host API keys from
+// a HashiCorp Vault KV secret
+type VaultConfig struct {
+	// Addr is the address of the Vault server
+	Addr *url.URL
+	// Token authenticates with Vault. Without one, the client logs in with
+	// Role instead.
+	Token string
+	// Role is the Vault role to log in as with the Kubernetes auth method,
+	// using the pod's service account token
+	Role string
+	// AuthPath is the path the Kubernetes auth method is mounted at.
+	// Defaults to "kubernetes".
+	AuthPath string
+	// JWTFile is the service account token to log in with. Defaults to
+	// the token Kubernetes mounts in pods.
+	JWTFile string
+	// Path is the API path of the secret, after "/v1/", e.g.
+	// "secret/data/snapshot_grafana" for a KV version 2 secret
+	Path string
+	// GrafanaKeyField is the field of the secret holding the Grafana API
+	// key. Defaults to "grafana_api_key".
+	GrafanaKeyField string
+	// SnapshotKeyField is the field of the secret holding the snapshot host
+	// API key. Defaults to "snapshot_api_key", and the Grafana API key is
+	// used if the secret doesn't have it.
+	SnapshotKeyField string
+	// MaxAge is how long to use keys read from a secret without a lease,
+	// such as a KV version 2 secret, before reading them again. Defaults to
+	// 5m.
+	MaxAge time.Duration
+	// TLS, if set, configures the TLS connections to Vault
+	TLS *TLSConfig
+}
+
+// Default path of the service account token in Kubernetes pods
+const defaultVaultJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultConfigFromEnv builds a VaultConfig for the secret at path from the
+// VAULT_ADDR, VAULT_TOKEN, VAULT_ROLE, VAULT_AUTH_PATH, VAULT_CACERT and
+// VAULT_SKIP_VERIFY environment variables
+func VaultConfigFromEnv(path string) (*VaultConfig, error) {
+	config := &VaultConfig{
+		Token:    os.Getenv("VAULT_TOKEN"),
+		Role:     os.Getenv("VAULT_ROLE"),
+		AuthPath: os.Getenv("VAULT_AUTH_PATH"),
+		Path:     path,
+	}
+	if addr := os.Getenv("VAULT_ADDR"); len(addr) > 0 {
+		vURL, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid VAULT_ADDR: %s", err.Error())
+		}
+		config.Addr = vURL
+	}
+	caFile := os.Getenv("VAULT_CACERT")
+	insecure := os.Getenv("VAULT_SKIP_VERIFY") == "true" || os.Getenv("VAULT_SKIP_VERIFY") == "1"
+	if len(caFile) > 0 || insecure {
+		config.TLS = &TLSConfig{CAFile: caFile, Insecure: insecure}
+	}
+	return config, nil
+}
+
+func processVaultConfig(configIn *VaultConfig) (*VaultConfig, error) {
+	configOut := *configIn
+
+	if configIn.Addr == nil || len(configIn.Addr.String()) == 0 {
+		return nil, errors.New("Missing required VaultConfig field: \"Addr\"")
+	}
+	if len(configIn.Path) == 0 {
+		return nil, errors.New("Missing required VaultConfig field: \"Path\"")
+	}
+	configOut.Path = strings.Trim(configIn.Path, "/")
+	if len(configIn.Token) == 0 && len(configIn.Role) == 0 {
+		return nil, errors.New("Missing required VaultConfig field: \"Token\" or \"Role\"")
+	}
+	if len(configOut.AuthPath) == 0 {
+		configOut.AuthPath = "kubernetes"
+	}
+	if len(configOut.JWTFile) == 0 {
+		configOut.JWTFile = defaultVaultJWTFile
+	}
+	if len(configOut.GrafanaKeyField) == 0 {
+		configOut.GrafanaKeyField = "grafana_api_key"
+	}
+	if len(configOut.SnapshotKeyField) == 0 {
+		configOut.SnapshotKeyField = "snapshot_api_key"
+	}
+	if configOut.MaxAge < 0 {
+		return nil, errors.New("VaultConfig field \"MaxAge\" cannot be negative")
+	}
+	configOut.MaxAge = defaultDuration(configOut.MaxAge, 5*time.Minute)
+	if configIn.TLS != nil {
+		tlsConfig, err := processTLSConfig(configIn.TLS)
+		if err != nil {
+			return nil, err
+		}
+		configOut.TLS = tlsConfig
+	}
+	return &configOut, nil
+}
+
+// Vault reads API keys from a Vault secret, caching them until the secret's
+// lease expires. Its GrafanaToken and SnapshotToken methods are used as a
+// Config's TokenProvider and SnapshotTokenProvider.
+type Vault struct {
+	config *VaultConfig
+	client *http.Client
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+	keys         map[string]string
+	keysExpire   time.Time
+}
+
+// NewVault creates a Vault for reading API keys as configured
+func NewVault(config *VaultConfig) (*Vault, error) {
+	c, err := processVaultConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if c.TLS != nil {
+		tlsConfig, err := c.TLS.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	return &Vault{config: c, client: client}, nil
+}
+
+// GrafanaToken returns the Grafana API key
+func (v *Vault) GrafanaToken(ctx context.Context) (string, error) {
+	return v.key(ctx, v.config.GrafanaKeyField, "")
+}
+
+// SnapshotToken returns the snapshot host API key, or the Grafana API key if
+// the secret doesn't have one
+func (v *Vault) SnapshotToken(ctx context.Context) (string, error) {
+	return v.key(ctx, v.config.SnapshotKeyField, v.config.GrafanaKeyField)
+}
+
+// key returns field of the secret, or fallback if it isn't set, reading the
+// secret again if its lease has expired or the last key was rejected
+func (v *Vault) key(ctx context.Context, field, fallback string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keys == nil || !time.Now().Before(v.keysExpire) || TokenRefresh(ctx) {
+		if err := v.readSecret(ctx); err != nil {
+			return "", err
+		}
+	}
+	if key, ok := v.keys[field]; ok {
+		return key, nil
+	}
+	if key, ok := v.keys[fallback]; ok && len(fallback) > 0 {
+		return key, nil
+	}
+	return "", fmt.Errorf("Vault secret %q has no field %q", v.config.Path, field)
+}
+
+// readSecret reads the keys from the secret, logging in again if Vault
+// rejects the token
+func (v *Vault) readSecret(ctx context.Context) error {
+	resp, err := v.request(ctx, "GET", v.config.Path, nil, false)
+	if err != nil && resp != nil && resp.statusCode == http.StatusForbidden && len(v.config.Token) == 0 {
+		resp, err = v.request(ctx, "GET", v.config.Path, nil, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, _ := resp.body["data"].(map[string]interface{})
+	// KV version 2 secrets nest the fields in another "data" object
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	if data == nil {
+		return fmt.Errorf("Vault secret %q has no data", v.config.Path)
+	}
+	keys := make(map[string]string)
+	for field, value := range data {
+		if key, ok := value.(string); ok {
+			keys[field] = key
+		}
+	}
+	v.keys = keys
+
+	lease, _ := resp.body["lease_duration"].(float64)
+	if lease > 0 {
+		v.keysExpire = time.Now().Add(time.Duration(lease) * time.Second)
+	} else {
+		v.keysExpire = time.Now().Add(v.config.MaxAge)
+	}
+	return nil
+}
+
+// login logs in with the Kubernetes auth method, returning the Vault token
+func (v *Vault) login(ctx context.Context) (string, error) {
+	jwt, err := ioutil.ReadFile(v.config.JWTFile)
+	if err != nil {
+		return "", fmt.Errorf("Could not read service account token: %s", err.Error())
+	}
+	loginBody := map[string]interface{}{
+		"role": v.config.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}
+	resp, err := v.request(ctx, "POST", "auth/"+strings.Trim(v.config.AuthPath, "/")+"/login", loginBody, false)
+	if err != nil {
+		return "", err
+	}
+	auth, _ := resp.body["auth"].(map[string]interface{})
+	token, _ := auth["client_token"].(string)
+	if len(token) == 0 {
+		return "", errors.New("Vault login returned no token")
+	}
+	v.token = token
+	v.tokenExpires = time.Time{}
+	if lease, _ := auth["lease_duration"].(float64); lease > 0 {
+		v.tokenExpires = time.Now().Add(time.Duration(lease) * time.Second)
+	}
+	return token, nil
+}
+
+// vaultToken returns the token to authenticate with Vault, logging in if
+// there isn't a current one
+func (v *Vault) vaultToken(ctx context.Context, relogin bool) (string, error) {
+	if len(v.config.Token) > 0 {
+		return v.config.Token, nil
+	}
+	if !relogin && len(v.token) > 0 && (v.tokenExpires.IsZero() || time.Now().Before(v.tokenExpires)) {
+		return v.token, nil
+	}
+	return v.login(ctx)
+}
+
+type vaultResponse struct {
+	statusCode int
+	body       map[string]interface{}
+}
+
+// request makes a request to Vault's API, returning the decoded response
+func (v *Vault) request(ctx context.Context, method, path string, body map[string]interface{}, relogin bool) (*vaultResponse, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	u := *v.config.Addr
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/" + path
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if !strings.HasPrefix(path, "auth/") {
+		token, err := v.vaultToken(ctx, relogin)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	vr := &vaultResponse{statusCode: resp.StatusCode}
+	if len(respBody) > 0 {
+		if err = json.Unmarshal(respBody, &vr.body); err != nil {
+			return vr, fmt.Errorf("Could not decode Vault response: %s", err.Error())
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		errs := []string{}
+		if list, ok := vr.body["errors"].([]interface{}); ok {
+			for _, e := range list {
+				errs = append(errs, fmt.Sprint(e))
+			}
+		}
+		return vr, fmt.Errorf("Vault returned %s for %s: %s", resp.Status, path, strings.Join(errs, "; "))
+	}
+	return vr, nil
+}
@@ -0,0 +1,148 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestVault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-vault")
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	jwtFile := filepath.Join(dir, "token")
+	ioutil.WriteFile(jwtFile, []byte("service-account-jwt\n"), 0600)
+
+	// a Vault with a KV version 2 secret, whose login tokens can be revoked
+	logins, reads := 0, 0
+	validToken := ""
+	grafanaKey := "XXXXX"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["role"] != "snapshotter" || body["jwt"] != "service-account-jwt" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			logins++
+			validToken = "vault-token-" + strconv.Itoa(logins)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": validToken, "lease_duration": 3600},
+			})
+		case "/v1/secret/data/grafana":
+			if r.Header.Get("X-Vault-Token") != validToken {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			reads++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"lease_duration": 0,
+				"data": map[string]interface{}{
+					"data":     map[string]interface{}{"grafana_api_key": grafanaKey},
+					"metadata": map[string]interface{}{"version": 1},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	addr, _ := url.Parse(server.URL)
+
+	vault, err := NewVault(&VaultConfig{Addr: addr, Role: "snapshotter", JWTFile: jwtFile, Path: "secret/data/grafana"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	ctx := context.Background()
+	refresh := context.WithValue(ctx, tokenRefreshKey{}, true)
+
+	vaultTests := []struct {
+		purpose  string
+		ctx      context.Context
+		provider func(ctx context.Context) (string, error)
+		revoke   bool   // revoke the Vault token first
+		rotate   string // rotate the Grafana key first
+		expected string
+		logins   int
+		reads    int
+	}{
+		{
+			purpose:  "First read logs in",
+			ctx:      ctx,
+			provider: vault.GrafanaToken,
+			expected: "XXXXX",
+			logins:   1,
+			reads:    1,
+		},
+		{
+			purpose:  "Snapshot key defaults to Grafana's",
+			ctx:      ctx,
+			provider: vault.SnapshotToken,
+			expected: "XXXXX",
+			logins:   1,
+			reads:    1,
+		},
+		{
+			purpose:  "Cached key isn't rotated",
+			ctx:      ctx,
+			provider: vault.GrafanaToken,
+			rotate:   "YYYYY",
+			expected: "XXXXX",
+			logins:   1,
+			reads:    1,
+		},
+		{
+			purpose:  "Refresh reads rotated key",
+			ctx:      refresh,
+			provider: vault.GrafanaToken,
+			expected: "YYYYY",
+			logins:   1,
+			reads:    2,
+		},
+		{
+			purpose:  "Revoked token logs in again",
+			ctx:      refresh,
+			provider: vault.GrafanaToken,
+			revoke:   true,
+			expected: "YYYYY",
+			logins:   2,
+			reads:    3,
+		},
+	}
+	// test
+	for _, vt := range vaultTests {
+		if vt.revoke {
+			validToken = ""
+		}
+		if len(vt.rotate) > 0 {
+			grafanaKey = vt.rotate
+		}
+		key, err := vt.provider(vt.ctx)
+		if err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", vt.purpose, err.Error())
+		} else if key != vt.expected {
+			t.Errorf("Test \"%s\" expected key %q, got %q", vt.purpose, vt.expected, key)
+		}
+		if logins != vt.logins || reads != vt.reads {
+			t.Errorf("Test \"%s\" expected %d logins and %d reads, got %d and %d", vt.purpose, vt.logins, vt.reads, logins, reads)
+		}
+	}
+
+	// missing field
+	vault.config.GrafanaKeyField = "missing"
+	if _, err = vault.GrafanaToken(ctx); err == nil {
+		t.Errorf("Missing field unexpectedly passed")
+	}
+}
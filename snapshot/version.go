@@ -0,0 +1,140 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grafanaInfo is what a SnapClient knows of the Grafana it snapshots, which
+// decides the API endpoints it uses
+type grafanaInfo struct {
+	// version is Grafana's version, e.g. "9.5.2", or empty if it couldn't
+	// be found, in which case the oldest endpoints are used
+	version      string
+	major, minor int
+	// unifiedAlerting is whether Grafana uses unified alerting, the default
+	// from Grafana 9, rather than legacy dashboard alerts
+	unifiedAlerting bool
+}
+
+// atLeast reports whether Grafana's version is at least major.minor
+func (i *grafanaInfo) atLeast(major, minor int) bool {
+	return i.major > major || (i.major == major && i.minor >= minor)
+}
+
+// Grafana versions which changed the endpoints used
+const (
+	// dashboards are no longer found by slug from Grafana 8, only by UID
+	slugRemovedMajor = 8
+	// /api/tsdb/query is replaced by /api/ds/query from Grafana 8
+	dsQueryMajor = 8
+)
+
+// grafanaRetryInterval is how long a Grafana that couldn't be detected is
+// assumed to be the oldest before it's detected again
+const grafanaRetryInterval = time.Minute
+
+// grafana returns what's known of Grafana, detecting it on first use from its
+// frontend settings, or its health check for Grafanas which don't say their
+// version in them. If it can't be found, the oldest endpoints are used and
+// it's detected again after grafanaRetryInterval.
+func (sc *SnapClient) grafana(ctx context.Context) *grafanaInfo {
+	sc.grafanaMu.Lock()
+	defer sc.grafanaMu.Unlock()
+	if sc.grafanaInfo != nil && (sc.grafanaRetry.IsZero() || time.Now().Before(sc.grafanaRetry)) {
+		return sc.grafanaInfo
+	}
+
+	info := &grafanaInfo{}
+	found := false
+	var settings struct {
+		BuildInfo struct {
+			Version string `json:"version"`
+		} `json:"buildInfo"`
+		UnifiedAlertingEnabled bool `json:"unifiedAlertingEnabled"`
+	}
+	body, err := sc.grafanaGet(ctx, "api/frontend/settings", url.Values{})
+	if err == nil {
+		err = json.Unmarshal(body, &settings)
+	}
+	if err != nil {
+		sc.config.Logger.Debug("Could not get Grafana settings, assuming legacy alerting", "error", err)
+	} else {
+		found = true
+		info.version = settings.BuildInfo.Version
+		info.unifiedAlerting = settings.UnifiedAlertingEnabled
+	}
+	if len(sc.config.GrafanaVersion) > 0 {
+		info.version = sc.config.GrafanaVersion
+	} else if len(info.version) == 0 {
+		var health struct {
+			Version string `json:"version"`
+		}
+		body, err := sc.grafanaGet(ctx, "api/health", url.Values{})
+		if err == nil {
+			err = json.Unmarshal(body, &health)
+		}
+		if err != nil {
+			sc.config.Logger.Debug("Could not get Grafana's version, assuming the oldest API", "error", err)
+		}
+		info.version = health.Version
+	}
+	if info.major, info.minor, err = parseVersion(info.version); err != nil {
+		sc.config.Logger.Debug("Could not parse Grafana's version, assuming the oldest API", "version", info.version, "error", err)
+		info.version = ""
+	}
+	sc.grafanaInfo = info
+	// a configured version is kept, as detecting again won't change it
+	if (found && len(info.version) > 0) || len(sc.config.GrafanaVersion) > 0 {
+		sc.config.Logger.Debug("Detected Grafana", "version", info.version, "unifiedAlerting", info.unifiedAlerting)
+		sc.grafanaRetry = time.Time{}
+	} else {
+		sc.grafanaRetry = time.Now().Add(grafanaRetryInterval)
+	}
+	return info
+}
+
+// parseVersion returns the major and minor numbers of a version like "10.2.3"
+// or "v9.0.0-beta1". An empty version is 0.0.
+func parseVersion(version string) (int, int, error) {
+	if len(version) == 0 {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid version %q", version)
+	}
+	minor := 0
+	if len(parts) > 1 {
+		// minor versions may have a suffix, as in 8.0-beta1
+		digits := strings.IndexFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+		if digits == -1 {
+			digits = len(parts[1])
+		}
+		if minor, err = strconv.Atoi(parts[1][:digits]); err != nil {
+			return 0, 0, fmt.Errorf("Invalid version %q", version)
+		}
+	}
+	return major, minor, nil
+}
+
+// dashboardUID returns the UID of the dashboard with the slug, for Grafanas
+// which only find dashboards by UID
+func (sc *SnapClient) dashboardUID(ctx context.Context, slug string) (string, error) {
+	dashboards, err := sc.searchDashboards(ctx, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	for _, d := range dashboards {
+		if d.Slug() == slug && len(d.UID) > 0 {
+			return d.UID, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrDashboardNotFound, slug)
+}
@@ -0,0 +1,210 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestParseVersion(t *testing.T) {
+	versionTests := []struct {
+		version      string
+		major, minor int
+		valid        bool
+	}{
+		{"10.2.3", 10, 2, true},
+		{"v9.0.0-beta1", 9, 0, true},
+		{"8.0-pre", 8, 0, true},
+		{"7", 7, 0, true},
+		{"", 0, 0, true},
+		{"latest", 0, 0, false},
+	}
+	for _, vt := range versionTests {
+		major, minor, err := parseVersion(vt.version)
+		if vt.valid && err != nil {
+			t.Errorf("Version %q unexpectedly failed: %s", vt.version, err.Error())
+		} else if !vt.valid && err == nil {
+			t.Errorf("Version %q unexpectedly passed", vt.version)
+		} else if major != vt.major || minor != vt.minor {
+			t.Errorf("Version %q expected %d.%d, got %d.%d", vt.version, vt.major, vt.minor, major, minor)
+		}
+	}
+}
+
+func TestGrafanaVersion(t *testing.T) {
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("by-slug", map[string]interface{}{"uid": "abc", "title": "By slug"})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	for _, version := range []string{"", "7.5.0", "10.2.3"} {
+		srv.Version = version
+		sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+		if err != nil {
+			t.Fatalf("Unexpectedly failed: %s", err.Error())
+		}
+		if info := sc.grafana(context.Background()); info.version != version {
+			t.Errorf("Expected version %q to be detected, got %q", version, info.version)
+		}
+		// Grafana 8 onwards only gets dashboards by UID
+		snapshot, err := sc.Take(&TakeConfig{DashSlug: "by-slug", From: &from, To: &to})
+		if err != nil {
+			t.Errorf("Version %q unexpectedly failed: %s", version, err.Error())
+		} else if snapshot.Title != "By slug" {
+			t.Errorf("Version %q expected dashboard \"By slug\", got %q", version, snapshot.Title)
+		}
+	}
+}
+
+func TestGrafanaDetectionCache(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+
+	// cases to test
+	var cacheTests = []struct {
+		purpose  string
+		version  string
+		expected map[string]int // requests after detecting twice
+	}{
+		{"Undetected Grafana", "", map[string]int{"/api/frontend/settings": 1, "/api/health": 1}},
+		{"Configured version", "9.1.0", map[string]int{"/api/frontend/settings": 1}},
+	}
+	// test
+	for _, ct := range cacheTests {
+		sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", GrafanaVersion: ct.version})
+		if err != nil {
+			t.Fatalf("Unexpectedly failed: %s", err.Error())
+		}
+		requests = map[string]int{}
+		for i := 0; i < 2; i++ {
+			if info := sc.grafana(context.Background()); info.version != ct.version {
+				t.Errorf("Test \"%s\" expected version %q, got %q", ct.purpose, ct.version, info.version)
+			}
+		}
+		if !reflect.DeepEqual(requests, ct.expected) {
+			t.Errorf("Test \"%s\" expected requests %v, got %v", ct.purpose, ct.expected, requests)
+		}
+		// an undetected Grafana is detected again once it's time to retry
+		if len(ct.version) == 0 {
+			sc.grafanaRetry = time.Now().Add(-time.Second)
+			sc.grafana(context.Background())
+			if requests["/api/frontend/settings"] != 2 {
+				t.Errorf("Test \"%s\" expected detection to be retried, got requests %v", ct.purpose, requests)
+			}
+		}
+	}
+}
+
+func TestDSQuery(t *testing.T) {
+	var query map[string]interface{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/ds/query":
+			var body struct {
+				Queries []map[string]interface{} `json:"queries"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			query = body.Queries[0]
+			w.Write([]byte(`{"results": {"A": {"frames": [{
+				"schema": {"name": "cpu", "fields": [{"name": "time", "type": "time"}, {"name": "value", "type": "number", "labels": {"host": "a"}}]},
+				"data": {"values": [[1000, 2000], [0.5, 0.7]]}
+			}]}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer grafana.Close()
+	grafanaURL, _ := url.Parse(grafana.URL + "/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", GrafanaVersion: "9.1.0"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	datasource := map[string]interface{}{"id": 3.0, "uid": "pg", "type": "postgres"}
+	r := TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	results, err := sc.tsdbQuery(context.Background(), r, datasource, time.Minute, []map[string]interface{}{{"refId": "A"}})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if ds, _ := query["datasource"].(map[string]interface{}); ds["uid"] != "pg" {
+		t.Errorf("Expected the query to reference the datasource by UID, got %v", query["datasource"])
+	}
+	data := tsdbToSnapshotData(results["A"])
+	if len(data) != 1 || data[0].Target != `{host="a"}` || len(data[0].Datapoints) != 2 || data[0].Datapoints[1][0] != 0.7 {
+		t.Errorf("Expected the frame's series, got %+v", data)
+	}
+
+	if _, err = NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX", GrafanaVersion: "latest"}); err == nil {
+		t.Error("Expected an invalid GrafanaVersion to fail")
+	}
+}
+
+func TestDatasourceReference(t *testing.T) {
+	var mu sync.Mutex
+	var queried []string
+	RegisterFetcher("byref", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		queried = append(queried, datasource["name"].(string))
+		return []SnapshotData{{Target: "series", Datapoints: [][]interface{}{{1.0, 1000.0}}}}, nil
+	}))
+	defer RegisterFetcher("byref", nil)
+
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	// Grafana 8 onwards refers to datasources by an object of their UID
+	srv.AddDashboard("refs", map[string]interface{}{
+		"uid": "refs",
+		"panels": []interface{}{
+			map[string]interface{}{
+				"id":         1.0,
+				"datasource": map[string]interface{}{"type": "byref", "uid": "uid-a"},
+				"targets":    []interface{}{map[string]interface{}{"refId": "A"}},
+			},
+			map[string]interface{}{
+				"id":         2.0,
+				"datasource": map[string]interface{}{"type": "datasource", "uid": "-- Mixed --"},
+				"targets": []interface{}{
+					map[string]interface{}{"refId": "A", "datasource": map[string]interface{}{"type": "byref", "uid": "uid-b"}},
+				},
+			},
+			// and panels using the default datasource have none
+			map[string]interface{}{
+				"id":         3.0,
+				"datasource": nil,
+				"targets":    []interface{}{map[string]interface{}{"refId": "A"}},
+			},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "Ref A", "uid": "uid-a", "type": "byref"})
+	srv.AddDatasource(map[string]interface{}{"name": "Ref B", "uid": "uid-b", "type": "byref", "isDefault": true})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	if _, err = sc.Take(&TakeConfig{DashUID: "refs", From: &from, To: &to}); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	sort.Strings(queried)
+	if !reflect.DeepEqual(queried, []string{"Ref A", "Ref B", "Ref B"}) {
+		t.Errorf("Expected \"Ref A\" and \"Ref B\" twice to be queried, got %v", queried)
+	}
+}
@@ -0,0 +1,47 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const warningsContextKey contextKey = 1
+
+// takeWarnings collects the warnings of a snapshot being taken
+type takeWarnings struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func contextWithWarnings(ctx context.Context) (context.Context, *takeWarnings) {
+	w := &takeWarnings{}
+	return context.WithValue(ctx, warningsContextKey, w), w
+}
+
+func (w *takeWarnings) warnings() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.list...)
+}
+
+// warn logs a problem which doesn't stop the snapshot being taken, and adds it
+// to the snapshot's warnings if ctx is taking one
+func (sc *SnapClient) warn(ctx context.Context, msg string, keyvals ...interface{}) {
+	sc.config.Logger.Warn(msg, keyvals...)
+	w, ok := ctx.Value(warningsContextKey).(*takeWarnings)
+	if !ok {
+		return
+	}
+	var fields []string
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields = append(fields, fmt.Sprintf("%v=%v", keyvals[i], keyvals[i+1]))
+	}
+	if len(fields) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, strings.Join(fields, ", "))
+	}
+	w.mu.Lock()
+	w.list = append(w.list, msg)
+	w.mu.Unlock()
+}
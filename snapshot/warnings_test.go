@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alexrudd/snapshot_grafana/snapshot/snapshottest"
+)
+
+func TestWarn(t *testing.T) {
+	grafanaURL, _ := url.Parse("http://grafana.local/")
+	sc, err := NewSnapClient(&Config{GrafanaAddr: grafanaURL, GrafanaAPIKey: "XXXXX"})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	// only logged outside of Take
+	sc.warn(context.Background(), "Not collected")
+
+	ctx, warnings := contextWithWarnings(context.Background())
+	sc.warn(ctx, "Skipping target: unsupported datasource type", "panel", "CPU", "type", "graphite")
+	sc.warn(ctx, "No fields")
+	expected := []string{
+		"Skipping target: unsupported datasource type (panel=CPU, type=graphite)",
+		"No fields",
+	}
+	if out := warnings.warnings(); !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected warnings %q, got %q", expected, out)
+	}
+}
+
+func TestOnQueryError(t *testing.T) {
+	RegisterFetcher("flaky", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, step time.Duration) ([]SnapshotData, error) {
+		if target["refId"] == "B" {
+			return nil, errors.New("datasource down")
+		}
+		return []SnapshotData{{Target: "up", Datapoints: [][]interface{}{{1.0, 1000.0}}}}, nil
+	}))
+	defer RegisterFetcher("flaky", nil)
+
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("flaky", map[string]interface{}{
+		"uid": "flaky",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "datasource": "flaky", "targets": []interface{}{map[string]interface{}{"refId": "A"}}},
+			map[string]interface{}{"id": 2.0, "datasource": "flaky", "targets": []interface{}{map[string]interface{}{"refId": "B"}}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "flaky", "type": "flaky"})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey, OnQueryError: QueryErrorWarn})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	snapshot, err := sc.Take(&TakeConfig{DashUID: "flaky", From: &from, To: &to})
+	if err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err.Error())
+	}
+	expected := []string{"Query failed (panel=2, refId=B, error=datasource down)"}
+	if snapshot.Datapoints != 1 || !reflect.DeepEqual(snapshot.Warnings, expected) {
+		t.Errorf("Expected 1 data point and warnings %q, got %d and %q", expected, snapshot.Datapoints, snapshot.Warnings)
+	}
+
+	// the TakeConfig overrides the Config
+	if _, err = sc.Take(&TakeConfig{DashUID: "flaky", From: &from, To: &to, OnQueryError: QueryErrorFail}); err == nil {
+		t.Error("Expected the failing query to fail the snapshot")
+	}
+	if _, err = sc.Take(&TakeConfig{DashUID: "flaky", From: &from, To: &to, OnQueryError: "ignore"}); err == nil {
+		t.Error("Expected an unknown OnQueryError to fail")
+	}
+}
+
+func TestMaxDataPoints(t *testing.T) {
+	var step time.Duration
+	RegisterFetcher("stepped", FetcherFunc(func(ctx context.Context, target, datasource map[string]interface{}, r TimeRange, s time.Duration) ([]SnapshotData, error) {
+		step = s
+		return nil, nil
+	}))
+	defer RegisterFetcher("stepped", nil)
+
+	srv := snapshottest.NewServer()
+	defer srv.Close()
+	srv.AddDashboard("stepped", map[string]interface{}{
+		"uid": "stepped",
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "title": "CPU", "datasource": "stepped", "targets": []interface{}{map[string]interface{}{"refId": "A"}}},
+		},
+	})
+	srv.AddDatasource(map[string]interface{}{"name": "stepped", "type": "stepped"})
+	from, to := time.Unix(0, 0), time.Unix(3600, 0)
+
+	// an hour at the full width panel's 2s interval is 1800 points
+	for _, mt := range []struct {
+		maxDataPoints int
+		step          time.Duration
+		warnings      []string
+	}{
+		{0, 2 * time.Second, nil},
+		{1800, 2 * time.Second, nil},
+		{100, 36 * time.Second, []string{"Widening query step to stay under max data points (panel=CPU, refId=A, step=2s, widened=36s, maxDataPoints=100)"}},
+	} {
+		sc, err := NewSnapClient(&Config{GrafanaAddr: srv.Addr(), GrafanaAPIKey: snapshottest.APIKey, MaxDataPoints: mt.maxDataPoints})
+		if err != nil {
+			t.Fatalf("Unexpectedly failed: %s", err.Error())
+		}
+		snapshot, err := sc.Take(&TakeConfig{DashUID: "stepped", From: &from, To: &to})
+		if err != nil {
+			t.Fatalf("Unexpectedly failed: %s", err.Error())
+		}
+		if step != mt.step || !reflect.DeepEqual(snapshot.Warnings, mt.warnings) {
+			t.Errorf("MaxDataPoints %d expected step %s and warnings %q, got %s and %q", mt.maxDataPoints, mt.step, mt.warnings, step, snapshot.Warnings)
+		}
+	}
+}
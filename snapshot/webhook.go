@@ -0,0 +1,112 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// WebhookConfig configures a request made with the result of each snapshot
+type WebhookConfig struct {
+	URL *url.URL
+	// Method defaults to POST
+	Method  string
+	Headers map[string]string
+	// BodyTemplate is a text/template for the request body, evaluated
+	// against the Notification, with a "json" function encoding its
+	// argument as JSON. Defaults to the Notification as JSON.
+	BodyTemplate string
+	// Timeout limits each request. Defaults to 30s, and negative disables
+	// the limit.
+	Timeout time.Duration
+}
+
+func processWebhookConfig(configIn *WebhookConfig) (*WebhookConfig, error) {
+	configOut := *configIn
+
+	if configIn.URL == nil || len(configIn.URL.Host) == 0 {
+		return nil, errors.New("Missing required WebhookConfig field: \"URL\"")
+	}
+	if len(configOut.Method) == 0 {
+		configOut.Method = "POST"
+	}
+	configOut.Method = strings.ToUpper(configOut.Method)
+	configOut.Timeout = defaultDuration(configOut.Timeout, 30*time.Second)
+	return &configOut, nil
+}
+
+// Webhook is a Notifier making an HTTP request with each Notification
+type Webhook struct {
+	config       *WebhookConfig
+	client       *http.Client
+	bodyTemplate *template.Template
+}
+
+// NewWebhook creates a Webhook making the configured request
+func NewWebhook(config *WebhookConfig) (*Webhook, error) {
+	c, err := processWebhookConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{config: c, client: &http.Client{}}
+	if c.Timeout > 0 {
+		w.client.Timeout = c.Timeout
+	}
+	if len(c.BodyTemplate) > 0 {
+		funcs := template.FuncMap{"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		}}
+		if w.bodyTemplate, err = template.New("WebhookConfig BodyTemplate").Funcs(funcs).Parse(c.BodyTemplate); err != nil {
+			return nil, fmt.Errorf("Invalid WebhookConfig BodyTemplate: %s", err.Error())
+		}
+	}
+	return w, nil
+}
+
+// Notify makes the webhook's request with the notification, failing unless
+// it's answered with a 2xx status
+func (w *Webhook) Notify(ctx context.Context, n *Notification) error {
+	var body bytes.Buffer
+	if w.bodyTemplate != nil {
+		if err := w.bodyTemplate.Execute(&body, n); err != nil {
+			return fmt.Errorf("Failed to evaluate WebhookConfig BodyTemplate: %s", err.Error())
+		}
+	} else if err := json.NewEncoder(&body).Encode(n); err != nil {
+		return err
+	}
+
+	var reqBody io.Reader
+	if w.config.Method != "GET" && w.config.Method != "HEAD" {
+		reqBody = &body
+	}
+	req, err := http.NewRequest(w.config.Method, w.config.URL.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range w.config.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Failed to call webhook: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Failed to call webhook: %s", resp.Status)
+	}
+	return nil
+}
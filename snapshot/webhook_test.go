@@ -0,0 +1,88 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var called *http.Request
+	var body string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		called = r
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer hook.Close()
+
+	from := time.Date(2017, time.February, 5, 6, 0, 0, 0, time.UTC)
+	n := &Notification{Status: NotifySuccess, Dashboard: "my-dash", URL: "http://grafana/dashboard/snapshot/abc", From: from, To: from.Add(time.Hour)}
+	notifyTests := []struct {
+		purpose  string
+		path     string
+		config   *WebhookConfig
+		valid    bool
+		expected string
+	}{
+		{
+			purpose:  "Default body",
+			path:     "/hook",
+			config:   &WebhookConfig{},
+			valid:    true,
+			expected: "POST application/json {\"status\":\"success\",\"dashboard\":\"my-dash\",\"url\":\"http://grafana/dashboard/snapshot/abc\",\"from\":\"2017-02-05T06:00:00Z\",\"to\":\"2017-02-05T07:00:00Z\"}\n",
+		},
+		{
+			purpose:  "Body template and headers",
+			path:     "/hook",
+			config:   &WebhookConfig{Method: "put", Headers: map[string]string{"Content-Type": "text/plain"}, BodyTemplate: `{{.Dashboard}} {{.Status}} {{json .URL}} {{.From.Format "2006-01-02"}}`},
+			valid:    true,
+			expected: "PUT text/plain my-dash success \"http://grafana/dashboard/snapshot/abc\" 2017-02-05",
+		},
+		{
+			purpose: "Invalid body template",
+			path:    "/hook",
+			config:  &WebhookConfig{BodyTemplate: "{{.Dashboard"},
+			valid:   false,
+		},
+		{
+			purpose: "Error status",
+			path:    "/broken",
+			config:  &WebhookConfig{},
+			valid:   false,
+		},
+		{
+			purpose: "Missing URL",
+			config:  &WebhookConfig{},
+			valid:   false,
+		},
+	}
+	// test
+	for _, nt := range notifyTests {
+		called = nil
+		if len(nt.path) > 0 {
+			nt.config.URL, _ = url.Parse(hook.URL + nt.path)
+		}
+		w, err := NewWebhook(nt.config)
+		if err == nil {
+			err = w.Notify(context.Background(), n)
+		}
+		if nt.valid && err != nil {
+			t.Errorf("Test \"%s\" unexpectedly failed: %s", nt.purpose, err.Error())
+		} else if !nt.valid && err == nil {
+			t.Errorf("Test \"%s\" unexpectedly passed", nt.purpose)
+		} else if nt.valid {
+			if result := called.Method + " " + called.Header.Get("Content-Type") + " " + body; result != nt.expected {
+				t.Errorf("Test \"%s\" expected request %q, got %q", nt.purpose, nt.expected, result)
+			}
+		}
+	}
+}